@@ -4,21 +4,34 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"net/url"
-	"regexp"
 	"strconv"
+	"time"
 
 	"github.com/msproject/relive/dbi"
 	"github.com/msproject/relive/dbmodel"
 	"github.com/msproject/relive/logger"
+	"github.com/msproject/relive/mediastore"
+	"github.com/msproject/relive/notifier"
+	"github.com/msproject/relive/passwd"
 	"github.com/msproject/relive/util"
 )
 
+//DefaultResetTTL - lifetime of a password reset token
+const DefaultResetTTL = 30 * time.Minute
+
 // AccountsAPI struct
 type AccountsAPI struct {
-	AccountDBI      dbi.AccountTblDBI
-	SubscriptionDBI dbi.SubscriptionTblDBI
-	LogObj          *logger.Logger
+	AccountDBI       dbi.AccountTblDBI
+	SubscriptionDBI  dbi.SubscriptionTblDBI
+	SessionDBI       dbi.SessionTblDBI
+	PasswordResetDBI dbi.PasswordResetTblDBI
+	TransferDBI      dbi.AccountTransferTblDBI
+	Notifier         notifier.Notifier
+	PWDPolicy        passwd.Policy
+	SessionTTL       time.Duration
+	ResetTTL         time.Duration
+	Store            mediastore.MediaStore
+	LogObj           *logger.Logger
 }
 
 //InitAccountsDB - create root user if it doesnt exist
@@ -63,34 +76,43 @@ func InitAccountsDB(sqlDBI dbi.DBI) (err error) {
 	return nil
 }
 
-//	/api/accounts/search  updated
-//func handleAccountsSearch(UserName string) error {
-func handleAccountsSearch(api AccountsAPI, args []string, w http.ResponseWriter, r *http.Request) error {
-	var username string
-	var req util.SearchAccountReq
-	if r.Method != "GET" {
-		w.Header().Set("Allow", "GET")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return fmt.Errorf("Incorrect Method used for API /api/accounts/Search")
+// /api/accounts/search - by user name, or the accounts of a business
+func handleAccountsSearchAny(api AccountsAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	if r.URL.Query().Get("user") != "" {
+		return handleAccountsSearch(api, params, w, r)
 	}
 
-	URLSuffix := args[0]
-	parsedURLSuffix, err := url.Parse(URLSuffix)
-	params := parsedURLSuffix.Query()
+	if err := permitted(rootOrAdmin, w, r); err != nil {
+		return err
+	}
+	return handleAdminAccountsSearch(api, params, w, r)
+}
 
-	if len(params["user"]) > 0 {
-		username = params["user"][0]
+//	/api/accounts/search?user=<name>
+func handleAccountsSearch(api AccountsAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	var username string
+	var req util.SearchAccountReq
+	var err error
+	query := r.URL.Query()
+
+	if len(query["user"]) > 0 {
+		username = query["user"][0]
 	} else {
 		w.WriteHeader(http.StatusBadRequest)
 		return fmt.Errorf("required query parameters NOT specified in search request")
 	}
 
-	req, err = api.AccountDBI.SearchAccount(username)
+	req, err = api.AccountDBI.SearchAccount(principalFrom(r).Scope(), username)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return err
 	}
 
+	if !principalFrom(r).Owns(int(req.ID), int(req.CompanyID)) {
+		w.WriteHeader(http.StatusForbidden)
+		return fmt.Errorf("account %s is not accessible", username)
+	}
+
 	w.WriteHeader(http.StatusOK)
 	err = writeResponse(req, w)
 	if err != nil {
@@ -100,24 +122,16 @@ func handleAccountsSearch(api AccountsAPI, args []string, w http.ResponseWriter,
 	return nil
 }
 
-func handleAdminAccountsSearch(api AccountsAPI, args []string, w http.ResponseWriter, r *http.Request) error {
+// /api/accounts/search?id=<admin>&role=<role> - accounts of a business
+func handleAdminAccountsSearch(api AccountsAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
 	var id, role uint64
 	var err error
-	var parsedURLSuffix *url.URL
 	var resp []util.UserDetails
 
-	if r.Method != "GET" {
-		w.Header().Set("Allow", "GET")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return fmt.Errorf("Incorrect Method used for API /api/accounts/Search")
-	}
-
-	URLSuffix := args[0]
-	parsedURLSuffix, err = url.Parse(URLSuffix)
-	params := parsedURLSuffix.Query()
+	query := r.URL.Query()
 
-	if len(params["id"]) > 0 {
-		id, err = strconv.ParseUint(params["id"][0], 10, 32)
+	if len(query["id"]) > 0 {
+		id, err = strconv.ParseUint(query["id"][0], 10, 32)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			return fmt.Errorf("invalid admin id specified in request URL")
@@ -127,8 +141,8 @@ func handleAdminAccountsSearch(api AccountsAPI, args []string, w http.ResponseWr
 		return fmt.Errorf("required query parameters NOT specified in search request")
 	}
 
-	if len(params["role"]) > 0 {
-		role, err = strconv.ParseUint(params["role"][0], 10, 32)
+	if len(query["role"]) > 0 {
+		role, err = strconv.ParseUint(query["role"][0], 10, 32)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			return fmt.Errorf("invalid admin id specified in request URL")
@@ -138,7 +152,13 @@ func handleAdminAccountsSearch(api AccountsAPI, args []string, w http.ResponseWr
 		return fmt.Errorf("required query parameters NOT specified in search request")
 	}
 
-	resp, err = api.AccountDBI.SearchAndGetAccountIDs(int(id), int(role))
+	p := principalFrom(r)
+	if !p.IsRoot() && int(id) != p.ID {
+		w.WriteHeader(http.StatusForbidden)
+		return fmt.Errorf("accounts of admin %d are not accessible", id)
+	}
+
+	resp, err = api.AccountDBI.SearchAndGetAccountIDs(p.Scope(), int(id), int(role))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return err
@@ -153,13 +173,7 @@ func handleAdminAccountsSearch(api AccountsAPI, args []string, w http.ResponseWr
 }
 
 // /api/accounts/create
-func handleAccountsCreate(api AccountsAPI, args []string, w http.ResponseWriter, r *http.Request) error {
-
-	if r.Method != "POST" {
-		w.Header().Set("Allow", "POST")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return fmt.Errorf("Incorrect Method used for API /api/accounts/create")
-	}
+func handleAccountsCreate(api AccountsAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
 
 	var req util.CreateAccountReq
 	d := json.NewDecoder(r.Body)
@@ -173,6 +187,18 @@ func handleAccountsCreate(api AccountsAPI, args []string, w http.ResponseWriter,
 		return fmt.Errorf("required parameters NOT specified in create request")
 	}
 
+	if err := api.PWDPolicy.Validate(req.PWD); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return err
+	}
+
+	/* a business can only create its own customers */
+	p := principalFrom(r)
+	if !p.IsRoot() && (req.Role != dbmodel.RoleCustomer || int(req.CompanyID) != p.ID) {
+		w.WriteHeader(http.StatusForbidden)
+		return fmt.Errorf("account %s may only create customers with PID %d", p.UserName, p.ID)
+	}
+
 	exists, err1 := api.AccountDBI.CheckAccountExists(req.UserName)
 	if err1 != nil {
 		w.WriteHeader(http.StatusUnauthorized)
@@ -183,6 +209,12 @@ func handleAccountsCreate(api AccountsAPI, args []string, w http.ResponseWriter,
 		return err1
 	}
 
+	if req.Role == dbmodel.RoleCustomer {
+		if err := checkCustomerLimit(api, w, int(req.CompanyID)); err != nil {
+			return err
+		}
+	}
+
 	err := api.AccountDBI.CreateAccount(req)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -194,73 +226,296 @@ func handleAccountsCreate(api AccountsAPI, args []string, w http.ResponseWriter,
 	return nil
 }
 
-// /api/Account/update -
-func handleAccountsUpdate(api AccountsAPI, args []string, w http.ResponseWriter, r *http.Request) error {
-	// check for API Method
-	if r.Method != "POST" {
-		w.Header().Set("Allow", "POST")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return fmt.Errorf("Incorrect Method used for API /api/Account/update")
+// checkCustomerLimit - a business may have as many customers as the most
+// generous of its running subscriptions allows, as subscribed. Without one
+// it is not limited.
+func checkCustomerLimit(api AccountsAPI, w http.ResponseWriter, business int) error {
+	subs, err := api.SubscriptionDBI.ListRunningSubscriptions(business)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+
+	limit := 0
+	for _, sub := range subs {
+		if sub.MaxCustomers == 0 {
+			return nil
+		}
+		if sub.MaxCustomers > limit {
+			limit = sub.MaxCustomers
+		}
+	}
+	if limit == 0 {
+		return nil
+	}
+
+	customers, err := api.AccountDBI.SearchAndGetAccountIDs(dbi.RootScope(), business, dbmodel.RoleCustomer)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	if len(customers) >= limit {
+		w.WriteHeader(http.StatusForbidden)
+		return fmt.Errorf("business %d has all %d customers its subscription allows", business, limit)
+	}
+	return nil
+}
+
+// checkUserNameFree - refuse renaming an account to a name another account has
+func checkUserNameFree(api AccountsAPI, w http.ResponseWriter, current, name string) error {
+	if name == current {
+		return nil
+	}
+	exists, err := api.AccountDBI.CheckAccountExists(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
 	}
+	if exists {
+		http.Error(w, "Account exists.", http.StatusConflict)
+		return fmt.Errorf("user name %s is taken", name)
+	}
+	return nil
+}
 
+// /api/Account/update -
+func handleAccountsUpdate(api AccountsAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
 	// decode the JSON against the structure
-	var req *dbmodel.AccountEntry
+	var req dbmodel.AccountEntry
 	d := json.NewDecoder(r.Body)
 	if err := d.Decode(&req); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(http.StatusBadRequest)
 		return fmt.Errorf("Error decoding the request: %s", err.Error())
 	}
+	/* a null body decodes to an empty entry */
+	if req.UserName == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("required parameters NOT specified in update request")
+	}
 
-	err := api.AccountDBI.UpdateAccount(req)
+	target, err := authorizeAccount(api.AccountDBI, w, r, req.ID)
+	if err != nil {
+		return err
+	}
+
+	/* only root moves accounts between businesses or changes roles */
+	if !principalFrom(r).IsRoot() && (req.PID != target.PID || req.Role != target.Role) {
+		w.WriteHeader(http.StatusForbidden)
+		return fmt.Errorf("PID and Role of account %d can only be changed by root", req.ID)
+	}
+
+	if err = checkUserNameFree(api, w, target.UserName, req.UserName); err != nil {
+		return err
+	}
+
+	err = api.AccountDBI.UpdateAccount(&req)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return err
 	}
 
+	/* sessions carry the role and PID, drop them so the change applies now */
+	err = api.SessionDBI.DeleteAccountSessions(req.ID)
+	if err != nil {
+		api.LogObj.PrintError("Failed to revoke sessions of account %d: %v", req.ID, err)
+	}
+
 	w.WriteHeader(http.StatusNoContent)
 	return nil
 }
 
-func handleMyAccountUpdate(api AccountsAPI, args []string, w http.ResponseWriter, r *http.Request) error {
-	// check for API Method
-	if r.Method != "POST" {
-		w.Header().Set("Allow", "POST")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return fmt.Errorf("Incorrect Method used for API /api/Account/update")
+func handleMyAccountUpdate(api AccountsAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	// decode the JSON against the structure
+	var req dbmodel.AccountEntry
+	d := json.NewDecoder(r.Body)
+	if err := d.Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("Error decoding the request: %s", err.Error())
+	}
+	/* a null body decodes to an empty entry */
+	if req.UserName == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("required parameters NOT specified in update request")
+	}
+
+	/* my/update always applies to the caller and keeps its business */
+	p := principalFrom(r)
+	req.ID = p.ID
+	req.PID = p.PID
+
+	me, err := authorizeAccount(api.AccountDBI, w, r, p.ID)
+	if err != nil {
+		return err
+	}
+	if err = checkUserNameFree(api, w, me.UserName, req.UserName); err != nil {
+		return err
 	}
 
-	// decode the JSON against the structure
-	var req *dbmodel.AccountEntry
+	err = api.AccountDBI.UpdateMyAccount(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+
+	err = api.SessionDBI.DeleteAccountSessions(req.ID)
+	if err != nil {
+		api.LogObj.PrintError("Failed to revoke sessions of account %d: %v", req.ID, err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// /api/accounts/change - change password
+func handleChangePassword(api AccountsAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+
+	var req util.ChangePasswordReq
 	d := json.NewDecoder(r.Body)
 	if err := d.Decode(&req); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return fmt.Errorf("Error decoding the request: %s", err.Error())
 	}
 
-	err := api.AccountDBI.UpdateMyAccount(req)
+	if req.OldPWD == "" || req.NewPWD == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("required parameters NOT specified in change request")
+	}
+
+	p := principalFrom(r)
+	_, err := api.AccountDBI.Login(p.UserName, req.OldPWD)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return fmt.Errorf("old password does not match")
+	}
+
+	if req.NewPWD == req.OldPWD {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("new password must differ from the old one")
+	}
+	if err = api.PWDPolicy.Validate(req.NewPWD); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return err
+	}
+
+	return setPassword(api, p.ID, req.NewPWD, w)
+}
+
+// setPassword - store the new password and log the account out everywhere
+func setPassword(api AccountsAPI, id int, PWD string, w http.ResponseWriter) error {
+	err := api.AccountDBI.ChangePassword(id, PWD)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return err
 	}
 
+	err = api.SessionDBI.DeleteAccountSessions(id)
+	if err != nil {
+		api.LogObj.PrintError("Failed to revoke sessions of account %d: %v", id, err)
+	}
+
 	w.WriteHeader(http.StatusNoContent)
 	return nil
 }
 
-// /api/accounts/change - change password
-func handleChangePassword(api AccountsAPI, args []string, w http.ResponseWriter, r *http.Request) error {
+// /api/accounts/forgot - send a password reset token to the account owner
+func handleForgotPassword(api AccountsAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+
+	var req util.ForgotPasswordReq
+	d := json.NewDecoder(r.Body)
+	if err := d.Decode(&req); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return fmt.Errorf("Error decoding the request: %s", err.Error())
+	}
+
+	if req.UserName == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("required parameters NOT specified in forgot request")
+	}
+
+	acct, err := api.AccountDBI.SearchAccount(dbi.RootScope(), req.UserName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+
+	/* the response is the same whether or not the account exists, so
+	 * this endpoint cannot be used to discover user names */
+	if acct.ID != 0 {
+		if err = sendPasswordReset(api, acct); err != nil {
+			api.LogObj.PrintError("Failed to send password reset to %s: %v", req.UserName, err)
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
 	return nil
 }
 
-// /api/accounts/login/  - login
-func handleAccountsLogin(api AccountsAPI, args []string, w http.ResponseWriter, r *http.Request) error {
+func sendPasswordReset(api AccountsAPI, acct util.SearchAccountReq) error {
+	token, err := randomToken()
+	if err != nil {
+		return err
+	}
+
+	ttl := api.ResetTTL
+	if ttl <= 0 {
+		ttl = DefaultResetTTL
+	}
+	now := time.Now().UTC()
+	reset := &dbmodel.PasswordResetEntry{
+		TokenHash: hashToken(token),
+		ID:        int(acct.ID),
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if err = api.PasswordResetDBI.CreatePasswordReset(reset); err != nil {
+		return err
+	}
+
+	account := &dbmodel.AccountEntry{
+		ID:       int(acct.ID),
+		UserName: acct.UserName,
+		EmailID:  acct.Email,
+	}
+	return api.Notifier.PasswordReset(account, token, reset.ExpiresAt)
+}
+
+// /api/accounts/reset - set a new password with a token from /api/accounts/forgot
+func handleResetPassword(api AccountsAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+
+	var req util.ResetPasswordReq
+	d := json.NewDecoder(r.Body)
+	if err := d.Decode(&req); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return fmt.Errorf("Error decoding the request: %s", err.Error())
+	}
+
+	if req.Token == "" || req.NewPWD == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("required parameters NOT specified in reset request")
+	}
+
+	/* check the policy first so a rejected password doesn't burn the token */
+	if err := api.PWDPolicy.Validate(req.NewPWD); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return err
+	}
 
-	if r.Method != "POST" {
-		w.Header().Set("Allow", "POST")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return fmt.Errorf("Incorrect Method used for API /api/accounts/login")
+	id, err := api.PasswordResetDBI.ConsumePasswordReset(hashToken(req.Token))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	if id == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("reset token is invalid, used or expired")
 	}
 
+	return setPassword(api, id, req.NewPWD, w)
+}
+
+// /api/accounts/login/  - login
+func handleAccountsLogin(api AccountsAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+
 	var req util.LoginReq
 	d := json.NewDecoder(r.Body)
 	if err := d.Decode(&req); err != nil {
@@ -284,7 +539,36 @@ func handleAccountsLogin(api AccountsAPI, args []string, w http.ResponseWriter,
 		return err
 	}
 
-	err = writeResponse(recs, w)
+	return issueSession(api, recs, w)
+}
+
+// issueSession - create a Session row for the account and return its token
+func issueSession(api AccountsAPI, account *dbmodel.AccountEntry, w http.ResponseWriter) error {
+	token, session, err := newSession(account, api.SessionTTL)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+
+	err = api.SessionDBI.CreateSession(session)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+
+	resp := util.LoginResp{
+		Token:     token,
+		TokenType: "Bearer",
+		ExpiresAt: session.ExpiresAt,
+		ID:        account.ID,
+		PID:       account.PID,
+		UserName:  account.UserName,
+		EmailID:   account.EmailID,
+		FirstName: account.FirstName,
+		LastName:  account.LastName,
+		Role:      account.Role,
+	}
+	err = writeResponse(resp, w)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return err
@@ -292,15 +576,62 @@ func handleAccountsLogin(api AccountsAPI, args []string, w http.ResponseWriter,
 	return nil
 }
 
-// /api/Account/delete -
-func handleAccountDelete(api AccountsAPI, args []string, w http.ResponseWriter, r *http.Request) error {
-	// check for API Method
-	if r.Method != "DELETE" {
-		w.Header().Set("Allow", "DELETE")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return fmt.Errorf("Incorrect Method used for API /api/Account/delete")
+// /api/accounts/refresh - exchange a valid session for a new one
+func handleAccountsRefresh(api AccountsAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+
+	token, err := bearerToken(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return err
+	}
+
+	session, err := api.SessionDBI.GetSession(hashToken(token))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	if session == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return fmt.Errorf("session is expired or revoked")
 	}
 
+	/* rotate: the old token stops working once the new one is issued */
+	err = api.SessionDBI.DeleteSession(session.SessionID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+
+	account := &dbmodel.AccountEntry{
+		ID:       session.ID,
+		PID:      session.PID,
+		UserName: session.UserName,
+		Role:     session.Role,
+	}
+	return issueSession(api, account, w)
+}
+
+// /api/accounts/logout - revoke the session used for this request
+func handleAccountsLogout(api AccountsAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+
+	token, err := bearerToken(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return err
+	}
+
+	err = api.SessionDBI.DeleteSession(hashToken(token))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// /api/Account/delete -
+func handleAccountDelete(api AccountsAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
 	// decode the JSON against the structure
 	var req util.CreateAccountReq
 	d := json.NewDecoder(r.Body)
@@ -314,105 +645,62 @@ func handleAccountDelete(api AccountsAPI, args []string, w http.ResponseWriter,
 		return fmt.Errorf("required parameters NOT specified in delete request")
 	}*/
 
-	err := api.AccountDBI.DeleteAccount(req.UserName)
+	p := principalFrom(r)
+	target, err := api.AccountDBI.SearchAccount(p.Scope(), req.UserName)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return err
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	/* a business can delete its customers but not itself */
+	if !p.IsRoot() && (int(target.CompanyID) != p.ID || int(target.ID) == p.ID) {
+		w.WriteHeader(http.StatusForbidden)
+		return fmt.Errorf("account %s is not accessible", req.UserName)
+	}
 
-	return nil
-}
+	deleted, err := api.AccountDBI.DeleteAccount(int(target.ID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
 
-func (api AccountsAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	for _, d := range account {
-		if d.re.MatchString(r.URL.String()) {
-			err := d.f(api, d.re.FindStringSubmatch(r.URL.String()), w, r)
-			if err != nil {
-				returnMessage := fmt.Sprintf("%v", err)
-				w.Write([]byte(returnMessage))
-			}
-			return
+	/* the account is gone, files that could not be deleted are only logged */
+	for _, m := range deleted {
+		if err = mediastore.DeletePrefix(r.Context(), api.Store, mediaStorePrefix(m)); err != nil {
+			api.LogObj.PrintError("Failed to delete media %s of account %s from the store: %v", m.FileName, req.UserName, err)
 		}
 	}
-	w.WriteHeader(http.StatusBadRequest)
-	w.Write([]byte("No match found.\n"))
-}
 
-type accountT struct {
-	regex string
-	re    *regexp.Regexp
-	f     func(api AccountsAPI, args []string, w http.ResponseWriter, r *http.Request) error
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
 }
 
-var account []accountT
-
-func init() {
-	var regex string
-	regex = "/api/accounts/search\\?user=([^/]+)$"
-	account = append(account,
-		accountT{
-			regex: regex,
-			re:    regexp.MustCompile(regex),
-			f:     handleAccountsSearch,
-		},
-	)
-	regex = "/api/accounts/search\\?id=([^/]+)&role=([^/]+)$"
-	account = append(account,
-		accountT{
-			regex: regex,
-			re:    regexp.MustCompile(regex),
-			f:     handleAdminAccountsSearch,
-		},
-	)
-	regex = "/api/accounts/create$"
-	account = append(account,
-		accountT{
-			regex: regex,
-			re:    regexp.MustCompile(regex),
-			f:     handleAccountsCreate,
-		},
-	)
-	regex = "/api/accounts/update$"
-	account = append(account,
-		accountT{
-			regex: regex,
-			re:    regexp.MustCompile(regex),
-			f:     handleAccountsUpdate,
-		},
-	)
-	regex = "/api/accounts/my/update$"
-	account = append(account,
-		accountT{
-			regex: regex,
-			re:    regexp.MustCompile(regex),
-			f:     handleMyAccountUpdate,
-		},
-	)
-	regex = "/api/accounts/change$"
-	account = append(account,
-		accountT{
-			regex: regex,
-			re:    regexp.MustCompile(regex),
-			f:     handleChangePassword,
-		},
-	)
-	regex = "/api/accounts/login$"
-	account = append(account,
-		accountT{
-			regex: regex,
-			re:    regexp.MustCompile(regex),
-			f:     handleAccountsLogin,
-		},
-	)
-	regex = "/api/account/delete$"
-	account = append(account,
-		accountT{
-			regex: regex,
-			re:    regexp.MustCompile(regex),
-			f:     handleAccountDelete,
-		},
-	)
+// bind - adapt a handler of this API to a routeHandler
+func (api AccountsAPI) bind(f func(api AccountsAPI, params routeParams, w http.ResponseWriter, r *http.Request) error) routeHandler {
+	return func(params routeParams, w http.ResponseWriter, r *http.Request) error {
+		return f(api, params, w, r)
+	}
+}
 
+// routes - endpoints of the accounts API
+func (api AccountsAPI) routes() []route {
+	return []route{
+		{method: "GET", pattern: "/api/accounts/search", roles: anyRole, handler: api.bind(handleAccountsSearchAny)},
+		{method: "POST", pattern: "/api/accounts/create", roles: rootOrAdmin, handler: api.bind(handleAccountsCreate)},
+		{method: "POST", pattern: "/api/accounts/update", roles: rootOrAdmin, handler: api.bind(handleAccountsUpdate)},
+		{method: "POST", pattern: "/api/accounts/my/update", roles: anyRole, handler: api.bind(handleMyAccountUpdate)},
+		{method: "POST", pattern: "/api/accounts/change", roles: anyRole, handler: api.bind(handleChangePassword)},
+		{method: "POST", pattern: "/api/accounts/login", roles: nil, handler: api.bind(handleAccountsLogin)},
+		{method: "POST", pattern: "/api/accounts/refresh", roles: anyRole, handler: api.bind(handleAccountsRefresh)},
+		{method: "POST", pattern: "/api/accounts/logout", roles: anyRole, handler: api.bind(handleAccountsLogout)},
+		{method: "POST", pattern: "/api/accounts/forgot", roles: nil, handler: api.bind(handleForgotPassword)},
+		{method: "POST", pattern: "/api/accounts/reset", roles: nil, handler: api.bind(handleResetPassword)},
+		{method: "DELETE", pattern: "/api/accounts/delete", roles: rootOrAdmin, handler: api.bind(handleAccountDelete)},
+		{method: "POST", pattern: "/api/accounts/transfers", roles: rootOrAdmin, handler: api.bind(handleTransferCreate)},
+		{method: "GET", pattern: "/api/accounts/transfers", roles: anyRole, handler: api.bind(handleTransferList)},
+		{method: "POST", pattern: "/api/accounts/transfers/{transfer}/accept", roles: anyRole, handler: api.bind(handleTransferAccept)},
+		{method: "POST", pattern: "/api/accounts/transfers/{transfer}/decline", roles: anyRole, handler: api.bind(handleTransferDecline)},
+		{method: "POST", pattern: "/api/accounts/transfers/{transfer}/cancel", roles: rootOrAdmin, handler: api.bind(handleTransferCancel)},
+	}
 }
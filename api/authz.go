@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/msproject/relive/dbi"
+	"github.com/msproject/relive/dbmodel"
+	"github.com/msproject/relive/logger"
+)
+
+// Principal - the authenticated account a request is made on behalf of
+type Principal struct {
+	ID       int
+	PID      int
+	UserName string
+	Role     int
+}
+
+type principalKey struct{}
+
+// Route role sets. A route with nil roles is public and needs no session.
+var (
+	anyRole     = []int{dbmodel.RoleRoot, dbmodel.RoleAdmin, dbmodel.RoleCustomer}
+	rootOrAdmin = []int{dbmodel.RoleRoot, dbmodel.RoleAdmin}
+	rootOnly    = []int{dbmodel.RoleRoot}
+)
+
+// authorizeRequest - validate the session and attach its principal to the
+// request context. Requests without a valid session are returned as is,
+// permitted() rejects them for every non public route.
+func authorizeRequest(logObj *logger.Logger, sessionDBI dbi.SessionTblDBI, w http.ResponseWriter, req *http.Request) *http.Request {
+	if req.Header.Get("Authorization") == "" {
+		return req
+	}
+
+	session, err := authenticate(logObj, sessionDBI, w, req)
+	if err != nil {
+		logObj.PrintInfo("unauthenticated request %s: %v", req.URL.Path, err)
+		return req
+	}
+
+	p := &Principal{
+		ID:       session.ID,
+		PID:      session.PID,
+		UserName: session.UserName,
+		Role:     session.Role,
+	}
+	return req.WithContext(context.WithValue(req.Context(), principalKey{}, p))
+}
+
+// principalFrom - the principal attached by authorizeRequest, nil if none
+func principalFrom(r *http.Request) *Principal {
+	p, _ := r.Context().Value(principalKey{}).(*Principal)
+	return p
+}
+
+// permitted - check a route's roles against the request principal,
+// writing 401 for missing sessions and 403 for roles not allowed
+func permitted(roles []int, w http.ResponseWriter, r *http.Request) error {
+	if roles == nil {
+		return nil
+	}
+
+	p := principalFrom(r)
+	if p == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return fmt.Errorf("a valid session is required for %s", r.URL.Path)
+	}
+
+	for _, role := range roles {
+		if p.Role == role {
+			return nil
+		}
+	}
+	w.WriteHeader(http.StatusForbidden)
+	return fmt.Errorf("account %s is not permitted to call %s", p.UserName, r.URL.Path)
+}
+
+// IsRoot - true for the relive operator
+func (p *Principal) IsRoot() bool {
+	return p.Role == dbmodel.RoleRoot
+}
+
+// Scope - the dbi tenant scope of this principal
+func (p *Principal) Scope() dbi.TenantScope {
+	return dbi.NewTenantScope(p.ID, p.Role)
+}
+
+// Owns - true if p may act on the account id whose parent is pid:
+// root owns everything, a business owns itself and its customers,
+// a customer owns only itself
+func (p *Principal) Owns(id, pid int) bool {
+	switch p.Role {
+	case dbmodel.RoleRoot:
+		return true
+	case dbmodel.RoleAdmin:
+		return id == p.ID || pid == p.ID
+	default:
+		return id == p.ID
+	}
+}
+
+// authorizeAccount - look up the account and check the request principal
+// owns it, writing 404 or 403 otherwise
+func authorizeAccount(accountDBI dbi.AccountTblDBI, w http.ResponseWriter, r *http.Request, id int) (*dbmodel.AccountEntry, error) {
+	p := principalFrom(r)
+	if p == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return nil, fmt.Errorf("a valid session is required for %s", r.URL.Path)
+	}
+
+	account, err := accountDBI.GetAccountByID(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, err
+	}
+	if account == nil {
+		if !p.IsRoot() {
+			/* don't tell other tenants which IDs exist */
+			w.WriteHeader(http.StatusForbidden)
+			return nil, fmt.Errorf("account %d is not accessible", id)
+		}
+		w.WriteHeader(http.StatusNotFound)
+		return nil, fmt.Errorf("account %d does not exist", id)
+	}
+
+	if !p.Owns(account.ID, account.PID) {
+		w.WriteHeader(http.StatusForbidden)
+		return nil, fmt.Errorf("account %d is not accessible", id)
+	}
+	return account, nil
+}
+
+// authenticate - validate the bearer token against the Session table.
+// The session carries the account's ID, PID and Role, so the Account
+// table (and the password hash) is only touched at login.
+func authenticate(logObj *logger.Logger, sessionDBI dbi.SessionTblDBI, w http.ResponseWriter, r *http.Request) (*dbmodel.SessionEntry, error) {
+	token, err := bearerToken(r)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := sessionDBI.GetSession(hashToken(token))
+	if err != nil {
+		logObj.PrintError("Failed to look up session: %v", err)
+		return nil, err
+	}
+	if session == nil {
+		return nil, fmt.Errorf("session is expired or revoked")
+	}
+	return session, nil
+}
@@ -0,0 +1,292 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/msproject/relive/dbi"
+	"github.com/msproject/relive/dbmodel"
+	"github.com/msproject/relive/logger"
+	"github.com/msproject/relive/util"
+)
+
+// CatalogAPI struct - albums organizing media, nested by event
+type CatalogAPI struct {
+	CatalogDBI dbi.CatalogTblDBI
+	Media      MediaAPI // media lookups and playback URL signing
+	LogObj     *logger.Logger
+}
+
+// authorizeCatalog - the catalog of the {catalog} parameter if the caller
+// may access its account
+func authorizeCatalog(api CatalogAPI, w http.ResponseWriter, r *http.Request, catalogParam string) (*dbmodel.CatalogEntry, error) {
+	catalogID, err := strconv.Atoi(catalogParam)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, fmt.Errorf("invalid catalog id specified in request URL")
+	}
+
+	ct, err := api.CatalogDBI.GetCatalog(catalogID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, err
+	}
+	if ct == nil {
+		/* like accounts, only root learns which catalogs exist */
+		if principalFrom(r).IsRoot() {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusForbidden)
+		}
+		return nil, fmt.Errorf("catalog %d is not accessible", catalogID)
+	}
+
+	if _, err = authorizeAccount(api.Media.AccountDBI, w, r, ct.ID); err != nil {
+		return nil, err
+	}
+	return ct, nil
+}
+
+// catalogTree - IDs of the catalog root and all catalogs below it
+func catalogTree(catalogs []dbmodel.CatalogEntry, root int) []int {
+	tree := []int{root}
+	for i := 0; i < len(tree); i++ {
+		for _, ct := range catalogs {
+			if ct.ParentID == tree[i] {
+				tree = append(tree, ct.CatalogID)
+			}
+		}
+	}
+	return tree
+}
+
+// checkCatalog - validate name and parent of ct, and resolve cover, the
+// FileName of a media of the account, to the store key of its poster
+func checkCatalog(api CatalogAPI, w http.ResponseWriter, r *http.Request, ct *dbmodel.CatalogEntry, cover *string) error {
+	if ct.Name == "" || len(ct.Name) > maxCatalogLen {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("a catalog name of up to %d characters is required", maxCatalogLen)
+	}
+
+	if ct.ParentID != 0 {
+		catalogs, err := api.CatalogDBI.ListCatalogs(ct.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		found := false
+		for _, c := range catalogs {
+			found = found || c.CatalogID == ct.ParentID
+		}
+		if !found {
+			w.WriteHeader(http.StatusBadRequest)
+			return fmt.Errorf("parent catalog %d is no catalog of customer %d", ct.ParentID, ct.ID)
+		}
+		/* a catalog cannot move below itself */
+		if ct.CatalogID != 0 {
+			for _, c := range catalogTree(catalogs, ct.CatalogID) {
+				if c == ct.ParentID {
+					w.WriteHeader(http.StatusBadRequest)
+					return fmt.Errorf("catalog %d cannot be moved below itself", ct.CatalogID)
+				}
+			}
+		}
+	}
+
+	if cover != nil {
+		ct.Cover = ""
+		if *cover != "" {
+			found, err := api.Media.MediaDBI.SearchMediaTypeByID(principalFrom(r).Scope(), uint64(ct.ID), 0, *cover, false)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return err
+			}
+			if len(found) == 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				return fmt.Errorf("cover media %s of customer %d not found", *cover, ct.ID)
+			}
+			ct.Cover = found[0].Poster
+		}
+	}
+	return nil
+}
+
+// signCover - the cover is a store key, hand out a URL valid for a while
+func (api CatalogAPI) signCover(ct *dbmodel.CatalogEntry) {
+	if ct.Cover != "" {
+		ct.Cover = api.Media.signMediaKey(ct.Cover)
+	}
+}
+
+// POST /api/catalogs - create a catalog
+func handleCatalogCreate(api CatalogAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	var req util.CatalogReq
+	d := json.NewDecoder(r.Body)
+	if err := d.Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("Error decoding the request: %s", err.Error())
+	}
+
+	if _, err := authorizeAccount(api.Media.AccountDBI, w, r, req.ID); err != nil {
+		return err
+	}
+
+	ct := &dbmodel.CatalogEntry{
+		ID:        req.ID,
+		ParentID:  req.ParentID,
+		Name:      req.Name,
+		SortOrder: req.SortOrder,
+	}
+	if err := checkCatalog(api, w, r, ct, &req.Cover); err != nil {
+		return err
+	}
+
+	if err := api.CatalogDBI.CreateCatalog(ct); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/catalogs/%d", ct.CatalogID))
+	w.WriteHeader(http.StatusCreated)
+	api.signCover(ct)
+	return writeResponse(ct, w)
+}
+
+// GET /api/catalogs?id= - all catalogs of a customer; ParentID links
+// them to a tree
+func handleCatalogList(api CatalogAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("invalid customer id specified in request URL")
+	}
+
+	if _, err = authorizeAccount(api.Media.AccountDBI, w, r, id); err != nil {
+		return err
+	}
+
+	catalogs, err := api.CatalogDBI.ListCatalogs(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	for i := range catalogs {
+		api.signCover(&catalogs[i])
+	}
+	return writeResponse(catalogs, w)
+}
+
+// GET /api/catalogs/{catalog}
+func handleCatalogGet(api CatalogAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	ct, err := authorizeCatalog(api, w, r, params["catalog"])
+	if err != nil {
+		return err
+	}
+	api.signCover(ct)
+	return writeResponse(ct, w)
+}
+
+// PUT /api/catalogs/{catalog} - rename, move, re-sort or change the cover
+func handleCatalogUpdate(api CatalogAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	ct, err := authorizeCatalog(api, w, r, params["catalog"])
+	if err != nil {
+		return err
+	}
+
+	var req util.UpdateCatalogReq
+	d := json.NewDecoder(r.Body)
+	if err = d.Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("Error decoding the request: %s", err.Error())
+	}
+
+	if req.ParentID != nil {
+		ct.ParentID = *req.ParentID
+	}
+	if req.Name != nil {
+		ct.Name = *req.Name
+	}
+	if req.SortOrder != nil {
+		ct.SortOrder = *req.SortOrder
+	}
+	if err = checkCatalog(api, w, r, ct, req.Cover); err != nil {
+		return err
+	}
+
+	if err = api.CatalogDBI.UpdateCatalog(ct); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	api.signCover(ct)
+	return writeResponse(ct, w)
+}
+
+// DELETE /api/catalogs/{catalog} - drop a catalog without subcatalogs, its
+// media are kept uncataloged
+func handleCatalogDelete(api CatalogAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	ct, err := authorizeCatalog(api, w, r, params["catalog"])
+	if err != nil {
+		return err
+	}
+
+	catalogs, err := api.CatalogDBI.ListCatalogs(ct.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	if len(catalogTree(catalogs, ct.CatalogID)) > 1 {
+		w.WriteHeader(http.StatusConflict)
+		return fmt.Errorf("catalog %d still has subcatalogs", ct.CatalogID)
+	}
+
+	if err = api.CatalogDBI.DeleteCatalog(ct.CatalogID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// PUT /api/catalogs/{catalog}/order - order the media of a catalog, media
+// not listed go after the listed ones
+func handleCatalogOrder(api CatalogAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	ct, err := authorizeCatalog(api, w, r, params["catalog"])
+	if err != nil {
+		return err
+	}
+
+	var req util.CatalogOrderReq
+	d := json.NewDecoder(r.Body)
+	if err = d.Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("Error decoding the request: %s", err.Error())
+	}
+
+	if _, err = api.CatalogDBI.SetCatalogMediaOrder(ct.CatalogID, ct.ID, req.FileNames); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (api CatalogAPI) bind(f func(api CatalogAPI, params routeParams, w http.ResponseWriter, r *http.Request) error) routeHandler {
+	return func(params routeParams, w http.ResponseWriter, r *http.Request) error {
+		return f(api, params, w, r)
+	}
+}
+
+// routes - endpoints of the catalog API
+func (api CatalogAPI) routes() []route {
+	return []route{
+		{method: "POST", pattern: "/api/catalogs", roles: rootOrAdmin, handler: api.bind(handleCatalogCreate)},
+		{method: "GET", pattern: "/api/catalogs", roles: anyRole, handler: api.bind(handleCatalogList)},
+		{method: "GET", pattern: "/api/catalogs/{catalog}", roles: anyRole, handler: api.bind(handleCatalogGet)},
+		{method: "PUT", pattern: "/api/catalogs/{catalog}", roles: rootOrAdmin, handler: api.bind(handleCatalogUpdate)},
+		{method: "DELETE", pattern: "/api/catalogs/{catalog}", roles: rootOrAdmin, handler: api.bind(handleCatalogDelete)},
+		{method: "PUT", pattern: "/api/catalogs/{catalog}/order", roles: rootOrAdmin, handler: api.bind(handleCatalogOrder)},
+	}
+}
@@ -1,125 +1,288 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"mime"
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"regexp"
 	"strconv"
 	"strings"
-	"syscall"
+	"time"
 
 	"github.com/msproject/relive/dbi"
 	"github.com/msproject/relive/dbmodel"
+	"github.com/msproject/relive/egress"
 	"github.com/msproject/relive/logger"
+	"github.com/msproject/relive/mediakey"
+	"github.com/msproject/relive/mediastore"
+	"github.com/msproject/relive/playback"
+	"github.com/msproject/relive/quota"
+	"github.com/msproject/relive/transcode"
+	"github.com/msproject/relive/upload"
+	"github.com/msproject/relive/util"
 )
 
 // MediaAPI struct
 type MediaAPI struct {
-	MediaDBI   dbi.MediaTypeTblDBI
-	AccountDBI dbi.AccountTblDBI
-	LogObj     *logger.Logger
+	MediaDBI        dbi.MediaTypeTblDBI
+	AccountDBI      dbi.AccountTblDBI
+	CatalogDBI      dbi.CatalogTblDBI
+	SubscriptionDBI dbi.SubscriptionTblDBI
+	Store           mediastore.MediaStore
+	Jobs            *transcode.Queue
+	KeyDBI          dbi.MediaKeyTblDBI
+	GrantDBI        dbi.MediaGrantTblDBI
+	EgressDBI       dbi.EgressTblDBI
+	Keys            *mediakey.Wrapper // nil unless HLS encryption is on
+	Uploads         *upload.Manager
+	Quotas          *quota.Quotas
+	Egress          *egress.Meter
+	Signer          *playback.Signer
+	PublicURL       string // prefix of playback URLs, e.g. https://media.example.com
+	LogObj          *logger.Logger
 }
 
-func (api MediaAPI) generateJPG(absFileName, fullPath, fileName string) (err error) {
-	var ffmpegPath string
+const playPrefix = "/api/media/play/"
 
-	ffmpegPath, err = exec.LookPath("ffmpeg")
+// playbackURL - signed URL of a file of the media name of account id.
+// The signature is part of the path, so the relative variant playlist and
+// segment URIs of a playlist carry it too.
+func (api MediaAPI) playbackURL(id int, name, file string) string {
+	expires, sig := api.Signer.Sign(id, name)
+	return fmt.Sprintf("%s%s%d/%s/%d/%s/%s", api.PublicURL, playPrefix, id,
+		url.PathEscape(name), expires, sig, url.PathEscape(file))
+}
+
+// mediaStoreName - account id and name m is stored under
+func mediaStoreName(m dbmodel.MediaTypeEntry) (int, string) {
+	storedID, fName, _, ok := splitMediaKey(m.URL)
+	if !ok {
+		storedID, fName = m.ID, m.FileName[0:len(m.FileName)-len(filepath.Ext(m.FileName))]
+	}
+	return storedID, fName
+}
+
+// mediaStorePrefix - store prefix of m, its playlist, segments, posters and
+// the upload share it
+func mediaStorePrefix(m dbmodel.MediaTypeEntry) string {
+	storedID, fName := mediaStoreName(m)
+	return fmt.Sprintf("%d/%s/", storedID, fName)
+}
+
+// splitMediaKey - account id, media name and file of a media store key as
+// stored in MediaType. Rows written before playback was signed hold a full
+// play URL. The account is the uploader, media taken over by another owner
+// stay where they were stored.
+func splitMediaKey(stored string) (int, string, string, bool) {
+	if i := strings.Index(stored, playPrefix); i >= 0 {
+		stored = stored[i+len(playPrefix):]
+	}
+
+	parts := strings.Split(stored, "/")
+	if len(parts) != 3 {
+		return 0, "", "", false
+	}
+	id, err := strconv.Atoi(parts[0])
 	if err != nil {
-		return fmt.Errorf("error looking up path for ffmpeg :%s", err.Error())
+		return 0, "", "", false
 	}
+	return id, parts[1], parts[2], true
+}
 
-	jpgFileName := fmt.Sprintf("%s/%s.jpg", fullPath, fileName)
-	cmd := exec.Command(ffmpegPath,
-		"-ss", "00:00:03", "-i", absFileName,
-		"-vframes", "1", "-q:v", "5", jpgFileName)
+// signMediaKey - signed URL for a media store key as stored in MediaType
+func (api MediaAPI) signMediaKey(stored string) string {
+	id, name, file, ok := splitMediaKey(stored)
+	if !ok {
+		return ""
+	}
+	return api.playbackURL(id, name, file)
+}
 
-	err = cmd.Start()
+// catalogIDs - the catalog of customer id, and with tree the catalogs
+// below it. Empty if the customer has no such catalog.
+func (api MediaAPI) catalogIDs(id, catalogID int, tree bool) ([]int, error) {
+	catalogs, err := api.CatalogDBI.ListCatalogs(id)
 	if err != nil {
-		return fmt.Errorf("Cannot start command, err: %v", err)
+		return nil, err
 	}
 
-	if err = cmd.Wait(); err != nil {
-		if exiterr, ok := err.(*exec.ExitError); ok {
-			if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
-				api.LogObj.PrintInfo("Exit Status: ", status.ExitStatus())
-				if status.ExitStatus() != 0 {
-					return fmt.Errorf("Exit Status: %d", status.ExitStatus())
-				}
+	for _, ct := range catalogs {
+		if ct.CatalogID == catalogID {
+			if tree {
+				return catalogTree(catalogs, catalogID), nil
 			}
-		} else {
-			return fmt.Errorf("error in  cmd.Wait: %v", err)
+			return []int{catalogID}, nil
 		}
 	}
-	return nil
+	return nil, nil
+}
+
+// signMedia - MediaType holds store keys, hand out URLs valid for a while
+func (api MediaAPI) signMedia(mt *dbmodel.MediaTypeEntry) {
+	mt.URL = api.signMediaKey(mt.URL)
+	mt.Poster = api.signMediaKey(mt.Poster)
+	mt.Thumbnails = api.signMediaKey(mt.Thumbnails)
+	if mt.Download = api.signMediaKey(mt.Download); mt.Download != "" {
+		mt.Download += "?download=1"
+	}
+}
+
+// countingWriter - counts the body bytes written, for egress accounting
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(p)
+	c.n += int64(n)
+	return n, err
 }
 
-func (api MediaAPI) transcodeMedia(absFileName, fullPath, fileName string) (err error) {
-	var ffmpegPath string
-	m3u8FileName := fmt.Sprintf("%s/%s.m3u8", fullPath, fileName)
-	tsFileName := fmt.Sprintf("%s/%s%%d.ts", fullPath, fileName)
+// /api/media/play/{id}/{name}/{expires}/{sig}/{file} - public, the
+// signature grants access. Range requests are answered with ranged reads,
+// caches may keep files until the URL expires, and ?download=1 has
+// browsers save the file. The bytes served are counted for billing.
+func handleMediaPlayBack(api MediaAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
 
-	ffmpegPath, err = exec.LookPath("ffmpeg")
+	/* media is stored per customer: <id>/<name>/<file> */
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("invalid customer id specified in request URL")
+	}
+	expires, err := strconv.ParseInt(params["expires"], 10, 64)
 	if err != nil {
-		return fmt.Errorf("Error looking up path for ffmpeg :%s", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("invalid expiry specified in request URL")
 	}
 
-	cmd := exec.Command(ffmpegPath,
-		"-y", "-i", absFileName,
-		"-codec", "copy", "-bsf", "h264_mp4toannexb",
-		"-map", "0", "-f", "segment", "-segment_time", "10",
-		"-segment_format", "mpegts", "-segment_list", m3u8FileName,
-		"-segment_list_type", "m3u8", tsFileName)
+	if err = api.Signer.Verify(id, params["name"], expires, params["sig"]); err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		return err
+	}
 
-	err = cmd.Start()
+	key, err := mediastore.Resolve(id, params["name"], "", params["file"])
 	if err != nil {
-		return fmt.Errorf("Cannot start command, err: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return err
 	}
 
-	if err = cmd.Wait(); err != nil {
-		if exiterr, ok := err.(*exec.ExitError); ok {
-			if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
-				api.LogObj.PrintInfo("Exit Status: ", status.ExitStatus())
-				if status.ExitStatus() != 0 {
-					return fmt.Errorf("Exit Status: %d", status.ExitStatus())
-				}
-			}
-		} else {
-			return fmt.Errorf("error in  cmd.Wait: %v", err)
-		}
+	info, err := api.Store.Stat(r.Context(), key)
+	if err == mediastore.ErrNotExist {
+		w.WriteHeader(http.StatusNotFound)
+		return fmt.Errorf("%s not found", r.URL.Path)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	api.LogObj.PrintInfo("playing: %s", key)
+
+	/* ServeContent answers Range, If-None-Match and If-Range requests
+	 * with ranged reads of the store */
+	content := mediastore.NewReadSeeker(r.Context(), api.Store, key, info.Size)
+	defer content.Close()
+	w.Header().Set("Content-Type", info.ContentType)
+	w.Header().Set("Cache-Control", playback.CacheControl(params["file"], time.Until(time.Unix(expires, 0))))
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime.UnixNano(), info.Size))
+	if r.URL.Query().Get("download") != "" {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": params["file"]}))
 	}
+
+	cw := &countingWriter{ResponseWriter: w}
+	http.ServeContent(cw, r, params["file"], info.ModTime, content)
+	api.Egress.Add(id, params["name"], cw.n)
 	return nil
 }
 
-// /api/media/play
-func handleMediaPlayBack(api MediaAPI, args []string, w http.ResponseWriter, r *http.Request) error {
+// /api/media/key/{id}/{name} - AES-128 key of an encrypted asset, only
+// for accounts entitled to the media: those of the tenancy holding it and
+// those it was shared with. id is the account the asset is stored under.
+func handleMediaKey(api MediaAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("invalid customer id specified in request URL")
+	}
+
+	playlist, err := mediastore.Resolve(id, params["name"], "", params["name"]+".m3u8")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return err
+	}
+	mt, err := api.MediaDBI.GetMediaTypeByURL(playlist)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	if mt == nil {
+		/* like accounts, only root learns which media exist */
+		if principalFrom(r).IsRoot() {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusForbidden)
+		}
+		return fmt.Errorf("media %s of customer %d is not accessible", params["name"], id)
+	}
+	if mt, err = authorizeMedia(api, w, r, mt.ID, mt.FileName, dbmodel.GrantViewer); err != nil {
+		return err
+	}
+	if mt.DeletedAt != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return fmt.Errorf("media %s of customer %d is in the trash", params["name"], id)
+	}
+
+	mk, err := api.KeyDBI.GetMediaKey(id, params["name"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	if mk == nil || api.Keys == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return fmt.Errorf("no key for media %s of customer %d", params["name"], id)
+	}
 
-	fileToPlay := strings.TrimPrefix(args[0], "/api/media/play/")
-	fileToPlay = fmt.Sprintf("/tmp/%s", fileToPlay)
-	api.LogObj.PrintInfo("playing: %s", fileToPlay)
+	key, err := api.Keys.Unwrap(id, params["name"], mk.WrappedKey)
+	if err != nil {
+		api.LogObj.PrintError("%v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return fmt.Errorf("could not read media key")
+	}
 
-	http.ServeFile(w, r, fileToPlay)
-	return nil
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	_, err = w.Write(key)
+	return err
 }
 
 // /api/media/search
-func handleMediaSearch(api MediaAPI, args []string, w http.ResponseWriter, r *http.Request) error {
+func handleMediaSearch(api MediaAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	return searchMedia(api, w, r, false)
+}
+
+// searchMedia - live or trashed media of the customer given by the id
+// query parameter, optionally restricted by pid and filename. With
+// catalogid=<CatalogID> the media filed in the catalog are listed in catalog
+// order, with tree=true those of its subcatalogs too.
+func searchMedia(api MediaAPI, w http.ResponseWriter, r *http.Request, trashed bool) error {
 	var id, pid uint64
 	var fname string
+	var catalogID int
 	var err error
-	var parsedURLSuffix *url.URL
 	var result []dbmodel.MediaTypeEntry
 
-	URLSuffix := args[0]
-	parsedURLSuffix, err = url.Parse(URLSuffix)
-	params := parsedURLSuffix.Query()
+	query := r.URL.Query()
 
-	if len(params["id"]) > 0 {
-		id, err = strconv.ParseUint(params["id"][0], 10, 32)
+	if len(query["id"]) > 0 {
+		id, err = strconv.ParseUint(query["id"][0], 10, 32)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			return fmt.Errorf("invalid customer id specified in request URL")
@@ -129,25 +292,52 @@ func handleMediaSearch(api MediaAPI, args []string, w http.ResponseWriter, r *ht
 		return fmt.Errorf("required query parameters NOT specified in search request")
 	}
 
-	if len(params["pid"]) > 0 {
-		pid, err = strconv.ParseUint(params["pid"][0], 10, 32)
+	if len(query["pid"]) > 0 {
+		pid, err = strconv.ParseUint(query["pid"][0], 10, 32)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			return fmt.Errorf("invalid parent id specified in request URL")
 		}
 	}
 
-	if len(params["filename"]) > 0 {
-		fname = params["filename"][0]
+	if len(query["filename"]) > 0 {
+		fname = query["filename"][0]
+	}
+
+	if len(query["catalogid"]) > 0 {
+		catalogID, err = strconv.Atoi(query["catalogid"][0])
+		if err != nil || catalogID <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return fmt.Errorf("invalid catalog specified in request URL")
+		}
+	}
+
+	_, err = authorizeAccount(api.AccountDBI, w, r, int(id))
+	if err != nil {
+		return err
 	}
 
-	result, err = api.MediaDBI.SearchMediaTypeByID(id, pid, fname)
+	if catalogID > 0 {
+		var catalogIDs []int
+		catalogIDs, err = api.catalogIDs(int(id), catalogID, query.Get("tree") == "true")
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return err
+		}
+		result, err = api.MediaDBI.SearchMediaByCatalog(principalFrom(r).Scope(), id, catalogIDs, trashed)
+	} else {
+		result, err = api.MediaDBI.SearchMediaTypeByID(principalFrom(r).Scope(), id, pid, fname, trashed)
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintln(w, err)
 		return err
 	}
 
+	for i := range result {
+		api.signMedia(&result[i])
+	}
+
 	err = writeResponse(result, w)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -157,33 +347,34 @@ func handleMediaSearch(api MediaAPI, args []string, w http.ResponseWriter, r *ht
 }
 
 // /api/media/store
-func handleMediaStore(api MediaAPI, args []string, w http.ResponseWriter, r *http.Request) error {
+func handleMediaStore(api MediaAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
 
 	var id uint64
 	var err error
-	var parsedURLSuffix *url.URL
-	var catalog, title string
+	var catalog, title, description string
 
-	URLSuffix := args[0]
-	parsedURLSuffix, err = url.Parse(URLSuffix)
-	params := parsedURLSuffix.Query()
+	query := r.URL.Query()
 
-	if len(params["catalog"]) > 0 {
-		catalog = params["catalog"][0]
+	if len(query["catalog"]) > 0 {
+		catalog = query["catalog"][0]
 	} else {
 		w.WriteHeader(http.StatusBadRequest)
 		return fmt.Errorf("invalid catalog specified in request URL")
 	}
 
-	if len(params["title"]) > 0 {
-		title = params["title"][0]
+	if len(query["title"]) > 0 {
+		title = query["title"][0]
 	} else {
 		w.WriteHeader(http.StatusBadRequest)
 		return fmt.Errorf("invalid title specified in request URL")
 	}
 
-	if len(params["id"]) > 0 {
-		id, err = strconv.ParseUint(params["id"][0], 10, 32)
+	if len(query["description"]) > 0 {
+		description = query["description"][0]
+	}
+
+	if len(query["id"]) > 0 {
+		id, err = strconv.ParseUint(query["id"][0], 10, 32)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			return fmt.Errorf("invalid customer id specified in request URL")
@@ -193,10 +384,16 @@ func handleMediaStore(api MediaAPI, args []string, w http.ResponseWriter, r *htt
 		return fmt.Errorf("required query parameters NOT specified in search request")
 	}
 
-	err = api.AccountDBI.CheckAccountExistsByID(id)
+	_, err = authorizeAccount(api.AccountDBI, w, r, int(id))
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		return fmt.Errorf("Cannot upload media to unknown customer")
+		return fmt.Errorf("Cannot upload media to customer %d: %v", id, err)
+	}
+
+	/* refuse what cannot fit before reading it, the form is a bit larger than the file */
+	if r.ContentLength > 0 {
+		if err = checkQuota(api, w, int(id), r.ContentLength); err != nil {
+			return err
+		}
 	}
 
 	r.ParseMultipartForm(32 << 20)
@@ -209,121 +406,321 @@ func handleMediaStore(api MediaAPI, args []string, w http.ResponseWriter, r *htt
 
 	defer file.Close()
 
-	fExt := filepath.Ext(header.Filename)
-	fName := header.Filename[0 : len(header.Filename)-len(fExt)]
+	if err = checkNewFileName(api, w, int(id), header.Filename); err != nil {
+		return err
+	}
 
-	outfilePath := fmt.Sprintf("/tmp/%s/%s", params["id"][0], fName)
-	err = os.MkdirAll(outfilePath, os.ModePerm)
+	/* ffprobe needs a file it can seek in */
+	tmp, err := ioutil.TempFile("", "relive-upload-")
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintln(w, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return err
 	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
 
-	outfileName := fmt.Sprintf("%s/%s", outfilePath, header.Filename)
-	out, err := os.Create(outfileName)
+	if _, err = io.Copy(tmp, file); err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
 	if err != nil {
-		fmt.Fprintf(w, "Unable to create the file for writing. Check your write access privilege")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return err
 	}
 
-	defer out.Close()
+	if r.ContentLength <= 0 {
+		if err = checkQuota(api, w, int(id), header.Size); err != nil {
+			return err
+		}
+	}
 
-	// write the content from POST to the file
-	_, err = io.Copy(out, file)
+	err = checkMedia(r.Context(), tmp.Name())
+	if errors.Is(err, transcode.ErrUnsupported) {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return err
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintln(w, err)
-		return fmt.Errorf("Cannot upload requested Object: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
 	}
 
-	//file upload complete. transcode the file for smooth playback.
-	err = api.transcodeMedia(outfileName, outfilePath, fName)
+	job, err := api.storeAndTranscode(r.Context(), &dbmodel.TranscodeJobEntry{
+		ID:          int(id),
+		Catalog:     catalog,
+		Title:       title,
+		Description: description,
+		FileName:    header.Filename,
+	}, tmp, header.Size)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintln(w, err)
-		return fmt.Errorf("Cannot transcode Media file: %v", err)
+		return err
 	}
 
-	err = api.generateJPG(outfileName, outfilePath, fName)
+	w.Header().Set("Location", fmt.Sprintf("/api/media/jobs/%d", job.JobID))
+	w.WriteHeader(http.StatusAccepted)
+	return writeResponse(api.newMediaJobResp(job), w)
+}
+
+// checkNewFileName - the file name of an upload names the media to the
+// client only, it must be a plain name no media of account id has yet.
+// Writes 400 or 409 otherwise.
+func checkNewFileName(api MediaAPI, w http.ResponseWriter, id int, fileName string) error {
+	if err := mediastore.CheckName(fileName); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("invalid file name %q", fileName)
+	}
+
+	mt, err := api.MediaDBI.GetMediaType(id, fileName)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintln(w, err)
-		return fmt.Errorf("Cannot generate jpg from Media file: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	if mt != nil {
+		w.WriteHeader(http.StatusConflict)
+		return fmt.Errorf("customer %d already has media %s", id, fileName)
 	}
+	return nil
+}
 
-	/*update DB only if all of the above succeeds */
-	mediaURL := fmt.Sprintf("http://localhost:9999/api/media/play/%s/%s/%s.m3u8", params["id"][0], fName, fName)
-	jpgURL := fmt.Sprintf("http://localhost:9999/api/media/play/%s/%s/%s.jpg", params["id"][0], fName, fName)
-	mDetails := &dbmodel.MediaTypeEntry{
-		ID:          int(id),
-		Catalog:     catalog,
-		FileName:    header.Filename,
-		Title:       title,
-		Description: title,
-		URL:         mediaURL,
-		Poster:      jpgURL,
+// subscribedRenditions - the ladder media of account id are transcoded
+// with: that of the latest running subscription of its business naming
+// one, "" for the server's
+func (api MediaAPI) subscribedRenditions(id int) (string, error) {
+	account, err := api.AccountDBI.GetAccountByID(id)
+	if err != nil || account == nil {
+		return "", err
+	}
+	business := account.ID
+	if account.Role == dbmodel.RoleCustomer && account.PID != 0 {
+		business = account.PID
 	}
 
-	err = api.MediaDBI.AddMediaType(mDetails)
+	subs, err := api.SubscriptionDBI.ListRunningSubscriptions(business)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintln(w, err)
-		return fmt.Errorf("Cannot upload requested Object: %v", err)
+		return "", err
+	}
+	renditions := ""
+	for _, sub := range subs {
+		if sub.Renditions != "" {
+			renditions = sub.Renditions
+		}
+	}
+	return renditions, nil
+}
+
+// storeAndTranscode - put an uploaded file into the media store and queue
+// its transcoding as job, which names account, catalog, title, description
+// and file name. The files are stored below a generated asset ID, never
+// below the file name, and transcoded with the renditions subscribed to.
+// The MediaType row is added once transcoding succeeded; poll the returned
+// job for progress.
+func (api MediaAPI) storeAndTranscode(ctx context.Context, job *dbmodel.TranscodeJobEntry,
+	src io.Reader, size int64) (*dbmodel.TranscodeJobEntry, error) {
+	asset, err := mediastore.NewAssetID()
+	if err != nil {
+		return nil, err
+	}
+	if job.Renditions, err = api.subscribedRenditions(job.ID); err != nil {
+		return nil, err
+	}
+	if job.SrcKey, err = mediastore.Resolve(job.ID, asset, "", mediastore.SourceFile(job.FileName)); err != nil {
+		return nil, err
+	}
+	job.SrcSize = size
+
+	err = api.Store.Put(ctx, job.SrcKey, src, size, mediastore.ContentType(job.FileName))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot upload requested Object: %v", err)
+	}
+
+	job.OutPrefix = fmt.Sprintf("%d/%s", job.ID, asset)
+	job.BaseName = asset
+	job.URL = mediastore.Key(job.ID, asset, asset+".m3u8")
+	job.Poster = mediastore.Key(job.ID, asset, transcode.PosterFile(asset, 1))
+	if err = api.Jobs.Enqueue(job); err != nil {
+		return nil, fmt.Errorf("Cannot queue transcoding of Media file: %v", err)
+	}
+	return job, nil
+}
+
+// checkMedia - transcode.ErrUnsupported unless the local file path is
+// media in a container and codecs we transcode
+func checkMedia(ctx context.Context, path string) error {
+	info, err := transcode.Probe(ctx, path)
+	if err != nil {
+		return err
+	}
+	return transcode.CheckSupported(info)
+}
+
+// decodeMediaRef - the media named by ID and FileName of a JSON body, if
+// the caller owns it
+func decodeMediaRef(api MediaAPI, w http.ResponseWriter, r *http.Request) (*dbmodel.MediaTypeEntry, error) {
+	// decode the JSON against the structure
+	var req dbmodel.MediaTypeEntry
+	d := json.NewDecoder(r.Body)
+	if err := d.Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, fmt.Errorf("Error decoding the request: %s", err.Error())
+	}
+
+	if req.ID == 0 || req.FileName == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, fmt.Errorf("required parameters ID and FileName NOT specified in request")
+	}
+
+	return authorizeMedia(api, w, r, req.ID, req.FileName, dbmodel.GrantOwner)
+}
+
+// /api/media/delete - remove the media, live or trashed, and everything
+// stored for it for good
+func handleMediaDelete(api MediaAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	req, err := decodeMediaRef(api, w, r)
+	if err != nil {
+		return err
 	}
 
-	fmt.Fprintf(w, "File uploaded successfully : ")
-	fmt.Fprintf(w, header.Filename)
+	n, err := api.MediaDBI.DeleteMediaType(req.ID, req.FileName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	if n == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return fmt.Errorf("media %s of customer %d not found", req.FileName, req.ID)
+	}
+
+	if err = api.purgeMedia(r.Context(), *req); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
 	return nil
 }
 
-type mediaT struct {
-	regex string
-	re    *regexp.Regexp
-	f     func(api MediaAPI, args []string, w http.ResponseWriter, r *http.Request) error
+// purgeMedia - delete the files and the key of m, whose row is gone
+func (api MediaAPI) purgeMedia(ctx context.Context, m dbmodel.MediaTypeEntry) error {
+	storedID, fName := mediaStoreName(m)
+	if err := mediastore.DeletePrefix(ctx, api.Store, mediaStorePrefix(m)); err != nil {
+		return err
+	}
+	return api.KeyDBI.DeleteMediaKey(storedID, fName)
+}
+
+// /api/media/jobs/{job}
+func handleMediaJobStatus(api MediaAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	job, err := authorizeJob(api, w, r, params["job"])
+	if err != nil {
+		return err
+	}
+	return writeResponse(api.newMediaJobResp(job), w)
 }
 
-var media []mediaT
+// /api/media/jobs/{job}/cancel
+func handleMediaJobCancel(api MediaAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	job, err := authorizeJob(api, w, r, params["job"])
+	if err != nil {
+		return err
+	}
 
-func (api MediaAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	for _, d := range media {
-		if d.re.MatchString(r.URL.String()) {
-			err := d.f(api, d.re.FindStringSubmatch(r.URL.String()), w, r)
-			if err != nil {
-				returnMessage := fmt.Sprintf("%v", err)
-				w.Write([]byte(returnMessage))
-			}
-			return
+	canceled, err := api.Jobs.Cancel(job.JobID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	if !canceled {
+		w.WriteHeader(http.StatusConflict)
+		return fmt.Errorf("transcode job %d already ended", job.JobID)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// authorizeJob - the job, if the caller may act on the account it uploads to
+func authorizeJob(api MediaAPI, w http.ResponseWriter, r *http.Request, jobParam string) (*dbmodel.TranscodeJobEntry, error) {
+	jobID, err := strconv.Atoi(jobParam)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, fmt.Errorf("invalid job id specified in request URL")
+	}
+
+	job, err := api.Jobs.Job(jobID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, err
+	}
+	if job == nil {
+		/* like accounts, only root learns which jobs exist */
+		if principalFrom(r).IsRoot() {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusForbidden)
 		}
+		return nil, fmt.Errorf("transcode job %d is not accessible", jobID)
+	}
+
+	if _, err = authorizeAccount(api.AccountDBI, w, r, job.ID); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func (api MediaAPI) newMediaJobResp(job *dbmodel.TranscodeJobEntry) util.MediaJobResp {
+	resp := util.MediaJobResp{
+		JobID:     job.JobID,
+		ID:        job.ID,
+		FileName:  job.FileName,
+		Title:     job.Title,
+		Status:    job.Status,
+		Step:      job.Step,
+		Attempts:  job.Attempts,
+		LastError: job.LastError,
+		NextRunAt: job.NextRunAt,
+		CreatedAt: job.CreatedAt,
+		UpdatedAt: job.UpdatedAt,
+	}
+	if job.Status == dbmodel.JobDone {
+		resp.URL = api.signMediaKey(job.URL)
+		resp.Poster = api.signMediaKey(job.Poster)
+	}
+	return resp
+}
+
+// bind - adapt a handler of this API to a routeHandler
+func (api MediaAPI) bind(f func(api MediaAPI, params routeParams, w http.ResponseWriter, r *http.Request) error) routeHandler {
+	return func(params routeParams, w http.ResponseWriter, r *http.Request) error {
+		return f(api, params, w, r)
 	}
-	w.WriteHeader(http.StatusBadRequest)
-	w.Write([]byte("No match found.\n"))
 }
 
-func init() {
-	var regex string
-	regex = "/api/media/store\\?([^/]+)$"
-	media = append(media,
-		mediaT{
-			regex: regex,
-			re:    regexp.MustCompile(regex),
-			f:     handleMediaStore,
-		},
-	)
-	regex = "/api/media/search\\?([^/]+)$"
-	media = append(media,
-		mediaT{
-			regex: regex,
-			re:    regexp.MustCompile(regex),
-			f:     handleMediaSearch,
-		},
-	)
-	regex = "/api/media/play/([^/]+)/([^/]+)/([^/]+)$"
-	media = append(media,
-		mediaT{
-			regex: regex,
-			re:    regexp.MustCompile(regex),
-			f:     handleMediaPlayBack,
-		},
-	)
+// routes - endpoints of the media API
+func (api MediaAPI) routes() []route {
+	return []route{
+		{method: "POST", pattern: "/api/media/store", roles: rootOrAdmin, handler: api.bind(handleMediaStore)},
+		{method: "DELETE", pattern: "/api/media/delete", roles: anyRole, handler: api.bind(handleMediaDelete)},
+		{method: "GET", pattern: "/api/media/search", roles: anyRole, handler: api.bind(handleMediaSearch)},
+		{method: "GET", pattern: "/api/media/shared", roles: anyRole, handler: api.bind(handleMediaShared)},
+		{method: "GET", pattern: "/api/media/usage", roles: anyRole, handler: api.bind(handleStorageUsage)},
+		{method: "GET", pattern: "/api/media/egress", roles: anyRole, handler: api.bind(handleEgressReport)},
+		{method: "PUT", pattern: "/api/media/update", roles: anyRole, handler: api.bind(handleMediaUpdate)},
+		{method: "GET", pattern: "/api/media/posters", roles: anyRole, handler: api.bind(handleMediaPosterList)},
+		{method: "PUT", pattern: "/api/media/posters", roles: anyRole, handler: api.bind(handleMediaPosterSelect)},
+		{method: "POST", pattern: "/api/media/move", roles: rootOrAdmin, handler: api.bind(handleMediaMove)},
+		{method: "GET", pattern: "/api/media/trash", roles: rootOrAdmin, handler: api.bind(handleMediaTrashList)},
+		{method: "POST", pattern: "/api/media/trash", roles: anyRole, handler: api.bind(handleMediaTrash)},
+		{method: "POST", pattern: "/api/media/restore", roles: anyRole, handler: api.bind(handleMediaRestore)},
+		{method: "GET", pattern: "/api/media/grants", roles: anyRole, handler: api.bind(handleMediaGrantList)},
+		{method: "PUT", pattern: "/api/media/grants", roles: anyRole, handler: api.bind(handleMediaGrantPut)},
+		{method: "DELETE", pattern: "/api/media/grants", roles: anyRole, handler: api.bind(handleMediaGrantDelete)},
+		{method: "GET", pattern: "/api/media/play/{id}/{name}/{expires}/{sig}/{file}", roles: nil, handler: api.bind(handleMediaPlayBack)},
+		{method: "GET", pattern: "/api/media/key/{id}/{name}", roles: anyRole, handler: api.bind(handleMediaKey)},
+		{method: "POST", pattern: uploadsPath, roles: rootOrAdmin, handler: api.bind(handleUploadCreate)},
+		{method: "HEAD", pattern: uploadsPath + "/{upload}", roles: rootOrAdmin, handler: api.bind(handleUploadHead)},
+		{method: "PATCH", pattern: uploadsPath + "/{upload}", roles: rootOrAdmin, handler: api.bind(handleUploadPatch)},
+		{method: "DELETE", pattern: uploadsPath + "/{upload}", roles: rootOrAdmin, handler: api.bind(handleUploadTerminate)},
+		{method: "GET", pattern: "/api/media/jobs/{job}", roles: anyRole, handler: api.bind(handleMediaJobStatus)},
+		{method: "POST", pattern: "/api/media/jobs/{job}/cancel", roles: rootOrAdmin, handler: api.bind(handleMediaJobCancel)},
+	}
 }
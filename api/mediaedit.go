@@ -0,0 +1,244 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+
+	"github.com/msproject/relive/dbmodel"
+	"github.com/msproject/relive/mediastore"
+	"github.com/msproject/relive/transcode"
+	"github.com/msproject/relive/util"
+)
+
+/* maximum lengths of the MediaType columns a client may set */
+const (
+	maxCatalogLen     = 256
+	maxTitleLen       = 100
+	maxDescriptionLen = 4096
+)
+
+// /api/media/update - change catalog, title, description or poster of a
+// live media
+func handleMediaUpdate(api MediaAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	var req util.UpdateMediaReq
+	d := json.NewDecoder(r.Body)
+	if err := d.Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("Error decoding the request: %s", err.Error())
+	}
+
+	if req.ID == 0 || req.FileName == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("required parameters ID and FileName NOT specified in update request")
+	}
+
+	found, err := authorizeMedia(api, w, r, req.ID, req.FileName, dbmodel.GrantEditor)
+	if err != nil {
+		return err
+	}
+	if found.DeletedAt != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return fmt.Errorf("media %s of customer %d not found", req.FileName, req.ID)
+	}
+
+	mt := *found
+	if req.Catalog != nil {
+		mt.Catalog = *req.Catalog
+	}
+	if req.Title != nil {
+		mt.Title = *req.Title
+	}
+	if req.Description != nil {
+		mt.Description = *req.Description
+	}
+	if mt.Catalog == "" || len(mt.Catalog) > maxCatalogLen || mt.Title == "" || len(mt.Title) > maxTitleLen ||
+		len(mt.Description) > maxDescriptionLen {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("catalog and title are required, up to %d and %d characters, description up to %d",
+			maxCatalogLen, maxTitleLen, maxDescriptionLen)
+	}
+
+	if req.Poster != nil {
+		storedID, asset, _, _ := splitMediaKey(mt.URL)
+		poster, err := mediastore.Resolve(storedID, asset, "", *req.Poster)
+		if err != nil || filepath.Ext(poster) != ".jpg" {
+			w.WriteHeader(http.StatusBadRequest)
+			return fmt.Errorf("invalid poster %q", *req.Poster)
+		}
+		if _, err = api.Store.Stat(r.Context(), poster); err == mediastore.ErrNotExist {
+			w.WriteHeader(http.StatusBadRequest)
+			return fmt.Errorf("poster %q does not exist", *req.Poster)
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+		mt.Poster = poster
+	}
+
+	if _, err = api.MediaDBI.UpdateMediaType(&mt); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+
+	api.signMedia(&mt)
+	return writeResponse(mt, w)
+}
+
+// posterKey - store key of poster candidate i of the media mt, next to its
+// playlist
+func posterKey(mt *dbmodel.MediaTypeEntry, i int) string {
+	storedID, name, _, ok := splitMediaKey(mt.URL)
+	if !ok {
+		return ""
+	}
+	key, err := mediastore.Resolve(storedID, name, "", transcode.PosterFile(name, i))
+	if err != nil {
+		return ""
+	}
+	return key
+}
+
+// GET /api/media/posters?id=&filename= - the poster candidates the media
+// pipeline sampled across a media
+func handleMediaPosterList(api MediaAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	id, err := strconv.Atoi(query.Get("id"))
+	if err != nil || query.Get("filename") == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("required query parameters id and filename NOT specified in request")
+	}
+
+	mt, err := authorizeMedia(api, w, r, id, query.Get("filename"), dbmodel.GrantViewer)
+	if err != nil {
+		return err
+	}
+	if mt.DeletedAt != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return fmt.Errorf("media %s of customer %d not found", mt.FileName, id)
+	}
+
+	var posters []util.MediaPosterResp
+	for i := 1; i <= mt.Posters; i++ {
+		key := posterKey(mt, i)
+		posters = append(posters, util.MediaPosterResp{Index: i, URL: api.signMediaKey(key), Selected: key == mt.Poster})
+	}
+	return writeResponse(posters, w)
+}
+
+// PUT /api/media/posters - make one of the poster candidates the poster
+func handleMediaPosterSelect(api MediaAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	var req util.SelectPosterReq
+	d := json.NewDecoder(r.Body)
+	if err := d.Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("Error decoding the request: %s", err.Error())
+	}
+
+	if req.ID == 0 || req.FileName == "" || req.Index < 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("required parameters ID, FileName and Index NOT specified in poster request")
+	}
+
+	found, err := authorizeMedia(api, w, r, req.ID, req.FileName, dbmodel.GrantEditor)
+	if err != nil {
+		return err
+	}
+	if found.DeletedAt != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return fmt.Errorf("media %s of customer %d not found", req.FileName, req.ID)
+	}
+	if req.Index > found.Posters {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("media %s has %d poster candidates, not %d", req.FileName, found.Posters, req.Index)
+	}
+
+	mt := *found
+	mt.Poster = posterKey(found, req.Index)
+	if _, err = api.MediaDBI.UpdateMediaType(&mt); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+
+	api.signMedia(&mt)
+	return writeResponse(mt, w)
+}
+
+// /api/media/move - move media of a customer to another catalog
+func handleMediaMove(api MediaAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	var req util.MoveMediaReq
+	d := json.NewDecoder(r.Body)
+	if err := d.Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("Error decoding the request: %s", err.Error())
+	}
+
+	if req.ID == 0 || len(req.FileNames) == 0 || (req.CatalogID == 0 && req.Catalog == "") || len(req.Catalog) > maxCatalogLen {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("required parameters ID, FileNames and Catalog or CatalogID NOT specified in move request")
+	}
+
+	if _, err := authorizeAccount(api.AccountDBI, w, r, req.ID); err != nil {
+		return err
+	}
+
+	/* filed in a catalog entity, the media carry its name */
+	if req.CatalogID != 0 {
+		ct, err := api.CatalogDBI.GetCatalog(req.CatalogID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+		if ct == nil || ct.ID != req.ID {
+			w.WriteHeader(http.StatusBadRequest)
+			return fmt.Errorf("catalog %d is no catalog of customer %d", req.CatalogID, req.ID)
+		}
+		req.Catalog = ct.Name
+	}
+
+	n, err := api.MediaDBI.MoveMediaTypes(req.ID, req.FileNames, req.Catalog, req.CatalogID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	return writeResponse(util.MoveMediaResp{Moved: n}, w)
+}
+
+// GET /api/media/trash - media of a customer in the trash
+func handleMediaTrashList(api MediaAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	return searchMedia(api, w, r, true)
+}
+
+// POST /api/media/trash - hide a media from search until it is restored or
+// deleted, the stored files are kept. Playback URLs handed out before stay
+// valid until they expire.
+func handleMediaTrash(api MediaAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	return setMediaTrashed(api, w, r, api.MediaDBI.TrashMediaType)
+}
+
+// /api/media/restore - bring a media back from the trash
+func handleMediaRestore(api MediaAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	return setMediaTrashed(api, w, r, api.MediaDBI.RestoreMediaType)
+}
+
+func setMediaTrashed(api MediaAPI, w http.ResponseWriter, r *http.Request, move func(id int, fileName string) (int64, error)) error {
+	req, err := decodeMediaRef(api, w, r)
+	if err != nil {
+		return err
+	}
+
+	n, err := move(req.ID, req.FileName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	if n == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return fmt.Errorf("media %s of customer %d not found", req.FileName, req.ID)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/msproject/relive/dbmodel"
+	"github.com/msproject/relive/util"
+)
+
+/* MediaGrant roles, each including the rights of those before */
+var grantRank = map[string]int{
+	dbmodel.GrantViewer: 1,
+	dbmodel.GrantEditor: 2,
+	dbmodel.GrantOwner:  3,
+}
+
+// mediaRole - the role the request principal has on the media fileName of
+// account id: root, and a business for its own and its customers' media,
+// are owners, a customer views its own media. A grant may add to that.
+func mediaRole(api MediaAPI, r *http.Request, id int, fileName string) (string, error) {
+	p := principalFrom(r)
+	if p.IsRoot() {
+		return dbmodel.GrantOwner, nil
+	}
+
+	role := ""
+	account, err := api.AccountDBI.GetAccountByID(id)
+	if err != nil {
+		return "", err
+	}
+	if account != nil && p.Owns(account.ID, account.PID) {
+		role = dbmodel.GrantOwner
+		if p.Role == dbmodel.RoleCustomer {
+			role = dbmodel.GrantViewer
+		}
+	}
+	if role == dbmodel.GrantOwner {
+		return role, nil
+	}
+
+	grant, err := api.GrantDBI.GetMediaGrant(id, fileName, p.ID)
+	if err != nil {
+		return "", err
+	}
+	if grant != nil && grantRank[grant.Role] > grantRank[role] {
+		role = grant.Role
+	}
+	return role, nil
+}
+
+// authorizeMedia - the media, live or trashed, if the request principal
+// has at least role need on it, writing 403 or 404 otherwise
+func authorizeMedia(api MediaAPI, w http.ResponseWriter, r *http.Request, id int, fileName, need string) (*dbmodel.MediaTypeEntry, error) {
+	role, err := mediaRole(api, r, id, fileName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, err
+	}
+	if grantRank[role] < grantRank[need] {
+		w.WriteHeader(http.StatusForbidden)
+		return nil, fmt.Errorf("media %s of customer %d is not accessible", fileName, id)
+	}
+
+	mt, err := api.MediaDBI.GetMediaType(id, fileName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, err
+	}
+	if mt == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return nil, fmt.Errorf("media %s of customer %d not found", fileName, id)
+	}
+	return mt, nil
+}
+
+// GET /api/media/grants?id=&filename= - the grants on a media
+func handleMediaGrantList(api MediaAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	id, err := strconv.Atoi(query.Get("id"))
+	if err != nil || query.Get("filename") == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("required query parameters id and filename NOT specified in request")
+	}
+
+	if _, err = authorizeMedia(api, w, r, id, query.Get("filename"), dbmodel.GrantOwner); err != nil {
+		return err
+	}
+
+	grants, err := api.GrantDBI.ListMediaGrants(id, query.Get("filename"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	return writeResponse(grants, w)
+}
+
+// PUT /api/media/grants - grant an account a role on a media, replacing
+// the role granted before. Media can be shared with the accounts the caller
+// acts for, a business with its customers.
+func handleMediaGrantPut(api MediaAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	var req util.MediaGrantReq
+	d := json.NewDecoder(r.Body)
+	if err := d.Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("Error decoding the request: %s", err.Error())
+	}
+
+	if req.ID == 0 || req.FileName == "" || req.AccountID == 0 || grantRank[req.Role] == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("required parameters ID, FileName, AccountID and Role NOT specified in grant request")
+	}
+	if req.AccountID == req.ID {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("account %d already holds media %s", req.ID, req.FileName)
+	}
+
+	if _, err := authorizeMedia(api, w, r, req.ID, req.FileName, dbmodel.GrantOwner); err != nil {
+		return err
+	}
+	if _, err := authorizeAccount(api.AccountDBI, w, r, req.AccountID); err != nil {
+		return err
+	}
+
+	grant := dbmodel.MediaGrantEntry{OwnerID: req.ID, FileName: req.FileName, AccountID: req.AccountID, Role: req.Role}
+	if err := api.GrantDBI.PutMediaGrant(&grant); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	return writeResponse(grant, w)
+}
+
+// DELETE /api/media/grants - revoke a grant, by an owner of the media or
+// by the account it was granted to
+func handleMediaGrantDelete(api MediaAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	var req util.MediaGrantReq
+	d := json.NewDecoder(r.Body)
+	if err := d.Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("Error decoding the request: %s", err.Error())
+	}
+
+	if req.ID == 0 || req.FileName == "" || req.AccountID == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("required parameters ID, FileName and AccountID NOT specified in revoke request")
+	}
+
+	if req.AccountID != principalFrom(r).ID {
+		if _, err := authorizeMedia(api, w, r, req.ID, req.FileName, dbmodel.GrantOwner); err != nil {
+			return err
+		}
+	}
+
+	n, err := api.GrantDBI.DeleteMediaGrant(req.ID, req.FileName, req.AccountID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	if n == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return fmt.Errorf("account %d has no grant on media %s of customer %d", req.AccountID, req.FileName, req.ID)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// GET /api/media/shared - live media other accounts shared with the caller
+func handleMediaShared(api MediaAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	result, err := api.GrantDBI.SearchSharedMedia(principalFrom(r).ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+
+	for i := range result {
+		api.signMedia(&result[i])
+	}
+	return writeResponse(result, w)
+}
@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"regexp"
 	"strconv"
 
 	"github.com/msproject/relive/dbi"
@@ -20,25 +19,21 @@ type PaymentAPI struct {
 	LogObj            *logger.Logger
 }
 
-//	/api/payment/search
-func handlePaymentSearch(api PaymentAPI, args []string, w http.ResponseWriter, r *http.Request) error {
-	// check for API Method
-	if r.Method != "GET" {
-		w.Header().Set("Allow", "GET")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return fmt.Errorf("Incorrect Method used for API /api/payment/search")
-	}
-
-	ID := args[1]
-	idInt, errs := strconv.Atoi(ID)
+//	/api/payment/search?id=<account>
+func handlePaymentSearch(api PaymentAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	idInt, errs := strconv.Atoi(r.URL.Query().Get("id"))
 
 	if errs != nil {
-		http.Error(w, errs.Error(), http.StatusInternalServerError)
-		return errs
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("invalid account id specified in request URL")
+	}
+
+	if err := authorizePayment(w, r, idInt); err != nil {
+		return err
 	}
 
 	var pays []util.PaymentDetails
-	pays, err := api.PaymentDBI.SearchPayment(idInt)
+	pays, err := api.PaymentDBI.SearchPayment(principalFrom(r).Scope(), idInt)
 
 	jsonStr, err := json.Marshal(pays)
 	fmt.Println("json: ", jsonStr)
@@ -63,14 +58,7 @@ func handlePaymentSearch(api PaymentAPI, args []string, w http.ResponseWriter, r
 }
 
 // /api/payment/do
-func handlePaymentDo(api PaymentAPI, args []string, w http.ResponseWriter, r *http.Request) error {
-
-	// check for API Method
-	if r.Method != "POST" {
-		w.Header().Set("Allow", "POST")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return fmt.Errorf("Incorrect Method used for API /api/payment/do")
-	}
+func handlePaymentDo(api PaymentAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
 
 	// decode the JSON against the structure
 	var req *dbmodel.PaymentEntry
@@ -80,6 +68,10 @@ func handlePaymentDo(api PaymentAPI, args []string, w http.ResponseWriter, r *ht
 		return fmt.Errorf("Error decoding the request: %s", err.Error())
 	}
 
+	if err := authorizePayment(w, r, req.ID); err != nil {
+		return err
+	}
+
 	err := api.PaymentDBI.AddPayment(req)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -91,14 +83,7 @@ func handlePaymentDo(api PaymentAPI, args []string, w http.ResponseWriter, r *ht
 }
 
 // /api/payment/update -
-func handlePaymentUpdate(api PaymentAPI, args []string, w http.ResponseWriter, r *http.Request) error {
-	// check for API Method
-	if r.Method != "POST" {
-		w.Header().Set("Allow", "POST")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return fmt.Errorf("Incorrect Method used for API /api/payment/update")
-	}
-
+func handlePaymentUpdate(api PaymentAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
 	// decode the JSON against the structure
 	var req *dbmodel.PaymentEntry
 	d := json.NewDecoder(r.Body)
@@ -107,6 +92,10 @@ func handlePaymentUpdate(api PaymentAPI, args []string, w http.ResponseWriter, r
 		return fmt.Errorf("Error decoding the request: %s", err.Error())
 	}
 
+	if err := authorizePayment(w, r, req.ID); err != nil {
+		return err
+	}
+
 	err := api.PaymentDBI.UpdatePayment(req)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -118,14 +107,7 @@ func handlePaymentUpdate(api PaymentAPI, args []string, w http.ResponseWriter, r
 }
 
 // /api/payment/delete -
-func handlePaymentDelete(api PaymentAPI, args []string, w http.ResponseWriter, r *http.Request) error {
-	// check for API Method
-	if r.Method != "DELETE" {
-		w.Header().Set("Allow", "DELETE")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return fmt.Errorf("Incorrect Method used for API /api/payment/delete")
-	}
-
+func handlePaymentDelete(api PaymentAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
 	// decode the JSON against the structure
 	var req *dbmodel.PaymentEntry
 	d := json.NewDecoder(r.Body)
@@ -139,6 +121,10 @@ func handlePaymentDelete(api PaymentAPI, args []string, w http.ResponseWriter, r
 		return fmt.Errorf("required parameters NOT specified in delete request")
 	}
 
+	if err := authorizePayment(w, r, req.ID); err != nil {
+		return err
+	}
+
 	err := api.PaymentDBI.DeletePayment(req.ID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -150,62 +136,29 @@ func handlePaymentDelete(api PaymentAPI, args []string, w http.ResponseWriter, r
 	return nil
 }
 
-type paymentT struct {
-	regex string
-	re    *regexp.Regexp
-	f     func(api PaymentAPI, args []string, w http.ResponseWriter, r *http.Request) error
+// authorizePayment - card details are only visible to their own account and root
+func authorizePayment(w http.ResponseWriter, r *http.Request, id int) error {
+	p := principalFrom(r)
+	if !p.IsRoot() && id != p.ID {
+		w.WriteHeader(http.StatusForbidden)
+		return fmt.Errorf("payment details of account %d are not accessible", id)
+	}
+	return nil
 }
 
-var payment []paymentT
-
-func (api PaymentAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	for _, d := range payment {
-		if d.re.MatchString(r.URL.String()) {
-			err := d.f(api, d.re.FindStringSubmatch(r.URL.String()), w, r)
-			if err != nil {
-				returnMessage := fmt.Sprintf("%v", err)
-				w.Write([]byte(returnMessage))
-			}
-			return
-		}
-	}
-	w.WriteHeader(http.StatusBadRequest)
-	w.Write([]byte("No match found.\n"))
+// bind - adapt a handler of this API to a routeHandler
+func (api PaymentAPI) bind(f func(api PaymentAPI, params routeParams, w http.ResponseWriter, r *http.Request) error) routeHandler {
+	return func(params routeParams, w http.ResponseWriter, r *http.Request) error {
+		return f(api, params, w, r)
+	}
 }
 
-func init() {
-	var regex string
-	regex = "/api/payment/search\\?([^/]+)$"
-	//regex = "/api/payment/search$"
-	payment = append(payment,
-		paymentT{
-			regex: regex,
-			re:    regexp.MustCompile(regex),
-			f:     handlePaymentSearch,
-		},
-	)
-	regex = "/api/payment/do$"
-	payment = append(payment,
-		paymentT{
-			regex: regex,
-			re:    regexp.MustCompile(regex),
-			f:     handlePaymentDo,
-		},
-	)
-	regex = "/api/payment/update$"
-	payment = append(payment,
-		paymentT{
-			regex: regex,
-			re:    regexp.MustCompile(regex),
-			f:     handlePaymentUpdate,
-		},
-	)
-	regex = "/api/payment/delete$"
-	payment = append(payment,
-		paymentT{
-			regex: regex,
-			re:    regexp.MustCompile(regex),
-			f:     handlePaymentDelete,
-		},
-	)
+// routes - endpoints of the payment API
+func (api PaymentAPI) routes() []route {
+	return []route{
+		{method: "GET", pattern: "/api/payment/search", roles: anyRole, handler: api.bind(handlePaymentSearch)},
+		{method: "POST", pattern: "/api/payment/do", roles: anyRole, handler: api.bind(handlePaymentDo)},
+		{method: "POST", pattern: "/api/payment/update", roles: anyRole, handler: api.bind(handlePaymentUpdate)},
+		{method: "DELETE", pattern: "/api/payment/delete", roles: anyRole, handler: api.bind(handlePaymentDelete)},
+	}
 }
@@ -1,13 +1,16 @@
 package api
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
-	"regexp"
+	"strconv"
 
 	"github.com/msproject/relive/dbi"
 	"github.com/msproject/relive/dbmodel"
 	"github.com/msproject/relive/logger"
+	"github.com/msproject/relive/money"
+	"github.com/msproject/relive/transcode"
 	"github.com/msproject/relive/util"
 )
 
@@ -17,7 +20,30 @@ type ProductsAPI struct {
 	LogObj     *logger.Logger
 }
 
-//InitProductsDB - create product if it doesnt exist
+/* defaultProducts - the catalog of a new installation, managed by root
+ * from then on */
+var defaultProducts = []dbmodel.ProductEntry{{
+	ProductID:   1001,
+	ProductType: "bronze",
+	StoreSize:   100,
+	Duration:    30,
+	Prices:      []dbmodel.ProductPriceEntry{{Price: money.Money{Minor: 10000, Currency: "USD"}}},
+}, {
+	ProductID:   1002,
+	ProductType: "silver",
+	StoreSize:   200,
+	Duration:    30,
+	Prices:      []dbmodel.ProductPriceEntry{{Price: money.Money{Minor: 20000, Currency: "USD"}}},
+}, {
+	ProductID:   1003,
+	ProductType: "gold",
+	StoreSize:   300,
+	Duration:    30,
+	Prices:      []dbmodel.ProductPriceEntry{{Price: money.Money{Minor: 30000, Currency: "USD"}}},
+}}
+
+//InitProductsDB - create the default products if there are none, archived
+//ones included
 func InitProductsDB(sqlDBI dbi.DBI) (err error) {
 	var exists bool
 
@@ -33,49 +59,166 @@ func InitProductsDB(sqlDBI dbi.DBI) (err error) {
 		return nil
 	}
 
-	req := []util.CreateProductReq{{
-		ProductID:   1001,
-		ProductType: "bronze",
-		StoreSize:   100,
-		Duration:    30,
-		Amount:      100,
-	}, {
-		ProductID:   1002,
-		ProductType: "silver",
-		StoreSize:   200,
-		Duration:    30,
-		Amount:      200,
-	}, {
-		ProductID:   1003,
-		ProductType: "gold",
-		StoreSize:   300,
-		Duration:    30,
-		Amount:      300,
-	}}
-	err = sqlDBI.ProductDBI.CreateProduct(req)
-	if err != nil {
-		fmt.Printf("Error creating product %s\n", err.Error())
+	products, err := sqlDBI.ProductDBI.GetAllProducts(true)
+	if err != nil || len(products) > 0 {
 		return err
 	}
+
+	for i := range defaultProducts {
+		pr := defaultProducts[i]
+		if err = sqlDBI.ProductDBI.CreateProduct(&pr); err != nil {
+			fmt.Printf("Error creating product %s\n", err.Error())
+			return err
+		}
+	}
 	return nil
 }
 
-func handleListProducts(api ProductsAPI, args []string, w http.ResponseWriter, r *http.Request) (err error) {
+// productEntry - the product req describes, 400 if it is not valid
+func productEntry(w http.ResponseWriter, req util.CreateProductReq) (*dbmodel.ProductEntry, error) {
+	invalid := func(format string, args ...interface{}) (*dbmodel.ProductEntry, error) {
+		err := fmt.Errorf(format, args...)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, err
+	}
+
+	if req.ProductID == 0 || req.ProductType == "" || req.Duration == 0 {
+		return invalid("required parameters NOT specified in product request")
+	}
+	if req.Renditions != "" {
+		if _, err := transcode.ParseLadder(req.Renditions); err != nil {
+			return invalid("invalid renditions: %v", err)
+		}
+	}
+	if len(req.Prices) == 0 {
+		return invalid("product %d has no price", req.ProductID)
+	}
+
+	pr := &dbmodel.ProductEntry{
+		ProductID:     int(req.ProductID),
+		ProductType:   req.ProductType,
+		StoreSize:     int(req.StoreSize),
+		Duration:      int(req.Duration),
+		MaxAdmins:     int(req.MaxAdmins),
+		MaxCustomers:  int(req.MaxCustomers),
+		Renditions:    req.Renditions,
+		RetentionDays: int(req.RetentionDays),
+		TrialDays:     int(req.TrialDays),
+	}
+	currencies := map[string]bool{}
+	for _, p := range req.Prices {
+		if currencies[p.Price.Currency] {
+			return invalid("product %d priced twice in %s", req.ProductID, p.Price.Currency)
+		}
+		currencies[p.Price.Currency] = true
+		if p.Price.Minor < 0 {
+			return invalid("negative price %s", p.Price)
+		}
+		if _, err := money.Tax(p.Price, p.TaxInclusive, p.TaxRate); err != nil {
+			return invalid("invalid price %s: %v", p.Price, err)
+		}
+		pr.Prices = append(pr.Prices, dbmodel.ProductPriceEntry{
+			ProductID:    pr.ProductID,
+			Price:        p.Price,
+			TaxInclusive: p.TaxInclusive,
+			TaxRate:      p.TaxRate,
+		})
+	}
+	return pr, nil
+}
+
+// productResp - the product with its prices in currency, all if empty
+func productResp(pr dbmodel.ProductEntry, currency string) (util.ProductResp, error) {
+	resp := util.ProductResp{
+		ProductID:     pr.ProductID,
+		ProductType:   pr.ProductType,
+		StoreSize:     pr.StoreSize,
+		Duration:      pr.Duration,
+		MaxAdmins:     pr.MaxAdmins,
+		MaxCustomers:  pr.MaxCustomers,
+		Renditions:    pr.Renditions,
+		RetentionDays: pr.RetentionDays,
+		TrialDays:     pr.TrialDays,
+		ArchivedAt:    pr.ArchivedAt,
+	}
+	for _, price := range pr.Prices {
+		if currency != "" && price.Price.Currency != currency {
+			continue
+		}
+		b, err := money.Tax(price.Price, price.TaxInclusive, price.TaxRate)
+		if err != nil {
+			return resp, fmt.Errorf("product %d: %v", pr.ProductID, err)
+		}
+		resp.Prices = append(resp.Prices, util.ProductPriceResp{
+			ProductPrice: util.ProductPrice{Price: price.Price, TaxInclusive: price.TaxInclusive, TaxRate: price.TaxRate},
+			Net:          b.Net,
+			Tax:          b.Tax,
+			Gross:        b.Gross,
+		})
+	}
+	return resp, nil
+}
+
+// getProduct - the product of the {product} path segment, 404 if none
+func getProduct(api ProductsAPI, w http.ResponseWriter, params routeParams) (*dbmodel.ProductEntry, error) {
+	id, err := strconv.Atoi(params["product"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, fmt.Errorf("invalid product id specified in request URL")
+	}
+
+	pr, err := api.ProductDBI.GetProduct(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, err
+	}
+	if pr == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return nil, fmt.Errorf("product %d not found", id)
+	}
+	return pr, nil
+}
+
+// GET /api/products/list - products on offer with their prices, only
+// those priced in ?currency= if given. Root lists the archived ones too
+// with ?archived=1
+func handleListProducts(api ProductsAPI, params routeParams, w http.ResponseWriter, r *http.Request) (err error) {
 
-	var resp []dbmodel.ProductEntry
+	currency := r.URL.Query().Get("currency")
+	if currency != "" {
+		if _, err = money.Exponent(currency); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return err
+		}
+	}
 
-	if r.Method != "GET" {
-		w.Header().Set("Allow", "GET")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return fmt.Errorf("Incorrect Method used for API /api/accounts/Search")
+	archived := r.URL.Query().Get("archived") == "1"
+	if archived && !principalFrom(r).IsRoot() {
+		w.WriteHeader(http.StatusForbidden)
+		return fmt.Errorf("only root lists archived products")
 	}
 
-	resp, err = api.ProductDBI.GetAllProducts()
+	var products []dbmodel.ProductEntry
+
+	products, err = api.ProductDBI.GetAllProducts(archived)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return err
 	}
 
+	var resp []util.ProductResp
+	for _, p := range products {
+		item, err := productResp(p, currency)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+		if currency != "" && len(item.Prices) == 0 {
+			continue
+		}
+		resp = append(resp, item)
+	}
+
 	err = writeResponse(resp, w)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -85,37 +228,129 @@ func handleListProducts(api ProductsAPI, args []string, w http.ResponseWriter, r
 	return nil
 }
 
-type productT struct {
-	regex string
-	re    *regexp.Regexp
-	f     func(api ProductsAPI, args []string, w http.ResponseWriter, r *http.Request) error
+// GET /api/products/{product} - a product, archived or not, so those
+// subscribed to an archived one still see what they have
+func handleGetProduct(api ProductsAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	pr, err := getProduct(api, w, params)
+	if err != nil {
+		return err
+	}
+
+	resp, err := productResp(*pr, "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	return writeResponse(resp, w)
 }
 
-var product []productT
-
-func (api ProductsAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	for _, d := range product {
-		if d.re.MatchString(r.URL.String()) {
-			err := d.f(api, d.re.FindStringSubmatch(r.URL.String()), w, r)
-			if err != nil {
-				returnMessage := fmt.Sprintf("%v", err)
-				w.Write([]byte(returnMessage))
-			}
-			return
-		}
+// POST /api/products - add a product to the catalog
+func handleCreateProduct(api ProductsAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	var req util.CreateProductReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("Error decoding the request: %s", err.Error())
+	}
+
+	pr, err := productEntry(w, req)
+	if err != nil {
+		return err
+	}
+
+	existing, err := api.ProductDBI.GetProduct(pr.ProductID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	if existing != nil {
+		w.WriteHeader(http.StatusConflict)
+		return fmt.Errorf("product %d exists", pr.ProductID)
+	}
+
+	if err = api.ProductDBI.CreateProduct(pr); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+
+	resp, err := productResp(*pr, "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	w.Header().Set("Location", fmt.Sprintf("/api/products/%d", pr.ProductID))
+	w.WriteHeader(http.StatusCreated)
+	return writeResponse(resp, w)
+}
+
+// PUT /api/products/{product} - change a product and its prices. The
+// ProductID of the body, if given, must be that of the path. Running
+// subscriptions keep the features they were made with.
+func handleUpdateProduct(api ProductsAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	existing, err := getProduct(api, w, params)
+	if err != nil {
+		return err
+	}
+
+	var req util.CreateProductReq
+	if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("Error decoding the request: %s", err.Error())
+	}
+	if req.ProductID != 0 && int(req.ProductID) != existing.ProductID {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("product %d can not become %d", existing.ProductID, req.ProductID)
+	}
+	req.ProductID = uint32(existing.ProductID)
+
+	pr, err := productEntry(w, req)
+	if err != nil {
+		return err
+	}
+	pr.ArchivedAt, pr.CreatedAt = existing.ArchivedAt, existing.CreatedAt
+
+	if err = api.ProductDBI.UpdateProduct(pr); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+
+	resp, err := productResp(*pr, "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	return writeResponse(resp, w)
+}
+
+// POST /api/products/{product}/archive - take a product off the list.
+// Subscriptions to it run on, new ones are refused
+func handleArchiveProduct(api ProductsAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	pr, err := getProduct(api, w, params)
+	if err != nil {
+		return err
+	}
+
+	if err = api.ProductDBI.ArchiveProduct(pr.ProductID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// bind - adapt a handler of this API to a routeHandler
+func (api ProductsAPI) bind(f func(api ProductsAPI, params routeParams, w http.ResponseWriter, r *http.Request) error) routeHandler {
+	return func(params routeParams, w http.ResponseWriter, r *http.Request) error {
+		return f(api, params, w, r)
 	}
-	w.WriteHeader(http.StatusBadRequest)
-	w.Write([]byte("No match found.\n"))
 }
 
-func init() {
-	var regex string
-	regex = "/api/products/list$"
-	product = append(product,
-		productT{
-			regex: regex,
-			re:    regexp.MustCompile(regex),
-			f:     handleListProducts,
-		},
-	)
+// routes - endpoints of the products API
+func (api ProductsAPI) routes() []route {
+	return []route{
+		{method: "GET", pattern: "/api/products/list", roles: anyRole, handler: api.bind(handleListProducts)},
+		{method: "POST", pattern: "/api/products", roles: rootOnly, handler: api.bind(handleCreateProduct)},
+		{method: "GET", pattern: "/api/products/{product}", roles: anyRole, handler: api.bind(handleGetProduct)},
+		{method: "PUT", pattern: "/api/products/{product}", roles: rootOnly, handler: api.bind(handleUpdateProduct)},
+		{method: "POST", pattern: "/api/products/{product}/archive", roles: rootOnly, handler: api.bind(handleArchiveProduct)},
+	}
 }
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultRetentionCheck - how often media past the retention of their subscription are looked for
+const DefaultRetentionCheck = time.Hour
+
+// DeleteExpiredMedia - delete, files and all, the media kept longer than
+// the subscription of their business retains them. Returns how many went;
+// media that failed are left for the next run.
+func (api MediaAPI) DeleteExpiredMedia(ctx context.Context) (int, error) {
+	expired, err := api.MediaDBI.ListExpiredMedia(time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	var failed error
+	for _, m := range expired {
+		n, err := api.MediaDBI.DeleteMediaType(m.ID, m.FileName)
+		if err == nil && n > 0 {
+			err = api.purgeMedia(ctx, m)
+		}
+		if err != nil {
+			api.LogObj.PrintError("Failed to delete media %s of account %d past its retention: %v", m.FileName, m.ID, err)
+			failed = err
+			continue
+		}
+		deleted += int(n)
+	}
+	return deleted, failed
+}
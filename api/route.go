@@ -0,0 +1,112 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// routeParams - values of the {name} segments of a matched route pattern
+type routeParams map[string]string
+
+// routeHandler - an API handler bound to its API struct
+type routeHandler func(params routeParams, w http.ResponseWriter, r *http.Request) error
+
+// route - one API endpoint. pattern is a path where a {name} segment
+// matches any single segment; roles are checked with permitted() before
+// the handler runs, nil roles make the route public.
+type route struct {
+	method  string
+	pattern string
+	roles   []int
+	handler routeHandler
+}
+
+type compiledRoute struct {
+	route
+	segments []string
+}
+
+// routeTable - dispatches on path and method. A path no route matches is a
+// 404, a path matched only by routes of other methods is a 405 with Allow.
+type routeTable struct {
+	routes []compiledRoute
+}
+
+func newRouteTable(routeSets ...[]route) *routeTable {
+	t := &routeTable{}
+	for _, set := range routeSets {
+		for _, rt := range set {
+			t.routes = append(t.routes, compiledRoute{
+				route:    rt,
+				segments: splitPath(rt.pattern),
+			})
+		}
+	}
+	return t
+}
+
+func splitPath(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
+
+// match - the params if the escaped request path matches the route
+func (c compiledRoute) match(segments []string) (routeParams, bool) {
+	if len(segments) != len(c.segments) {
+		return nil, false
+	}
+
+	params := routeParams{}
+	for i, seg := range c.segments {
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			/* unescape per segment so an encoded '/' can't add a segment */
+			val, err := url.PathUnescape(segments[i])
+			if err != nil || val == "" || strings.Contains(val, "/") {
+				return nil, false
+			}
+			params[seg[1:len(seg)-1]] = val
+			continue
+		}
+		if seg != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+func (t *routeTable) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := splitPath(r.URL.EscapedPath())
+
+	var allowed []string
+	for _, rt := range t.routes {
+		params, ok := rt.match(segments)
+		if !ok {
+			continue
+		}
+		if rt.method != r.Method {
+			allowed = append(allowed, rt.method)
+			continue
+		}
+
+		err := permitted(rt.roles, w, r)
+		if err == nil {
+			err = rt.handler(params, w, r)
+		}
+		if err != nil {
+			returnMessage := fmt.Sprintf("%v", err)
+			w.Write([]byte(returnMessage))
+		}
+		return
+	}
+
+	if len(allowed) > 0 {
+		sort.Strings(allowed)
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		http.Error(w, fmt.Sprintf("Method %s is not allowed for %s.", r.Method, r.URL.Path), http.StatusMethodNotAllowed)
+		return
+	}
+
+	http.Error(w, fmt.Sprintf("Invalid Request made. %s is not an active endpoint.", r.URL.Path), http.StatusNotFound)
+}
@@ -1,61 +1,66 @@
 package api
 
 import (
-	"fmt"
 	"github.com/msproject/relive/dbi"
 	"github.com/msproject/relive/logger"
 	"net/http"
-	"strings"
 )
 
-//Router - main HTTP handler for all relive non-SSL APIs
+// exposedHeaders - response headers browser clients may read
+const exposedHeaders = "Location,Tus-Resumable,Tus-Version,Tus-Extension,Tus-Checksum-Algorithm,Upload-Offset,Upload-Length,Upload-Expires,X-Transcode-Job,X-Storage-Warning,ETag,Accept-Ranges,Content-Range,Content-Disposition"
+
+//Router - main HTTP handler for all relive APIs
 type Router struct {
-	Account      http.Handler
-	Subscription http.Handler
-	Payment      http.Handler
-	Media        http.Handler
-	Product      http.Handler
-	AccountDBI   dbi.AccountTblDBI
-	LogObj       *logger.Logger
+	SessionDBI dbi.SessionTblDBI
+	LogObj     *logger.Logger
+	routes     *routeTable
+}
+
+// NewRouter - build the route table of all APIs
+func NewRouter(account AccountsAPI, subscription SubscriptionAPI, payment PaymentAPI, media MediaAPI,
+	product ProductsAPI, catalog CatalogAPI, sessionDBI dbi.SessionTblDBI, logObj *logger.Logger) Router {
+	return Router{
+		SessionDBI: sessionDBI,
+		LogObj:     logObj,
+		routes: newRouteTable(
+			account.routes(),
+			subscription.routes(),
+			payment.routes(),
+			media.routes(),
+			product.routes(),
+			catalog.routes(),
+		),
+	}
 }
 
 func (r Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 	if req.Method == "OPTIONS" {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "Origin,Authorization,DNT,X-Auth,X-CustomHeader,Keep-Alive,User-Agent,X-Requested-With,If-Modified-Since,Cache-Control,Content-Type")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, DELETE, HEAD, PATCH")
+		w.Header().Set("Access-Control-Allow-Headers", "Origin,Authorization,DNT,X-Auth,X-CustomHeader,Keep-Alive,User-Agent,X-Requested-With,If-Modified-Since,Cache-Control,Content-Type,Tus-Resumable,Upload-Length,Upload-Metadata,Upload-Offset,Upload-Checksum")
+		w.Header().Set("Access-Control-Expose-Headers", exposedHeaders)
 		w.Header().Set("Access-Control-Max-Age", "1728000")
 		w.Header().Set("Content-Type", "text/plain charset=UTF-8")
 		w.Header().Set("Content-Length", "0")
+		if isUploadsPath(req.URL.Path) {
+			tusDiscovery(w)
+			w.WriteHeader(http.StatusNoContent)
+		}
 		return
 	}
-	if req.Method == "POST" || req.Method == "GET" || req.Method == "PUT" || req.Method == "DELETE" {
+	if req.Method == "POST" || req.Method == "GET" || req.Method == "PUT" || req.Method == "DELETE" ||
+		req.Method == "HEAD" || req.Method == "PATCH" {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "Origin,Authorization,DNT,X-Auth,X-CustomHeader,Keep-Alive,User-Agent,X-Requested-With,If-Modified-Since,Cache-Control,Content-Type")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, DELETE, HEAD, PATCH")
+		w.Header().Set("Access-Control-Allow-Headers", "Origin,Authorization,DNT,X-Auth,X-CustomHeader,Keep-Alive,User-Agent,X-Requested-With,If-Modified-Since,Cache-Control,Content-Type,Tus-Resumable,Upload-Length,Upload-Metadata,Upload-Offset,Upload-Checksum")
+		w.Header().Set("Access-Control-Expose-Headers", exposedHeaders)
 	}
 
-	url := req.URL.String()
-
-	r.LogObj.PrintInfo("request URL: %s", url)
+	r.LogObj.PrintInfo("request URL: %s %s", req.Method, req.URL.String())
 
-	if strings.Contains(url, "/accounts/") || strings.Contains(url, "/accounts?") {
-		r.Account.ServeHTTP(w, req)
-		return
-	} else if strings.Contains(url, "/subscription/") || strings.Contains(url, "/subscription?") {
-		r.Subscription.ServeHTTP(w, req)
-		return
-	} else if strings.Contains(url, "/payment/") || strings.Contains(url, "/payment?") {
-		r.Payment.ServeHTTP(w, req)
-		return
-	} else if strings.Contains(url, "/media/") || strings.Contains(url, "/media?") {
-		r.Media.ServeHTTP(w, req)
-		return
-	} else if strings.Contains(url, "/products/") || strings.Contains(url, "/products?") {
-		r.Product.ServeHTTP(w, req)
-		return
-	}
+	/* Authenticate, the route table checks the principal against route roles */
+	req = authorizeRequest(r.LogObj, r.SessionDBI, w, req)
 
-	http.Error(w, fmt.Sprintf("Invalid Request made. %s is not an active endpoint.", url), http.StatusMethodNotAllowed)
+	r.routes.ServeHTTP(w, req)
 }
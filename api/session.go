@@ -0,0 +1,72 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/msproject/relive/dbmodel"
+)
+
+//DefaultSessionTTL - lifetime of a session issued at login or refresh
+const DefaultSessionTTL = time.Hour
+
+// newSession - generate a random bearer token and the Session row for it.
+// Only the SHA-256 of the token is stored, the token itself goes to the client.
+func newSession(account *dbmodel.AccountEntry, ttl time.Duration) (string, *dbmodel.SessionEntry, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	if ttl <= 0 {
+		ttl = DefaultSessionTTL
+	}
+	now := time.Now().UTC()
+	session := &dbmodel.SessionEntry{
+		SessionID: hashToken(token),
+		ID:        account.ID,
+		PID:       account.PID,
+		UserName:  account.UserName,
+		Role:      account.Role,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	return token, session, nil
+}
+
+// randomToken - 256 bit random token, URL safe
+func randomToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("could not generate token: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// hashToken - the form tokens are stored and looked up in
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// bearerToken - extract the token from "Authorization: Bearer <token>"
+func bearerToken(r *http.Request) (string, error) {
+	authData := r.Header.Get("Authorization")
+	if authData == "" {
+		return "", fmt.Errorf("authorization header is empty")
+	}
+	if !strings.HasPrefix(authData, "Bearer ") {
+		return "", fmt.Errorf("authorization header is not a bearer token")
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(authData, "Bearer "))
+	if token == "" {
+		return "", fmt.Errorf("bearer token is empty")
+	}
+	return token, nil
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/msproject/relive/quota"
+	"github.com/msproject/relive/util"
+)
+
+/* dates of the egress report */
+const dateLayout = "2006-01-02"
+
+// checkQuota - whether add more bytes may be stored for account id,
+// writing 413 if the quota blocks them and 402 if the subscriptions of the
+// business expired. Past the soft limit or the quota the response carries
+// an X-Storage-Warning header.
+func checkQuota(api MediaAPI, w http.ResponseWriter, id int, add int64) error {
+	usage, err := api.Quotas.Check(id, add)
+	if err == quota.ErrReadOnly {
+		http.Error(w, err.Error(), http.StatusPaymentRequired)
+		return fmt.Errorf("business %d: %v", usage.Business, err)
+	}
+	if err == quota.ErrExceeded {
+		http.Error(w, fmt.Sprintf("%v: %d of %d bytes used", err, usage.Used(), usage.Quota), http.StatusRequestEntityTooLarge)
+		return fmt.Errorf("business %d: %v", usage.Business, err)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+
+	if state := usage.State(add); state != quota.StateOK {
+		w.Header().Set("X-Storage-Warning", fmt.Sprintf("%s: %d of %d bytes used", state, usage.Used()+add, usage.Quota))
+	}
+	return nil
+}
+
+// GET /api/media/usage?id=<account> - storage used by the business of the
+// account against its quota
+func handleStorageUsage(api MediaAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("invalid account id specified in request URL")
+	}
+	if _, err = authorizeAccount(api.AccountDBI, w, r, id); err != nil {
+		return err
+	}
+
+	usage, err := api.Quotas.Usage(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+
+	return writeResponse(util.StorageUsageResp{
+		ID:             id,
+		BusinessID:     usage.Business,
+		Unlimited:      usage.Unlimited,
+		QuotaBytes:     usage.Quota,
+		SoftLimitBytes: usage.SoftLimit,
+		MediaBytes:     usage.Media,
+		PendingBytes:   usage.Pending,
+		UsedBytes:      usage.Used(),
+		OverageBytes:   usage.Overage,
+		State:          usage.State(0),
+		Policy:         string(api.Quotas.Policy()),
+		ReadOnly:       usage.ReadOnly,
+	}, w)
+}
+
+// GET /api/media/egress?id=<account>&from=<date>&to=<date> - bytes
+// playback served per day of the media of the account and, for a business,
+// of its customers. Dates are UTC as YYYY-MM-DD, the current month if left
+// out. Bytes served in the last minutes may not be counted yet.
+func handleEgressReport(api MediaAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	id, err := strconv.Atoi(query.Get("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("invalid account id specified in request URL")
+	}
+
+	now := time.Now().UTC()
+	from, to := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC), now
+	if s := query.Get("from"); s != "" {
+		from, err = time.Parse(dateLayout, s)
+	}
+	if s := query.Get("to"); s != "" && err == nil {
+		to, err = time.Parse(dateLayout, s)
+	}
+	if err != nil || to.Before(from) {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("invalid from and to dates specified in request URL, expected %s", dateLayout)
+	}
+
+	if _, err = authorizeAccount(api.AccountDBI, w, r, id); err != nil {
+		return err
+	}
+
+	days, err := api.EgressDBI.ListEgress(id, from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+
+	resp := util.EgressResp{ID: id, From: from.Format(dateLayout), To: to.Format(dateLayout)}
+	for _, day := range days {
+		resp.TotalBytes += day.Bytes
+		resp.Days = append(resp.Days, util.EgressDay{ID: day.ID, Day: day.Day.Format(dateLayout), Bytes: day.Bytes})
+	}
+	return writeResponse(resp, w)
+}
@@ -4,10 +4,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"regexp"
+	"strconv"
+	"time"
 
 	"github.com/msproject/relive/dbi"
+	"github.com/msproject/relive/dbmodel"
 	"github.com/msproject/relive/logger"
+	"github.com/msproject/relive/subscription"
 	"github.com/msproject/relive/util"
 )
 
@@ -16,64 +19,78 @@ type SubscriptionAPI struct {
 	SubscriptionDBI        dbi.SubscriptionTblDBI
 	SubscriptionAccountDBI dbi.SubscriptionAccountTblDBI
 	ProductDBI             dbi.ProductTblDBI
+	PaymentDBI             dbi.PaymentTblDBI
 	LogObj                 *logger.Logger
 }
 
-//	/api/subscription/search
-func handleSubscriptionSearch(api SubscriptionAPI, args []string, w http.ResponseWriter, r *http.Request) error {
-
-	// check for API Method
-	if r.Method != "GET" {
-		w.Header().Set("Allow", "GET")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return fmt.Errorf("Incorrect Method used for API /api/subscription/search")
+/* subscriptionDateLayouts - StartDate root may give a subscription, UTC */
+var subscriptionDateLayouts = []string{"2006-01-02 15:04:05", "2006-01-02"}
+
+// subscrDetails - the details of sub shown to its business
+func subscrDetails(sub dbmodel.SubscriptionEntry) util.SubscrDetails {
+	return util.SubscrDetails{
+		ID:          sub.ID,
+		ProductID:   sub.ProductID,
+		SubscrCode:  sub.SubscriptionCode,
+		ProductType: sub.ProductType,
+		State:       sub.State,
+		StartDate:   sub.StartDate,
+		EndDate:     sub.EndDate,
+		GraceUntil:  sub.GraceUntil,
 	}
+}
 
-	fmt.Println("before prtining args")
-	/* anything after /api/subscription/search/ will be in args, split by '/' */
-	fmt.Println("arguments in search: ", args)
-
-	//err := api.SubscriptionDBI.SearchSubscription(strconv.Atoi(args))
-	var subscrCode uint32
-	subscrCode = 3
-	fmt.Println("subscrCode = ", args)
+// /api/subscription/search?code=<subscription code>, or ?id=<account> for
+// all subscriptions of a business, expired ones too
+func handleSubscriptionSearch(api SubscriptionAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
 
 	var subs []util.SubscrDetails
-	subs, err := api.SubscriptionDBI.SearchSubscription(subscrCode)
-	//err := api.SubscriptionDBI.SearchSubscription(uint32(args))
-	//fmt.Println(subs)
-
-	jsonStr, err := json.Marshal(subs)
-	fmt.Println("json: ", jsonStr)
-
-	w.Header().Set("Content-Type", "application/json")
-	n, err := w.Write(jsonStr)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return fmt.Errorf("Failure to write, err = %s", err)
-	}
-	if n != len(jsonStr) {
-		w.WriteHeader(http.StatusInternalServerError)
-		return fmt.Errorf("Short write sent = %d, wrote = %d", len(jsonStr), n)
+	if query.Get("id") != "" {
+		id, err := strconv.Atoi(query.Get("id"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return fmt.Errorf("invalid account id specified in request URL")
+		}
+		entries, err := api.SubscriptionDBI.ListSubscriptions(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+		for _, sub := range entries {
+			subs = append(subs, subscrDetails(sub))
+		}
+	} else {
+		code, err := strconv.ParseUint(query.Get("code"), 10, 32)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return fmt.Errorf("invalid subscription code specified in request URL")
+		}
+		subs, err = api.SubscriptionDBI.SearchSubscription(uint32(code))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
 	}
 
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return err
+	/* a business sees its own subscription, a customer the one of its business */
+	p := principalFrom(r)
+	visible := subs[:0]
+	for _, sub := range subs {
+		if p.IsRoot() || sub.ID == p.ID || sub.ID == p.PID {
+			visible = append(visible, sub)
+		}
 	}
+	subs = visible
 
-	return nil
+	return writeResponse(subs, w)
 }
 
-// /api/subscription/create
-func handleSubscriptionCreate(api SubscriptionAPI, args []string, w http.ResponseWriter, r *http.Request) error {
-
-	// check for API Method
-	if r.Method != "POST" {
-		w.Header().Set("Allow", "POST")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return fmt.Errorf("Incorrect Method used for API /api/subscription/create")
-	}
+// /api/subscription/create - subscribe a business to a product from now,
+// or from the StartDate root gives. The period follows from the product's
+// Duration. A business subscribing itself needs a payment method on file.
+// It gets one trial, that of the first product with one it subscribes to.
+func handleSubscriptionCreate(api SubscriptionAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
 
 	// decode the JSON against the structure
 	var req util.CreateSubscriptionReq
@@ -83,26 +100,80 @@ func handleSubscriptionCreate(api SubscriptionAPI, args []string, w http.Respons
 		return fmt.Errorf("Error decoding the request: %s", err.Error())
 	}
 
-	err := api.SubscriptionDBI.CreateSubscription(req)
+	p := principalFrom(r)
+	if !p.IsRoot() && int(req.ID) != p.ID {
+		w.WriteHeader(http.StatusForbidden)
+		return fmt.Errorf("account %s may only subscribe itself", p.UserName)
+	}
+
+	if req.EndDate != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("the EndDate of a subscription follows from its product")
+	}
+	start, err := subscriptionStart(w, p, req.StartDate)
+	if err != nil {
+		return err
+	}
+
+	pr, err := checkSubscribedProduct(api, w, int(req.ProductID), int(req.NumberOfAdmins))
+	if err != nil {
+		return err
+	}
+
+	/* root may grant a subscription, a business pays for its own */
+	if !p.IsRoot() {
+		payments, err := api.PaymentDBI.SearchPayment(dbi.RootScope(), int(req.ID))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+		if len(payments) == 0 {
+			w.WriteHeader(http.StatusPaymentRequired)
+			return fmt.Errorf("account %d has no payment method on file", req.ID)
+		}
+	}
+
+	trial := false
+	if pr.TrialDays > 0 {
+		if trial, err = api.SubscriptionDBI.UseTrial(int(req.ID), time.Now().UTC()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+	}
+
+	sub := subscription.Start(int(req.ID), *pr, start, trial)
+	sub.StoreLocation, sub.NumberOfAdmins = req.StoreLocation, int(req.NumberOfAdmins)
+	err = api.SubscriptionDBI.CreateSubscription(&sub)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return err
 	}
 
+	w.Header().Set("Location", fmt.Sprintf("/api/subscription/search?code=%d", sub.SubscriptionCode))
 	w.WriteHeader(http.StatusCreated)
-
-	return nil
+	return writeResponse(subscrDetails(sub), w)
 }
 
-// /api/subscription/update -
-func handleSubscriptionUpdate(api SubscriptionAPI, args []string, w http.ResponseWriter, r *http.Request) error {
-	// check for API Method
-	if r.Method != "POST" {
-		w.Header().Set("Allow", "POST")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return fmt.Errorf("Incorrect Method used for API /api/subscription/update")
+// subscriptionStart - now, or the StartDate root gives
+func subscriptionStart(w http.ResponseWriter, p *Principal, startDate string) (time.Time, error) {
+	if startDate == "" {
+		return time.Now().UTC().Truncate(time.Second), nil
+	}
+	if !p.IsRoot() {
+		w.WriteHeader(http.StatusForbidden)
+		return time.Time{}, fmt.Errorf("only root picks the StartDate of a subscription")
 	}
+	for _, layout := range subscriptionDateLayouts {
+		if start, err := time.Parse(layout, startDate); err == nil {
+			return start, nil
+		}
+	}
+	w.WriteHeader(http.StatusBadRequest)
+	return time.Time{}, fmt.Errorf("invalid StartDate %q, expected %s", startDate, subscriptionDateLayouts[0])
+}
 
+// /api/subscription/update -
+func handleSubscriptionUpdate(api SubscriptionAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
 	// decode the JSON against the structure
 	var req util.CreateSubscriptionReq
 	d := json.NewDecoder(r.Body)
@@ -116,7 +187,27 @@ func handleSubscriptionUpdate(api SubscriptionAPI, args []string, w http.Respons
 		return fmt.Errorf("required parameters NOT specified in update request")
 	}
 
-	err := api.SubscriptionDBI.UpdateSubscription(req)
+	if err := authorizeSubscription(api, w, r, req.SubscriptionCode); err != nil {
+		return err
+	}
+
+	sub, err := api.SubscriptionDBI.GetSubscription(req.SubscriptionCode)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	if sub == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return fmt.Errorf("subscription %d not found", req.SubscriptionCode)
+	}
+
+	/* the admins allowed when subscribed, whatever the product allows now */
+	if sub.MaxAdmins > 0 && int(req.NumberOfAdmins) > sub.MaxAdmins {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("subscription %d allows %d admins, not %d", req.SubscriptionCode, sub.MaxAdmins, req.NumberOfAdmins)
+	}
+
+	err = api.SubscriptionDBI.UpdateSubscription(req)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return err
@@ -127,15 +218,9 @@ func handleSubscriptionUpdate(api SubscriptionAPI, args []string, w http.Respons
 	return nil
 }
 
-// /api/subscription/delete -
-func handleSubscriptionDelete(api SubscriptionAPI, args []string, w http.ResponseWriter, r *http.Request) error {
-	// check for API Method
-	if r.Method != "DELETE" {
-		w.Header().Set("Allow", "DELETE")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return fmt.Errorf("Incorrect Method used for API /api/subscription/delete")
-	}
-
+// /api/subscription/delete - root removes a subscription altogether.
+// Businesses cancel theirs, whose record stays.
+func handleSubscriptionDelete(api SubscriptionAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
 	// decode the JSON against the structure
 	var req util.CreateSubscriptionReq
 	d := json.NewDecoder(r.Body)
@@ -160,61 +245,94 @@ func handleSubscriptionDelete(api SubscriptionAPI, args []string, w http.Respons
 	return nil
 }
 
-type subscriptionT struct {
-	regex string
-	re    *regexp.Regexp
-	f     func(api SubscriptionAPI, args []string, w http.ResponseWriter, r *http.Request) error
+// /api/subscription/cancel - stop renewing a subscription. It runs to the
+// end of its period and expires.
+func handleSubscriptionCancel(api SubscriptionAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	var req util.CreateSubscriptionReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("Error decoding the request: %s", err.Error())
+	}
+
+	if req.SubscriptionCode == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("required parameters NOT specified in cancel request")
+	}
+
+	if err := authorizeSubscription(api, w, r, req.SubscriptionCode); err != nil {
+		return err
+	}
+
+	canceled, err := api.SubscriptionDBI.CancelSubscription(req.SubscriptionCode, time.Now().UTC())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	if !canceled {
+		w.WriteHeader(http.StatusConflict)
+		return fmt.Errorf("subscription %d is not running or already canceled", req.SubscriptionCode)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
 }
 
-var subscription []subscriptionT
-
-func (api SubscriptionAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	for _, d := range subscription {
-		if d.re.MatchString(r.URL.String()) {
-			err := d.f(api, d.re.FindStringSubmatch(r.URL.String()), w, r)
-			if err != nil {
-				returnMessage := fmt.Sprintf("%v", err)
-				w.Write([]byte(returnMessage))
-			}
-			return
-		}
+// checkSubscribedProduct - the product of a new subscription must be on
+// offer and allow admins. Those to an archived product run on.
+func checkSubscribedProduct(api SubscriptionAPI, w http.ResponseWriter, productID, admins int) (*dbmodel.ProductEntry, error) {
+	pr, err := api.ProductDBI.GetProduct(productID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, err
+	}
+	if pr == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, fmt.Errorf("product %d not found", productID)
+	}
+	if pr.ArchivedAt != nil {
+		w.WriteHeader(http.StatusConflict)
+		return nil, fmt.Errorf("product %d is archived", productID)
+	}
+	if pr.MaxAdmins > 0 && admins > pr.MaxAdmins {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, fmt.Errorf("product %d allows %d admins, not %d", productID, pr.MaxAdmins, admins)
+	}
+	return pr, nil
+}
+
+// authorizeSubscription - only root and the subscribed business may change a subscription
+func authorizeSubscription(api SubscriptionAPI, w http.ResponseWriter, r *http.Request, subscriptionCode uint32) error {
+	p := principalFrom(r)
+	if p.IsRoot() {
+		return nil
+	}
+
+	subs, err := api.SubscriptionDBI.SearchSubscription(subscriptionCode)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	if len(subs) == 0 || subs[0].ID != p.ID {
+		w.WriteHeader(http.StatusForbidden)
+		return fmt.Errorf("subscription %d is not accessible", subscriptionCode)
+	}
+	return nil
+}
+
+// bind - adapt a handler of this API to a routeHandler
+func (api SubscriptionAPI) bind(f func(api SubscriptionAPI, params routeParams, w http.ResponseWriter, r *http.Request) error) routeHandler {
+	return func(params routeParams, w http.ResponseWriter, r *http.Request) error {
+		return f(api, params, w, r)
 	}
-	w.WriteHeader(http.StatusBadRequest)
-	w.Write([]byte("No match found.\n"))
 }
 
-func init() {
-	var regex string
-	regex = "/api/subscription/search\\?([^/]+)$"
-	subscription = append(subscription,
-		subscriptionT{
-			regex: regex,
-			re:    regexp.MustCompile(regex),
-			f:     handleSubscriptionSearch,
-		},
-	)
-	regex = "/api/subscription/create$"
-	subscription = append(subscription,
-		subscriptionT{
-			regex: regex,
-			re:    regexp.MustCompile(regex),
-			f:     handleSubscriptionCreate,
-		},
-	)
-	regex = "/api/subscription/update$"
-	subscription = append(subscription,
-		subscriptionT{
-			regex: regex,
-			re:    regexp.MustCompile(regex),
-			f:     handleSubscriptionUpdate,
-		},
-	)
-	regex = "/api/subscription/delete$"
-	subscription = append(subscription,
-		subscriptionT{
-			regex: regex,
-			re:    regexp.MustCompile(regex),
-			f:     handleSubscriptionDelete,
-		},
-	)
+// routes - endpoints of the subscription API
+func (api SubscriptionAPI) routes() []route {
+	return []route{
+		{method: "GET", pattern: "/api/subscription/search", roles: anyRole, handler: api.bind(handleSubscriptionSearch)},
+		{method: "POST", pattern: "/api/subscription/create", roles: rootOrAdmin, handler: api.bind(handleSubscriptionCreate)},
+		{method: "POST", pattern: "/api/subscription/update", roles: rootOrAdmin, handler: api.bind(handleSubscriptionUpdate)},
+		{method: "DELETE", pattern: "/api/subscription/delete", roles: rootOnly, handler: api.bind(handleSubscriptionDelete)},
+		{method: "POST", pattern: "/api/subscription/cancel", roles: rootOrAdmin, handler: api.bind(handleSubscriptionCancel)},
+	}
 }
@@ -0,0 +1,203 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/msproject/relive/dbmodel"
+	"github.com/msproject/relive/util"
+)
+
+// POST /api/accounts/transfers - ask to move a customer, and the media it
+// holds, to another business. Either business may ask, the customer has to
+// accept before anything moves.
+func handleTransferCreate(api AccountsAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	var req util.AccountTransferReq
+	d := json.NewDecoder(r.Body)
+	if err := d.Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("Error decoding the request: %s", err.Error())
+	}
+
+	if req.CustomerID == 0 || req.ToPID == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("required parameters CustomerID and ToPID NOT specified in transfer request")
+	}
+
+	p := principalFrom(r)
+	customer, err := api.AccountDBI.GetAccountByID(req.CustomerID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	if customer == nil || customer.Role != dbmodel.RoleCustomer ||
+		!(p.IsRoot() || customer.PID == p.ID || req.ToPID == p.ID) {
+		/* don't tell other tenants which IDs exist */
+		if customer == nil && p.IsRoot() {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusForbidden)
+		}
+		return fmt.Errorf("customer %d is not accessible", req.CustomerID)
+	}
+
+	business, err := api.AccountDBI.GetAccountByID(req.ToPID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	if business == nil || business.Role != dbmodel.RoleAdmin || business.ID == customer.PID {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("account %d is no other business to transfer customer %d to", req.ToPID, req.CustomerID)
+	}
+
+	pending, err := api.TransferDBI.GetPendingAccountTransfer(customer.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	if pending != nil {
+		w.WriteHeader(http.StatusConflict)
+		return fmt.Errorf("customer %d already has transfer %d pending", customer.ID, pending.TransferID)
+	}
+
+	transfer := dbmodel.AccountTransferEntry{
+		CustomerID:  customer.ID,
+		FromPID:     customer.PID,
+		ToPID:       business.ID,
+		RequestedBy: p.ID,
+	}
+	if err = api.TransferDBI.CreateAccountTransfer(&transfer); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	return writeResponse(transfer, w)
+}
+
+// GET /api/accounts/transfers - transfers the caller is the customer or a
+// business of. Root lists those of the account given by the id query
+// parameter.
+func handleTransferList(api AccountsAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	p := principalFrom(r)
+	id := p.ID
+	if p.IsRoot() && r.URL.Query().Get("id") != "" {
+		var err error
+		if id, err = strconv.Atoi(r.URL.Query().Get("id")); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return fmt.Errorf("invalid account id specified in request URL")
+		}
+	}
+
+	transfers, err := api.TransferDBI.ListAccountTransfers(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	return writeResponse(transfers, w)
+}
+
+// POST /api/accounts/transfers/{transfer}/accept - the customer consents
+// and moves to the new business. Its sessions are revoked, they carry the
+// business it was with.
+func handleTransferAccept(api AccountsAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	transfer, err := authorizeTransfer(api, w, r, params["transfer"])
+	if err != nil {
+		return err
+	}
+	if transfer.CustomerID != principalFrom(r).ID {
+		w.WriteHeader(http.StatusForbidden)
+		return fmt.Errorf("only customer %d may accept transfer %d", transfer.CustomerID, transfer.TransferID)
+	}
+	if err = checkCustomerLimit(api, w, transfer.ToPID); err != nil {
+		return err
+	}
+
+	if err = decideTransfer(api, w, transfer, dbmodel.TransferAccepted); err != nil {
+		return err
+	}
+	if err = api.SessionDBI.DeleteAccountSessions(transfer.CustomerID); err != nil {
+		api.LogObj.PrintError("Failed to revoke sessions of customer %d: %v", transfer.CustomerID, err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// POST /api/accounts/transfers/{transfer}/decline - the customer stays
+func handleTransferDecline(api AccountsAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	transfer, err := authorizeTransfer(api, w, r, params["transfer"])
+	if err != nil {
+		return err
+	}
+	if transfer.CustomerID != principalFrom(r).ID {
+		w.WriteHeader(http.StatusForbidden)
+		return fmt.Errorf("only customer %d may decline transfer %d", transfer.CustomerID, transfer.TransferID)
+	}
+
+	if err = decideTransfer(api, w, transfer, dbmodel.TransferDeclined); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// POST /api/accounts/transfers/{transfer}/cancel - a business withdraws
+// the request before the customer decided
+func handleTransferCancel(api AccountsAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	transfer, err := authorizeTransfer(api, w, r, params["transfer"])
+	if err != nil {
+		return err
+	}
+
+	if err = decideTransfer(api, w, transfer, dbmodel.TransferCanceled); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func decideTransfer(api AccountsAPI, w http.ResponseWriter, transfer *dbmodel.AccountTransferEntry, status string) error {
+	n, err := api.TransferDBI.DecideAccountTransfer(transfer.TransferID, status)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	if n == 0 {
+		/* decided before, or the customer left the business meanwhile */
+		w.WriteHeader(http.StatusConflict)
+		return fmt.Errorf("transfer %d is no longer pending", transfer.TransferID)
+	}
+	return nil
+}
+
+// authorizeTransfer - the transfer, if the caller is root, its customer or
+// one of its businesses
+func authorizeTransfer(api AccountsAPI, w http.ResponseWriter, r *http.Request, transferParam string) (*dbmodel.AccountTransferEntry, error) {
+	transferID, err := strconv.Atoi(transferParam)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, fmt.Errorf("invalid transfer id specified in request URL")
+	}
+
+	transfer, err := api.TransferDBI.GetAccountTransfer(transferID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, err
+	}
+
+	p := principalFrom(r)
+	if transfer == nil || !(p.IsRoot() || p.ID == transfer.CustomerID || p.ID == transfer.FromPID || p.ID == transfer.ToPID) {
+		/* like accounts, only root learns which transfers exist */
+		if transfer == nil && p.IsRoot() {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusForbidden)
+		}
+		return nil, fmt.Errorf("transfer %d is not accessible", transferID)
+	}
+	return transfer, nil
+}
@@ -0,0 +1,267 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/msproject/relive/dbmodel"
+	"github.com/msproject/relive/transcode"
+	"github.com/msproject/relive/upload"
+)
+
+/* resumable uploads, tus 1.0.0 core protocol with the creation,
+ * termination, checksum and expiration extensions */
+
+const (
+	uploadsPath    = "/api/media/uploads"
+	tusVersion     = "1.0.0"
+	tusExtensions  = "creation,termination,checksum,expiration"
+	offsetMimeType = "application/offset+octet-stream"
+
+	// jobHeader - transcode job a completed upload was handed to
+	jobHeader = "X-Transcode-Job"
+
+	statusChecksumMismatch = 460
+)
+
+// tusDiscovery - headers answering an OPTIONS request on the uploads
+func tusDiscovery(w http.ResponseWriter) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Checksum-Algorithm", upload.ChecksumAlgorithms)
+}
+
+// tusResumable - refuse requests of a protocol version other than ours
+func tusResumable(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if v := r.Header.Get("Tus-Resumable"); v != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return fmt.Errorf("unsupported tus version %q", v)
+	}
+	return nil
+}
+
+// authorizeUpload - the upload of the {upload} parameter if the caller may
+// access its account. Upload IDs are random, so a missing upload is a 404
+// for everyone, which tells a tus client to start over.
+func authorizeUpload(api MediaAPI, w http.ResponseWriter, r *http.Request, uploadID string) (*dbmodel.UploadEntry, error) {
+	up, err := api.Uploads.Get(uploadID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, err
+	}
+	if up == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return nil, fmt.Errorf("upload %s not found", uploadID)
+	}
+	if _, err = authorizeAccount(api.AccountDBI, w, r, up.ID); err != nil {
+		return nil, err
+	}
+	return up, nil
+}
+
+func writeUploadState(w http.ResponseWriter, up *dbmodel.UploadEntry) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(up.Offset, 10))
+	w.Header().Set("Upload-Expires", up.ExpiresAt.UTC().Format(http.TimeFormat))
+	if up.JobID != 0 {
+		w.Header().Set(jobHeader, fmt.Sprintf("/api/media/jobs/%d", up.JobID))
+	}
+}
+
+// POST /api/media/uploads - create an upload of Upload-Length bytes. The
+// Upload-Metadata carries filename, id, catalog, title and optionally
+// description.
+func handleUploadCreate(api MediaAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	if err := tusResumable(w, r); err != nil {
+		return err
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("invalid Upload-Length specified in request")
+	}
+
+	meta, err := upload.ParseMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return err
+	}
+	id, err := strconv.Atoi(meta["id"])
+	if err != nil || meta["filename"] == "" || meta["catalog"] == "" || meta["title"] == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("required Upload-Metadata filename, id, catalog and title NOT specified")
+	}
+
+	if _, err = authorizeAccount(api.AccountDBI, w, r, id); err != nil {
+		return fmt.Errorf("Cannot upload media to customer %d: %v", id, err)
+	}
+
+	fileName := meta["filename"]
+	if err = checkNewFileName(api, w, id, fileName); err != nil {
+		return err
+	}
+
+	if err = checkQuota(api, w, id, length); err != nil {
+		return err
+	}
+
+	up := &dbmodel.UploadEntry{
+		ID:          id,
+		Catalog:     meta["catalog"],
+		Title:       meta["title"],
+		Description: meta["description"],
+		FileName:    fileName,
+		Length:      length,
+	}
+	if err = api.Uploads.Create(up); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+
+	w.Header().Set("Location", uploadsPath+"/"+up.UploadID)
+	w.Header().Set("Upload-Expires", up.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+// HEAD /api/media/uploads/{upload} - offset to resume the upload at
+func handleUploadHead(api MediaAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	if err := tusResumable(w, r); err != nil {
+		return err
+	}
+	up, err := authorizeUpload(api, w, r, params["upload"])
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Length", strconv.FormatInt(up.Length, 10))
+	writeUploadState(w, up)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// PATCH /api/media/uploads/{upload} - append a chunk at Upload-Offset,
+// verified against Upload-Checksum if sent. The last chunk hands the file
+// over to transcoding; a PATCH without body on a complete upload retries
+// a hand-off that failed.
+func handleUploadPatch(api MediaAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	if err := tusResumable(w, r); err != nil {
+		return err
+	}
+	if r.Header.Get("Content-Type") != offsetMimeType {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return fmt.Errorf("PATCH requires Content-Type %s", offsetMimeType)
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("invalid Upload-Offset specified in request")
+	}
+
+	uploadID := params["upload"]
+	up, err := authorizeUpload(api, w, r, uploadID)
+	if err != nil {
+		return err
+	}
+	/* the upload is counted at its full length, the quota may have shrunk since */
+	if err = checkQuota(api, w, up.ID, 0); err != nil {
+		return err
+	}
+
+	up, err = api.Uploads.Append(uploadID, offset, r.Body, r.Header.Get("Upload-Checksum"))
+	if up == nil && err == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return fmt.Errorf("upload %s not found", uploadID)
+	}
+	if up != nil {
+		writeUploadState(w, up)
+	}
+	switch err {
+	case nil:
+	case upload.ErrOffsetMismatch, upload.ErrBusy:
+		w.WriteHeader(http.StatusConflict)
+		return err
+	case upload.ErrBadChecksum:
+		w.WriteHeader(http.StatusBadRequest)
+		return err
+	case upload.ErrChecksumMismatch:
+		w.WriteHeader(statusChecksumMismatch)
+		return err
+	case upload.ErrTooLarge:
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return err
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+
+	if up.Offset == up.Length && up.JobID == 0 {
+		up, err = api.Uploads.Complete(uploadID, func(up *dbmodel.UploadEntry, src *os.File) (int, error) {
+			if err := checkMedia(r.Context(), src.Name()); err != nil {
+				return 0, err
+			}
+			job, err := api.storeAndTranscode(r.Context(), &dbmodel.TranscodeJobEntry{
+				ID:          up.ID,
+				Catalog:     up.Catalog,
+				Title:       up.Title,
+				Description: up.Description,
+				FileName:    up.FileName,
+			}, src, up.Length)
+			if err != nil {
+				return 0, err
+			}
+			return job.JobID, nil
+		})
+		if errors.Is(err, transcode.ErrUnsupported) {
+			/* no point in resuming, the content is what it is */
+			if rerr := api.Uploads.Remove(uploadID); rerr != nil {
+				api.LogObj.PrintError("Failed to remove rejected upload %s: %v", uploadID, rerr)
+			}
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return err
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+		writeUploadState(w, up)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// DELETE /api/media/uploads/{upload} - abandon an upload
+func handleUploadTerminate(api MediaAPI, params routeParams, w http.ResponseWriter, r *http.Request) error {
+	if err := tusResumable(w, r); err != nil {
+		return err
+	}
+	if _, err := authorizeUpload(api, w, r, params["upload"]); err != nil {
+		return err
+	}
+
+	if err := api.Uploads.Remove(params["upload"]); err != nil {
+		status := http.StatusInternalServerError
+		if err == upload.ErrBusy {
+			status = http.StatusConflict
+		}
+		http.Error(w, err.Error(), status)
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// isUploadsPath - whether an OPTIONS request asks about the uploads
+func isUploadsPath(path string) bool {
+	return path == uploadsPath || strings.HasPrefix(path, uploadsPath+"/")
+}
@@ -16,24 +16,34 @@ type AccountTblDBI interface {
 	//CheckAccountExists - test
 	CheckAccountExistsByID(id uint64) error
 
+	// GetAccountByID - get an account row without its password digest, nil if none
+	GetAccountByID(id int) (*dbmodel.AccountEntry, error)
+
 	// Login - test
 	Login(userName, PWD string) (*dbmodel.AccountEntry, error)
 
+	// ChangePassword - store a new password digest for the account
+	ChangePassword(id int, PWD string) error
+
 	// CreateAccount - test
 	CreateAccount(req util.CreateAccountReq) error
 
-	// SearchAccount - test
-	SearchAccount(UserName string) (util.SearchAccountReq, error)
+	// SearchAccount - search an account by user name within the scope
+	SearchAccount(scope TenantScope, UserName string) (util.SearchAccountReq, error)
 
 	UpdateAccount(upDetails *dbmodel.AccountEntry) error
 
 	UpdateMyAccount(upDetails *dbmodel.AccountEntry) error
 
-	// SearchAndGetAccountIDs - test
-	SearchAndGetAccountIDs(adminID int, role int) ([]util.UserDetails, error)
+	// SearchAndGetAccountIDs - accounts of the given role under adminID, within the scope
+	SearchAndGetAccountIDs(scope TenantScope, adminID int, role int) ([]util.UserDetails, error)
 
 	// AddAccounts - testing
 	AddAccounts(acDetails *dbmodel.AccountEntry) error
 
-	DeleteAccount(userName string) error
+	// DeleteAccount - delete an account and what it holds. Media it holds
+	// that another account was granted owner of pass to that account, and
+	// the customers of a business are detached from it rather than deleted.
+	// Returns the media deleted with it, whose files are left in the store.
+	DeleteAccount(id int) ([]dbmodel.MediaTypeEntry, error)
 }
@@ -0,0 +1,28 @@
+package dbi
+
+import (
+	"github.com/msproject/relive/dbmodel"
+)
+
+// AccountTransferTblDBI - requests moving a customer to another business
+type AccountTransferTblDBI interface {
+	// CreateAccountTransfer - add a pending transfer, setting its
+	// TransferID
+	CreateAccountTransfer(atDetails *dbmodel.AccountTransferEntry) error
+
+	// GetAccountTransfer - get a transfer by TransferID, nil if none exists
+	GetAccountTransfer(transferID int) (*dbmodel.AccountTransferEntry, error)
+
+	// GetPendingAccountTransfer - the pending transfer of a customer, nil
+	// if none
+	GetPendingAccountTransfer(customerID int) (*dbmodel.AccountTransferEntry, error)
+
+	// ListAccountTransfers - transfers account id is the customer or one of
+	// the businesses of, newest first
+	ListAccountTransfers(id int) ([]dbmodel.AccountTransferEntry, error)
+
+	// DecideAccountTransfer - end a pending transfer with status. Accepting
+	// moves the customer to ToPID, provided it is still with FromPID.
+	// Returns 0 if the transfer is no longer pending or the customer moved
+	DecideAccountTransfer(transferID int, status string) (int64, error)
+}
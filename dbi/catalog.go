@@ -0,0 +1,29 @@
+package dbi
+
+import (
+	"github.com/msproject/relive/dbmodel"
+)
+
+// CatalogTblDBI - albums organizing the media of an account
+type CatalogTblDBI interface {
+	// CreateCatalog - add a catalog, setting its CatalogID
+	CreateCatalog(ctDetails *dbmodel.CatalogEntry) error
+
+	// GetCatalog - get a catalog by CatalogID, nil if none exists
+	GetCatalog(catalogID int) (*dbmodel.CatalogEntry, error)
+
+	// ListCatalogs - all catalogs of account id, siblings ordered by
+	// SortOrder
+	ListCatalogs(id int) ([]dbmodel.CatalogEntry, error)
+
+	// UpdateCatalog - set parent, name, cover and sort order of a catalog
+	UpdateCatalog(ctDetails *dbmodel.CatalogEntry) error
+
+	// DeleteCatalog - drop a catalog, its media are kept uncataloged
+	DeleteCatalog(catalogID int) error
+
+	// SetCatalogMediaOrder - position the media of account id in the
+	// catalog in the order of fileNames, the ones not listed after them.
+	// Returns the number of media positioned
+	SetCatalogMediaOrder(catalogID, id int, fileNames []string) (int64, error)
+}
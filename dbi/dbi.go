@@ -3,6 +3,7 @@ package dbi
 import (
 	"fmt"
 	"github.com/msproject/relive/logger"
+	"github.com/msproject/relive/passwd"
 	"sync"
 	"time"
 )
@@ -16,15 +17,25 @@ type DBI struct {
 	SubscriptionAccountDBI SubscriptionAccountTblDBI
 	ProductDBI             ProductTblDBI
 	MediaTypeDBI           MediaTypeTblDBI
+	SessionDBI             SessionTblDBI
+	PasswordResetDBI       PasswordResetTblDBI
+	TranscodeJobDBI        TranscodeJobTblDBI
+	MediaKeyDBI            MediaKeyTblDBI
+	UploadDBI              UploadTblDBI
+	CatalogDBI             CatalogTblDBI
+	MediaGrantDBI          MediaGrantTblDBI
+	AccountTransferDBI     AccountTransferTblDBI
+	StorageDBI             StorageTblDBI
+	EgressDBI              EgressTblDBI
 }
 
 var dbi *DBI
 var once sync.Once
 
 // InitializeDBI - init
-func InitializeDBI(svcAddr string, dbTimeout time.Duration, logObj *logger.Logger) (DBI, error) {
+func InitializeDBI(svcAddr string, dbTimeout time.Duration, hasher passwd.Hasher, logObj *logger.Logger) (DBI, error) {
 	once.Do(func() {
-		sqlDBI, sqlErr := NewSQLDBI(svcAddr, dbTimeout, logObj)
+		sqlDBI, sqlErr := NewSQLDBI(svcAddr, dbTimeout, hasher, logObj)
 		if sqlErr != nil {
 			return
 		}
@@ -36,6 +47,16 @@ func InitializeDBI(svcAddr string, dbTimeout time.Duration, logObj *logger.Logge
 			SubscriptionAccountDBI: sqlDBI,
 			ProductDBI:             sqlDBI,
 			MediaTypeDBI:           sqlDBI,
+			SessionDBI:             sqlDBI,
+			PasswordResetDBI:       sqlDBI,
+			TranscodeJobDBI:        sqlDBI,
+			MediaKeyDBI:            sqlDBI,
+			UploadDBI:              sqlDBI,
+			CatalogDBI:             sqlDBI,
+			MediaGrantDBI:          sqlDBI,
+			AccountTransferDBI:     sqlDBI,
+			StorageDBI:             sqlDBI,
+			EgressDBI:              sqlDBI,
 		}
 	})
 	if dbi != nil {
@@ -0,0 +1,17 @@
+package dbi
+
+import (
+	"time"
+
+	"github.com/msproject/relive/dbmodel"
+)
+
+// EgressTblDBI - bytes playback served of the media of accounts, per day
+type EgressTblDBI interface {
+	// AddEgress - add bytes served on day to account id
+	AddEgress(id int, day time.Time, bytes int64) error
+
+	// ListEgress - bytes served per day from from to to, both included,
+	// of account id and of the customers it is the business of
+	ListEgress(id int, from, to time.Time) ([]dbmodel.EgressEntry, error)
+}
@@ -0,0 +1,27 @@
+package dbi
+
+import (
+	"github.com/msproject/relive/dbmodel"
+)
+
+// MediaGrantTblDBI - rights of accounts on media they do not hold
+type MediaGrantTblDBI interface {
+	// PutMediaGrant - grant an account a role on a media, replacing the
+	// role granted before
+	PutMediaGrant(mgDetails *dbmodel.MediaGrantEntry) error
+
+	// GetMediaGrant - the grant of account accountID on the media fileName
+	// held by ownerID, nil if none
+	GetMediaGrant(ownerID int, fileName string, accountID int) (*dbmodel.MediaGrantEntry, error)
+
+	// ListMediaGrants - all grants on the media fileName held by ownerID
+	ListMediaGrants(ownerID int, fileName string) ([]dbmodel.MediaGrantEntry, error)
+
+	// DeleteMediaGrant - revoke the grant of an account, returns the
+	// number of grants revoked
+	DeleteMediaGrant(ownerID int, fileName string, accountID int) (int64, error)
+
+	// SearchSharedMedia - live media other accounts granted accountID a
+	// role on
+	SearchSharedMedia(accountID int) ([]dbmodel.MediaTypeEntry, error)
+}
@@ -0,0 +1,18 @@
+package dbi
+
+import (
+	"github.com/msproject/relive/dbmodel"
+)
+
+// MediaKeyTblDBI - wrapped content keys of encrypted HLS assets
+type MediaKeyTblDBI interface {
+	// PutMediaKey - store the key of an asset, replacing an earlier one
+	PutMediaKey(mkDetails *dbmodel.MediaKeyEntry) error
+
+	// GetMediaKey - the key of the media name of account id, nil if the
+	// asset is not encrypted
+	GetMediaKey(id int, name string) (*dbmodel.MediaKeyEntry, error)
+
+	// DeleteMediaKey - drop the key of an asset
+	DeleteMediaKey(id int, name string) error
+}
@@ -1,6 +1,8 @@
 package dbi
 
 import (
+	"time"
+
 	"github.com/msproject/relive/dbmodel"
 )
 
@@ -8,8 +10,39 @@ import (
 type MediaTypeTblDBI interface {
 	// AddMediatype - testing
 	AddMediaType(mtDetails *dbmodel.MediaTypeEntry) error
-	// SearchMediaTypeByID - testing
-	SearchMediaTypeByID(id, pid uint64, fname string) ([]dbmodel.MediaTypeEntry, error)
-	//GetMediaCount - test
+	// SearchMediaTypeByID - media of customer id within the scope; pid and
+	// fname further restrict to a parent business and file name if set.
+	// trashed selects the media in the trash instead of the live media
+	SearchMediaTypeByID(scope TenantScope, id, pid uint64, fname string, trashed bool) ([]dbmodel.MediaTypeEntry, error)
+	// UpdateMediaType - set catalog, title, description and poster of the
+	// media of customer mtDetails.ID named mtDetails.FileName, returns the
+	// number of rows updated
+	UpdateMediaType(mtDetails *dbmodel.MediaTypeEntry) (int64, error)
+	// TrashMediaType - move live media to the trash, returns the number
+	// of rows moved
+	TrashMediaType(id int, fileName string) (int64, error)
+	// RestoreMediaType - bring media back from the trash, returns the
+	// number of rows restored
+	RestoreMediaType(id int, fileName string) (int64, error)
+	// SearchMediaByCatalog - media of customer id within the scope filed
+	// in any of the Catalog entities catalogIDs, in catalog order
+	SearchMediaByCatalog(scope TenantScope, id uint64, catalogIDs []int, trashed bool) ([]dbmodel.MediaTypeEntry, error)
+	// MoveMediaTypes - move media of customer id to catalog, filed in the
+	// Catalog entity catalogID unless it is 0. Returns the number of rows
+	// moved
+	MoveMediaTypes(id int, fileNames []string, catalog string, catalogID int) (int64, error)
+	// GetMediaType - the media, live or trashed, of account id named
+	// fileName regardless of tenancy, nil if none
+	GetMediaType(id int, fileName string) (*dbmodel.MediaTypeEntry, error)
+	// GetMediaTypeByURL - the media whose playlist is the store key url,
+	// nil if none
+	GetMediaTypeByURL(url string) (*dbmodel.MediaTypeEntry, error)
+	// DeleteMediaType - delete the media of customer id named fileName
+	// and the grants on it, returns the number of rows deleted
+	DeleteMediaType(id int, fileName string) (int64, error)
+	// ListExpiredMedia - media, live or trashed, older at now than the
+	// subscription of their business retains media
+	ListExpiredMedia(now time.Time) ([]dbmodel.MediaTypeEntry, error)
+	//GetMediaCount - live media of customer id, not counting the trash
 	GetMediaCount(id int) (int, error)
 }
@@ -0,0 +1,16 @@
+package dbi
+
+import (
+	"github.com/msproject/relive/dbmodel"
+)
+
+// PasswordResetTblDBI - single use tokens issued by /api/accounts/forgot
+type PasswordResetTblDBI interface {
+	// CreatePasswordReset - store a reset token, dropping any unused
+	// tokens issued earlier for the same account
+	CreatePasswordReset(prDetails *dbmodel.PasswordResetEntry) error
+
+	// ConsumePasswordReset - mark an unexpired, unused token as used and
+	// return the account ID it was issued for, 0 if there is none
+	ConsumePasswordReset(tokenHash string) (int, error)
+}
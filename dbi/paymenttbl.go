@@ -8,7 +8,7 @@ import (
 // PaymentTblDBI - testing
 type PaymentTblDBI interface {
 	AddPayment(pyDetails *dbmodel.PaymentEntry) error
-	SearchPayment(ID int) ([]util.PaymentDetails, error)
+	SearchPayment(scope TenantScope, ID int) ([]util.PaymentDetails, error)
 	UpdatePayment(pyDetails *dbmodel.PaymentEntry) error
 	DeletePayment(paymentID int) error
 }
@@ -2,7 +2,6 @@ package dbi
 
 import (
 	"github.com/msproject/relive/dbmodel"
-	"github.com/msproject/relive/util"
 )
 
 // ProductTblDBI - testing
@@ -11,9 +10,20 @@ type ProductTblDBI interface {
 	// CheckAccountTableExists - test
 	CheckProductTableExists() (bool, error)
 
-	// CreateProduct - create product
-	CreateProduct(req []util.CreateProductReq) error
+	// CreateProduct - create a product with its prices, an error if its
+	// ProductID is taken
+	CreateProduct(pr *dbmodel.ProductEntry) error
 
-	// GetAllProducts - get all products
-	GetAllProducts() ([]dbmodel.ProductEntry, error)
+	// UpdateProduct - change a product and replace its prices
+	UpdateProduct(pr *dbmodel.ProductEntry) error
+
+	// ArchiveProduct - take a product off the list, keeping it valid for
+	// the subscriptions to it
+	ArchiveProduct(id int) error
+
+	// GetProduct - a product, archived or not, nil if none
+	GetProduct(id int) (*dbmodel.ProductEntry, error)
+
+	// GetAllProducts - get all products, the archived ones too if archived
+	GetAllProducts(archived bool) ([]dbmodel.ProductEntry, error)
 }
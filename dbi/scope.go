@@ -0,0 +1,36 @@
+package dbi
+
+import (
+	"github.com/msproject/relive/dbmodel"
+)
+
+// TenantScope - the rows a caller may read, derived from the authenticated
+// account. Root sees every row, a business admin its own rows and those of
+// the customers whose PID is its ID, a customer only its own rows.
+type TenantScope struct {
+	AccountID int
+	Role      int
+}
+
+// RootScope - unrestricted scope, for internal callers such as DB init
+func RootScope() TenantScope {
+	return TenantScope{AccountID: 0, Role: dbmodel.RoleRoot}
+}
+
+// NewTenantScope - scope for an authenticated account
+func NewTenantScope(accountID, role int) TenantScope {
+	return TenantScope{AccountID: accountID, Role: role}
+}
+
+// clause - SQL condition (without leading AND) restricting an Account ID
+// column to this scope, with its args
+func (s TenantScope) clause(col string) (string, []interface{}) {
+	switch s.Role {
+	case dbmodel.RoleRoot:
+		return "1 = 1", nil
+	case dbmodel.RoleAdmin:
+		return col + " IN (SELECT ID FROM Account WHERE ID = ? OR PID = ?)", []interface{}{s.AccountID, s.AccountID}
+	default:
+		return col + " = ?", []interface{}{s.AccountID}
+	}
+}
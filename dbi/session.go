@@ -0,0 +1,23 @@
+package dbi
+
+import (
+	"github.com/msproject/relive/dbmodel"
+)
+
+// SessionTblDBI - bearer sessions issued by /api/accounts/login
+type SessionTblDBI interface {
+	// CreateSession - store a new session
+	CreateSession(sDetails *dbmodel.SessionEntry) error
+
+	// GetSession - get an unexpired session by its SessionID
+	GetSession(sessionID string) (*dbmodel.SessionEntry, error)
+
+	// DeleteSession - revoke one session
+	DeleteSession(sessionID string) error
+
+	// DeleteAccountSessions - revoke all sessions of an account
+	DeleteAccountSessions(id int) error
+
+	// DeleteExpiredSessions - purge sessions past their expiry
+	DeleteExpiredSessions() (int64, error)
+}
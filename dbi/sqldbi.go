@@ -1,14 +1,13 @@
 package dbi
 
 import (
-	"crypto/md5"
 	"database/sql"
 	"fmt"
 	"github.com/msproject/relive/dbmodel"
 	"github.com/msproject/relive/logger"
+	"github.com/msproject/relive/passwd"
 	"github.com/msproject/relive/util"
-	"io"
-	"math/rand"
+	"strings"
 	"time"
 )
 
@@ -17,6 +16,7 @@ type SQLIF interface {
 	Query(string, ...interface{}) (*sql.Rows, error)
 	QueryRow(string, ...interface{}) *sql.Row
 	Exec(string, ...interface{}) (sql.Result, error)
+	Begin() (*sql.Tx, error)
 }
 
 // SQLDBI - testing
@@ -24,11 +24,12 @@ type SQLDBI struct {
 	accessStr string
 	db        SQLIF
 	timeout   time.Duration
+	hasher    passwd.Hasher
 	logObj    *logger.Logger
 }
 
 // NewSQLDBI - testing
-func NewSQLDBI(dsn string, timeout time.Duration, logObj *logger.Logger) (sqlDBI *SQLDBI, err error) {
+func NewSQLDBI(dsn string, timeout time.Duration, hasher passwd.Hasher, logObj *logger.Logger) (sqlDBI *SQLDBI, err error) {
 
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
@@ -39,6 +40,7 @@ func NewSQLDBI(dsn string, timeout time.Duration, logObj *logger.Logger) (sqlDBI
 		accessStr: dsn,
 		timeout:   timeout,
 		db:        db,
+		hasher:    hasher,
 		logObj:    logObj,
 	}
 	return //
@@ -88,6 +90,32 @@ func (sqlDbi *SQLDBI) CheckAccountExistsByID(id uint64) error {
 	return fmt.Errorf("account does not exist")
 }
 
+//GetAccountByID - get an account row without its password digest, nil if none
+func (sqlDbi *SQLDBI) GetAccountByID(id int) (*dbmodel.AccountEntry, error) {
+	const getAccountQuery = `SELECT ID, PID, UserName, FirstName, LastName, EmailID, Role FROM Account WHERE ID = ?`
+
+	rows, err := sqlDbi.db.Query(getAccountQuery, id)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed querying accounts %v", err)
+		return nil, fmt.Errorf("Failed querying accounts %v", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, nil
+	}
+
+	var lastName sql.NullString
+	account := &dbmodel.AccountEntry{}
+	err = rows.Scan(&account.ID, &account.PID, &account.UserName, &account.FirstName, &lastName, &account.EmailID, &account.Role)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed scanning accounts %v", err)
+		return nil, fmt.Errorf("Failed scanning accounts %v", err)
+	}
+	account.LastName = lastName.String
+	return account, nil
+}
+
 func checkCount(rows *sql.Rows) (count int) {
 	for rows.Next() {
 		err := rows.Scan(&count)
@@ -137,8 +165,14 @@ func (sqlDbi *SQLDBI) CreateAccount(req util.CreateAccountReq) error {
 	const createAccountQuery = `INSERT INTO Account (PID, UserName, FirstName, LastName, CompanyName, EmailID, PasswdDigest, Salt, Role) VALUES `
 	var err error
 
-	// Get passwordDigest and salt here
-	passwordDigest, salt := saltedHash(req.PWD)
+	// salt is part of the encoded digest, Salt column is only kept for
+	// legacy MD5 rows
+	passwordDigest, err := sqlDbi.hasher.Hash(req.PWD)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed to hash password: %s", err.Error())
+		return fmt.Errorf("Failed to create the account %v", err)
+	}
+	salt := ""
 
 	query := createAccountQuery
 	args := []interface{}{}
@@ -156,46 +190,6 @@ func (sqlDbi *SQLDBI) CreateAccount(req util.CreateAccountReq) error {
 	return nil
 }
 
-const letterBytes = "abcdefghijklmnopqrstuvwxyz@#$ABCDEFGHIJKLMNOPQRSTUVWXYZ"
-
-// randStringBytes, saltedHash, hashPWDAndSalt functions:
-//      - used to generate a random `salt`value and encrypt user password
-func randStringBytes(n int) string {
-	rand.Seed(time.Now().UnixNano())
-	b := make([]byte, n)
-	for i := range b {
-		b[i] = letterBytes[rand.Intn(len(letterBytes))]
-	}
-	return string(b)
-}
-
-func saltedHash(PWD string) (string, string) {
-	h := md5.New()
-	io.WriteString(h, PWD)
-	pwmd5 := fmt.Sprintf("%x", h.Sum(nil))
-
-	// Generate random salt
-	salt := randStringBytes(10)
-
-	// salt + MD5 splicing
-	io.WriteString(h, salt)
-	io.WriteString(h, pwmd5)
-	passwordDigest := fmt.Sprintf("%x", h.Sum(nil))
-	return passwordDigest, salt
-}
-
-func hashPWDAndSalt(PWD, salt string) string {
-	h := md5.New()
-	io.WriteString(h, PWD)
-	pwmd5 := fmt.Sprintf("%x", h.Sum(nil))
-
-	// salt + MD5 splicing
-	io.WriteString(h, salt)
-	io.WriteString(h, pwmd5)
-	passwordDigest := fmt.Sprintf("%x", h.Sum(nil))
-	return passwordDigest
-}
-
 //Login - verify user/password from DB
 func (sqlDbi *SQLDBI) Login(userName, PWD string) (*dbmodel.AccountEntry, error) {
 	const LoginQuery = `SELECT ID, PID, UserName, EmailID, FirstName, LastName, Role, PasswdDigest, Salt
@@ -216,31 +210,73 @@ func (sqlDbi *SQLDBI) Login(userName, PWD string) (*dbmodel.AccountEntry, error)
 	defer rows.Close()
 	account := &dbmodel.AccountEntry{}
 	var passwordDigest, salt string
-	if rows.Next() {
-		err := rows.Scan(
-			&account.ID,
-			&account.PID,
-			&account.UserName,
-			&account.EmailID,
-			&account.FirstName,
-			&account.LastName,
-			&account.Role,
-			&passwordDigest,
-			&salt)
+	if !rows.Next() {
+		return nil, fmt.Errorf("The username and password don't match")
+	}
+	err = rows.Scan(
+		&account.ID,
+		&account.PID,
+		&account.UserName,
+		&account.EmailID,
+		&account.FirstName,
+		&account.LastName,
+		&account.Role,
+		&passwordDigest,
+		&salt)
+	if err != nil {
+		return nil, fmt.Errorf("Failed scanning accounts %v", err)
+	}
+	/* release the connection before a possible rehash UPDATE */
+	rows.Close()
+
+	var match bool
+	if passwd.Identify(passwordDigest) == passwd.MD5 {
+		match = passwd.VerifyLegacyMD5(PWD, salt, passwordDigest)
+	} else {
+		match, err = passwd.Verify(PWD, passwordDigest)
 		if err != nil {
-			return nil, fmt.Errorf("Failed scanning accounts %v", err)
+			sqlDbi.logObj.PrintError("Failed verifying password digest for %s: %v", userName, err)
 		}
 	}
 
-	inPasswordDigest := hashPWDAndSalt(PWD, salt)
-
-	if inPasswordDigest != passwordDigest {
+	if !match {
 		return nil, fmt.Errorf("The username and password don't match")
 	}
 
+	if sqlDbi.hasher.NeedsRehash(passwordDigest) {
+		/* a failed upgrade must not fail the login, the old digest
+		 * still verifies and we retry on the next login */
+		if err = sqlDbi.rehashPassword(account.ID, PWD); err != nil {
+			sqlDbi.logObj.PrintError("Failed to rehash password for %s: %v", userName, err)
+		}
+	}
+
 	return account, nil
 }
 
+// ChangePassword - store a new password digest for the account
+func (sqlDbi *SQLDBI) ChangePassword(id int, PWD string) error {
+	err := sqlDbi.rehashPassword(id, PWD)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed to change password of account %d: %s", id, err.Error())
+		return fmt.Errorf("Failed to change the password %v", err)
+	}
+	return nil
+}
+
+// rehashPassword - store a digest of PWD using the current hasher
+func (sqlDbi *SQLDBI) rehashPassword(id int, PWD string) error {
+	const rehashQuery = `UPDATE Account SET PasswdDigest = ?, Salt = '' WHERE ID = ?`
+
+	passwordDigest, err := sqlDbi.hasher.Hash(PWD)
+	if err != nil {
+		return err
+	}
+
+	_, err = sqlDbi.db.Exec(rehashQuery, passwordDigest, id)
+	return err
+}
+
 // AddAccounts - testing
 func (sqlDbi *SQLDBI) AddAccounts(acDetails *dbmodel.AccountEntry) (err error) {
 
@@ -306,11 +342,15 @@ func (sqlDbi *SQLDBI) UpdatePayment(pyDetails *dbmodel.PaymentEntry) (err error)
 }
 
 //SearchPayment -- test
-func (sqlDbi *SQLDBI) SearchPayment(ID int) (pays []util.PaymentDetails, err error) {
+func (sqlDbi *SQLDBI) SearchPayment(scope TenantScope, ID int) (pays []util.PaymentDetails, err error) {
 
 	const SearchPaymentQry = `SELECT ID, CCNumber, BillingAddress, CCExpiry, CVVCode FROM Payment WHERE ID = ?`
 
-	rows, err := sqlDbi.db.Query(SearchPaymentQry, ID)
+	scopeQry, scopeArgs := scope.clause("ID")
+	query := SearchPaymentQry + " AND " + scopeQry
+	args := append([]interface{}{ID}, scopeArgs...)
+
+	rows, err := sqlDbi.db.Query(query, args...)
 
 	if err != nil {
 		return pays, err
@@ -368,23 +408,59 @@ func (sqlDbi *SQLDBI) AddPaymentHistory(pyhDetails *dbmodel.PaymentHistoryEntry)
 *
 **********************************************************************************************************************************/
 
-// CreateSubscription - testing
-func (sqlDbi *SQLDBI) CreateSubscription(req util.CreateSubscriptionReq) (err error) {
+const subscriptionSelect = `SELECT ID, ProductID, SubscriptionCode, ProductType, StoreLocation, StartDate, EndDate, NumberOfAdmins,
+	        State, GraceUntil, CanceledAt, UpdatedAt, StoreSize, MaxAdmins, MaxCustomers, Renditions, RetentionDays, Duration FROM Subscription `
 
-	const sqlInsertSubscriptionQry = `INSERT INTO Subscription (ID, ProductID, ProductType, StoreLocation, StartDate, EndDate, NumberOfAdmins) VALUES `
+// querySubscriptions - the subscriptions of query
+func (sqlDbi *SQLDBI) querySubscriptions(query string, args ...interface{}) ([]dbmodel.SubscriptionEntry, error) {
+	rows, err := sqlDbi.db.Query(query, args...)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed querying subscriptions %v", err)
+		return nil, fmt.Errorf("Failed querying subscriptions %v", err)
+	}
+	defer rows.Close()
 
-	var query = sqlInsertSubscriptionQry
-	args := []interface{}{}
+	var subs []dbmodel.SubscriptionEntry
+	for rows.Next() {
+		var sub dbmodel.SubscriptionEntry
+		var graceUntil, canceledAt sql.NullTime
+		err = rows.Scan(&sub.ID, &sub.ProductID, &sub.SubscriptionCode, &sub.ProductType, &sub.StoreLocation, &sub.StartDate,
+			&sub.EndDate, &sub.NumberOfAdmins, &sub.State, &graceUntil, &canceledAt, &sub.UpdatedAt, &sub.StoreSize, &sub.MaxAdmins,
+			&sub.MaxCustomers, &sub.Renditions, &sub.RetentionDays, &sub.Duration)
+		if err != nil {
+			sqlDbi.logObj.PrintError("Failed scanning subscriptions %v", err)
+			return nil, fmt.Errorf("Failed scanning subscriptions %v", err)
+		}
+		if graceUntil.Valid {
+			sub.GraceUntil = &graceUntil.Time
+		}
+		if canceledAt.Valid {
+			sub.CanceledAt = &canceledAt.Time
+		}
+		subs = append(subs, sub)
+	}
+	return subs, rows.Err()
+}
 
-	query += "(?, ?, ?, ?, ?, ?, ?)"
-	args = append(args, req.ID, req.ProductID, req.ProductType, req.StoreLocation, req.StartDate, req.EndDate, req.NumberOfAdmins)
-	//query += sqlUpdateAccountQry
+// CreateSubscription - add a subscription in its first period
+func (sqlDbi *SQLDBI) CreateSubscription(sub *dbmodel.SubscriptionEntry) (err error) {
 
-	_, err = sqlDbi.db.Exec(query, args...)
+	const sqlInsertSubscriptionQry = `INSERT INTO Subscription (ID, ProductID, ProductType, StoreLocation, StartDate, EndDate, NumberOfAdmins,
+	        State, UpdatedAt, StoreSize, MaxAdmins, MaxCustomers, Renditions, RetentionDays, Duration)
+	        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
+	now := time.Now().UTC()
+	result, err := sqlDbi.db.Exec(sqlInsertSubscriptionQry, sub.ID, sub.ProductID, sub.ProductType, sub.StoreLocation, sub.StartDate,
+		sub.EndDate, sub.NumberOfAdmins, sub.State, now, sub.StoreSize, sub.MaxAdmins, sub.MaxCustomers, sub.Renditions, sub.RetentionDays,
+		sub.Duration)
+	if err != nil {
+		return err
+	}
+	code, err := result.LastInsertId()
 	if err != nil {
 		return err
 	}
+	sub.SubscriptionCode, sub.UpdatedAt = int(code), now
 	return nil
 }
 
@@ -420,11 +496,9 @@ func (sqlDbi *SQLDBI) DeleteSubscription(subscriptionCode uint32) (err error) {
 
 //SearchSubscription - test
 func (sqlDbi *SQLDBI) SearchSubscription(subscriptionCode uint32) (subs []util.SubscrDetails, err error) {
-	const SearchSubscriptionQry = `SELECT ID, ProductID, SubscriptionCode, ProductType FROM Subscription WHERE SubscriptionCode = ?`
+	const SearchSubscriptionQry = `SELECT ID, ProductID, SubscriptionCode, ProductType, State, StartDate, EndDate, GraceUntil
+	        FROM Subscription WHERE SubscriptionCode = ?`
 
-	fmt.Println(subscriptionCode)
-	//Result result
-	//result, err := sqlDbi.db.Exec(SearchSubscriptionQry, subscriptionCode)
 	rows, err := sqlDbi.db.Query(SearchSubscriptionQry, subscriptionCode)
 
 	if err != nil {
@@ -434,18 +508,16 @@ func (sqlDbi *SQLDBI) SearchSubscription(subscriptionCode uint32) (subs []util.S
 	defer rows.Close()
 	for rows.Next() {
 		var sub util.SubscrDetails
+		var graceUntil sql.NullTime
 
-		//fmt.Println(sub.productType)
-		//if err := rows.Scan(&ProductType); err != nil {
-		//		fmt.Println("Error")
-		//	}
-
-		if err := rows.Scan(&sub.ID, &sub.ProductID, &sub.SubscrCode, &sub.ProductType); err != nil {
-			fmt.Println("Error in scanning")
+		if err := rows.Scan(&sub.ID, &sub.ProductID, &sub.SubscrCode, &sub.ProductType, &sub.State, &sub.StartDate, &sub.EndDate,
+			&graceUntil); err != nil {
+			sqlDbi.logObj.PrintError("Failed scanning subscription %d: %v", subscriptionCode, err)
+			return nil, fmt.Errorf("Failed scanning subscription %v", err)
+		}
+		if graceUntil.Valid {
+			sub.GraceUntil = &graceUntil.Time
 		}
-
-		//fmt.Println(rows)
-		fmt.Println("sub.ProductType: ", sub.ProductType)
 
 		subs = append(subs, sub)
 	}
@@ -453,6 +525,92 @@ func (sqlDbi *SQLDBI) SearchSubscription(subscriptionCode uint32) (subs []util.S
 
 }
 
+// GetSubscription - a subscription by SubscriptionCode, nil if none
+func (sqlDbi *SQLDBI) GetSubscription(subscriptionCode uint32) (*dbmodel.SubscriptionEntry, error) {
+	subs, err := sqlDbi.querySubscriptions(subscriptionSelect+`WHERE SubscriptionCode = ?`, subscriptionCode)
+	if err != nil || len(subs) == 0 {
+		return nil, err
+	}
+	return &subs[0], nil
+}
+
+// ListSubscriptions - subscriptions of an account, latest first
+func (sqlDbi *SQLDBI) ListSubscriptions(id int) ([]dbmodel.SubscriptionEntry, error) {
+	return sqlDbi.querySubscriptions(subscriptionSelect+`WHERE ID = ? ORDER BY SubscriptionCode DESC`, id)
+}
+
+// ListRunningSubscriptions - subscriptions of an account that started and
+// did not expire
+func (sqlDbi *SQLDBI) ListRunningSubscriptions(id int) ([]dbmodel.SubscriptionEntry, error) {
+	return sqlDbi.querySubscriptions(subscriptionSelect+`WHERE ID = ? AND StartDate <= ? AND State <> ? ORDER BY SubscriptionCode`,
+		id, time.Now().UTC(), dbmodel.SubscriptionExpired)
+}
+
+// ListDueSubscriptions - running subscriptions whose period ended by now,
+// and those past due, whose grace period the caller checks
+func (sqlDbi *SQLDBI) ListDueSubscriptions(now time.Time) ([]dbmodel.SubscriptionEntry, error) {
+	const dueQuery = `WHERE (State IN (?, ?, ?) AND EndDate <= ?) OR State = ? ORDER BY EndDate`
+
+	return sqlDbi.querySubscriptions(subscriptionSelect+dueQuery, dbmodel.SubscriptionTrialing, dbmodel.SubscriptionActive,
+		dbmodel.SubscriptionCanceled, now, dbmodel.SubscriptionPastDue)
+}
+
+// AdvanceSubscription - move a subscription on if it still is in the
+// state and period it was read in, so that it moves on once only
+func (sqlDbi *SQLDBI) AdvanceSubscription(prev, next *dbmodel.SubscriptionEntry) (bool, error) {
+	const advanceQuery = `UPDATE Subscription SET State = ?, StartDate = ?, EndDate = ?, GraceUntil = ?, UpdatedAt = ?
+	        WHERE SubscriptionCode = ? AND State = ? AND EndDate = ?`
+
+	now := time.Now().UTC()
+	result, err := sqlDbi.db.Exec(advanceQuery, next.State, next.StartDate, next.EndDate, next.GraceUntil, now,
+		prev.SubscriptionCode, prev.State, prev.EndDate)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed to advance subscription %d: %s", prev.SubscriptionCode, err.Error())
+		return false, fmt.Errorf("Failed to advance the subscription %v", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("Failed to advance the subscription %v", err)
+	}
+	next.UpdatedAt = now
+	return n > 0, nil
+}
+
+// CancelSubscription - stop renewing a trialing, active or past due
+// subscription
+func (sqlDbi *SQLDBI) CancelSubscription(subscriptionCode uint32, at time.Time) (bool, error) {
+	const cancelQuery = `UPDATE Subscription SET State = ?, CanceledAt = ?, UpdatedAt = ?
+	        WHERE SubscriptionCode = ? AND State IN (?, ?, ?)`
+
+	result, err := sqlDbi.db.Exec(cancelQuery, dbmodel.SubscriptionCanceled, at, at, subscriptionCode,
+		dbmodel.SubscriptionTrialing, dbmodel.SubscriptionActive, dbmodel.SubscriptionPastDue)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed to cancel subscription %d: %s", subscriptionCode, err.Error())
+		return false, fmt.Errorf("Failed to cancel the subscription %v", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("Failed to cancel the subscription %v", err)
+	}
+	return n > 0, nil
+}
+
+// UseTrial - take the one trial of an account, false if it was taken
+func (sqlDbi *SQLDBI) UseTrial(id int, at time.Time) (bool, error) {
+	const useTrialQuery = `UPDATE Account SET TrialUsedAt = ? WHERE ID = ? AND TrialUsedAt IS NULL`
+
+	result, err := sqlDbi.db.Exec(useTrialQuery, at, id)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed to take the trial of account %d: %s", id, err.Error())
+		return false, fmt.Errorf("Failed to take the trial %v", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("Failed to take the trial %v", err)
+	}
+	return n > 0, nil
+}
+
 // AddSubscriptionAccount - testing
 func (sqlDbi *SQLDBI) AddSubscriptionAccount(subacDetails *dbmodel.SubscriptionAccountEntry) (err error) {
 
@@ -479,26 +637,182 @@ func (sqlDbi *SQLDBI) AddSubscriptionAccount(subacDetails *dbmodel.SubscriptionA
 *
 **********************************************************************************************************************************/
 
-// AddProduct - testing
-func (sqlDbi *SQLDBI) AddProduct(prDetails *dbmodel.ProductEntry) (err error) {
-
-	const sqlInsertProductsQry = `INSERT INTO Product (ProductID, ProductType, StoreSize, Duration, Amount) VALUES `
+/* product rows, scanned by scanProduct */
+const productSelect = `SELECT ProductID, ProductType, StoreSize, Duration, MaxAdmins, MaxCustomers, Renditions, RetentionDays,
+	        TrialDays, ArchivedAt, CreatedAt, UpdatedAt FROM Product `
+
+func scanProduct(rows *sql.Rows) (dbmodel.ProductEntry, error) {
+	var pr dbmodel.ProductEntry
+	var archivedAt sql.NullTime
+	err := rows.Scan(&pr.ProductID, &pr.ProductType, &pr.StoreSize, &pr.Duration, &pr.MaxAdmins, &pr.MaxCustomers,
+		&pr.Renditions, &pr.RetentionDays, &pr.TrialDays, &archivedAt, &pr.CreatedAt, &pr.UpdatedAt)
+	if archivedAt.Valid {
+		pr.ArchivedAt = &archivedAt.Time
+	}
+	return pr, err
+}
 
-	var query = sqlInsertProductsQry
-	args := []interface{}{}
+// queryProducts - the products of query with their prices
+func (sqlDbi *SQLDBI) queryProducts(query string, args ...interface{}) ([]dbmodel.ProductEntry, error) {
+	const getPricesQuery = `SELECT ProductID, Currency, Amount, TaxInclusive, TaxRate FROM ProductPrice WHERE ProductID IN (%s)
+	        ORDER BY ProductID, Currency`
+	var productList []dbmodel.ProductEntry
 
-	query += "(?, ?, ?, ?, ?)"
-	args = append(args, prDetails.ProductID, prDetails.ProductType, prDetails.StoreSize, prDetails.Duration, prDetails.Amount)
-	//query += sqlUpdateAccountQry
+	rows, err := sqlDbi.db.Query(query, args...)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed to Search products: %s", err.Error())
+		return nil, fmt.Errorf("Failed to Search products %v", err)
+	}
 
-	_, err = sqlDbi.db.Exec(query, args...)
+	defer rows.Close()
+	byID := map[int]int{}
+	for rows.Next() {
+		item, err := scanProduct(rows)
+		if err != nil {
+			sqlDbi.logObj.PrintError("Failed to Search products: %s", err.Error())
+			return nil, fmt.Errorf("Failed to Search products %v", err)
+		}
+		byID[item.ProductID] = len(productList)
+		productList = append(productList, item)
+	}
+	if len(productList) == 0 {
+		return nil, rows.Err()
+	}
 
+	ids := make([]interface{}, len(productList))
+	for i, pr := range productList {
+		ids[i] = pr.ProductID
+	}
+	marks := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	priceRows, err := sqlDbi.db.Query(fmt.Sprintf(getPricesQuery, marks), ids...)
 	if err != nil {
+		sqlDbi.logObj.PrintError("Failed to Search product prices: %s", err.Error())
+		return nil, fmt.Errorf("Failed to Search product prices %v", err)
+	}
+
+	defer priceRows.Close()
+	for priceRows.Next() {
+		var p dbmodel.ProductPriceEntry
+		err := priceRows.Scan(&p.ProductID, &p.Price.Currency, &p.Price.Minor, &p.TaxInclusive, &p.TaxRate)
+		if err != nil {
+			sqlDbi.logObj.PrintError("Failed to Search product prices: %s", err.Error())
+			return nil, fmt.Errorf("Failed to Search product prices %v", err)
+		}
+		if i, ok := byID[p.ProductID]; ok {
+			productList[i].Prices = append(productList[i].Prices, p)
+		}
+	}
+
+	return productList, priceRows.Err()
+}
+
+// setPrices - replace the prices of product id
+func setPrices(tx *sql.Tx, id int, prices []dbmodel.ProductPriceEntry) error {
+	const deletePricesQuery = `DELETE FROM ProductPrice WHERE ProductID = ?`
+	const createPriceQuery = `INSERT INTO ProductPrice (ProductID, Currency, Amount, TaxInclusive, TaxRate) VALUES (?, ?, ?, ?, ?)`
+
+	if _, err := tx.Exec(deletePricesQuery, id); err != nil {
 		return err
 	}
+	for _, p := range prices {
+		if _, err := tx.Exec(createPriceQuery, id, p.Price.Currency, p.Price.Minor, p.TaxInclusive, p.TaxRate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateProduct - add a product with its prices, an error if the
+// ProductID is taken
+func (sqlDbi *SQLDBI) CreateProduct(pr *dbmodel.ProductEntry) error {
+	const createProductQuery = `INSERT INTO Product (ProductID, ProductType, StoreSize, Duration, MaxAdmins, MaxCustomers,
+	        Renditions, RetentionDays, TrialDays, CreatedAt, UpdatedAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	tx, err := sqlDbi.db.Begin()
+	if err != nil {
+		return fmt.Errorf("Failed to create the product %v", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	_, err = tx.Exec(createProductQuery, pr.ProductID, pr.ProductType, pr.StoreSize, pr.Duration, pr.MaxAdmins, pr.MaxCustomers,
+		pr.Renditions, pr.RetentionDays, pr.TrialDays, now, now)
+	if err == nil {
+		err = setPrices(tx, pr.ProductID, pr.Prices)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed to create product %d: %s", pr.ProductID, err.Error())
+		return fmt.Errorf("Failed to create the product %v", err)
+	}
+	pr.CreatedAt, pr.UpdatedAt = now, now
+	return nil
+}
+
+// UpdateProduct - change a product and replace its prices, archived or
+// not. Subscriptions keep the product they subscribed to, and with it its
+// new features.
+func (sqlDbi *SQLDBI) UpdateProduct(pr *dbmodel.ProductEntry) error {
+	const updateProductQuery = `UPDATE Product SET ProductType = ?, StoreSize = ?, Duration = ?, MaxAdmins = ?, MaxCustomers = ?,
+	        Renditions = ?, RetentionDays = ?, TrialDays = ?, UpdatedAt = ? WHERE ProductID = ?`
+
+	tx, err := sqlDbi.db.Begin()
+	if err != nil {
+		return fmt.Errorf("Failed to update the product %v", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	_, err = tx.Exec(updateProductQuery, pr.ProductType, pr.StoreSize, pr.Duration, pr.MaxAdmins, pr.MaxCustomers,
+		pr.Renditions, pr.RetentionDays, pr.TrialDays, now, pr.ProductID)
+	if err == nil {
+		err = setPrices(tx, pr.ProductID, pr.Prices)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed to update product %d: %s", pr.ProductID, err.Error())
+		return fmt.Errorf("Failed to update the product %v", err)
+	}
+	pr.UpdatedAt = now
+	return nil
+}
+
+// ArchiveProduct - take a product off the list. It is kept, priced and
+// valid for the subscriptions to it.
+func (sqlDbi *SQLDBI) ArchiveProduct(id int) error {
+	const archiveProductQuery = `UPDATE Product SET ArchivedAt = ?, UpdatedAt = ? WHERE ProductID = ? AND ArchivedAt IS NULL`
+
+	now := time.Now().UTC()
+	if _, err := sqlDbi.db.Exec(archiveProductQuery, now, now, id); err != nil {
+		sqlDbi.logObj.PrintError("Failed to archive product %d: %s", id, err.Error())
+		return fmt.Errorf("Failed to archive the product %v", err)
+	}
 	return nil
 }
 
+// GetProduct - a product with its prices, archived or not, nil if none
+func (sqlDbi *SQLDBI) GetProduct(id int) (*dbmodel.ProductEntry, error) {
+	products, err := sqlDbi.queryProducts(productSelect+`WHERE ProductID = ?`, id)
+	if err != nil || len(products) == 0 {
+		return nil, err
+	}
+	return &products[0], nil
+}
+
+//GetAllProducts - get all products with their prices, the archived ones
+//too if archived is set
+func (sqlDbi *SQLDBI) GetAllProducts(archived bool) ([]dbmodel.ProductEntry, error) {
+	query := productSelect
+	if !archived {
+		query += `WHERE ArchivedAt IS NULL `
+	}
+	return sqlDbi.queryProducts(query + `ORDER BY ProductID`)
+}
+
 /**********************************************************************************************************************************
 *
 *	ACCOUNT FUNCTIONS
@@ -507,8 +821,8 @@ func (sqlDbi *SQLDBI) AddProduct(prDetails *dbmodel.ProductEntry) (err error) {
 
 // SearchAccount - function to Search an account row.
 //                 Duplicate rows are not allowed and will throw error
-func (sqlDbi *SQLDBI) SearchAccount(UserName string) (util.SearchAccountReq, error) {
-	const SearchAccountQuery = `SELECT ID, UserName, EmailID, FirstName, LastName, Role FROM Account WHERE UserName = ?`
+func (sqlDbi *SQLDBI) SearchAccount(scope TenantScope, UserName string) (util.SearchAccountReq, error) {
+	const SearchAccountQuery = `SELECT ID, PID, UserName, EmailID, FirstName, LastName, Role FROM Account WHERE UserName = ?`
 	var req util.SearchAccountReq
 
 	// Get passwordDigest and salt here
@@ -519,6 +833,10 @@ func (sqlDbi *SQLDBI) SearchAccount(UserName string) (util.SearchAccountReq, err
 
 	args = append(args, UserName)
 
+	scopeQry, scopeArgs := scope.clause("ID")
+	query += " AND " + scopeQry
+	args = append(args, scopeArgs...)
+
 	rows, err := sqlDbi.db.Query(query, args...)
 	if err != nil {
 		sqlDbi.logObj.PrintError("Failed to Search account: %s", err.Error())
@@ -532,7 +850,7 @@ func (sqlDbi *SQLDBI) SearchAccount(UserName string) (util.SearchAccountReq, err
 			sqlDbi.logObj.PrintError("Found more than one entry for user: %s", UserName)
 			return req, fmt.Errorf("Found more than one entry for user: %s", UserName)
 		}
-		err := rows.Scan(&req.ID, &req.UserName, &req.Email, &req.FirstName, &req.LastName, &req.Role)
+		err := rows.Scan(&req.ID, &req.CompanyID, &req.UserName, &req.Email, &req.FirstName, &req.LastName, &req.Role)
 		if err != nil {
 			sqlDbi.logObj.PrintError("Failed to Search account: %s", err.Error())
 			return req, fmt.Errorf("Failed to Search the account %v", err)
@@ -544,12 +862,13 @@ func (sqlDbi *SQLDBI) SearchAccount(UserName string) (util.SearchAccountReq, err
 }
 
 //SearchAndGetAccountIDs - test
-func (sqlDbi *SQLDBI) SearchAndGetAccountIDs(adminID int, role int) ([]util.UserDetails, error) {
+func (sqlDbi *SQLDBI) SearchAndGetAccountIDs(scope TenantScope, adminID int, role int) ([]util.UserDetails, error) {
 	const SearchAccountQuery = `SELECT UserName, ID FROM Account WHERE ROLE = ? AND PID = ?`
 	var userList []util.UserDetails
 
-	query := SearchAccountQuery
-	args := []interface{}{role, adminID}
+	scopeQry, scopeArgs := scope.clause("ID")
+	query := SearchAccountQuery + " AND " + scopeQry
+	args := append([]interface{}{role, adminID}, scopeArgs...)
 
 	rows, err := sqlDbi.db.Query(query, args...)
 	if err != nil {
@@ -651,55 +970,155 @@ func (sqlDbi *SQLDBI) UpdateMyAccount(upmDetails *dbmodel.AccountEntry) (err err
 }
 
 //DeleteAccount - test
-func (sqlDbi *SQLDBI) DeleteAccount(userName string) (err error) {
-	const deleteAccountQry = `DELETE FROM Account WHERE userName = ?`
+func (sqlDbi *SQLDBI) DeleteAccount(id int) (deleted []dbmodel.MediaTypeEntry, err error) {
+	const accountExistsQry = `SELECT ID FROM Account WHERE ID = ?`
+	/* per media the first account granted owner that holds no media of that name yet */
+	const takeoverQry = `SELECT g.FileName, MIN(g.AccountID) FROM MediaGrant g WHERE g.OwnerID = ? AND g.Role = ?
+	        AND NOT EXISTS (SELECT 1 FROM MediaType m WHERE m.ID = g.AccountID AND m.FileName = g.FileName)
+	        GROUP BY g.FileName`
+	const takeOverMediaQry = `UPDATE MediaType SET ID = ?, CatalogID = 0, Position = 0 WHERE ID = ? AND FileName = ?`
+	const dropTakeoverGrantQry = `DELETE FROM MediaGrant WHERE OwnerID = ? AND FileName = ? AND AccountID = ?`
+	const moveGrantsQry = `UPDATE MediaGrant SET OwnerID = ? WHERE OwnerID = ? AND FileName = ?`
+	const deleteGrantsQry = `DELETE FROM MediaGrant WHERE OwnerID = ?`
+	/* keys are stored under the uploader, which may differ from the holder */
+	const deleteHeldKeysQry = `DELETE k FROM MediaKey k JOIN MediaType m
+	        ON m.URL = CONCAT(k.ID, '/', k.Name, '/', k.Name, '.m3u8') WHERE m.ID = ?`
+	const deleteUnheldKeysQry = `DELETE FROM MediaKey WHERE ID = ?
+	        AND CONCAT(ID, '/', Name, '/', Name, '.m3u8') NOT IN (SELECT URL FROM MediaType)`
+	const detachCustomersQry = `UPDATE Account SET PID = 0 WHERE PID = ? AND Role = ?`
+	const cancelTransfersQry = `UPDATE AccountTransfer SET Status = ?, DecidedAt = ?
+	        WHERE Status = ? AND (FromPID = ? OR ToPID = ?)`
+	/* what the account still holds goes with it, MediaType_ibfk_1 cascades */
+	const heldMediaQry = `SELECT FileName, URL FROM MediaType WHERE ID = ?`
+	const deleteAccountQry = `DELETE FROM Account WHERE ID = ?`
+
+	tx, err := sqlDbi.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
 
-	_, err = sqlDbi.db.Exec(deleteAccountQry, userName)
+	err = tx.QueryRow(accountExistsQry, id).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
 
+	rows, err := tx.Query(takeoverQry, id, dbmodel.GrantOwner)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	takeovers := map[string]int{}
+	for rows.Next() {
+		var fileName string
+		var owner int
+		if err = rows.Scan(&fileName, &owner); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		takeovers[fileName] = owner
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for fileName, owner := range takeovers {
+		if _, err = tx.Exec(takeOverMediaQry, owner, id, fileName); err != nil {
+			return nil, err
+		}
+		if _, err = tx.Exec(dropTakeoverGrantQry, id, fileName, owner); err != nil {
+			return nil, err
+		}
+		if _, err = tx.Exec(moveGrantsQry, owner, id, fileName); err != nil {
+			return nil, err
+		}
+	}
+
+	if rows, err = tx.Query(heldMediaQry, id); err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		m := dbmodel.MediaTypeEntry{ID: id}
+		if err = rows.Scan(&m.FileName, &m.URL); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		deleted = append(deleted, m)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	for _, stmt := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{deleteGrantsQry, []interface{}{id}},
+		{deleteHeldKeysQry, []interface{}{id}},
+		{deleteUnheldKeysQry, []interface{}{id}},
+		{detachCustomersQry, []interface{}{id, dbmodel.RoleCustomer}},
+		{cancelTransfersQry, []interface{}{dbmodel.TransferCanceled, now, dbmodel.TransferPending, id, id}},
+		{deleteAccountQry, []interface{}{id}},
+	} {
+		if _, err = tx.Exec(stmt.query, stmt.args...); err != nil {
+			sqlDbi.logObj.PrintError("Failed to delete account: %s", err.Error())
+			return nil, fmt.Errorf("Failed to delete the account %v", err)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return deleted, nil
 }
 
 // AddMediaType - testing
 func (sqlDbi *SQLDBI) AddMediaType(mtDetails *dbmodel.MediaTypeEntry) (err error) {
 
-	const sqlInsertMediatypeQry = `INSERT INTO MediaType (ID, Catalog, FileName, Title, Description, URL, Poster) VALUES `
+	const sqlInsertMediatypeQry = `INSERT INTO MediaType (ID, Catalog, FileName, Title, Description, URL, Poster, Posters, Thumbnails,
+	        Download, StoredBytes) VALUES `
+	const sqlInsertMediaInfoQry = `INSERT INTO MediaInfo (URL, Container, Duration, Width, Height, VideoCodec, AudioCodec,
+	        Bitrate, FrameRate, FileSize) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
 	var query = sqlInsertMediatypeQry
 	args := []interface{}{}
 
-	query += "(?, ?, ?, ?, ?, ?, ?)"
-	args = append(args, mtDetails.ID, mtDetails.Catalog, mtDetails.FileName, mtDetails.Title, mtDetails.Description, mtDetails.URL, mtDetails.Poster)
-
-	_, err = sqlDbi.db.Exec(query, args...)
+	query += "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	args = append(args, mtDetails.ID, mtDetails.Catalog, mtDetails.FileName, mtDetails.Title, mtDetails.Description, mtDetails.URL, mtDetails.Poster,
+		mtDetails.Posters, mtDetails.Thumbnails, mtDetails.Download, mtDetails.StoredBytes)
 
+	/* the media and what was probed about it are added together */
+	tx, err := sqlDbi.db.Begin()
 	if err != nil {
 		return err
 	}
-	return nil
-}
-
-//SearchMediaTypeByID - testing
-func (sqlDbi *SQLDBI) SearchMediaTypeByID(id, pid uint64, fname string) ([]dbmodel.MediaTypeEntry, error) {
-	const searchMediaQuery = `SELECT ID, Catalog, FileName, Title, Description, URL, Poster FROM MediaType WHERE ID = ? `
-	var resp []dbmodel.MediaTypeEntry
-
-	query := searchMediaQuery
-	args := []interface{}{}
+	defer tx.Rollback()
 
-	args = append(args, id)
-
-	if pid > 0 {
-		query += ` AND PID = ? `
-		args = append(args, pid)
+	if _, err = tx.Exec(query, args...); err != nil {
+		return err
 	}
 
-	if len(fname) > 0 {
-		query += ` AND FileName = ? `
-		args = append(args, fname)
+	if info := mtDetails.Info; info != nil {
+		_, err = tx.Exec(sqlInsertMediaInfoQry, mtDetails.URL, info.Container, info.Duration, info.Width, info.Height,
+			info.VideoCodec, info.AudioCodec, info.Bitrate, info.FrameRate, info.FileSize)
+		if err != nil {
+			return err
+		}
 	}
+	return tx.Commit()
+}
+
+/* media rows with what was probed about them */
+const mediaSelect = `SELECT m.ID, m.Catalog, m.FileName, m.Title, m.Description, m.URL, m.Poster, m.Posters, m.Thumbnails, m.Download,
+	        m.CatalogID, m.Position, m.StoredBytes, m.DeletedAt, i.URL, i.Container, i.Duration, i.Width, i.Height, i.VideoCodec,
+	        i.AudioCodec, i.Bitrate, i.FrameRate, i.FileSize FROM MediaType m LEFT JOIN MediaInfo i ON i.URL = m.URL `
+
+func (sqlDbi *SQLDBI) queryMedia(query string, args ...interface{}) ([]dbmodel.MediaTypeEntry, error) {
+	var resp []dbmodel.MediaTypeEntry
 
 	rows, err := sqlDbi.db.Query(query, args...)
 	if err != nil {
@@ -710,36 +1129,243 @@ func (sqlDbi *SQLDBI) SearchMediaTypeByID(id, pid uint64, fname string) ([]dbmod
 	defer rows.Close()
 	for rows.Next() {
 		var item dbmodel.MediaTypeEntry
-		err := rows.Scan(&item.ID, &item.Catalog, &item.FileName, &item.Title, &item.Description, &item.URL, &item.Poster)
+		var deletedAt sql.NullTime
+		var infoURL, container, videoCodec, audioCodec sql.NullString
+		var duration, frameRate sql.NullFloat64
+		var width, height, bitrate, fileSize sql.NullInt64
+		err := rows.Scan(&item.ID, &item.Catalog, &item.FileName, &item.Title, &item.Description, &item.URL, &item.Poster,
+			&item.Posters, &item.Thumbnails, &item.Download, &item.CatalogID, &item.Position, &item.StoredBytes, &deletedAt, &infoURL, &container, &duration, &width, &height,
+			&videoCodec, &audioCodec, &bitrate, &frameRate, &fileSize)
 		if err != nil {
 			sqlDbi.logObj.PrintError("Failed to scan media : %s", err.Error())
 			return resp, fmt.Errorf("Failed to scan media: %v", err)
 		}
+		if deletedAt.Valid {
+			item.DeletedAt = &deletedAt.Time
+		}
+		if infoURL.Valid {
+			item.Info = &dbmodel.MediaInfoEntry{
+				Container:  container.String,
+				Duration:   duration.Float64,
+				Width:      int(width.Int64),
+				Height:     int(height.Int64),
+				VideoCodec: videoCodec.String,
+				AudioCodec: audioCodec.String,
+				Bitrate:    bitrate.Int64,
+				FrameRate:  frameRate.Float64,
+				FileSize:   fileSize.Int64,
+			}
+		}
 		resp = append(resp, item)
 	}
 
 	return resp, nil
-
 }
 
-//GetMediaCount - test
-func (sqlDbi *SQLDBI) GetMediaCount(id int) (int, error) {
-	const getMediaCntQuery = "Select COUNT(*) as count from MediaType where ID = ?"
-	var (
-		rows  *sql.Rows
-		err   error
-		count int
-	)
-
-	rows, err = sqlDbi.db.Query(getMediaCntQuery, id)
-	if err != nil {
-		sqlDbi.logObj.PrintError("Failed querying mediatype %v", err)
-		return 0, fmt.Errorf("Failed querying mediatype %v", err)
+func trashClause(trashed bool) string {
+	if trashed {
+		return ` AND m.DeletedAt IS NOT NULL `
 	}
-	defer rows.Close()
+	return ` AND m.DeletedAt IS NULL `
+}
 
-	for rows.Next() {
-		err := rows.Scan(&count)
+//SearchMediaTypeByID - testing
+func (sqlDbi *SQLDBI) SearchMediaTypeByID(scope TenantScope, id, pid uint64, fname string, trashed bool) ([]dbmodel.MediaTypeEntry, error) {
+	query := mediaSelect + `WHERE m.ID = ? ` + trashClause(trashed)
+	args := []interface{}{}
+
+	args = append(args, id)
+
+	/* MediaType is keyed by the customer, its business is the Account PID */
+	if pid > 0 {
+		query += ` AND m.ID IN (SELECT ID FROM Account WHERE PID = ?) `
+		args = append(args, pid)
+	}
+
+	scopeQry, scopeArgs := scope.clause("m.ID")
+	query += " AND " + scopeQry
+	args = append(args, scopeArgs...)
+
+	if len(fname) > 0 {
+		query += ` AND m.FileName = ? `
+		args = append(args, fname)
+	}
+
+	return sqlDbi.queryMedia(query, args...)
+}
+
+// SearchMediaByCatalog - media of customer id filed in the catalogs, in
+// catalog order
+func (sqlDbi *SQLDBI) SearchMediaByCatalog(scope TenantScope, id uint64, catalogIDs []int, trashed bool) ([]dbmodel.MediaTypeEntry, error) {
+	if len(catalogIDs) == 0 {
+		return nil, nil
+	}
+
+	query := mediaSelect + `WHERE m.ID = ? ` + trashClause(trashed) +
+		` AND m.CatalogID IN (?` + strings.Repeat(", ?", len(catalogIDs)-1) + `) `
+	args := []interface{}{id}
+	for _, catalogID := range catalogIDs {
+		args = append(args, catalogID)
+	}
+
+	scopeQry, scopeArgs := scope.clause("m.ID")
+	/* unpositioned media (Position 0) come after the positioned ones */
+	query += " AND " + scopeQry + ` ORDER BY m.CatalogID, m.Position = 0, m.Position, m.FileName`
+	args = append(args, scopeArgs...)
+
+	return sqlDbi.queryMedia(query, args...)
+}
+
+// UpdateMediaType - set catalog, title, description and poster of a media
+func (sqlDbi *SQLDBI) UpdateMediaType(mtDetails *dbmodel.MediaTypeEntry) (int64, error) {
+	const updateMediaQry = `UPDATE MediaType SET Catalog = ?, Title = ?, Description = ?, Poster = ?
+	        WHERE ID = ? AND FileName = ?`
+
+	result, err := sqlDbi.db.Exec(updateMediaQry, mtDetails.Catalog, mtDetails.Title, mtDetails.Description,
+		mtDetails.Poster, mtDetails.ID, mtDetails.FileName)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed to update media: %s", err.Error())
+		return 0, fmt.Errorf("Failed to update media %v", err)
+	}
+	return result.RowsAffected()
+}
+
+// TrashMediaType - move live media to the trash
+func (sqlDbi *SQLDBI) TrashMediaType(id int, fileName string) (int64, error) {
+	const trashMediaQry = `UPDATE MediaType SET DeletedAt = ? WHERE ID = ? AND FileName = ? AND DeletedAt IS NULL`
+
+	result, err := sqlDbi.db.Exec(trashMediaQry, time.Now().UTC(), id, fileName)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed to trash media: %s", err.Error())
+		return 0, fmt.Errorf("Failed to trash media %v", err)
+	}
+	return result.RowsAffected()
+}
+
+// RestoreMediaType - bring media back from the trash
+func (sqlDbi *SQLDBI) RestoreMediaType(id int, fileName string) (int64, error) {
+	const restoreMediaQry = `UPDATE MediaType SET DeletedAt = NULL WHERE ID = ? AND FileName = ? AND DeletedAt IS NOT NULL`
+
+	result, err := sqlDbi.db.Exec(restoreMediaQry, id, fileName)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed to restore media: %s", err.Error())
+		return 0, fmt.Errorf("Failed to restore media %v", err)
+	}
+	return result.RowsAffected()
+}
+
+// MoveMediaTypes - move media of customer id to catalog, filed in the
+// Catalog entity catalogID unless 0
+func (sqlDbi *SQLDBI) MoveMediaTypes(id int, fileNames []string, catalog string, catalogID int) (int64, error) {
+	if len(fileNames) == 0 {
+		return 0, nil
+	}
+
+	query := `UPDATE MediaType SET Catalog = ?, CatalogID = ?, Position = 0 WHERE ID = ? AND FileName IN (?` +
+		strings.Repeat(", ?", len(fileNames)-1) + `)`
+	args := []interface{}{catalog, catalogID, id}
+	for _, fileName := range fileNames {
+		args = append(args, fileName)
+	}
+
+	result, err := sqlDbi.db.Exec(query, args...)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed to move media: %s", err.Error())
+		return 0, fmt.Errorf("Failed to move media %v", err)
+	}
+	return result.RowsAffected()
+}
+
+// GetMediaType - the media of account id named fileName, live or trashed
+func (sqlDbi *SQLDBI) GetMediaType(id int, fileName string) (*dbmodel.MediaTypeEntry, error) {
+	found, err := sqlDbi.queryMedia(mediaSelect+`WHERE m.ID = ? AND m.FileName = ?`, id, fileName)
+	if err != nil || len(found) == 0 {
+		return nil, err
+	}
+	return &found[0], nil
+}
+
+// GetMediaTypeByURL - the media whose playlist is stored at url
+func (sqlDbi *SQLDBI) GetMediaTypeByURL(url string) (*dbmodel.MediaTypeEntry, error) {
+	found, err := sqlDbi.queryMedia(mediaSelect+`WHERE m.URL = ?`, url)
+	if err != nil || len(found) == 0 {
+		return nil, err
+	}
+	return &found[0], nil
+}
+
+//DeleteMediaType - delete the media of customer id named fileName
+func (sqlDbi *SQLDBI) DeleteMediaType(id int, fileName string) (int64, error) {
+	const deleteMediaQry = `DELETE FROM MediaType WHERE ID = ? AND FileName = ?`
+	const deleteGrantsQry = `DELETE FROM MediaGrant WHERE OwnerID = ? AND FileName = ?`
+
+	tx, err := sqlDbi.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(deleteMediaQry, id, fileName)
+	if err == nil {
+		_, err = tx.Exec(deleteGrantsQry, id, fileName)
+	}
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed to delete media: %s", err.Error())
+		return 0, fmt.Errorf("Failed to delete media %v", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// ListExpiredMedia - media, live or trashed, added longer ago than the
+// running subscriptions of their business retain media. A business with
+// one retaining them for good keeps them all.
+func (sqlDbi *SQLDBI) ListExpiredMedia(now time.Time) ([]dbmodel.MediaTypeEntry, error) {
+	const expiredMediaQry = `SELECT m.ID, m.FileName, m.URL FROM MediaType m JOIN Account a ON a.ID = m.ID
+	        JOIN (SELECT ID, MAX(RetentionDays) AS Days FROM Subscription WHERE StartDate <= ? AND State <> ?
+	        GROUP BY ID HAVING MIN(RetentionDays) > 0) r ON r.ID = IF(a.Role = ? AND a.PID <> 0, a.PID, a.ID)
+	        WHERE m.CreatedAt < DATE_SUB(?, INTERVAL r.Days DAY)`
+
+	rows, err := sqlDbi.db.Query(expiredMediaQry, now, dbmodel.SubscriptionExpired, dbmodel.RoleCustomer, now)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed querying expired media %v", err)
+		return nil, fmt.Errorf("Failed querying expired media %v", err)
+	}
+	defer rows.Close()
+
+	var expired []dbmodel.MediaTypeEntry
+	for rows.Next() {
+		var m dbmodel.MediaTypeEntry
+		if err = rows.Scan(&m.ID, &m.FileName, &m.URL); err != nil {
+			sqlDbi.logObj.PrintError("Failed scanning expired media %v", err)
+			return nil, fmt.Errorf("Failed scanning expired media %v", err)
+		}
+		expired = append(expired, m)
+	}
+	return expired, rows.Err()
+}
+
+//GetMediaCount - test
+func (sqlDbi *SQLDBI) GetMediaCount(id int) (int, error) {
+	const getMediaCntQuery = "Select COUNT(*) as count from MediaType where ID = ? AND DeletedAt IS NULL"
+	var (
+		rows  *sql.Rows
+		err   error
+		count int
+	)
+
+	rows, err = sqlDbi.db.Query(getMediaCntQuery, id)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed querying mediatype %v", err)
+		return 0, fmt.Errorf("Failed querying mediatype %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		err := rows.Scan(&count)
 		if err != nil {
 			sqlDbi.logObj.PrintError("Failed scanning mediatype %v", err)
 			return 0, fmt.Errorf("Failed querying mediatype %v", err)
@@ -782,60 +1408,927 @@ func (sqlDbi *SQLDBI) CheckProductTableExists() (bool, error) {
 	return false, nil
 }
 
-// CreateProduct - function to create an product row.
-// Duplicate rows are not allowed and will throw error
-func (sqlDbi *SQLDBI) CreateProduct(req []util.CreateProductReq) error {
-	const createProductQuery = `INSERT INTO Product (ProductID, ProductType, StoreSize, Duration, Amount) VALUES `
-	const endQuery = ` ON DUPLICATE KEY UPDATE ProductID = VALUES(ProductID), ProductType = VALUES(ProductType), 
-                    StoreSize = VALUES(StoreSize), Duration = VALUES(Duration), Amount = VALUES(Amount) `
-	var err error
+/**********************************************************************************************************************************
+*
+*	SESSION FUNCTIONS
+*
+**********************************************************************************************************************************/
+
+// CreateSession - store a new session
+func (sqlDbi *SQLDBI) CreateSession(sDetails *dbmodel.SessionEntry) (err error) {
 
-	query := createProductQuery
+	const sqlInsertSessionQry = `INSERT INTO Session (SessionID, ID, PID, UserName, Role, CreatedAt, ExpiresAt) VALUES `
+
+	var query = sqlInsertSessionQry
 	args := []interface{}{}
 
-	for i, r := range req {
-		if i == len(req)-1 {
-			query += "(?, ?, ?, ?, ?)"
-		} else {
-			query += "(?, ?, ?, ?, ?), "
+	query += "(?, ?, ?, ?, ?, ?, ?)"
+	args = append(args, sDetails.SessionID, sDetails.ID, sDetails.PID, sDetails.UserName, sDetails.Role, sDetails.CreatedAt, sDetails.ExpiresAt)
+
+	_, err = sqlDbi.db.Exec(query, args...)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed to create session: %s", err.Error())
+		return fmt.Errorf("Failed to create the session %v", err)
+	}
+	return nil
+}
+
+// GetSession - get an unexpired session, nil if none exists
+func (sqlDbi *SQLDBI) GetSession(sessionID string) (*dbmodel.SessionEntry, error) {
+	const getSessionQuery = `SELECT SessionID, ID, PID, UserName, Role, CreatedAt, ExpiresAt FROM Session
+	        WHERE SessionID = ? AND ExpiresAt > ?`
+
+	rows, err := sqlDbi.db.Query(getSessionQuery, sessionID, time.Now().UTC())
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed querying session %v", err)
+		return nil, fmt.Errorf("Failed querying session %v", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, nil
+	}
+
+	session := &dbmodel.SessionEntry{}
+	err = rows.Scan(&session.SessionID, &session.ID, &session.PID, &session.UserName, &session.Role, &session.CreatedAt, &session.ExpiresAt)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed scanning session %v", err)
+		return nil, fmt.Errorf("Failed scanning session %v", err)
+	}
+	return session, nil
+}
+
+// DeleteSession - revoke one session
+func (sqlDbi *SQLDBI) DeleteSession(sessionID string) (err error) {
+	const deleteSessionQry = `DELETE FROM Session WHERE SessionID = ?`
+
+	_, err = sqlDbi.db.Exec(deleteSessionQry, sessionID)
+
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteAccountSessions - revoke all sessions of an account
+func (sqlDbi *SQLDBI) DeleteAccountSessions(id int) (err error) {
+	const deleteAccountSessionsQry = `DELETE FROM Session WHERE ID = ?`
+
+	_, err = sqlDbi.db.Exec(deleteAccountSessionsQry, id)
+
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteExpiredSessions - purge sessions past their expiry
+func (sqlDbi *SQLDBI) DeleteExpiredSessions() (int64, error) {
+	const deleteExpiredSessionsQry = `DELETE FROM Session WHERE ExpiresAt <= ?`
+
+	result, err := sqlDbi.db.Exec(deleteExpiredSessionsQry, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+/**********************************************************************************************************************************
+*
+*	PASSWORD RESET FUNCTIONS
+*
+**********************************************************************************************************************************/
+
+// CreatePasswordReset - store a reset token, dropping unused earlier ones
+func (sqlDbi *SQLDBI) CreatePasswordReset(prDetails *dbmodel.PasswordResetEntry) (err error) {
+	const deleteOutstandingQry = `DELETE FROM PasswordReset WHERE ID = ? AND UsedAt IS NULL`
+	const sqlInsertPasswordResetQry = `INSERT INTO PasswordReset (TokenHash, ID, CreatedAt, ExpiresAt) VALUES (?, ?, ?, ?)`
+
+	_, err = sqlDbi.db.Exec(deleteOutstandingQry, prDetails.ID)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed to drop outstanding password resets: %s", err.Error())
+		return fmt.Errorf("Failed to create the password reset %v", err)
+	}
+
+	_, err = sqlDbi.db.Exec(sqlInsertPasswordResetQry, prDetails.TokenHash, prDetails.ID, prDetails.CreatedAt, prDetails.ExpiresAt)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed to create password reset: %s", err.Error())
+		return fmt.Errorf("Failed to create the password reset %v", err)
+	}
+	return nil
+}
+
+// ConsumePasswordReset - mark the token used, 0 if it is unknown, used or expired
+func (sqlDbi *SQLDBI) ConsumePasswordReset(tokenHash string) (int, error) {
+	/* the conditional UPDATE makes the token single use even when two
+	 * requests race with it */
+	const consumeQry = `UPDATE PasswordReset SET UsedAt = ? WHERE TokenHash = ? AND UsedAt IS NULL AND ExpiresAt > ?`
+	const getAccountQry = `SELECT ID FROM PasswordReset WHERE TokenHash = ?`
+
+	now := time.Now().UTC()
+	result, err := sqlDbi.db.Exec(consumeQry, now, tokenHash, now)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed to consume password reset: %s", err.Error())
+		return 0, fmt.Errorf("Failed to consume the password reset %v", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if n != 1 {
+		return 0, nil
+	}
+
+	var id int
+	err = sqlDbi.db.QueryRow(getAccountQry, tokenHash).Scan(&id)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed to scan password reset: %s", err.Error())
+		return 0, fmt.Errorf("Failed to consume the password reset %v", err)
+	}
+	return id, nil
+}
+
+/**********************************************************************************************************************************
+*
+*	TRANSCODE JOB FUNCTIONS
+*
+**********************************************************************************************************************************/
+
+const transcodeJobColumns = `JobID, ID, Catalog, Title, Description, FileName, SrcKey, OutPrefix, BaseName, URL, Poster,
+	        SrcSize, Renditions, Status, Step, Attempts, LastError, NextRunAt, CreatedAt, UpdatedAt`
+
+func scanTranscodeJob(rows *sql.Rows) (*dbmodel.TranscodeJobEntry, error) {
+	job := &dbmodel.TranscodeJobEntry{}
+	err := rows.Scan(&job.JobID, &job.ID, &job.Catalog, &job.Title, &job.Description, &job.FileName, &job.SrcKey, &job.OutPrefix, &job.BaseName,
+		&job.URL, &job.Poster, &job.SrcSize, &job.Renditions, &job.Status, &job.Step, &job.Attempts, &job.LastError, &job.NextRunAt, &job.CreatedAt, &job.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// CreateTranscodeJob - queue a job, setting its JobID
+func (sqlDbi *SQLDBI) CreateTranscodeJob(job *dbmodel.TranscodeJobEntry) (err error) {
+	const sqlInsertTranscodeJobQry = `INSERT INTO TranscodeJob (ID, Catalog, Title, Description, FileName, SrcKey, OutPrefix, BaseName,
+	        URL, Poster, SrcSize, Renditions, Status, NextRunAt, CreatedAt, UpdatedAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	now := time.Now().UTC()
+	job.Status = dbmodel.JobQueued
+	job.NextRunAt, job.CreatedAt, job.UpdatedAt = now, now, now
+
+	result, err := sqlDbi.db.Exec(sqlInsertTranscodeJobQry, job.ID, job.Catalog, job.Title, job.Description, job.FileName, job.SrcKey,
+		job.OutPrefix, job.BaseName, job.URL, job.Poster, job.SrcSize, job.Renditions, job.Status, job.NextRunAt, job.CreatedAt, job.UpdatedAt)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed to create transcode job: %s", err.Error())
+		return fmt.Errorf("Failed to create the transcode job %v", err)
+	}
+
+	jobID, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("Failed to get the transcode job id %v", err)
+	}
+	job.JobID = int(jobID)
+	return nil
+}
+
+// GetTranscodeJob - get a job by JobID, nil if none exists
+func (sqlDbi *SQLDBI) GetTranscodeJob(jobID int) (*dbmodel.TranscodeJobEntry, error) {
+	getTranscodeJobQuery := `SELECT ` + transcodeJobColumns + ` FROM TranscodeJob WHERE JobID = ?`
+
+	rows, err := sqlDbi.db.Query(getTranscodeJobQuery, jobID)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed querying transcode job %v", err)
+		return nil, fmt.Errorf("Failed querying transcode job %v", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, nil
+	}
+
+	job, err := scanTranscodeJob(rows)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed scanning transcode job %v", err)
+		return nil, fmt.Errorf("Failed scanning transcode job %v", err)
+	}
+	return job, nil
+}
+
+// ClaimTranscodeJob - mark the oldest due queued job running, nil if none is due
+func (sqlDbi *SQLDBI) ClaimTranscodeJob() (*dbmodel.TranscodeJobEntry, error) {
+	const nextDueJobQry = `SELECT JobID FROM TranscodeJob WHERE Status = ? AND NextRunAt <= ?
+	        ORDER BY NextRunAt, JobID LIMIT 1`
+	/* conditional, another worker may claim the same job first */
+	const claimJobQry = `UPDATE TranscodeJob SET Status = ?, Attempts = Attempts + 1, UpdatedAt = ?
+	        WHERE JobID = ? AND Status = ?`
+
+	for {
+		now := time.Now().UTC()
+
+		var jobID int
+		err := sqlDbi.db.QueryRow(nextDueJobQry, dbmodel.JobQueued, now).Scan(&jobID)
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		if err != nil {
+			sqlDbi.logObj.PrintError("Failed querying due transcode jobs %v", err)
+			return nil, fmt.Errorf("Failed querying due transcode jobs %v", err)
+		}
+
+		result, err := sqlDbi.db.Exec(claimJobQry, dbmodel.JobRunning, now, jobID, dbmodel.JobQueued)
+		if err != nil {
+			sqlDbi.logObj.PrintError("Failed to claim transcode job: %s", err.Error())
+			return nil, fmt.Errorf("Failed to claim the transcode job %v", err)
 		}
-		args = append(args, r.ProductID, r.ProductType, r.StoreSize, r.Duration, r.Amount)
+		if n, err := result.RowsAffected(); err != nil || n != 1 {
+			continue
+		}
+		return sqlDbi.GetTranscodeJob(jobID)
 	}
-	query += endQuery
+}
 
-	_, err = sqlDbi.db.Exec(query, args...)
+// SetTranscodeJobStep - record the step a running job is in
+func (sqlDbi *SQLDBI) SetTranscodeJobStep(jobID int, step string) (err error) {
+	const setStepQry = `UPDATE TranscodeJob SET Step = ?, UpdatedAt = ? WHERE JobID = ? AND Status = ?`
+
+	_, err = sqlDbi.db.Exec(setStepQry, step, time.Now().UTC(), jobID, dbmodel.JobRunning)
 	if err != nil {
-		sqlDbi.logObj.PrintError("Failed to create product: %s", err.Error())
-		return fmt.Errorf("Failed to create the product %v", err)
+		return err
 	}
+	return nil
+}
+
+// FinishTranscodeJob - move a running job to status, false if it was canceled meanwhile
+func (sqlDbi *SQLDBI) FinishTranscodeJob(jobID int, status, lastError string, nextRunAt time.Time) (bool, error) {
+	const finishJobQry = `UPDATE TranscodeJob SET Status = ?, LastError = ?, NextRunAt = ?, UpdatedAt = ?
+	        WHERE JobID = ? AND Status = ?`
+
+	result, err := sqlDbi.db.Exec(finishJobQry, status, lastError, nextRunAt.UTC(), time.Now().UTC(), jobID, dbmodel.JobRunning)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed to update transcode job: %s", err.Error())
+		return false, fmt.Errorf("Failed to update the transcode job %v", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
 
+// CancelTranscodeJob - cancel a queued or running job, false if it had already ended
+func (sqlDbi *SQLDBI) CancelTranscodeJob(jobID int) (bool, error) {
+	const cancelJobQry = `UPDATE TranscodeJob SET Status = ?, UpdatedAt = ? WHERE JobID = ? AND Status IN (?, ?)`
+
+	result, err := sqlDbi.db.Exec(cancelJobQry, dbmodel.JobCanceled, time.Now().UTC(), jobID, dbmodel.JobQueued, dbmodel.JobRunning)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed to cancel transcode job: %s", err.Error())
+		return false, fmt.Errorf("Failed to cancel the transcode job %v", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// RequeueRunningTranscodeJobs - return jobs left running by a previous process to the queue
+func (sqlDbi *SQLDBI) RequeueRunningTranscodeJobs() (int64, error) {
+	const requeueQry = `UPDATE TranscodeJob SET Status = ?, NextRunAt = ?, UpdatedAt = ? WHERE Status = ?`
+
+	now := time.Now().UTC()
+	result, err := sqlDbi.db.Exec(requeueQry, dbmodel.JobQueued, now, now, dbmodel.JobRunning)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+/**********************************************************************************************************************************
+*
+*	MEDIA KEY FUNCTIONS
+*
+**********************************************************************************************************************************/
+
+// PutMediaKey - store the key of an asset, replacing an earlier one
+func (sqlDbi *SQLDBI) PutMediaKey(mkDetails *dbmodel.MediaKeyEntry) (err error) {
+	const sqlPutMediaKeyQry = `REPLACE INTO MediaKey (ID, Name, WrappedKey, CreatedAt) VALUES (?, ?, ?, ?)`
+
+	_, err = sqlDbi.db.Exec(sqlPutMediaKeyQry, mkDetails.ID, mkDetails.Name, mkDetails.WrappedKey, mkDetails.CreatedAt)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed to store media key: %s", err.Error())
+		return fmt.Errorf("Failed to store the media key %v", err)
+	}
 	return nil
 }
 
-//GetAllProducts - get all products
-func (sqlDbi *SQLDBI) GetAllProducts() ([]dbmodel.ProductEntry, error) {
-	const getProductsQuery = `SELECT ProductID, ProductType, StoreSize, Duration, Amount FROM Product `
-	var productList []dbmodel.ProductEntry
+// GetMediaKey - the key of an asset, nil if it is not encrypted
+func (sqlDbi *SQLDBI) GetMediaKey(id int, name string) (*dbmodel.MediaKeyEntry, error) {
+	const getMediaKeyQuery = `SELECT ID, Name, WrappedKey, CreatedAt FROM MediaKey WHERE ID = ? AND Name = ?`
 
-	args := []interface{}{}
+	mk := &dbmodel.MediaKeyEntry{}
+	err := sqlDbi.db.QueryRow(getMediaKeyQuery, id, name).Scan(&mk.ID, &mk.Name, &mk.WrappedKey, &mk.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed querying media key %v", err)
+		return nil, fmt.Errorf("Failed querying media key %v", err)
+	}
+	return mk, nil
+}
+
+// DeleteMediaKey - drop the key of an asset
+func (sqlDbi *SQLDBI) DeleteMediaKey(id int, name string) (err error) {
+	const deleteMediaKeyQry = `DELETE FROM MediaKey WHERE ID = ? AND Name = ?`
 
-	rows, err := sqlDbi.db.Query(getProductsQuery, args...)
+	_, err = sqlDbi.db.Exec(deleteMediaKeyQry, id, name)
 	if err != nil {
-		sqlDbi.logObj.PrintError("Failed to Search products: %s", err.Error())
-		return nil, fmt.Errorf("Failed to Search products %v", err)
+		return err
 	}
+	return nil
+}
 
+/**********************************************************************************************************************************
+*
+*	UPLOAD FUNCTIONS
+*
+**********************************************************************************************************************************/
+
+// CreateUpload - record a new upload
+func (sqlDbi *SQLDBI) CreateUpload(upDetails *dbmodel.UploadEntry) (err error) {
+	const sqlInsertUploadQry = `INSERT INTO Upload (UploadID, ID, Catalog, Title, Description, FileName, UploadLength,
+	        UploadOffset, ExpiresAt, CreatedAt, UpdatedAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	now := time.Now().UTC()
+	upDetails.CreatedAt, upDetails.UpdatedAt = now, now
+
+	_, err = sqlDbi.db.Exec(sqlInsertUploadQry, upDetails.UploadID, upDetails.ID, upDetails.Catalog, upDetails.Title,
+		upDetails.Description, upDetails.FileName, upDetails.Length, upDetails.Offset, upDetails.ExpiresAt.UTC(), upDetails.CreatedAt, upDetails.UpdatedAt)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed to create upload: %s", err.Error())
+		return fmt.Errorf("Failed to create the upload %v", err)
+	}
+	return nil
+}
+
+// GetUpload - get an upload by UploadID, nil if none exists
+func (sqlDbi *SQLDBI) GetUpload(uploadID string) (*dbmodel.UploadEntry, error) {
+	const getUploadQuery = `SELECT UploadID, ID, Catalog, Title, Description, FileName, UploadLength, UploadOffset, JobID,
+	        ExpiresAt, CreatedAt, UpdatedAt FROM Upload WHERE UploadID = ?`
+
+	up := &dbmodel.UploadEntry{}
+	err := sqlDbi.db.QueryRow(getUploadQuery, uploadID).Scan(&up.UploadID, &up.ID, &up.Catalog, &up.Title, &up.Description, &up.FileName,
+		&up.Length, &up.Offset, &up.JobID, &up.ExpiresAt, &up.CreatedAt, &up.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed querying upload %v", err)
+		return nil, fmt.Errorf("Failed querying upload %v", err)
+	}
+	return up, nil
+}
+
+// SetUploadOffset - move the offset of an upload, false if it is no longer at from
+func (sqlDbi *SQLDBI) SetUploadOffset(uploadID string, from, to int64, expiresAt time.Time) (bool, error) {
+	const setOffsetQry = `UPDATE Upload SET UploadOffset = ?, ExpiresAt = ?, UpdatedAt = ?
+	        WHERE UploadID = ? AND UploadOffset = ?`
+
+	result, err := sqlDbi.db.Exec(setOffsetQry, to, expiresAt.UTC(), time.Now().UTC(), uploadID, from)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed to update upload offset: %s", err.Error())
+		return false, fmt.Errorf("Failed to update the upload offset %v", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// SetUploadJob - record the transcode job a completed upload went to
+func (sqlDbi *SQLDBI) SetUploadJob(uploadID string, jobID int) (err error) {
+	const setJobQry = `UPDATE Upload SET JobID = ?, UpdatedAt = ? WHERE UploadID = ?`
+
+	_, err = sqlDbi.db.Exec(setJobQry, jobID, time.Now().UTC(), uploadID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteUpload - drop an upload
+func (sqlDbi *SQLDBI) DeleteUpload(uploadID string) (err error) {
+	const deleteUploadQry = `DELETE FROM Upload WHERE UploadID = ?`
+
+	_, err = sqlDbi.db.Exec(deleteUploadQry, uploadID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetExpiredUploads - IDs of uploads that expired before now
+func (sqlDbi *SQLDBI) GetExpiredUploads(now time.Time) ([]string, error) {
+	const expiredUploadsQuery = `SELECT UploadID FROM Upload WHERE ExpiresAt < ?`
+
+	rows, err := sqlDbi.db.Query(expiredUploadsQuery, now.UTC())
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed querying expired uploads %v", err)
+		return nil, fmt.Errorf("Failed querying expired uploads %v", err)
+	}
 	defer rows.Close()
+
+	var ids []string
 	for rows.Next() {
-		var item dbmodel.ProductEntry
-		err := rows.Scan(&item.ProductID, &item.ProductType, &item.StoreSize, &item.Duration, &item.Amount)
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+/**********************************************************************************************************************************
+*
+*	CATALOG FUNCTIONS
+*
+**********************************************************************************************************************************/
+
+const catalogColumns = `CatalogID, ID, ParentID, Name, Cover, SortOrder, CreatedAt, UpdatedAt`
+
+func scanCatalog(rows *sql.Rows) (*dbmodel.CatalogEntry, error) {
+	ct := &dbmodel.CatalogEntry{}
+	err := rows.Scan(&ct.CatalogID, &ct.ID, &ct.ParentID, &ct.Name, &ct.Cover, &ct.SortOrder, &ct.CreatedAt, &ct.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return ct, nil
+}
+
+// CreateCatalog - add a catalog, setting its CatalogID
+func (sqlDbi *SQLDBI) CreateCatalog(ctDetails *dbmodel.CatalogEntry) (err error) {
+	const sqlInsertCatalogQry = `INSERT INTO Catalog (ID, ParentID, Name, Cover, SortOrder, CreatedAt, UpdatedAt)
+	        VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+	now := time.Now().UTC()
+	ctDetails.CreatedAt, ctDetails.UpdatedAt = now, now
+
+	result, err := sqlDbi.db.Exec(sqlInsertCatalogQry, ctDetails.ID, ctDetails.ParentID, ctDetails.Name, ctDetails.Cover,
+		ctDetails.SortOrder, ctDetails.CreatedAt, ctDetails.UpdatedAt)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed to create catalog: %s", err.Error())
+		return fmt.Errorf("Failed to create the catalog %v", err)
+	}
+
+	catalogID, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("Failed to get the catalog id %v", err)
+	}
+	ctDetails.CatalogID = int(catalogID)
+	return nil
+}
+
+// GetCatalog - get a catalog by CatalogID, nil if none exists
+func (sqlDbi *SQLDBI) GetCatalog(catalogID int) (*dbmodel.CatalogEntry, error) {
+	getCatalogQuery := `SELECT ` + catalogColumns + ` FROM Catalog WHERE CatalogID = ?`
+
+	rows, err := sqlDbi.db.Query(getCatalogQuery, catalogID)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed querying catalog %v", err)
+		return nil, fmt.Errorf("Failed querying catalog %v", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, nil
+	}
+	return scanCatalog(rows)
+}
+
+// ListCatalogs - all catalogs of account id
+func (sqlDbi *SQLDBI) ListCatalogs(id int) ([]dbmodel.CatalogEntry, error) {
+	listCatalogsQuery := `SELECT ` + catalogColumns + ` FROM Catalog WHERE ID = ? ORDER BY ParentID, SortOrder, Name`
+
+	rows, err := sqlDbi.db.Query(listCatalogsQuery, id)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed querying catalogs %v", err)
+		return nil, fmt.Errorf("Failed querying catalogs %v", err)
+	}
+	defer rows.Close()
+
+	var catalogs []dbmodel.CatalogEntry
+	for rows.Next() {
+		ct, err := scanCatalog(rows)
 		if err != nil {
-			sqlDbi.logObj.PrintError("Failed to Search products: %s", err.Error())
-			return nil, fmt.Errorf("Failed to Search products %v", err)
+			sqlDbi.logObj.PrintError("Failed scanning catalog %v", err)
+			return nil, fmt.Errorf("Failed scanning catalog %v", err)
 		}
-		productList = append(productList, item)
+		catalogs = append(catalogs, *ct)
+	}
+	return catalogs, rows.Err()
+}
+
+// UpdateCatalog - set parent, name, cover and sort order of a catalog
+func (sqlDbi *SQLDBI) UpdateCatalog(ctDetails *dbmodel.CatalogEntry) (err error) {
+	const updateCatalogQry = `UPDATE Catalog SET ParentID = ?, Name = ?, Cover = ?, SortOrder = ?, UpdatedAt = ?
+	        WHERE CatalogID = ?`
+	/* media keep the name of their catalog in MediaType.Catalog */
+	const renameMediaQry = `UPDATE MediaType SET Catalog = ? WHERE CatalogID = ?`
+
+	ctDetails.UpdatedAt = time.Now().UTC()
+
+	tx, err := sqlDbi.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(updateCatalogQry, ctDetails.ParentID, ctDetails.Name, ctDetails.Cover, ctDetails.SortOrder,
+		ctDetails.UpdatedAt, ctDetails.CatalogID)
+	if err == nil {
+		_, err = tx.Exec(renameMediaQry, ctDetails.Name, ctDetails.CatalogID)
+	}
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed to update catalog: %s", err.Error())
+		return fmt.Errorf("Failed to update the catalog %v", err)
 	}
+	return tx.Commit()
+}
 
-	return productList, nil
+// DeleteCatalog - drop a catalog, its media are kept uncataloged
+func (sqlDbi *SQLDBI) DeleteCatalog(catalogID int) (err error) {
+	const uncatalogMediaQry = `UPDATE MediaType SET CatalogID = 0, Position = 0 WHERE CatalogID = ?`
+	const deleteCatalogQry = `DELETE FROM Catalog WHERE CatalogID = ?`
+
+	tx, err := sqlDbi.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(uncatalogMediaQry, catalogID); err == nil {
+		_, err = tx.Exec(deleteCatalogQry, catalogID)
+	}
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed to delete catalog: %s", err.Error())
+		return fmt.Errorf("Failed to delete the catalog %v", err)
+	}
+	return tx.Commit()
+}
+
+// SetCatalogMediaOrder - position media in a catalog in the order of fileNames
+func (sqlDbi *SQLDBI) SetCatalogMediaOrder(catalogID, id int, fileNames []string) (int64, error) {
+	const resetPositionsQry = `UPDATE MediaType SET Position = 0 WHERE ID = ? AND CatalogID = ?`
+	const positionMediaQry = `UPDATE MediaType SET Position = ? WHERE ID = ? AND CatalogID = ? AND FileName = ?`
+
+	tx, err := sqlDbi.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	/* media left out lose their place and sort after the ones listed */
+	if _, err = tx.Exec(resetPositionsQry, id, catalogID); err != nil {
+		sqlDbi.logObj.PrintError("Failed to order catalog media: %s", err.Error())
+		return 0, fmt.Errorf("Failed to order the catalog media %v", err)
+	}
+
+	var positioned int64
+	for i, fileName := range fileNames {
+		result, err := tx.Exec(positionMediaQry, i+1, id, catalogID, fileName)
+		if err != nil {
+			sqlDbi.logObj.PrintError("Failed to order catalog media: %s", err.Error())
+			return 0, fmt.Errorf("Failed to order the catalog media %v", err)
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		positioned += n
+	}
+	return positioned, tx.Commit()
+}
+
+/**********************************************************************************************************************************
+*
+*	MEDIA GRANT FUNCTIONS
+*
+**********************************************************************************************************************************/
+
+// PutMediaGrant - grant an account a role on a media
+func (sqlDbi *SQLDBI) PutMediaGrant(mgDetails *dbmodel.MediaGrantEntry) error {
+	const putMediaGrantQry = `INSERT INTO MediaGrant (OwnerID, FileName, AccountID, Role, CreatedAt) VALUES (?, ?, ?, ?, ?)
+	        ON DUPLICATE KEY UPDATE Role = VALUES(Role)`
+
+	mgDetails.CreatedAt = time.Now().UTC()
+	_, err := sqlDbi.db.Exec(putMediaGrantQry, mgDetails.OwnerID, mgDetails.FileName, mgDetails.AccountID,
+		mgDetails.Role, mgDetails.CreatedAt)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed to grant media: %s", err.Error())
+		return fmt.Errorf("Failed to grant media %v", err)
+	}
+	return nil
+}
 
+func (sqlDbi *SQLDBI) queryMediaGrants(query string, args ...interface{}) ([]dbmodel.MediaGrantEntry, error) {
+	rows, err := sqlDbi.db.Query(query, args...)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed querying media grants %v", err)
+		return nil, fmt.Errorf("Failed querying media grants %v", err)
+	}
+	defer rows.Close()
+
+	var grants []dbmodel.MediaGrantEntry
+	for rows.Next() {
+		var mg dbmodel.MediaGrantEntry
+		if err = rows.Scan(&mg.OwnerID, &mg.FileName, &mg.AccountID, &mg.Role, &mg.CreatedAt); err != nil {
+			sqlDbi.logObj.PrintError("Failed scanning media grant %v", err)
+			return nil, fmt.Errorf("Failed scanning media grant %v", err)
+		}
+		grants = append(grants, mg)
+	}
+	return grants, rows.Err()
+}
+
+// GetMediaGrant - the grant of an account on a media, nil if none
+func (sqlDbi *SQLDBI) GetMediaGrant(ownerID int, fileName string, accountID int) (*dbmodel.MediaGrantEntry, error) {
+	const getMediaGrantQry = `SELECT OwnerID, FileName, AccountID, Role, CreatedAt FROM MediaGrant
+	        WHERE OwnerID = ? AND FileName = ? AND AccountID = ?`
+
+	grants, err := sqlDbi.queryMediaGrants(getMediaGrantQry, ownerID, fileName, accountID)
+	if err != nil || len(grants) == 0 {
+		return nil, err
+	}
+	return &grants[0], nil
+}
+
+// ListMediaGrants - all grants on a media
+func (sqlDbi *SQLDBI) ListMediaGrants(ownerID int, fileName string) ([]dbmodel.MediaGrantEntry, error) {
+	const listMediaGrantsQry = `SELECT OwnerID, FileName, AccountID, Role, CreatedAt FROM MediaGrant
+	        WHERE OwnerID = ? AND FileName = ? ORDER BY AccountID`
+
+	return sqlDbi.queryMediaGrants(listMediaGrantsQry, ownerID, fileName)
+}
+
+// DeleteMediaGrant - revoke the grant of an account on a media
+func (sqlDbi *SQLDBI) DeleteMediaGrant(ownerID int, fileName string, accountID int) (int64, error) {
+	const deleteMediaGrantQry = `DELETE FROM MediaGrant WHERE OwnerID = ? AND FileName = ? AND AccountID = ?`
+
+	result, err := sqlDbi.db.Exec(deleteMediaGrantQry, ownerID, fileName, accountID)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed to revoke media grant: %s", err.Error())
+		return 0, fmt.Errorf("Failed to revoke media grant %v", err)
+	}
+	return result.RowsAffected()
+}
+
+// SearchSharedMedia - live media an account was granted a role on
+func (sqlDbi *SQLDBI) SearchSharedMedia(accountID int) ([]dbmodel.MediaTypeEntry, error) {
+	query := mediaSelect + `JOIN MediaGrant g ON g.OwnerID = m.ID AND g.FileName = m.FileName
+	        WHERE g.AccountID = ? ` + trashClause(false) + ` ORDER BY m.ID, m.FileName`
+
+	return sqlDbi.queryMedia(query, accountID)
+}
+
+/**********************************************************************************************************************************
+*
+*	ACCOUNT TRANSFER FUNCTIONS
+*
+**********************************************************************************************************************************/
+
+const accountTransferColumns = `TransferID, CustomerID, FromPID, ToPID, RequestedBy, Status, CreatedAt, DecidedAt`
+
+func (sqlDbi *SQLDBI) queryAccountTransfers(query string, args ...interface{}) ([]dbmodel.AccountTransferEntry, error) {
+	rows, err := sqlDbi.db.Query(query, args...)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed querying account transfers %v", err)
+		return nil, fmt.Errorf("Failed querying account transfers %v", err)
+	}
+	defer rows.Close()
+
+	var transfers []dbmodel.AccountTransferEntry
+	for rows.Next() {
+		var at dbmodel.AccountTransferEntry
+		var decidedAt sql.NullTime
+		err = rows.Scan(&at.TransferID, &at.CustomerID, &at.FromPID, &at.ToPID, &at.RequestedBy, &at.Status,
+			&at.CreatedAt, &decidedAt)
+		if err != nil {
+			sqlDbi.logObj.PrintError("Failed scanning account transfer %v", err)
+			return nil, fmt.Errorf("Failed scanning account transfer %v", err)
+		}
+		if decidedAt.Valid {
+			at.DecidedAt = &decidedAt.Time
+		}
+		transfers = append(transfers, at)
+	}
+	return transfers, rows.Err()
+}
+
+// CreateAccountTransfer - add a pending transfer, setting its TransferID
+func (sqlDbi *SQLDBI) CreateAccountTransfer(atDetails *dbmodel.AccountTransferEntry) error {
+	const createTransferQry = `INSERT INTO AccountTransfer (CustomerID, FromPID, ToPID, RequestedBy, Status, CreatedAt)
+	        VALUES (?, ?, ?, ?, ?, ?)`
+
+	atDetails.Status = dbmodel.TransferPending
+	atDetails.CreatedAt = time.Now().UTC()
+	result, err := sqlDbi.db.Exec(createTransferQry, atDetails.CustomerID, atDetails.FromPID, atDetails.ToPID,
+		atDetails.RequestedBy, atDetails.Status, atDetails.CreatedAt)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed to create account transfer: %s", err.Error())
+		return fmt.Errorf("Failed to create the account transfer %v", err)
+	}
+
+	transferID, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("Failed to get the account transfer id %v", err)
+	}
+	atDetails.TransferID = int(transferID)
+	return nil
+}
+
+// GetAccountTransfer - get a transfer by TransferID, nil if none exists
+func (sqlDbi *SQLDBI) GetAccountTransfer(transferID int) (*dbmodel.AccountTransferEntry, error) {
+	query := `SELECT ` + accountTransferColumns + ` FROM AccountTransfer WHERE TransferID = ?`
+
+	transfers, err := sqlDbi.queryAccountTransfers(query, transferID)
+	if err != nil || len(transfers) == 0 {
+		return nil, err
+	}
+	return &transfers[0], nil
+}
+
+// GetPendingAccountTransfer - the pending transfer of a customer, nil if none
+func (sqlDbi *SQLDBI) GetPendingAccountTransfer(customerID int) (*dbmodel.AccountTransferEntry, error) {
+	query := `SELECT ` + accountTransferColumns + ` FROM AccountTransfer WHERE CustomerID = ? AND Status = ?`
+
+	transfers, err := sqlDbi.queryAccountTransfers(query, customerID, dbmodel.TransferPending)
+	if err != nil || len(transfers) == 0 {
+		return nil, err
+	}
+	return &transfers[0], nil
+}
+
+// ListAccountTransfers - transfers involving account id, newest first
+func (sqlDbi *SQLDBI) ListAccountTransfers(id int) ([]dbmodel.AccountTransferEntry, error) {
+	query := `SELECT ` + accountTransferColumns + ` FROM AccountTransfer
+	        WHERE CustomerID = ? OR FromPID = ? OR ToPID = ? ORDER BY TransferID DESC`
+
+	return sqlDbi.queryAccountTransfers(query, id, id, id)
+}
+
+// DecideAccountTransfer - end a pending transfer, moving the customer if
+// it is accepted
+func (sqlDbi *SQLDBI) DecideAccountTransfer(transferID int, status string) (int64, error) {
+	const decideTransferQry = `UPDATE AccountTransfer SET Status = ?, DecidedAt = ? WHERE TransferID = ? AND Status = ?`
+	const moveCustomerQry = `UPDATE Account a JOIN AccountTransfer t ON t.CustomerID = a.ID AND t.FromPID = a.PID
+	        SET a.PID = t.ToPID WHERE t.TransferID = ?`
+
+	tx, err := sqlDbi.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(decideTransferQry, status, time.Now().UTC(), transferID, dbmodel.TransferPending)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed to decide account transfer: %s", err.Error())
+		return 0, fmt.Errorf("Failed to decide the account transfer %v", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n == 0 {
+		return 0, err
+	}
+
+	if status == dbmodel.TransferAccepted {
+		result, err = tx.Exec(moveCustomerQry, transferID)
+		if err != nil {
+			sqlDbi.logObj.PrintError("Failed to move customer: %s", err.Error())
+			return 0, fmt.Errorf("Failed to move the customer %v", err)
+		}
+		if n, err = result.RowsAffected(); err != nil || n == 0 {
+			return 0, err
+		}
+	}
+	return n, tx.Commit()
+}
+
+/**********************************************************************************************************************************
+*
+*	STORAGE FUNCTIONS
+*
+**********************************************************************************************************************************/
+
+// GetStorageUsage - bytes a business and its customers use. Media added
+// before their stored bytes were counted are taken at their upload size.
+func (sqlDbi *SQLDBI) GetStorageUsage(id int) (dbmodel.StorageUsageEntry, error) {
+	const tenantQry = `SELECT ID FROM Account WHERE ID = ? OR PID = ?`
+	mediaBytesQry := `SELECT COALESCE(SUM(GREATEST(m.StoredBytes, COALESCE(i.FileSize, 0))), 0)
+	        FROM MediaType m LEFT JOIN MediaInfo i ON i.URL = m.URL WHERE m.ID IN (` + tenantQry + `)`
+	uploadBytesQry := `SELECT COALESCE(SUM(UploadLength), 0) FROM Upload WHERE JobID = 0 AND ID IN (` + tenantQry + `)`
+	jobBytesQry := `SELECT COALESCE(SUM(SrcSize), 0) FROM TranscodeJob WHERE Status IN (?, ?) AND ID IN (` + tenantQry + `)`
+
+	var usage dbmodel.StorageUsageEntry
+	var uploadBytes, jobBytes int64
+	err := sqlDbi.db.QueryRow(mediaBytesQry, id, id).Scan(&usage.MediaBytes)
+	if err == nil {
+		err = sqlDbi.db.QueryRow(uploadBytesQry, id, id).Scan(&uploadBytes)
+	}
+	if err == nil {
+		err = sqlDbi.db.QueryRow(jobBytesQry, dbmodel.JobQueued, dbmodel.JobRunning, id, id).Scan(&jobBytes)
+	}
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed querying storage usage %v", err)
+		return usage, fmt.Errorf("Failed querying storage usage %v", err)
+	}
+	usage.PendingBytes = uploadBytes + jobBytes
+	return usage, nil
+}
+
+// GetSubscribedStorage - the largest StoreSize of the running
+// subscriptions of an account, those that started and did not expire
+func (sqlDbi *SQLDBI) GetSubscribedStorage(id int) (dbmodel.SubscribedStorageEntry, error) {
+	const storeSizeQry = `SELECT COALESCE(MAX(IF(State <> ? AND StartDate <= ?, StoreSize, NULL)), 0),
+	        COALESCE(SUM(State <> ? AND StartDate <= ?), 0), COALESCE(SUM(State = ?), 0) FROM Subscription WHERE ID = ?`
+
+	var storage dbmodel.SubscribedStorageEntry
+	now := time.Now().UTC()
+	expired := dbmodel.SubscriptionExpired
+	err := sqlDbi.db.QueryRow(storeSizeQry, expired, now, expired, now, expired, id).Scan(&storage.StoreSize, &storage.Running, &storage.Expired)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed querying subscribed storage %v", err)
+		return storage, fmt.Errorf("Failed querying subscribed storage %v", err)
+	}
+	return storage, nil
+}
+
+// RecordStorageOverage - keep the peak overage of the billing period
+func (sqlDbi *SQLDBI) RecordStorageOverage(id int, period string, bytes int64) error {
+	const recordOverageQry = `INSERT INTO StorageOverage (ID, Period, OverageBytes, UpdatedAt) VALUES (?, ?, ?, ?)
+	        ON DUPLICATE KEY UPDATE UpdatedAt = IF(VALUES(OverageBytes) > OverageBytes, VALUES(UpdatedAt), UpdatedAt),
+	        OverageBytes = GREATEST(OverageBytes, VALUES(OverageBytes))`
+
+	_, err := sqlDbi.db.Exec(recordOverageQry, id, period, bytes, time.Now().UTC())
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed to record storage overage: %s", err.Error())
+		return fmt.Errorf("Failed to record storage overage %v", err)
+	}
+	return nil
+}
+
+// GetStorageOverage - peak overage of the billing period, 0 if none
+func (sqlDbi *SQLDBI) GetStorageOverage(id int, period string) (int64, error) {
+	const getOverageQry = `SELECT COALESCE(MAX(OverageBytes), 0) FROM StorageOverage WHERE ID = ? AND Period = ?`
+
+	var bytes int64
+	if err := sqlDbi.db.QueryRow(getOverageQry, id, period).Scan(&bytes); err != nil {
+		sqlDbi.logObj.PrintError("Failed querying storage overage %v", err)
+		return 0, fmt.Errorf("Failed querying storage overage %v", err)
+	}
+	return bytes, nil
+}
+
+/**********************************************************************************************************************************
+*
+*	EGRESS FUNCTIONS
+*
+**********************************************************************************************************************************/
+
+// AddEgress - count bytes served on a day
+func (sqlDbi *SQLDBI) AddEgress(id int, day time.Time, bytes int64) error {
+	const addEgressQry = `INSERT INTO Egress (ID, Day, Bytes) VALUES (?, ?, ?)
+	        ON DUPLICATE KEY UPDATE Bytes = Bytes + VALUES(Bytes)`
+
+	_, err := sqlDbi.db.Exec(addEgressQry, id, day.UTC().Format("2006-01-02"), bytes)
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed to add egress: %s", err.Error())
+		return fmt.Errorf("Failed to add egress %v", err)
+	}
+	return nil
+}
+
+// ListEgress - daily egress of an account and its customers
+func (sqlDbi *SQLDBI) ListEgress(id int, from, to time.Time) ([]dbmodel.EgressEntry, error) {
+	const listEgressQry = `SELECT ID, Day, Bytes FROM Egress
+	        WHERE (ID = ? OR ID IN (SELECT ID FROM Account WHERE PID = ?)) AND Day BETWEEN ? AND ?
+	        ORDER BY Day, ID`
+
+	var resp []dbmodel.EgressEntry
+	rows, err := sqlDbi.db.Query(listEgressQry, id, id, from.UTC().Format("2006-01-02"), to.UTC().Format("2006-01-02"))
+	if err != nil {
+		sqlDbi.logObj.PrintError("Failed to list egress: %s", err.Error())
+		return resp, fmt.Errorf("Failed to list egress %v", err)
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var item dbmodel.EgressEntry
+		if err = rows.Scan(&item.ID, &item.Day, &item.Bytes); err != nil {
+			sqlDbi.logObj.PrintError("Failed to scan egress : %s", err.Error())
+			return resp, fmt.Errorf("Failed to scan egress: %v", err)
+		}
+		resp = append(resp, item)
+	}
+	return resp, rows.Err()
 }
@@ -0,0 +1,22 @@
+package dbi
+
+import (
+	"github.com/msproject/relive/dbmodel"
+)
+
+// StorageTblDBI - storage used and subscribed by businesses
+type StorageTblDBI interface {
+	// GetStorageUsage - bytes business id and its customers use
+	GetStorageUsage(id int) (dbmodel.StorageUsageEntry, error)
+
+	// GetSubscribedStorage - the largest StoreSize of the running
+	// subscriptions of account id, as subscribed, and how many run and have expired
+	GetSubscribedStorage(id int) (dbmodel.SubscribedStorageEntry, error)
+
+	// RecordStorageOverage - raise the overage of account id in the
+	// billing period, a month as YYYY-MM, to bytes if it is below
+	RecordStorageOverage(id int, period string, bytes int64) error
+
+	// GetStorageOverage - peak overage of account id in the billing period
+	GetStorageOverage(id int, period string) (int64, error)
+}
@@ -1,14 +1,43 @@
 package dbi
 
 import (
-	//"github.com/msproject/relive/dbmodel"
+	"time"
+
+	"github.com/msproject/relive/dbmodel"
 	"github.com/msproject/relive/util"
 )
 
 // SubscriptionTblDBI - testing
 type SubscriptionTblDBI interface {
-	CreateSubscription(req util.CreateSubscriptionReq) error
+	// CreateSubscription - add sub, setting its SubscriptionCode
+	CreateSubscription(sub *dbmodel.SubscriptionEntry) error
 	UpdateSubscription(req util.CreateSubscriptionReq) error
 	DeleteSubscription(subscriptionCode uint32) error
 	SearchSubscription(subscriptionCode uint32) ([]util.SubscrDetails, error)
+
+	// GetSubscription - a subscription by SubscriptionCode, nil if none
+	GetSubscription(subscriptionCode uint32) (*dbmodel.SubscriptionEntry, error)
+
+	// ListSubscriptions - subscriptions of account id, expired ones too
+	ListSubscriptions(id int) ([]dbmodel.SubscriptionEntry, error)
+
+	// ListRunningSubscriptions - subscriptions of account id that started
+	// and did not expire
+	ListRunningSubscriptions(id int) ([]dbmodel.SubscriptionEntry, error)
+
+	// ListDueSubscriptions - subscriptions whose period ended by now, and
+	// those past due
+	ListDueSubscriptions(now time.Time) ([]dbmodel.SubscriptionEntry, error)
+
+	// AdvanceSubscription - move prev on to the state, period and grace
+	// period of next, false if it moved since prev was read
+	AdvanceSubscription(prev, next *dbmodel.SubscriptionEntry) (bool, error)
+
+	// CancelSubscription - have a running subscription expire at the end
+	// of its period, false if it was not running or already canceled
+	CancelSubscription(subscriptionCode uint32, at time.Time) (bool, error)
+
+	// UseTrial - take the one trial of account id, false if it was taken
+	// before. Deleting subscriptions does not give it back.
+	UseTrial(id int, at time.Time) (bool, error)
 }
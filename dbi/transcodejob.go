@@ -0,0 +1,35 @@
+package dbi
+
+import (
+	"time"
+
+	"github.com/msproject/relive/dbmodel"
+)
+
+// TranscodeJobTblDBI - persistent queue of media transcoding jobs
+type TranscodeJobTblDBI interface {
+	// CreateTranscodeJob - queue a job, setting its JobID
+	CreateTranscodeJob(job *dbmodel.TranscodeJobEntry) error
+
+	// GetTranscodeJob - get a job by JobID, nil if none exists
+	GetTranscodeJob(jobID int) (*dbmodel.TranscodeJobEntry, error)
+
+	// ClaimTranscodeJob - mark the oldest due queued job running and
+	// count the attempt, nil if no job is due
+	ClaimTranscodeJob() (*dbmodel.TranscodeJobEntry, error)
+
+	// SetTranscodeJobStep - record the step a running job is in
+	SetTranscodeJobStep(jobID int, step string) error
+
+	// FinishTranscodeJob - move a running job to status. A job canceled
+	// meanwhile stays canceled, false is returned then.
+	FinishTranscodeJob(jobID int, status, lastError string, nextRunAt time.Time) (bool, error)
+
+	// CancelTranscodeJob - cancel a queued or running job, false if it
+	// had already ended
+	CancelTranscodeJob(jobID int) (bool, error)
+
+	// RequeueRunningTranscodeJobs - return jobs left running by a
+	// previous process to the queue
+	RequeueRunningTranscodeJobs() (int64, error)
+}
@@ -0,0 +1,29 @@
+package dbi
+
+import (
+	"time"
+
+	"github.com/msproject/relive/dbmodel"
+)
+
+// UploadTblDBI - state of resumable uploads
+type UploadTblDBI interface {
+	// CreateUpload - record a new upload
+	CreateUpload(upDetails *dbmodel.UploadEntry) error
+
+	// GetUpload - get an upload by UploadID, nil if none exists
+	GetUpload(uploadID string) (*dbmodel.UploadEntry, error)
+
+	// SetUploadOffset - move the offset of an upload from one value to
+	// another, false if the upload is no longer at from
+	SetUploadOffset(uploadID string, from, to int64, expiresAt time.Time) (bool, error)
+
+	// SetUploadJob - record the transcode job a completed upload went to
+	SetUploadJob(uploadID string, jobID int) error
+
+	// DeleteUpload - drop an upload
+	DeleteUpload(uploadID string) error
+
+	// GetExpiredUploads - IDs of uploads that expired before now
+	GetExpiredUploads(now time.Time) ([]string, error)
+}
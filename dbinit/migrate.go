@@ -0,0 +1,333 @@
+package dbinit
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/msproject/relive/dbmodel"
+)
+
+// LockTimeout - how long a migration waits for another instance migrating
+// the same database
+const LockTimeout = time.Minute
+
+// states of a migration
+const (
+	StateApplied  = "applied"
+	StatePending  = "pending"
+	StateModified = "modified" // applied, its statements changed since
+	StateUnknown  = "unknown"  // applied by a newer build
+)
+
+// MigrationStatus - a migration and whether the database has it
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	State     string
+	AppliedAt time.Time
+}
+
+// Checksum - hex SHA-256 of the Up and Down statements of m
+func Checksum(m dbmodel.Migration) string {
+	h := sha256.New()
+	for _, stmts := range [][]string{m.Up, m.Down} {
+		for _, stmt := range stmts {
+			h.Write([]byte(stmt))
+			h.Write([]byte{0})
+		}
+		h.Write([]byte{1})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Up - apply the pending migrations, the ones applied are returned
+func (d *Config) Up() (applied []MigrationStatus, err error) {
+	err = d.locked(func(ctx context.Context, conn *sql.Conn, status []MigrationStatus) error {
+		pending, err := planUp(status)
+		if err != nil {
+			return err
+		}
+		for _, st := range pending {
+			if err = d.apply(ctx, conn, d.migration(st.Version), true); err != nil {
+				return err
+			}
+			applied = append(applied, st)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down - revert the last n applied migrations, the ones reverted are
+// returned
+func (d *Config) Down(n int) (reverted []MigrationStatus, err error) {
+	err = d.locked(func(ctx context.Context, conn *sql.Conn, status []MigrationStatus) error {
+		last, err := planDown(status, n)
+		if err != nil {
+			return err
+		}
+		for _, st := range last {
+			if err = d.apply(ctx, conn, d.migration(st.Version), false); err != nil {
+				return err
+			}
+			reverted = append(reverted, st)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Status - every migration known to this build or applied to the database,
+// by version
+func (d *Config) Status() ([]MigrationStatus, error) {
+	db, err := sql.Open("mysql", d.metaDataURL)
+	if err != nil {
+		return nil, fmt.Errorf("could not open DB %s err[%s]", d.metaDataURL, err.Error())
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	return migrationStatus(d.migrations, applied), nil
+}
+
+/* locked - run fn holding the migration lock of the database, on the
+ * connection the lock is held by */
+func (d *Config) locked(fn func(ctx context.Context, conn *sql.Conn, status []MigrationStatus) error) error {
+	db, err := sql.Open("mysql", d.metaDataURL)
+	if err != nil {
+		return fmt.Errorf("could not open DB %s err[%s]", d.metaDataURL, err.Error())
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	/* GET_LOCK is server wide, the name takes the database */
+	const getLockQry = `SELECT GET_LOCK(CONCAT('relive_migrate.', DATABASE()), ?)`
+	const releaseLockQry = `SELECT RELEASE_LOCK(CONCAT('relive_migrate.', DATABASE()))`
+
+	var got sql.NullInt64
+	if err = conn.QueryRowContext(ctx, getLockQry, int(LockTimeout/time.Second)).Scan(&got); err != nil {
+		return fmt.Errorf("could not take the migration lock %v", err)
+	}
+	if !got.Valid || got.Int64 != 1 {
+		return fmt.Errorf("another instance is migrating the database, gave up after %v", LockTimeout)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(ctx, releaseLockQry); err != nil {
+			d.logObj.PrintError("Failed to release the migration lock: %v", err)
+		}
+	}()
+
+	if _, err = conn.ExecContext(ctx, dbmodel.SchemaMigrationsSQL); err != nil {
+		return fmt.Errorf("error creating schema_migrations %s", err.Error())
+	}
+	/* read with the lock held, an instance that held it before may have
+	 * done the work */
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return err
+	}
+	return fn(ctx, conn, migrationStatus(d.migrations, applied))
+}
+
+/* apply - run the Up or Down statements of m and record it */
+func (d *Config) apply(ctx context.Context, conn *sql.Conn, m dbmodel.Migration, up bool) error {
+	const addQry = `INSERT INTO schema_migrations (Version, Name, Checksum) VALUES (?, ?, ?)`
+	const removeQry = `DELETE FROM schema_migrations WHERE Version = ?`
+
+	direction, stmts := "up", m.Up
+	if !up {
+		direction, stmts = "down", m.Down
+	}
+	d.logObj.PrintInfo("Migrating %s %d %s", direction, m.Version, m.Name)
+
+	/* DDL commits implicitly in MySQL, a migration failing midway stays
+	 * applied up to the failing statement */
+	for _, stmt := range stmts {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			if m.Baseline && alreadyDone(err) {
+				d.logObj.PrintInfo("Ignore a DB error of the baseline, the database predates migrations: [%s]", err.Error())
+				continue
+			}
+			return fmt.Errorf("migration %d %s %s failed at %s: %v", m.Version, m.Name, direction, stmt, err)
+		}
+	}
+
+	var err error
+	if up {
+		_, err = conn.ExecContext(ctx, addQry, m.Version, m.Name, Checksum(m))
+	} else {
+		_, err = conn.ExecContext(ctx, removeQry, m.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("migration %d %s %s applied but not recorded: %v", m.Version, m.Name, direction, err)
+	}
+	return nil
+}
+
+/* migration - the migration of version, known to exist */
+func (d *Config) migration(version int) dbmodel.Migration {
+	for _, m := range d.migrations {
+		if m.Version == version {
+			return m
+		}
+	}
+	return dbmodel.Migration{}
+}
+
+/* alreadyDone - errors of a statement whose work was done before:
+ * 1. Error 1050: Adding existing tables
+ * 2. Error 1051: Dropping non-existent table
+ * 3. Error 1060: Adding existing columns
+ * 4. Error 1061: Adding an existing index
+ * 5. Error 1091: Dropping a non-existent column or key */
+func alreadyDone(err error) bool {
+	var driverErr *mysql.MySQLError
+	if !errors.As(err, &driverErr) {
+		return false
+	}
+	switch driverErr.Number {
+	case 1050, 1051, 1060, 1061, 1091:
+		return true
+	}
+	return false
+}
+
+/* appliedMigrations - the migrations recorded, none if schema_migrations
+ * does not exist yet */
+func appliedMigrations(ctx context.Context, conn *sql.Conn) ([]dbmodel.SchemaMigrationEntry, error) {
+	const listQry = `SELECT Version, Name, Checksum, AppliedAt FROM schema_migrations ORDER BY Version`
+
+	rows, err := conn.QueryContext(ctx, listQry)
+	if err != nil {
+		var driverErr *mysql.MySQLError
+		if errors.As(err, &driverErr) && driverErr.Number == 1146 {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	var applied []dbmodel.SchemaMigrationEntry
+	for rows.Next() {
+		var e dbmodel.SchemaMigrationEntry
+		if err = rows.Scan(&e.Version, &e.Name, &e.Checksum, &e.AppliedAt); err != nil {
+			return nil, err
+		}
+		applied = append(applied, e)
+	}
+	return applied, rows.Err()
+}
+
+/* checkMigrations - versions must be positive and ascending */
+func checkMigrations(migrations []dbmodel.Migration) error {
+	last := 0
+	for _, m := range migrations {
+		if m.Version <= last {
+			return fmt.Errorf("migration %d %s out of order, after %d", m.Version, m.Name, last)
+		}
+		if m.Name == "" {
+			return fmt.Errorf("migration %d has no name", m.Version)
+		}
+		last = m.Version
+	}
+	return nil
+}
+
+/* migrationStatus - merge the migrations known, in ascending order, with
+ * those applied */
+func migrationStatus(migrations []dbmodel.Migration, applied []dbmodel.SchemaMigrationEntry) []MigrationStatus {
+	byVersion := map[int]dbmodel.SchemaMigrationEntry{}
+	for _, e := range applied {
+		byVersion[e.Version] = e
+	}
+
+	var status []MigrationStatus
+	for _, m := range migrations {
+		st := MigrationStatus{Version: m.Version, Name: m.Name, State: StatePending}
+		if e, ok := byVersion[m.Version]; ok {
+			st.State, st.AppliedAt = StateApplied, e.AppliedAt
+			if e.Checksum != Checksum(m) {
+				st.State = StateModified
+			}
+			delete(byVersion, m.Version)
+		}
+		status = append(status, st)
+	}
+
+	/* applied ones this build does not know come after those it does */
+	for _, e := range applied {
+		if _, ok := byVersion[e.Version]; ok {
+			status = append(status, MigrationStatus{Version: e.Version, Name: e.Name, State: StateUnknown, AppliedAt: e.AppliedAt})
+		}
+	}
+	return status
+}
+
+/* verify - the database and this build must agree on what was applied */
+func verify(status []MigrationStatus) error {
+	for _, st := range status {
+		switch st.State {
+		case StateModified:
+			return fmt.Errorf("migration %d %s was edited after it was applied, add a new migration instead", st.Version, st.Name)
+		case StateUnknown:
+			return fmt.Errorf("migration %d %s was applied by a newer build", st.Version, st.Name)
+		}
+	}
+	return nil
+}
+
+/* planUp - the pending migrations in the order to apply them. A pending
+ * migration below an applied one was added out of order. */
+func planUp(status []MigrationStatus) ([]MigrationStatus, error) {
+	if err := verify(status); err != nil {
+		return nil, err
+	}
+	var pending []MigrationStatus
+	for _, st := range status {
+		if st.State == StatePending {
+			pending = append(pending, st)
+		} else if len(pending) > 0 {
+			return nil, fmt.Errorf("migration %d %s is pending below the applied %d", pending[0].Version, pending[0].Name, st.Version)
+		}
+	}
+	return pending, nil
+}
+
+/* planDown - the last n applied migrations, latest first */
+func planDown(status []MigrationStatus, n int) ([]MigrationStatus, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("nothing to revert with %d migrations", n)
+	}
+	if err := verify(status); err != nil {
+		return nil, err
+	}
+	var last []MigrationStatus
+	for i := len(status) - 1; i >= 0 && len(last) < n; i-- {
+		if status[i].State == StateApplied {
+			last = append(last, status[i])
+		}
+	}
+	return last, nil
+}
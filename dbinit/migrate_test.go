@@ -0,0 +1,116 @@
+package dbinit
+
+import (
+	"testing"
+
+	"github.com/msproject/relive/dbmodel"
+)
+
+var testMigrations = []dbmodel.Migration{
+	{Version: 1, Name: "baseline", Up: []string{"CREATE TABLE A (ID int)"}, Down: []string{"DROP TABLE A"}},
+	{Version: 2, Name: "add b", Up: []string{"CREATE TABLE B (ID int)"}, Down: []string{"DROP TABLE B"}},
+	{Version: 3, Name: "add c", Up: []string{"CREATE TABLE C (ID int)"}, Down: []string{"DROP TABLE C"}},
+}
+
+func applied(versions ...int) []dbmodel.SchemaMigrationEntry {
+	var entries []dbmodel.SchemaMigrationEntry
+	for _, v := range versions {
+		m := testMigrations[v-1]
+		entries = append(entries, dbmodel.SchemaMigrationEntry{Version: v, Name: m.Name, Checksum: Checksum(m)})
+	}
+	return entries
+}
+
+func versions(status []MigrationStatus) []int {
+	var v []int
+	for _, st := range status {
+		v = append(v, st.Version)
+	}
+	return v
+}
+
+func equal(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestChecksum(t *testing.T) {
+	m := testMigrations[0]
+	edited := m
+	edited.Down = []string{"DROP TABLE IF EXISTS A"}
+	if Checksum(m) == Checksum(edited) {
+		t.Errorf("edited Down kept the checksum")
+	}
+
+	/* statements are not merely concatenated */
+	split := dbmodel.Migration{Up: []string{"CREATE TABLE A", " (ID int)"}, Down: m.Down}
+	if Checksum(m) == Checksum(split) {
+		t.Errorf("statements split differently share a checksum")
+	}
+}
+
+func TestCheckMigrations(t *testing.T) {
+	if err := checkMigrations(testMigrations); err != nil {
+		t.Fatal(err)
+	}
+	swapped := []dbmodel.Migration{testMigrations[1], testMigrations[0]}
+	if err := checkMigrations(swapped); err == nil {
+		t.Errorf("expected migrations out of order refused")
+	}
+}
+
+func TestPlanUp(t *testing.T) {
+	pending, err := planUp(migrationStatus(testMigrations, applied(1)))
+	if err != nil || !equal(versions(pending), []int{2, 3}) {
+		t.Errorf("pending %v %v, want 2 and 3", versions(pending), err)
+	}
+
+	if pending, err = planUp(migrationStatus(testMigrations, applied(1, 2, 3))); err != nil || len(pending) != 0 {
+		t.Errorf("pending %v %v, want none", versions(pending), err)
+	}
+
+	/* 2 was added after 3 was applied */
+	if _, err = planUp(migrationStatus(testMigrations, applied(1, 3))); err == nil {
+		t.Errorf("expected a migration pending below an applied one refused")
+	}
+
+	edited := applied(1, 2)
+	edited[1].Checksum = "0"
+	status := migrationStatus(testMigrations, edited)
+	if status[1].State != StateModified {
+		t.Errorf("edited migration is %s", status[1].State)
+	}
+	if _, err = planUp(status); err == nil {
+		t.Errorf("expected an edited migration refused")
+	}
+
+	newer := append(applied(1, 2, 3), dbmodel.SchemaMigrationEntry{Version: 4, Name: "add d"})
+	status = migrationStatus(testMigrations, newer)
+	if len(status) != 4 || status[3].State != StateUnknown {
+		t.Errorf("expected migration 4 unknown, got %v", status)
+	}
+	if _, err = planUp(status); err == nil {
+		t.Errorf("expected a database migrated by a newer build refused")
+	}
+}
+
+func TestPlanDown(t *testing.T) {
+	status := migrationStatus(testMigrations, applied(1, 2))
+	last, err := planDown(status, 1)
+	if err != nil || !equal(versions(last), []int{2}) {
+		t.Errorf("reverting %v %v, want 2", versions(last), err)
+	}
+	if last, err = planDown(status, 5); err != nil || !equal(versions(last), []int{2, 1}) {
+		t.Errorf("reverting %v %v, want 2 and 1", versions(last), err)
+	}
+	if _, err = planDown(status, 0); err == nil {
+		t.Errorf("expected reverting no migration refused")
+	}
+}
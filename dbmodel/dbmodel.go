@@ -1,5 +1,73 @@
 package dbmodel
 
+import (
+	"time"
+
+	"github.com/msproject/relive/money"
+)
+
+// Account.Role values
+const (
+	//RoleRoot - relive operator, may act on every account
+	RoleRoot = 0
+	//RoleAdmin - videography business, owns the customers whose PID is its ID
+	RoleAdmin = 1
+	//RoleCustomer - end customer of a business
+	RoleCustomer = 2
+)
+
+// TranscodeJob.Status values
+const (
+	//JobQueued - waiting for a worker, or for NextRunAt after a failed attempt
+	JobQueued = "queued"
+	//JobRunning - claimed by a worker
+	JobRunning = "running"
+	//JobDone - media transcoded and added to MediaType
+	JobDone = "done"
+	//JobFailed - gave up after the last attempt
+	JobFailed = "failed"
+	//JobCanceled - canceled by the uploader
+	JobCanceled = "canceled"
+)
+
+// MediaGrant.Role values, in increasing order of rights
+const (
+	//GrantViewer - may list and play the media
+	GrantViewer = "viewer"
+	//GrantEditor - may also change catalog, title, description and poster
+	GrantEditor = "editor"
+	//GrantOwner - may also trash, delete and share the media, and takes it
+	//over when the account holding it is deleted
+	GrantOwner = "owner"
+)
+
+// AccountTransfer.Status values
+const (
+	//TransferPending - waiting for the customer to accept or decline
+	TransferPending = "pending"
+	//TransferAccepted - the customer moved to the new business
+	TransferAccepted = "accepted"
+	//TransferDeclined - declined by the customer
+	TransferDeclined = "declined"
+	//TransferCanceled - withdrawn by a business before the customer decided
+	TransferCanceled = "canceled"
+)
+
+// Subscription.State values. All but expired subscriptions run: canceled
+// ones until their EndDate, past due ones until their GraceUntil
+const (
+	//SubscriptionTrialing - in the free trial of its product
+	SubscriptionTrialing = "trialing"
+	//SubscriptionActive - in a period of its product's Duration
+	SubscriptionActive = "active"
+	//SubscriptionPastDue - its period ended and it could not be renewed
+	SubscriptionPastDue = "past_due"
+	//SubscriptionCanceled - not renewed once its period ends
+	SubscriptionCanceled = "canceled"
+	//SubscriptionExpired - ended, the business is read-only for uploads
+	SubscriptionExpired = "expired"
+)
+
 type (
 	// AccountEntry - testing
 	AccountEntry struct {
@@ -29,16 +97,30 @@ type (
 		LastType      string
 	}
 
-	// SubscriptionEntry - testing
+	// SubscriptionEntry - a subscription of business ID to a product, in
+	// its current period from StartDate to EndDate
 	SubscriptionEntry struct {
 		ID               int
 		ProductID        int
 		SubscriptionCode int
 		ProductType      string
 		StoreLocation    string
-		StartDate        string
-		EndDate          string
+		StartDate        time.Time
+		EndDate          time.Time
 		NumberOfAdmins   int
+		State            string
+		GraceUntil       *time.Time // set while past due
+		CanceledAt       *time.Time
+		UpdatedAt        time.Time
+
+		/* the features of the product when subscribed, kept through
+		 * renewals whatever the product becomes */
+		StoreSize     int
+		MaxAdmins     int
+		MaxCustomers  int
+		Renditions    string
+		RetentionDays int
+		Duration      int // days of a period
 	}
 
 	// SubscriptionAccountEntry - testing
@@ -47,13 +129,36 @@ type (
 		PID int
 	}
 
-	// ProductEntry - testing
+	// ProductEntry - a plan businesses subscribe to and what it entitles
+	// them to. Uploads are transcoded with Renditions and media deleted
+	// RetentionDays after they were added. A zero MaxAdmins, MaxCustomers
+	// or RetentionDays is no limit, a zero TrialDays no trial, empty
+	// Renditions the ladder the server transcodes with. An archived product is off the list but valid for
+	// the subscriptions to it
 	ProductEntry struct {
-		ProductID   int
-		ProductType string
-		StoreSize   int
-		Duration    int
-		Amount      int
+		ProductID     int
+		ProductType   string
+		StoreSize     int
+		Duration      int
+		MaxAdmins     int
+		MaxCustomers  int
+		Renditions    string
+		RetentionDays int
+		TrialDays     int
+		Prices        []ProductPriceEntry
+		ArchivedAt    *time.Time
+		CreatedAt     time.Time
+		UpdatedAt     time.Time
+	}
+
+	// ProductPriceEntry - price of a product in one currency. An inclusive
+	// price has TaxRate, in basis points, taken out of it, an exclusive one
+	// has it added
+	ProductPriceEntry struct {
+		ProductID    int
+		Price        money.Money
+		TaxInclusive bool
+		TaxRate      int
 	}
 
 	// MediaTypeEntry - testing
@@ -65,5 +170,166 @@ type (
 		Description string
 		URL         string
 		Poster      string
+		Posters     int             // poster candidates generated, 1 to Posters
+		Thumbnails  string          // store key of the WebVTT thumbnail track, "" if none
+		Download    string          // store key of the download MP4, "" if none
+		CatalogID   int             // 0 unless filed in a Catalog entity
+		Position    int             // order within the catalog
+		Info        *MediaInfoEntry // nil for media added before probing
+		StoredBytes int64           // original and transcoded files in the store
+		DeletedAt   *time.Time      // set while the media is in the trash
+	}
+
+	// StorageUsageEntry - bytes a business and its customers use: stored
+	// media, trash included, and pending uploads and transcoding sources
+	StorageUsageEntry struct {
+		MediaBytes   int64
+		PendingBytes int64
+	}
+
+	// SubscribedStorageEntry - the largest StoreSize of the running
+	// subscriptions of a business, as subscribed, and how many run and have expired
+	SubscribedStorageEntry struct {
+		StoreSize int
+		Running   int
+		Expired   int
+	}
+
+	// EgressEntry - bytes playback served on Day, a UTC date, of the media
+	// of account ID
+	EgressEntry struct {
+		ID    int
+		Day   time.Time
+		Bytes int64
+	}
+
+	// CatalogEntry - an album of account ID's media. ParentID is 0 for a
+	// top level catalog, SortOrder orders siblings, Cover is the store
+	// key of the poster shown for the catalog
+	CatalogEntry struct {
+		CatalogID int
+		ID        int
+		ParentID  int
+		Name      string
+		Cover     string
+		SortOrder int
+		CreatedAt time.Time
+		UpdatedAt time.Time
+	}
+
+	// MediaInfoEntry - what ffprobe reports about an upload. Duration is in
+	// seconds, Bitrate the overall bit/s, FileSize the upload in bytes;
+	// the codecs are empty if there is no such stream
+	MediaInfoEntry struct {
+		Container  string
+		Duration   float64
+		Width      int
+		Height     int
+		VideoCodec string
+		AudioCodec string
+		Bitrate    int64
+		FrameRate  float64
+		FileSize   int64
+	}
+
+	// SessionEntry - a bearer session issued at login. SessionID is the
+	// SHA-256 of the token handed to the client, never the token itself
+	SessionEntry struct {
+		SessionID string
+		ID        int
+		PID       int
+		UserName  string
+		Role      int
+		CreatedAt time.Time
+		ExpiresAt time.Time
+	}
+
+	// PasswordResetEntry - a single use password reset token. TokenHash is
+	// the SHA-256 of the token sent to the account owner
+	PasswordResetEntry struct {
+		TokenHash string
+		ID        int
+		CreatedAt time.Time
+		ExpiresAt time.Time
+	}
+
+	// TranscodeJobEntry - an uploaded file waiting to be transcoded. SrcKey
+	// is the media store key of the upload, OutPrefix the key prefix the
+	// playlist, segments and poster named BaseName go to; the MediaType row
+	// with URL and Poster, the store keys of master playlist and poster,
+	// is added once all steps succeed
+	TranscodeJobEntry struct {
+		JobID       int
+		ID          int
+		Catalog     string
+		Title       string
+		Description string
+		FileName    string
+		SrcKey      string
+		OutPrefix   string
+		BaseName    string
+		URL         string
+		Poster      string
+		SrcSize     int64  // bytes of the original put to the store
+		Renditions  string // ladder of the subscription, "" for the server's
+		Status      string
+		Step        string
+		Attempts    int
+		LastError   string
+		NextRunAt   time.Time
+		CreatedAt   time.Time
+		UpdatedAt   time.Time
+	}
+
+	// MediaKeyEntry - AES-128 key of an encrypted HLS asset, Name being the
+	// media name below the account. WrappedKey is the key encrypted with
+	// the key encryption key, never the key itself
+	MediaKeyEntry struct {
+		ID         int
+		Name       string
+		WrappedKey []byte
+		CreatedAt  time.Time
+	}
+
+	// MediaGrantEntry - rights of account AccountID on the media FileName
+	// held by account OwnerID, besides those of OwnerID's tenancy
+	MediaGrantEntry struct {
+		OwnerID   int
+		FileName  string
+		AccountID int
+		Role      string
+		CreatedAt time.Time
+	}
+
+	// AccountTransferEntry - a request to move customer CustomerID from
+	// business FromPID to business ToPID, made by account RequestedBy.
+	// The customer's PID only changes once the customer accepts
+	AccountTransferEntry struct {
+		TransferID  int
+		CustomerID  int
+		FromPID     int
+		ToPID       int
+		RequestedBy int
+		Status      string
+		CreatedAt   time.Time
+		DecidedAt   *time.Time // set once accepted, declined or canceled
+	}
+
+	// UploadEntry - a resumable upload of FileName for account ID, staged
+	// on local disk until Offset reaches Length. JobID is the transcode
+	// job the completed upload was handed to, 0 before
+	UploadEntry struct {
+		UploadID    string
+		ID          int
+		Catalog     string
+		Title       string
+		Description string
+		FileName    string
+		Length      int64
+		Offset      int64
+		JobID       int
+		ExpiresAt   time.Time
+		CreatedAt   time.Time
+		UpdatedAt   time.Time
 	}
 )
@@ -1,17 +1,18 @@
 package dbmodel
 
-// this file is used by an "init" application to
-// create DB and create tables
-// ideally this must be run only once
-// this is used only to facilitate automatic
-// creation of database by a go application.
-
-//TableCreateSQL - create statements
-var TableCreateSQL = []string{
+// this file is used by dbinit to create the DB and migrate
+// its tables, see Migrations for the versions of the schema
+
+//DatabaseSQL - create and select the database the migrations run in
+var DatabaseSQL = []string{
 	`CREATE DATABASE IF NOT EXISTS relive;`,
 
 	`USE relive;`,
+}
 
+/* baselineSQL - the schema as the unversioned DB init left it: tables
+ * first, then the ALTERs it ran on every start */
+var baselineSQL = []string{
 	`CREATE TABLE IF NOT EXISTS Account (
 		  ID int(11) NOT NULL AUTO_INCREMENT,
 		  PID int(11) NOT NULL,
@@ -82,7 +83,174 @@ var TableCreateSQL = []string{
           PRIMARY KEY (URL),
 		  CONSTRAINT MediaType_ibfk_1 FOREIGN KEY (ID) REFERENCES Account (ID) ON DELETE CASCADE ON UPDATE CASCADE
 		) ENGINE=InnoDB DEFAULT CHARSET=utf8 ;`,
-}
 
-//TableDeleteSQL - delete/drop statements
-var TableDeleteSQL = []string{}
+	`CREATE TABLE IF NOT EXISTS MediaInfo (
+		  URL varchar(1024) NOT NULL,
+		  Container varchar(128) NOT NULL,
+		  Duration double NOT NULL DEFAULT 0,
+		  Width int(11) NOT NULL DEFAULT 0,
+		  Height int(11) NOT NULL DEFAULT 0,
+		  VideoCodec varchar(32) NOT NULL DEFAULT '',
+		  AudioCodec varchar(32) NOT NULL DEFAULT '',
+		  Bitrate bigint(20) NOT NULL DEFAULT 0,
+		  FrameRate double NOT NULL DEFAULT 0,
+		  FileSize bigint(20) NOT NULL DEFAULT 0,
+		  PRIMARY KEY (URL),
+		  CONSTRAINT MediaInfo_ibfk_1 FOREIGN KEY (URL) REFERENCES MediaType (URL) ON DELETE CASCADE ON UPDATE CASCADE
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8 ;`,
+
+	`CREATE TABLE IF NOT EXISTS Session (
+		  SessionID char(64) NOT NULL,
+		  ID int(11) NOT NULL,
+		  PID int(11) NOT NULL,
+		  UserName varchar(100) NOT NULL,
+		  Role tinyint NOT NULL,
+		  CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		  ExpiresAt TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:01',
+		  PRIMARY KEY (SessionID),
+		  KEY Session_ID (ID),
+		  CONSTRAINT Session_ibfk_1 FOREIGN KEY (ID) REFERENCES Account (ID) ON DELETE CASCADE ON UPDATE CASCADE
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8 ;`,
+
+	`CREATE TABLE IF NOT EXISTS PasswordReset (
+		  TokenHash char(64) NOT NULL,
+		  ID int(11) NOT NULL,
+		  CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		  ExpiresAt TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:01',
+		  UsedAt TIMESTAMP NULL DEFAULT NULL,
+		  PRIMARY KEY (TokenHash),
+		  KEY PasswordReset_ID (ID),
+		  CONSTRAINT PasswordReset_ibfk_1 FOREIGN KEY (ID) REFERENCES Account (ID) ON DELETE CASCADE ON UPDATE CASCADE
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8 ;`,
+
+	`CREATE TABLE IF NOT EXISTS TranscodeJob (
+		  JobID int(11) NOT NULL AUTO_INCREMENT,
+		  ID int(11) NOT NULL,
+		  Catalog varchar(256) NOT NULL,
+		  Title varchar(100) NOT NULL,
+		  Description varchar(4096) NOT NULL DEFAULT '',
+		  FileName varchar(256) NOT NULL,
+		  SrcKey varchar(1024) NOT NULL,
+		  OutPrefix varchar(1024) NOT NULL,
+		  BaseName varchar(256) NOT NULL,
+		  URL varchar(1024) NOT NULL,
+		  Poster varchar(1024) NOT NULL,
+		  Status varchar(16) NOT NULL,
+		  Step varchar(32) NOT NULL DEFAULT '',
+		  Attempts int(11) NOT NULL DEFAULT 0,
+		  LastError varchar(4096) NOT NULL DEFAULT '',
+		  NextRunAt TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:01',
+		  CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		  UpdatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		  PRIMARY KEY (JobID),
+		  KEY TranscodeJob_Status (Status, NextRunAt),
+		  CONSTRAINT TranscodeJob_ibfk_1 FOREIGN KEY (ID) REFERENCES Account (ID) ON DELETE CASCADE ON UPDATE CASCADE
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8 ;`,
+
+	`CREATE TABLE IF NOT EXISTS MediaKey (
+		  ID int(11) NOT NULL,
+		  Name varchar(256) NOT NULL,
+		  WrappedKey varbinary(128) NOT NULL,
+		  CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		  PRIMARY KEY (ID, Name)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8 ;`,
+
+	`CREATE TABLE IF NOT EXISTS Upload (
+		  UploadID varchar(64) NOT NULL,
+		  ID int(11) NOT NULL,
+		  Catalog varchar(256) NOT NULL,
+		  Title varchar(100) NOT NULL,
+		  Description varchar(4096) NOT NULL DEFAULT '',
+		  FileName varchar(256) NOT NULL,
+		  UploadLength bigint(20) NOT NULL,
+		  UploadOffset bigint(20) NOT NULL DEFAULT 0,
+		  JobID int(11) NOT NULL DEFAULT 0,
+		  ExpiresAt TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:01',
+		  CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		  UpdatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		  PRIMARY KEY (UploadID),
+		  KEY Upload_ExpiresAt (ExpiresAt),
+		  CONSTRAINT Upload_ibfk_1 FOREIGN KEY (ID) REFERENCES Account (ID) ON DELETE CASCADE ON UPDATE CASCADE
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8 ;`,
+
+	`CREATE TABLE IF NOT EXISTS Catalog (
+		  CatalogID int(11) NOT NULL AUTO_INCREMENT,
+		  ID int(11) NOT NULL,
+		  ParentID int(11) NOT NULL DEFAULT 0,
+		  Name varchar(256) NOT NULL,
+		  Cover varchar(1024) NOT NULL DEFAULT '',
+		  SortOrder int(11) NOT NULL DEFAULT 0,
+		  CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		  UpdatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		  PRIMARY KEY (CatalogID),
+		  KEY Catalog_Parent (ID, ParentID),
+		  CONSTRAINT Catalog_ibfk_1 FOREIGN KEY (ID) REFERENCES Account (ID) ON DELETE CASCADE ON UPDATE CASCADE
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8 ;`,
+
+	`CREATE TABLE IF NOT EXISTS MediaGrant (
+		  OwnerID int(11) NOT NULL,
+		  FileName varchar(256) NOT NULL,
+		  AccountID int(11) NOT NULL,
+		  Role varchar(16) NOT NULL,
+		  CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		  PRIMARY KEY (OwnerID, FileName, AccountID),
+		  KEY MediaGrant_AccountID (AccountID),
+		  CONSTRAINT MediaGrant_ibfk_1 FOREIGN KEY (AccountID) REFERENCES Account (ID) ON DELETE CASCADE ON UPDATE CASCADE
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8 ;`,
+
+	`CREATE TABLE IF NOT EXISTS AccountTransfer (
+		  TransferID int(11) NOT NULL AUTO_INCREMENT,
+		  CustomerID int(11) NOT NULL,
+		  FromPID int(11) NOT NULL,
+		  ToPID int(11) NOT NULL,
+		  RequestedBy int(11) NOT NULL,
+		  Status varchar(16) NOT NULL,
+		  CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		  DecidedAt TIMESTAMP NULL DEFAULT NULL,
+		  PRIMARY KEY (TransferID),
+		  KEY AccountTransfer_CustomerID (CustomerID, Status),
+		  CONSTRAINT AccountTransfer_ibfk_1 FOREIGN KEY (CustomerID) REFERENCES Account (ID) ON DELETE CASCADE ON UPDATE CASCADE
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8 ;`,
+
+	`CREATE TABLE IF NOT EXISTS StorageOverage (
+		  ID int(11) NOT NULL,
+		  Period char(7) NOT NULL,
+		  OverageBytes bigint(20) NOT NULL DEFAULT 0,
+		  UpdatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		  PRIMARY KEY (ID, Period),
+		  CONSTRAINT StorageOverage_ibfk_1 FOREIGN KEY (ID) REFERENCES Account (ID) ON DELETE CASCADE ON UPDATE CASCADE
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8 ;`,
+
+	/* no foreign key, what was served is billed even after the account went */
+	`CREATE TABLE IF NOT EXISTS Egress (
+		  ID int(11) NOT NULL,
+		  Day date NOT NULL,
+		  Bytes bigint(20) NOT NULL DEFAULT 0,
+		  PRIMARY KEY (ID, Day)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8 ;`,
+
+	`ALTER TABLE MediaType ADD COLUMN DeletedAt TIMESTAMP NULL DEFAULT NULL ;`,
+
+	`ALTER TABLE MediaType ADD COLUMN CatalogID int(11) NOT NULL DEFAULT 0, ADD COLUMN Position int(11) NOT NULL DEFAULT 0 ;`,
+
+	/* keys outlive the account that uploaded when an owner takes the media over */
+	`ALTER TABLE MediaKey DROP FOREIGN KEY MediaKey_ibfk_1 ;`,
+
+	`ALTER TABLE MediaType ADD COLUMN StoredBytes bigint(20) NOT NULL DEFAULT 0 ;`,
+
+	`ALTER TABLE TranscodeJob ADD COLUMN SrcSize bigint(20) NOT NULL DEFAULT 0 ;`,
+
+	`ALTER TABLE MediaType ADD COLUMN Posters int(11) NOT NULL DEFAULT 0, ADD COLUMN Thumbnails varchar(1024) NOT NULL DEFAULT '' ;`,
+
+	`ALTER TABLE MediaType ADD COLUMN Download varchar(1024) NOT NULL DEFAULT '' ;`,
+
+	/* stored below asset IDs, nothing else keeps two uploads from taking one file name.
+	 * Of the media taking a name already the newest stays, by its last transcode job */
+	`DELETE m FROM MediaType m
+		  JOIN MediaType n ON n.ID = m.ID AND n.FileName = m.FileName AND n.URL <> m.URL
+		  LEFT JOIN (SELECT URL, MAX(JobID) AS JobID FROM TranscodeJob GROUP BY URL) jm ON jm.URL = m.URL
+		  LEFT JOIN (SELECT URL, MAX(JobID) AS JobID FROM TranscodeJob GROUP BY URL) jn ON jn.URL = n.URL
+		  WHERE (COALESCE(jm.JobID, 0), m.URL) < (COALESCE(jn.JobID, 0), n.URL) ;`,
+
+	`ALTER TABLE MediaType ADD UNIQUE KEY MediaType_file (ID, FileName) ;`,
+}
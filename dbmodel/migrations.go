@@ -0,0 +1,229 @@
+package dbmodel
+
+import "time"
+
+type (
+	// Migration - a version of the schema. Up moves the schema from the
+	// version before to this one, Down moves it back. Once released the
+	// statements of a migration must not change, a change to the schema is
+	// a new migration. Baseline marks the migration adopting databases the
+	// unversioned DB init created, the only one whose CREATEs, ADDs and
+	// DROPs may find their work already done.
+	Migration struct {
+		Version  int
+		Name     string
+		Up       []string
+		Down     []string
+		Baseline bool
+	}
+
+	// SchemaMigrationEntry - a migration applied to the database. Checksum
+	// is that of the statements applied, to tell if they were edited since
+	SchemaMigrationEntry struct {
+		Version   int
+		Name      string
+		Checksum  string
+		AppliedAt time.Time
+	}
+)
+
+// SchemaMigrationsSQL - the table the applied migrations are recorded in
+const SchemaMigrationsSQL = `CREATE TABLE IF NOT EXISTS schema_migrations (
+		  Version int(11) NOT NULL,
+		  Name varchar(255) NOT NULL,
+		  Checksum char(64) NOT NULL,
+		  AppliedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		  PRIMARY KEY (Version)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8 ;`
+
+// Migrations - versions of the schema, in the order they are applied
+var Migrations = []Migration{
+	{Version: 1, Name: "baseline", Up: baselineSQL, Down: baselineDropSQL, Baseline: true},
+	{Version: 2, Name: "product prices", Up: productPriceSQL, Down: productPriceDropSQL},
+	{Version: 3, Name: "product catalog", Up: productCatalogSQL, Down: productCatalogDropSQL},
+	{Version: 4, Name: "subscription lifecycle", Up: subscriptionStateSQL, Down: subscriptionStateDropSQL},
+	{Version: 5, Name: "subscription features", Up: subscriptionFeaturesSQL, Down: subscriptionFeaturesDropSQL},
+	{Version: 6, Name: "renditions and retention", Up: renditionsRetentionSQL, Down: renditionsRetentionDropSQL},
+	{Version: 7, Name: "trial use", Up: trialUseSQL, Down: trialUseDropSQL},
+	{Version: 8, Name: "unique user names", Up: uniqueUserNameSQL, Down: uniqueUserNameDropSQL},
+	{Version: 9, Name: "subscription duration", Up: subscriptionDurationSQL, Down: subscriptionDurationDropSQL},
+}
+
+/* productPriceSQL - prices in minor units per currency replace the whole
+ * Amount of a Product. The currency of Amount was never recorded, it is
+ * taken for US dollars. */
+var productPriceSQL = []string{
+	`CREATE TABLE ProductPrice (
+		  ProductID int(11) NOT NULL,
+		  Currency char(3) NOT NULL,
+		  Amount bigint(20) NOT NULL,
+		  TaxInclusive tinyint(1) NOT NULL DEFAULT 0,
+		  TaxRate int(11) NOT NULL DEFAULT 0,
+		  PRIMARY KEY (ProductID, Currency),
+		  CONSTRAINT ProductPrice_ibfk_1 FOREIGN KEY (ProductID) REFERENCES Product (ProductID) ON DELETE CASCADE ON UPDATE CASCADE
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8 ;`,
+
+	`INSERT INTO ProductPrice (ProductID, Currency, Amount) SELECT ProductID, 'USD', Amount * 100 FROM Product ;`,
+
+	`ALTER TABLE Product DROP COLUMN Amount ;`,
+}
+
+/* productPriceDropSQL - back to whole US dollars, rounded. Products without
+ * a price in dollars come back free. */
+var productPriceDropSQL = []string{
+	`ALTER TABLE Product ADD COLUMN Amount int(11) NOT NULL DEFAULT 0 ;`,
+
+	`UPDATE Product p JOIN ProductPrice pp ON pp.ProductID = p.ProductID AND pp.Currency = 'USD'
+		  SET p.Amount = ROUND(pp.Amount / 100) ;`,
+
+	`DROP TABLE ProductPrice ;`,
+}
+
+/* baselineDropSQL - drop the tables of the baseline, referencing tables last */
+var baselineDropSQL = []string{
+	`DROP TABLE IF EXISTS Egress ;`,
+	`DROP TABLE IF EXISTS StorageOverage ;`,
+	`DROP TABLE IF EXISTS AccountTransfer ;`,
+	`DROP TABLE IF EXISTS MediaGrant ;`,
+	`DROP TABLE IF EXISTS Catalog ;`,
+	`DROP TABLE IF EXISTS Upload ;`,
+	`DROP TABLE IF EXISTS MediaKey ;`,
+	`DROP TABLE IF EXISTS TranscodeJob ;`,
+	`DROP TABLE IF EXISTS PasswordReset ;`,
+	`DROP TABLE IF EXISTS Session ;`,
+	`DROP TABLE IF EXISTS MediaInfo ;`,
+	`DROP TABLE IF EXISTS MediaType ;`,
+	`DROP TABLE IF EXISTS SubscriptionAccount ;`,
+	`DROP TABLE IF EXISTS Subscription ;`,
+	`DROP TABLE IF EXISTS PaymentHistory ;`,
+	`DROP TABLE IF EXISTS Payment ;`,
+	`DROP TABLE IF EXISTS Product ;`,
+	`DROP TABLE IF EXISTS Account ;`,
+}
+
+/* productCatalogSQL - features of products and their archiving. Deleting a
+ * product no longer takes the subscriptions to it along, it is refused. */
+var productCatalogSQL = []string{
+	`ALTER TABLE Product ADD COLUMN MaxAdmins int(11) NOT NULL DEFAULT 0, ADD COLUMN MaxCustomers int(11) NOT NULL DEFAULT 0,
+		  ADD COLUMN Renditions varchar(255) NOT NULL DEFAULT '', ADD COLUMN RetentionDays int(11) NOT NULL DEFAULT 0,
+		  ADD COLUMN ArchivedAt TIMESTAMP NULL DEFAULT NULL,
+		  ADD COLUMN CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		  ADD COLUMN UpdatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ;`,
+
+	`ALTER TABLE Subscription DROP FOREIGN KEY Subscription_ibfk_2 ;`,
+
+	`ALTER TABLE Subscription ADD CONSTRAINT Subscription_ibfk_2 FOREIGN KEY (ProductID) REFERENCES Product (ProductID)
+		  ON DELETE RESTRICT ON UPDATE CASCADE ;`,
+}
+
+var productCatalogDropSQL = []string{
+	`ALTER TABLE Subscription DROP FOREIGN KEY Subscription_ibfk_2 ;`,
+
+	`ALTER TABLE Subscription ADD CONSTRAINT Subscription_ibfk_2 FOREIGN KEY (ProductID) REFERENCES Product (ProductID)
+		  ON DELETE CASCADE ON UPDATE CASCADE ;`,
+
+	`ALTER TABLE Product DROP COLUMN MaxAdmins, DROP COLUMN MaxCustomers, DROP COLUMN Renditions, DROP COLUMN RetentionDays,
+		  DROP COLUMN ArchivedAt, DROP COLUMN CreatedAt, DROP COLUMN UpdatedAt ;`,
+}
+
+/* subscriptionStateSQL - subscriptions get a state. Those that ended keep
+ * ending, those left open ended by the epoch defaults get a first period of
+ * their product's Duration from now. */
+var subscriptionStateSQL = []string{
+	`ALTER TABLE Product ADD COLUMN TrialDays int(11) NOT NULL DEFAULT 0 ;`,
+
+	`ALTER TABLE Subscription ADD COLUMN State varchar(16) NOT NULL DEFAULT 'active',
+		  ADD COLUMN GraceUntil TIMESTAMP NULL DEFAULT NULL, ADD COLUMN CanceledAt TIMESTAMP NULL DEFAULT NULL,
+		  ADD COLUMN UpdatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		  ADD KEY Subscription_due (State, EndDate) ;`,
+
+	`UPDATE Subscription SET State = 'expired' WHERE EndDate >= '1970-01-02' AND EndDate <= CURRENT_TIMESTAMP ;`,
+
+	`UPDATE Subscription s JOIN Product p ON p.ProductID = s.ProductID
+		  SET s.StartDate = GREATEST(s.StartDate, CURRENT_TIMESTAMP),
+		  s.EndDate = DATE_ADD(GREATEST(s.StartDate, CURRENT_TIMESTAMP), INTERVAL GREATEST(p.Duration, 1) DAY)
+		  WHERE s.EndDate < '1970-01-02' ;`,
+}
+
+/* subscriptionStateDropSQL - the dates alone tell again whether a
+ * subscription runs */
+var subscriptionStateDropSQL = []string{
+	`ALTER TABLE Subscription DROP KEY Subscription_due, DROP COLUMN State, DROP COLUMN GraceUntil,
+		  DROP COLUMN CanceledAt, DROP COLUMN UpdatedAt ;`,
+
+	`ALTER TABLE Product DROP COLUMN TrialDays ;`,
+}
+
+/* subscriptionFeaturesSQL - a subscription keeps the features of its product
+ * as they were when it was made, changes to the product are for new
+ * subscriptions. Those made before get the features the product has now. */
+var subscriptionFeaturesSQL = []string{
+	`ALTER TABLE Subscription ADD COLUMN StoreSize int(11) NOT NULL DEFAULT 0, ADD COLUMN MaxAdmins int(11) NOT NULL DEFAULT 0,
+		  ADD COLUMN MaxCustomers int(11) NOT NULL DEFAULT 0, ADD COLUMN Renditions varchar(255) NOT NULL DEFAULT '',
+		  ADD COLUMN RetentionDays int(11) NOT NULL DEFAULT 0 ;`,
+
+	`UPDATE Subscription s JOIN Product p ON p.ProductID = s.ProductID
+		  SET s.StoreSize = p.StoreSize, s.MaxAdmins = p.MaxAdmins, s.MaxCustomers = p.MaxCustomers,
+		  s.Renditions = p.Renditions, s.RetentionDays = p.RetentionDays ;`,
+}
+
+var subscriptionFeaturesDropSQL = []string{
+	`ALTER TABLE Subscription DROP COLUMN StoreSize, DROP COLUMN MaxAdmins, DROP COLUMN MaxCustomers,
+		  DROP COLUMN Renditions, DROP COLUMN RetentionDays ;`,
+}
+
+/* renditionsRetentionSQL - jobs transcode with the ladder of the
+ * subscription, media are kept as long as it retains them. Retention of
+ * media added before counts from now. */
+var renditionsRetentionSQL = []string{
+	`ALTER TABLE TranscodeJob ADD COLUMN Renditions varchar(255) NOT NULL DEFAULT '' ;`,
+
+	`ALTER TABLE MediaType ADD COLUMN CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ;`,
+}
+
+var renditionsRetentionDropSQL = []string{
+	`ALTER TABLE MediaType DROP COLUMN CreatedAt ;`,
+
+	`ALTER TABLE TranscodeJob DROP COLUMN Renditions ;`,
+}
+
+/* trialUseSQL - a business has one trial, recorded with the account so that
+ * deleting subscriptions does not give it back. A business that subscribed
+ * before had its chance. */
+var trialUseSQL = []string{
+	`ALTER TABLE Account ADD COLUMN TrialUsedAt TIMESTAMP NULL DEFAULT NULL ;`,
+
+	`UPDATE Account a JOIN (SELECT ID, MIN(StartDate) AS StartDate FROM Subscription GROUP BY ID) s ON s.ID = a.ID
+		  SET a.TrialUsedAt = s.StartDate ;`,
+}
+
+var trialUseDropSQL = []string{
+	`ALTER TABLE Account DROP COLUMN TrialUsedAt ;`,
+}
+
+/* uniqueUserNameSQL - accounts are looked up by user name, a name may only
+ * be taken once. The oldest account keeps a name taken more than once, the
+ * others get their ID appended and are to be renamed by root. */
+var uniqueUserNameSQL = []string{
+	`UPDATE Account a JOIN (SELECT UserName, MIN(ID) AS ID FROM Account GROUP BY UserName HAVING COUNT(*) > 1) d
+		  ON d.UserName = a.UserName AND a.ID <> d.ID
+		  SET a.UserName = CONCAT(LEFT(a.UserName, 88), '#', a.ID) ;`,
+
+	`ALTER TABLE Account ADD UNIQUE KEY Account_UserName (UserName) ;`,
+}
+
+var uniqueUserNameDropSQL = []string{
+	`ALTER TABLE Account DROP KEY Account_UserName ;`,
+}
+
+/* subscriptionDurationSQL - a subscription renews for the period it was
+ * subscribed with, like its other features */
+var subscriptionDurationSQL = []string{
+	`ALTER TABLE Subscription ADD COLUMN Duration int(11) NOT NULL DEFAULT 0 ;`,
+
+	`UPDATE Subscription s JOIN Product p ON p.ProductID = s.ProductID SET s.Duration = p.Duration ;`,
+}
+
+var subscriptionDurationDropSQL = []string{
+	`ALTER TABLE Subscription DROP COLUMN Duration ;`,
+}
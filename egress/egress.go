@@ -0,0 +1,130 @@
+// Package egress - bytes playback serves, counted per account and day for
+// billing and usage reports. Counting happens in memory, the counts are
+// added to the Egress table periodically.
+package egress
+
+import (
+	"sync"
+	"time"
+
+	"github.com/msproject/relive/dbi"
+	"github.com/msproject/relive/logger"
+	"github.com/msproject/relive/mediastore"
+)
+
+//DefaultFlushInterval - how often counted bytes are persisted
+const DefaultFlushInterval = time.Minute
+
+/* served - bytes of the media name stored below account id on a day. An
+ * empty name marks bytes already attributed to account id. */
+type served struct {
+	id   int
+	name string
+	day  time.Time
+}
+
+// Meter - counts bytes served per media. Bytes are billed to the account
+// holding the media, which is not the one it is stored below once an owner
+// took it over. Counts not flushed yet are lost if the process dies.
+type Meter struct {
+	egressDBI dbi.EgressTblDBI
+	mediaDBI  dbi.MediaTypeTblDBI
+	interval  time.Duration
+	logObj    *logger.Logger
+	now       func() time.Time
+
+	mu      sync.Mutex
+	pending map[served]int64
+}
+
+// NewMeter - meter persisting its counts every interval once started
+func NewMeter(egressDBI dbi.EgressTblDBI, mediaDBI dbi.MediaTypeTblDBI, interval time.Duration, logObj *logger.Logger) *Meter {
+	if interval <= 0 {
+		interval = DefaultFlushInterval
+	}
+	return &Meter{
+		egressDBI: egressDBI,
+		mediaDBI:  mediaDBI,
+		interval:  interval,
+		logObj:    logObj,
+		now:       time.Now,
+		pending:   map[served]int64{},
+	}
+}
+
+// Start - flush the counts periodically
+func (m *Meter) Start() {
+	go func() {
+		for range time.Tick(m.interval) {
+			if err := m.Flush(); err != nil {
+				m.logObj.PrintError("Failed to persist egress, retrying: %v", err)
+			}
+		}
+	}()
+}
+
+// Add - count bytes served of the media name stored below account id
+func (m *Meter) Add(id int, name string, bytes int64) {
+	if bytes <= 0 {
+		return
+	}
+	m.add(served{id: id, name: name, day: m.now().UTC().Truncate(24 * time.Hour)}, bytes)
+}
+
+func (m *Meter) add(s served, bytes int64) {
+	m.mu.Lock()
+	m.pending[s] += bytes
+	m.mu.Unlock()
+}
+
+// Flush - add the counts to the Egress table. Counts that could not be
+// added are kept for the next flush.
+func (m *Meter) Flush() error {
+	m.mu.Lock()
+	pending := m.pending
+	m.pending = map[served]int64{}
+	m.mu.Unlock()
+
+	accounts := map[served]int64{}
+	holders := map[served]int{}
+	for s, bytes := range pending {
+		media := served{id: s.id, name: s.name}
+		id, ok := holders[media]
+		if !ok {
+			var err error
+			if id, err = m.holder(s.id, s.name); err != nil {
+				for s, bytes := range pending {
+					m.add(s, bytes)
+				}
+				return err
+			}
+			holders[media] = id
+		}
+		accounts[served{id: id, day: s.day}] += bytes
+	}
+
+	var failed error
+	for s, bytes := range accounts {
+		if err := m.egressDBI.AddEgress(s.id, s.day, bytes); err != nil {
+			m.add(s, bytes)
+			failed = err
+		}
+	}
+	return failed
+}
+
+// holder - the account holding the media name stored below account id,
+// id itself if the media is gone
+func (m *Meter) holder(id int, name string) (int, error) {
+	if name == "" {
+		return id, nil
+	}
+	mt, err := m.mediaDBI.GetMediaTypeByURL(mediastore.Key(id, name, name+".m3u8"))
+	if err != nil {
+		return 0, err
+	}
+	if mt == nil {
+		return id, nil
+	}
+	return mt.ID, nil
+}
@@ -0,0 +1,79 @@
+package egress
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/msproject/relive/dbi"
+	"github.com/msproject/relive/dbmodel"
+	"github.com/msproject/relive/logger"
+)
+
+/* memMedia - in memory media, only GetMediaTypeByURL is used */
+type memMedia struct {
+	dbi.MediaTypeTblDBI
+	byURL map[string]dbmodel.MediaTypeEntry
+}
+
+func (m memMedia) GetMediaTypeByURL(url string) (*dbmodel.MediaTypeEntry, error) {
+	mt, ok := m.byURL[url]
+	if !ok {
+		return nil, nil
+	}
+	return &mt, nil
+}
+
+/* memEgress - in memory EgressTblDBI, failing while err is set */
+type memEgress struct {
+	bytes map[int]int64
+	err   error
+}
+
+func (m *memEgress) AddEgress(id int, day time.Time, bytes int64) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.bytes[id] += bytes
+	return nil
+}
+
+func (m *memEgress) ListEgress(id int, from, to time.Time) ([]dbmodel.EgressEntry, error) {
+	return nil, nil
+}
+
+func TestFlush(t *testing.T) {
+	logObj, err := logger.NewLoggerObject(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	/* account 3 took over the wedding stored below account 2 */
+	media := memMedia{byURL: map[string]dbmodel.MediaTypeEntry{
+		"2/wedding/wedding.m3u8": {ID: 3, FileName: "wedding.mp4"},
+		"2/party/party.m3u8":     {ID: 2, FileName: "party.mp4"},
+	}}
+	store := &memEgress{bytes: map[int]int64{}, err: errors.New("down")}
+	m := NewMeter(store, media, time.Minute, logObj)
+
+	m.Add(2, "wedding", 100)
+	m.Add(2, "party", 50)
+	m.Add(2, "party", 0)
+	m.Add(4, "gone", 7)
+	if err = m.Flush(); err == nil {
+		t.Fatalf("expected the failing flush reported")
+	}
+
+	store.err = nil
+	m.Add(2, "wedding", 20)
+	if err = m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if store.bytes[3] != 120 || store.bytes[2] != 50 || store.bytes[4] != 7 {
+		t.Errorf("expected 120, 50 and 7 bytes for accounts 3, 2 and 4, got %v", store.bytes)
+	}
+
+	if err = m.Flush(); err != nil || len(store.bytes) != 3 || store.bytes[3] != 120 {
+		t.Errorf("expected nothing left to flush, got %v %v", store.bytes, err)
+	}
+}
@@ -1,8 +1,10 @@
 package integrationtest
 
 import (
+	"crypto/tls"
 	"database/sql"
 	"fmt"
+	"net/http"
 
 	"github.com/msproject/relive/testtools"
 )
@@ -10,15 +12,24 @@ import (
 // TestCfg - config params used for integration test
 type TestCfg struct {
 	reliveServerURL string
+	reliveHTTPURL   string // plaintext listener, only redirects to HTTPS
 	mysqlAccessAddr string
+	notifyFile      string // password reset tokens, written by the file notifier
+	mediaDir        string // the local media store
 }
 
 var (
 	reliveTestCfg = &TestCfg{
-		reliveServerURL: "http://localhost:9999",
+		reliveServerURL: "https://localhost:8443",
+		reliveHTTPURL:   "http://localhost:9999",
 	}
 )
 
+func init() {
+	/* relive runs with the self-signed relive_cert.pem */
+	http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+}
+
 func reliveSetupTestEnv() error {
 	return nil
 }
@@ -15,6 +15,11 @@ func TestRelive(t *testing.T) {
 	if err != nil {
 		t.Fatal("Error getting wd: ", err.Error())
 	}
+	reliveTestCfg.notifyFile = rootDir + "/relive_notify.log"
+	os.Remove(reliveTestCfg.notifyFile)
+	reliveTestCfg.mediaDir = rootDir + "/relive_media"
+	os.RemoveAll(reliveTestCfg.mediaDir)
+	os.RemoveAll(rootDir + "/relive_uploads")
 	var test1 = &testtools.Comp{
 		Name:       "Top",
 		Sequential: true,
@@ -59,7 +64,15 @@ func TestRelive(t *testing.T) {
 						Command: func() *exec.Cmd {
 							return exec.Command("./relive",
 								"-cert", rootDir+"/../relive_cert.pem",
-								"-key", rootDir+"/../relive_key.pem")
+								"-key", rootDir+"/../relive_key.pem",
+								"-notifier", "file:"+reliveTestCfg.notifyFile,
+								"-mediastore", "local:"+reliveTestCfg.mediaDir,
+								"-uploaddir", rootDir+"/relive_uploads",
+								"-quotaunit", "1048576",
+								"-freequota", "10",
+								"-egressflush", "1s",
+								"-subscriptioncheck", "1s",
+								"-retentioncheck", "1s")
 						},
 					},
 					&testtools.DelayHealthCheck{
@@ -110,6 +123,36 @@ func TestRelive(t *testing.T) {
 							w.Err = testCreateAccountCustomer()
 						},
 					},
+					&testtools.GoFunc{
+						Name: "Test Cross Tenant Reads",
+						Func: func(w *testtools.GoFunc) {
+							w.Err = testCrossTenantReads()
+						},
+					},
+					&testtools.GoFunc{
+						Name: "Test User Name Taken",
+						Func: func(w *testtools.GoFunc) {
+							w.Err = testUserNameTaken()
+						},
+					},
+					&testtools.GoFunc{
+						Name: "Test Plaintext Redirect",
+						Func: func(w *testtools.GoFunc) {
+							w.Err = testPlaintextRedirect()
+						},
+					},
+					&testtools.GoFunc{
+						Name: "Test Routing",
+						Func: func(w *testtools.GoFunc) {
+							w.Err = testRouting()
+						},
+					},
+					&testtools.GoFunc{
+						Name: "Test Change And Reset Password",
+						Func: func(w *testtools.GoFunc) {
+							w.Err = testChangeAndResetPassword()
+						},
+					},
 					&testtools.GoFunc{
 						Name: "Test Create Subscription",
 						Func: func(w *testtools.GoFunc) {
@@ -122,6 +165,84 @@ func TestRelive(t *testing.T) {
 							w.Err = testUploadMedia()
 						},
 					},
+					&testtools.GoFunc{
+						Name: "Test Resumable Upload",
+						Func: func(w *testtools.GoFunc) {
+							w.Err = testResumableUpload()
+						},
+					},
+					&testtools.GoFunc{
+						Name: "Test Upload File Names",
+						Func: func(w *testtools.GoFunc) {
+							w.Err = testUploadFileNames()
+						},
+					},
+					&testtools.GoFunc{
+						Name: "Test Media Lifecycle",
+						Func: func(w *testtools.GoFunc) {
+							w.Err = testMediaLifecycle()
+						},
+					},
+					&testtools.GoFunc{
+						Name: "Test Catalogs",
+						Func: func(w *testtools.GoFunc) {
+							w.Err = testCatalogs()
+						},
+					},
+					&testtools.GoFunc{
+						Name: "Test Poster Candidates",
+						Func: func(w *testtools.GoFunc) {
+							w.Err = testPosterCandidates()
+						},
+					},
+					&testtools.GoFunc{
+						Name: "Test Playback Caching And Egress",
+						Func: func(w *testtools.GoFunc) {
+							w.Err = testPlayback()
+						},
+					},
+					&testtools.GoFunc{
+						Name: "Test Media Sharing",
+						Func: func(w *testtools.GoFunc) {
+							w.Err = testMediaSharing()
+						},
+					},
+					&testtools.GoFunc{
+						Name: "Test Account Transfer",
+						Func: func(w *testtools.GoFunc) {
+							w.Err = testAccountTransfer()
+						},
+					},
+					&testtools.GoFunc{
+						Name: "Test Storage Quota",
+						Func: func(w *testtools.GoFunc) {
+							w.Err = testStorageQuota()
+						},
+					},
+					&testtools.GoFunc{
+						Name: "Test Product Prices",
+						Func: func(w *testtools.GoFunc) {
+							w.Err = testProductPrices()
+						},
+					},
+					&testtools.GoFunc{
+						Name: "Test Product Catalog",
+						Func: func(w *testtools.GoFunc) {
+							w.Err = testProductCatalog()
+						},
+					},
+					&testtools.GoFunc{
+						Name: "Test Subscription Lifecycle",
+						Func: func(w *testtools.GoFunc) {
+							w.Err = testSubscriptionLifecycle()
+						},
+					},
+					&testtools.GoFunc{
+						Name: "Test Media Retention",
+						Func: func(w *testtools.GoFunc) {
+							w.Err = testMediaRetention()
+						},
+					},
 				},
 			},
 
@@ -0,0 +1,127 @@
+package integrationtest
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/msproject/relive/dbmodel"
+	"github.com/msproject/relive/util"
+)
+
+func createCatalog(token string, req util.CatalogReq) (dbmodel.CatalogEntry, error) {
+	var created dbmodel.CatalogEntry
+	status, err := doRequest("POST", reliveTestCfg.reliveServerURL+"/api/catalogs", token, req, &created)
+	if err != nil {
+		return created, err
+	}
+	if status != http.StatusCreated || created.CatalogID == 0 {
+		return created, fmt.Errorf("expected 201 creating catalog %s, got %d", req.Name, status)
+	}
+	return created, nil
+}
+
+// testCatalogs - a wedding with ceremony and reception below it, media
+// filed and ordered in the ceremony and listed through the tree
+func testCatalogs() error {
+	token, err := loginAs(tenantAdminA, tenantPWD)
+	if err != nil {
+		return err
+	}
+	adminA, err := lookupAccount(token, tenantAdminA)
+	if err != nil {
+		return err
+	}
+	id := int(adminA.ID)
+
+	wedding, err := createCatalog(token, util.CatalogReq{ID: id, Name: "wedding"})
+	if err != nil {
+		return err
+	}
+	ceremony, err := createCatalog(token, util.CatalogReq{ID: id, ParentID: wedding.CatalogID, Name: "ceremony"})
+	if err != nil {
+		return err
+	}
+	if _, err = createCatalog(token, util.CatalogReq{ID: id, ParentID: wedding.CatalogID, Name: "reception", SortOrder: 1}); err != nil {
+		return err
+	}
+
+	base := reliveTestCfg.reliveServerURL
+	/* a catalog cannot go below its own subcatalog */
+	status, err := doRequest("PUT", fmt.Sprintf("%s/api/catalogs/%d", base, wedding.CatalogID), token,
+		util.UpdateCatalogReq{ParentID: &ceremony.CatalogID}, nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusBadRequest {
+		return fmt.Errorf("expected 400 moving a catalog below itself, got %d", status)
+	}
+
+	files := []string{"aisle.mp4", "vows.mp4", "rings.mp4"}
+	for _, f := range files {
+		if err = seedMedia(id, "drafts", f); err != nil {
+			return err
+		}
+	}
+	status, err = doRequest("POST", base+"/api/media/move", token,
+		util.MoveMediaReq{ID: id, FileNames: files, CatalogID: ceremony.CatalogID}, nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("expected 200 filing media in the ceremony, got %d", status)
+	}
+
+	/* ordered twice, the second time leaving the aisle out: it loses its
+	 * place and comes after the ones listed */
+	order := []string{"rings.mp4", "vows.mp4"}
+	for _, fileNames := range [][]string{files, order} {
+		status, err = doRequest("PUT", fmt.Sprintf("%s/api/catalogs/%d/order", base, ceremony.CatalogID), token,
+			util.CatalogOrderReq{FileNames: fileNames}, nil)
+		if err != nil {
+			return err
+		}
+		if status != http.StatusNoContent {
+			return fmt.Errorf("expected 204 ordering the ceremony, got %d", status)
+		}
+	}
+
+	var found []dbmodel.MediaTypeEntry
+	status, err = doRequest("GET", fmt.Sprintf("%s/api/media/search?id=%d&catalogid=%d&tree=true", base, id, wedding.CatalogID),
+		token, nil, &found)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK || len(found) != 3 || found[0].Catalog != "ceremony" {
+		return fmt.Errorf("expected the ceremony media below the wedding, got %d %+v", status, found)
+	}
+	for i, f := range append(order, "aisle.mp4") {
+		if found[i].FileName != f {
+			return fmt.Errorf("expected the ceremony media ordered %v then the aisle, got %+v", order, found)
+		}
+	}
+
+	/* other tenants do not see the catalog */
+	tokenB, err := loginAs(tenantAdminB, tenantPWD)
+	if err != nil {
+		return err
+	}
+	if err = expectStatus(tokenB, fmt.Sprintf("/api/catalogs/%d", wedding.CatalogID), http.StatusForbidden); err != nil {
+		return err
+	}
+
+	status, err = doRequest("DELETE", fmt.Sprintf("%s/api/catalogs/%d", base, wedding.CatalogID), token, nil, nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusConflict {
+		return fmt.Errorf("expected 409 deleting a catalog with subcatalogs, got %d", status)
+	}
+	status, err = doRequest("DELETE", fmt.Sprintf("%s/api/catalogs/%d", base, ceremony.CatalogID), token, nil, nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusNoContent {
+		return fmt.Errorf("expected 204 deleting the ceremony, got %d", status)
+	}
+	return nil
+}
@@ -6,10 +6,36 @@ import (
 	"fmt"
 	"net/http"
 
+	"github.com/msproject/relive/dbmodel"
 	"github.com/msproject/relive/util"
 )
 
 var accountCreateURL = reliveTestCfg.reliveServerURL + "/api/accounts/create"
+var accountLoginURL = reliveTestCfg.reliveServerURL + "/api/accounts/login"
+
+// loginAs - login and return the bearer token of the new session
+func loginAs(userName, pwd string) (string, error) {
+	jsonObj, err := json.Marshal(&util.LoginReq{UserName: userName, PWD: pwd})
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := http.Post(accountLoginURL, "application/json", bytes.NewBuffer(jsonObj))
+	if err != nil {
+		return "", fmt.Errorf("failed to login as %s! err: %v", userName, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("failed to get 2XX response for login %s", resp.Status)
+	}
+
+	var loginResp util.LoginResp
+	if err = json.NewDecoder(resp.Body).Decode(&loginResp); err != nil {
+		return "", fmt.Errorf("failed to decode login response! err: %v", err)
+	}
+	return loginResp.Token, nil
+}
 
 func createDummyAccount() ([]byte, error) {
 	data := &util.CreateAccountReq{
@@ -17,7 +43,7 @@ func createDummyAccount() ([]byte, error) {
 		Email:     "test@relive.com",
 		FirstName: "testFirst",
 		LastName:  "testLast",
-		PWD:       "test001",
+		PWD:       "test0001",
 		Role:      0,
 	}
 
@@ -39,11 +65,17 @@ func testCreateAccount() error {
 		return fmt.Errorf("failed to create dummy recording! err: %v", createErr)
 	}
 
+	token, err := loginAs("root", "video@Cloud")
+	if err != nil {
+		return err
+	}
+
 	req, err := http.NewRequest("POST", accountCreateURL, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return fmt.Errorf("failed to create recording http request! err: %v", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
@@ -61,11 +93,48 @@ func testCreateAccount() error {
 }
 
 func testCreateAccountAdmin() error {
+	token, err := loginAs("root", "video@Cloud")
+	if err != nil {
+		return err
+	}
+
+	for _, userName := range []string{tenantAdminA, tenantAdminB} {
+		err = createTestAccount(token, &util.CreateAccountReq{
+			UserName:    userName,
+			Email:       userName + "@relive.com",
+			FirstName:   "tenant",
+			LastName:    userName,
+			CompanyName: userName,
+			PWD:         tenantPWD,
+			Role:        dbmodel.RoleAdmin,
+		})
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 func testCreateAccountCustomer() error {
-	return nil
+	token, err := loginAs(tenantAdminA, tenantPWD)
+	if err != nil {
+		return err
+	}
+
+	adminA, err := lookupAccount(token, tenantAdminA)
+	if err != nil {
+		return err
+	}
+
+	return createTestAccount(token, &util.CreateAccountReq{
+		UserName:  tenantCustomerA,
+		Email:     tenantCustomerA + "@relive.com",
+		FirstName: "tenant",
+		LastName:  tenantCustomerA,
+		PWD:       tenantPWD,
+		CompanyID: adminA.ID,
+		Role:      dbmodel.RoleCustomer,
+	})
 }
 
 func testCreateSubscription() error {
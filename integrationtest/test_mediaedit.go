@@ -0,0 +1,176 @@
+package integrationtest
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/msproject/relive/dbmodel"
+	"github.com/msproject/relive/util"
+)
+
+// seedMedia - add a MediaType row directly, transcoding needs ffmpeg
+func seedMedia(id int, catalog, fileName string) error {
+	db, err := sql.Open("mysql", reliveTestCfg.mysqlAccessAddr)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	name := fileName[:len(fileName)-4]
+	_, err = db.Exec(`INSERT INTO MediaType (ID, Catalog, FileName, Title, Description, URL, Poster) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		id, catalog, fileName, name, "", fmt.Sprintf("%d/%s/%s.m3u8", id, name, name), fmt.Sprintf("%d/%s/%s.jpg", id, name, name))
+	return err
+}
+
+func searchMediaOf(token string, path string, id int) ([]dbmodel.MediaTypeEntry, error) {
+	var found []dbmodel.MediaTypeEntry
+	status, err := doRequest("GET", fmt.Sprintf("%s%s?id=%d", reliveTestCfg.reliveServerURL, path, id), token, nil, &found)
+	if err == nil && status != http.StatusOK {
+		err = fmt.Errorf("expected 200 from %s, got %d", path, status)
+	}
+	return found, err
+}
+
+// testMediaLifecycle - update, move, trash, restore and delete of media
+func testMediaLifecycle() error {
+	token, err := loginAs(tenantAdminA, tenantPWD)
+	if err != nil {
+		return err
+	}
+	adminA, err := lookupAccount(token, tenantAdminA)
+	if err != nil {
+		return err
+	}
+	id := int(adminA.ID)
+	for _, f := range []string{"edit1.mp4", "edit2.mp4"} {
+		if err = seedMedia(id, "drafts", f); err != nil {
+			return err
+		}
+	}
+
+	base := reliveTestCfg.reliveServerURL
+	title, description := "Edited", "fixed the typo"
+	var updated dbmodel.MediaTypeEntry
+	status, err := doRequest("PUT", base+"/api/media/update", token,
+		util.UpdateMediaReq{ID: id, FileName: "edit1.mp4", Title: &title, Description: &description}, &updated)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK || updated.Title != title || updated.Description != description || updated.Catalog != "drafts" {
+		return fmt.Errorf("expected the title and description updated, got %d %+v", status, updated)
+	}
+
+	var moved util.MoveMediaResp
+	status, err = doRequest("POST", base+"/api/media/move", token,
+		util.MoveMediaReq{ID: id, FileNames: []string{"edit1.mp4", "edit2.mp4"}, Catalog: "published"}, &moved)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK || moved.Moved != 2 {
+		return fmt.Errorf("expected 2 media moved, got %d %+v", status, moved)
+	}
+
+	ref := dbmodel.MediaTypeEntry{ID: id, FileName: "edit2.mp4"}
+	if err = expectPost(token, "/api/media/trash", ref, http.StatusNoContent); err != nil {
+		return err
+	}
+	if err = expectPost(token, "/api/media/trash", ref, http.StatusNotFound); err != nil {
+		return err
+	}
+	live, err := searchMediaOf(token, "/api/media/search", id)
+	if err != nil {
+		return err
+	}
+	trash, err := searchMediaOf(token, "/api/media/trash", id)
+	if err != nil {
+		return err
+	}
+	if len(live) != 1 || live[0].Catalog != "published" || len(trash) != 1 || trash[0].DeletedAt == nil {
+		return fmt.Errorf("expected one live and one trashed media, got %+v and %+v", live, trash)
+	}
+
+	if err = expectPost(token, "/api/media/restore", ref, http.StatusNoContent); err != nil {
+		return err
+	}
+	for _, f := range []string{"edit1.mp4", "edit2.mp4"} {
+		status, err = doRequest("DELETE", base+"/api/media/delete", token, dbmodel.MediaTypeEntry{ID: id, FileName: f}, nil)
+		if err != nil {
+			return err
+		}
+		if status != http.StatusNoContent {
+			return fmt.Errorf("expected 204 deleting %s, got %d", f, status)
+		}
+	}
+	return nil
+}
+
+// testPosterCandidates - choose the poster among the candidates the media
+// pipeline sampled, seeded as transcoding needs ffmpeg
+func testPosterCandidates() error {
+	token, err := loginAs(tenantAdminA, tenantPWD)
+	if err != nil {
+		return err
+	}
+	adminA, err := lookupAccount(token, tenantAdminA)
+	if err != nil {
+		return err
+	}
+	id := int(adminA.ID)
+	if err = seedMedia(id, "drafts", "scenes.mp4"); err != nil {
+		return err
+	}
+
+	db, err := sql.Open("mysql", reliveTestCfg.mysqlAccessAddr)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.Exec(`UPDATE MediaType SET Poster = ?, Posters = 3, Thumbnails = ? WHERE ID = ? AND FileName = ?`,
+		fmt.Sprintf("%d/scenes/scenes_poster_1.jpg", id), fmt.Sprintf("%d/scenes/scenes_thumbs.vtt", id), id, "scenes.mp4")
+	if err != nil {
+		return err
+	}
+
+	postersPath := fmt.Sprintf("/api/media/posters?id=%d&filename=scenes.mp4", id)
+	postersURL := reliveTestCfg.reliveServerURL + postersPath
+	var posters []util.MediaPosterResp
+	status, err := doRequest("GET", postersURL, token, nil, &posters)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK || len(posters) != 3 || !posters[0].Selected || posters[1].Selected || posters[2].URL == "" {
+		return fmt.Errorf("expected 3 candidates, the first selected, got %d %+v", status, posters)
+	}
+
+	var updated dbmodel.MediaTypeEntry
+	status, err = doRequest("PUT", reliveTestCfg.reliveServerURL+"/api/media/posters", token,
+		util.SelectPosterReq{ID: id, FileName: "scenes.mp4", Index: 2}, &updated)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK || !strings.HasSuffix(updated.Poster, "/scenes_poster_2.jpg") || updated.Thumbnails == "" {
+		return fmt.Errorf("expected candidate 2 as poster and a thumbnail track, got %d %+v", status, updated)
+	}
+	if err = expectRequest("PUT", "/api/media/posters", token,
+		util.SelectPosterReq{ID: id, FileName: "scenes.mp4", Index: 4}, http.StatusBadRequest); err != nil {
+		return err
+	}
+
+	tokenB, err := loginAs(tenantAdminB, tenantPWD)
+	if err != nil {
+		return err
+	}
+	if err = expectStatus(tokenB, postersPath, http.StatusForbidden); err != nil {
+		return err
+	}
+	if status, err = doRequest("GET", postersURL, token, nil, &posters); err != nil {
+		return err
+	}
+	if status != http.StatusOK || posters[0].Selected || !posters[1].Selected {
+		return fmt.Errorf("expected candidate 2 selected, got %d %+v", status, posters)
+	}
+	return expectRequest("DELETE", "/api/media/delete", token, dbmodel.MediaTypeEntry{ID: id, FileName: "scenes.mp4"},
+		http.StatusNoContent)
+}
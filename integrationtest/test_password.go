@@ -0,0 +1,95 @@
+package integrationtest
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/msproject/relive/notifier"
+	"github.com/msproject/relive/util"
+)
+
+// lastResetToken - latest reset token the file notifier wrote for userName
+func lastResetToken(userName string) (string, error) {
+	f, err := os.Open(reliveTestCfg.notifyFile)
+	if err != nil {
+		return "", fmt.Errorf("could not open notification file: %v", err)
+	}
+	defer f.Close()
+
+	var token string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var msg notifier.Message
+		if err = json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			return "", err
+		}
+		if msg.Type == "password_reset" && msg.UserName == userName {
+			token = msg.Token
+		}
+	}
+	if token == "" {
+		return "", fmt.Errorf("no reset token sent to %s", userName)
+	}
+	return token, scanner.Err()
+}
+
+func expectPost(token, path string, body interface{}, expected int) error {
+	return expectRequest("POST", path, token, body, expected)
+}
+
+func testChangeAndResetPassword() error {
+	const changedPWD = "changed002"
+
+	token, err := loginAs(tenantCustomerA, tenantPWD)
+	if err != nil {
+		return err
+	}
+
+	/* wrong old password, weak new password, then a valid change */
+	err = expectPost(token, "/api/accounts/change", &util.ChangePasswordReq{OldPWD: "wrong0001", NewPWD: changedPWD}, http.StatusUnauthorized)
+	if err != nil {
+		return err
+	}
+	err = expectPost(token, "/api/accounts/change", &util.ChangePasswordReq{OldPWD: tenantPWD, NewPWD: "short"}, http.StatusBadRequest)
+	if err != nil {
+		return err
+	}
+	err = expectPost(token, "/api/accounts/change", &util.ChangePasswordReq{OldPWD: tenantPWD, NewPWD: changedPWD}, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	if _, err = loginAs(tenantCustomerA, changedPWD); err != nil {
+		return err
+	}
+
+	/* forgot answers the same for unknown users */
+	err = expectPost("", "/api/accounts/forgot", &util.ForgotPasswordReq{UserName: "nosuchuser"}, http.StatusAccepted)
+	if err != nil {
+		return err
+	}
+	err = expectPost("", "/api/accounts/forgot", &util.ForgotPasswordReq{UserName: tenantCustomerA}, http.StatusAccepted)
+	if err != nil {
+		return err
+	}
+
+	resetToken, err := lastResetToken(tenantCustomerA)
+	if err != nil {
+		return err
+	}
+	err = expectPost("", "/api/accounts/reset", &util.ResetPasswordReq{Token: resetToken, NewPWD: tenantPWD}, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+
+	/* tokens are single use */
+	err = expectPost("", "/api/accounts/reset", &util.ResetPasswordReq{Token: resetToken, NewPWD: changedPWD}, http.StatusBadRequest)
+	if err != nil {
+		return err
+	}
+
+	_, err = loginAs(tenantCustomerA, tenantPWD)
+	return err
+}
@@ -0,0 +1,144 @@
+package integrationtest
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/msproject/relive/dbmodel"
+	"github.com/msproject/relive/util"
+)
+
+// getPlayback - GET a signed playback URL with the given request headers
+func getPlayback(playURL string, header map[string]string) (*http.Response, []byte, error) {
+	req, err := http.NewRequest("GET", reliveTestCfg.reliveServerURL+playURL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return resp, body, err
+}
+
+// testPlayback - ranged download of the MP4 with caching headers, a
+// playlist revalidated, and the bytes served reported as egress
+func testPlayback() error {
+	token, err := loginAs(tenantAdminA, tenantPWD)
+	if err != nil {
+		return err
+	}
+	adminA, err := lookupAccount(token, tenantAdminA)
+	if err != nil {
+		return err
+	}
+	id := int(adminA.ID)
+	if err = seedMedia(id, "downloads", "clip.mp4"); err != nil {
+		return err
+	}
+
+	/* the files transcoding would have put to the store */
+	dir := filepath.Join(reliveTestCfg.mediaDir, fmt.Sprint(id), "clip")
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	playlist := []byte("#EXTM3U\n#EXT-X-VERSION:3\n")
+	if err = ioutil.WriteFile(filepath.Join(dir, "clip.m3u8"), playlist, 0600); err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "clip_download.mp4"), bytes.Repeat([]byte{7}, 1000), 0600); err != nil {
+		return err
+	}
+	db, err := sql.Open("mysql", reliveTestCfg.mysqlAccessAddr)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.Exec(`UPDATE MediaType SET Download = ? WHERE ID = ? AND FileName = ?`,
+		fmt.Sprintf("%d/clip/clip_download.mp4", id), id, "clip.mp4")
+	if err != nil {
+		return err
+	}
+
+	found, err := searchMediaOf(token, "/api/media/search", id)
+	if err != nil {
+		return err
+	}
+	var clip *dbmodel.MediaTypeEntry
+	for i := range found {
+		if found[i].FileName == "clip.mp4" {
+			clip = &found[i]
+		}
+	}
+	if clip == nil || !strings.HasSuffix(clip.Download, "/clip_download.mp4?download=1") {
+		return fmt.Errorf("expected a download URL for the clip, got %+v", clip)
+	}
+
+	resp, body, err := getPlayback(clip.Download, map[string]string{"Range": "bytes=0-99"})
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusPartialContent || len(body) != 100 || resp.Header.Get("Content-Range") != "bytes 0-99/1000" {
+		return fmt.Errorf("expected the first 100 of 1000 bytes, got %d %q", resp.StatusCode, resp.Header.Get("Content-Range"))
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" || !strings.HasSuffix(resp.Header.Get("Cache-Control"), "immutable") ||
+		!strings.HasPrefix(resp.Header.Get("Content-Disposition"), "attachment") {
+		return fmt.Errorf("expected an immutable attachment with an ETag, got %v", resp.Header)
+	}
+	if resp, _, err = getPlayback(clip.Download, map[string]string{"If-None-Match": etag}); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusNotModified {
+		return fmt.Errorf("expected 304 revalidating the download, got %d", resp.StatusCode)
+	}
+
+	if resp, body, err = getPlayback(clip.URL, nil); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(body, playlist) || resp.Header.Get("Cache-Control") != "no-cache" {
+		return fmt.Errorf("expected the playlist revalidated by caches, got %d %v", resp.StatusCode, resp.Header)
+	}
+
+	/* counts are persisted every second */
+	served := int64(100 + len(playlist))
+	var report util.EgressResp
+	for i := 0; i < 10; i++ {
+		time.Sleep(time.Second)
+		status, err := doRequest("GET", fmt.Sprintf("%s/api/media/egress?id=%d", reliveTestCfg.reliveServerURL, id), token, nil, &report)
+		if err != nil {
+			return err
+		}
+		if status != http.StatusOK {
+			return fmt.Errorf("expected 200 from the egress report, got %d", status)
+		}
+		if report.TotalBytes >= served {
+			break
+		}
+	}
+	if report.TotalBytes != served || len(report.Days) != 1 || report.Days[0].ID != id {
+		return fmt.Errorf("expected %d bytes served to account %d, got %+v", served, id, report)
+	}
+
+	tokenB, err := loginAs(tenantAdminB, tenantPWD)
+	if err != nil {
+		return err
+	}
+	if err = expectStatus(tokenB, fmt.Sprintf("/api/media/egress?id=%d", id), http.StatusForbidden); err != nil {
+		return err
+	}
+	return expectRequest("DELETE", "/api/media/delete", token, dbmodel.MediaTypeEntry{ID: id, FileName: "clip.mp4"},
+		http.StatusNoContent)
+}
@@ -0,0 +1,337 @@
+package integrationtest
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/msproject/relive/dbmodel"
+	"github.com/msproject/relive/money"
+	"github.com/msproject/relive/util"
+)
+
+// testProductPrices - the schema is at the latest migration and the default
+// products list their US dollar prices exactly, alone when listed in
+// another currency
+func testProductPrices() error {
+	db, err := sql.Open("mysql", reliveTestCfg.mysqlAccessAddr)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var version int
+	if err = db.QueryRow(`SELECT MAX(Version) FROM schema_migrations`).Scan(&version); err != nil {
+		return err
+	}
+	if latest := dbmodel.Migrations[len(dbmodel.Migrations)-1].Version; version != latest {
+		return fmt.Errorf("expected the schema migrated to %d, got %d", latest, version)
+	}
+
+	token, err := loginAs("root", "video@Cloud")
+	if err != nil {
+		return err
+	}
+
+	listURL := reliveTestCfg.reliveServerURL + "/api/products/list"
+	var products []util.ProductResp
+	status, err := doRequest("GET", listURL, token, nil, &products)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("expected 200 listing products, got %d", status)
+	}
+
+	var gold *util.ProductResp
+	for i := range products {
+		if products[i].ProductID == 1003 {
+			gold = &products[i]
+		}
+	}
+	if gold == nil || len(gold.Prices) != 1 {
+		return fmt.Errorf("expected gold with one price, got %+v", products)
+	}
+	price := gold.Prices[0]
+	if price.Price.Minor != 30000 || price.Price.Currency != "USD" || price.Gross != price.Price || price.Tax.Minor != 0 {
+		return fmt.Errorf("expected gold at 300.00 USD untaxed, got %+v", price)
+	}
+
+	products = nil
+	if status, err = doRequest("GET", listURL+"?currency=EUR", token, nil, &products); err != nil {
+		return err
+	}
+	if status != http.StatusOK || len(products) != 0 {
+		return fmt.Errorf("expected no product priced in EUR, got %d %+v", status, products)
+	}
+
+	if status, err = doRequest("GET", listURL+"?currency=usd", token, nil, nil); err != nil {
+		return err
+	}
+	if status != http.StatusBadRequest {
+		return fmt.Errorf("expected 400 listing in an unknown currency, got %d", status)
+	}
+	return nil
+}
+
+// testProductCatalog - root manages products, their features hold for the
+// businesses subscribed to them, and an archived product leaves the list
+// but not its subscriptions
+func testProductCatalog() error {
+	rootToken, err := loginAs("root", "video@Cloud")
+	if err != nil {
+		return err
+	}
+	adminToken, err := loginAs(tenantAdminA, tenantPWD)
+	if err != nil {
+		return err
+	}
+
+	usd, err := money.Parse("49.99", "USD")
+	if err != nil {
+		return err
+	}
+	platinum := util.CreateProductReq{
+		ProductID:    2001,
+		ProductType:  "platinum",
+		StoreSize:    500,
+		Duration:     30,
+		MaxAdmins:    2,
+		MaxCustomers: 1,
+		Renditions:   "720p,audio",
+		Prices: []util.ProductPrice{
+			{Price: usd, TaxRate: 2000},
+			{Price: money.Money{Minor: 4500, Currency: "EUR"}, TaxInclusive: true, TaxRate: 2000},
+		},
+	}
+
+	if err = expectRequest("POST", "/api/products", adminToken, platinum, http.StatusForbidden); err != nil {
+		return err
+	}
+	bogus := platinum
+	bogus.Renditions = "4k"
+	if err = expectRequest("POST", "/api/products", rootToken, bogus, http.StatusBadRequest); err != nil {
+		return err
+	}
+	if err = expectRequest("POST", "/api/products", rootToken, platinum, http.StatusCreated); err != nil {
+		return err
+	}
+	if err = expectRequest("POST", "/api/products", rootToken, platinum, http.StatusConflict); err != nil {
+		return err
+	}
+
+	var products []util.ProductResp
+	listURL := reliveTestCfg.reliveServerURL + "/api/products/list"
+	if _, err = doRequest("GET", listURL+"?currency=EUR", adminToken, nil, &products); err != nil {
+		return err
+	}
+	if len(products) != 1 || len(products[0].Prices) != 1 {
+		return fmt.Errorf("expected platinum alone priced in EUR, got %+v", products)
+	}
+	if eur := products[0].Prices[0]; eur.Net.Minor != 3750 || eur.Tax.Minor != 750 || eur.Gross.Minor != 4500 {
+		return fmt.Errorf("expected 45.00 EUR to include 7.50 of tax, got %+v", eur)
+	}
+
+	platinum.StoreSize = 600
+	if err = expectRequest("PUT", "/api/products/2001", rootToken, platinum, http.StatusOK); err != nil {
+		return err
+	}
+
+	/* a business of its own, its customers are counted */
+	const business = "catalogbiz"
+	bizToken, bizID, err := newBusiness(rootToken, business)
+	if err != nil {
+		return err
+	}
+
+	db, err := sql.Open("mysql", reliveTestCfg.mysqlAccessAddr)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err = addPayment(db, bizID); err != nil {
+		return err
+	}
+
+	sub := util.CreateSubscriptionReq{ID: uint32(bizID), ProductID: 2001, NumberOfAdmins: 3}
+	if err = expectRequest("POST", "/api/subscription/create", bizToken, sub, http.StatusBadRequest); err != nil {
+		return err
+	}
+	sub.NumberOfAdmins = 1
+	if err = expectRequest("POST", "/api/subscription/create", bizToken, sub, http.StatusCreated); err != nil {
+		return err
+	}
+
+	for i, expected := range []int{http.StatusNoContent, http.StatusForbidden} {
+		customer := fmt.Sprintf("%scust%d", business, i)
+		err = expectRequest("POST", "/api/accounts/create", bizToken, util.CreateAccountReq{
+			UserName:  customer,
+			Email:     customer + "@relive.com",
+			FirstName: "tenant",
+			LastName:  customer,
+			PWD:       tenantPWD,
+			CompanyID: uint32(bizID),
+			Role:      dbmodel.RoleCustomer,
+		}, expected)
+		if err != nil {
+			return err
+		}
+	}
+
+	/* the business keeps what it subscribed to, a changed product is for new subscriptions */
+	lowered := platinum
+	lowered.StoreSize, lowered.MaxCustomers = 100, 5
+	if err = expectRequest("PUT", "/api/products/2001", rootToken, lowered, http.StatusOK); err != nil {
+		return err
+	}
+	if usage, err := storageUsage(bizToken, bizID); err != nil || usage.QuotaBytes != 600*quotaUnit {
+		return fmt.Errorf("expected the quota of platinum as subscribed, got %+v %v", usage, err)
+	}
+	err = expectRequest("POST", "/api/accounts/create", bizToken, util.CreateAccountReq{
+		UserName:  business + "cust2",
+		Email:     business + "cust2@relive.com",
+		FirstName: "tenant",
+		LastName:  business + "cust2",
+		PWD:       tenantPWD,
+		CompanyID: uint32(bizID),
+		Role:      dbmodel.RoleCustomer,
+	}, http.StatusForbidden)
+	if err != nil {
+		return err
+	}
+
+	if err = expectRequest("POST", "/api/products/2001/archive", adminToken, nil, http.StatusForbidden); err != nil {
+		return err
+	}
+	if err = expectRequest("POST", "/api/products/2001/archive", rootToken, nil, http.StatusNoContent); err != nil {
+		return err
+	}
+
+	products = nil
+	if _, err = doRequest("GET", listURL, adminToken, nil, &products); err != nil {
+		return err
+	}
+	for _, p := range products {
+		if p.ProductID == 2001 {
+			return fmt.Errorf("expected archived platinum off the list, got %+v", p)
+		}
+	}
+	if err = expectStatus(adminToken, "/api/products/list?archived=1", http.StatusForbidden); err != nil {
+		return err
+	}
+	products = nil
+	if _, err = doRequest("GET", listURL+"?archived=1", rootToken, nil, &products); err != nil {
+		return err
+	}
+	if len(products) == 0 || products[len(products)-1].ProductID != 2001 || products[len(products)-1].ArchivedAt == nil {
+		return fmt.Errorf("expected root to list archived platinum, got %+v", products)
+	}
+
+	var kept util.ProductResp
+	status, err := doRequest("GET", reliveTestCfg.reliveServerURL+"/api/products/2001", bizToken, nil, &kept)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK || kept.StoreSize != 100 || kept.ArchivedAt == nil {
+		return fmt.Errorf("expected the subscribed business to still see platinum, got %d %+v", status, kept)
+	}
+
+	/* the running subscription stays, no new one is taken */
+	return expectRequest("POST", "/api/subscription/create", bizToken, sub, http.StatusConflict)
+}
+
+// testMediaRetention - media of a business and its customers are deleted,
+// files and all, once older than its subscription retains them
+func testMediaRetention() error {
+	const business, customer = "retainbiz", "retaincust"
+
+	db, err := sql.Open("mysql", reliveTestCfg.mysqlAccessAddr)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rootToken, err := loginAs("root", "video@Cloud")
+	if err != nil {
+		return err
+	}
+	archive := util.CreateProductReq{
+		ProductID:     2004,
+		ProductType:   "archive",
+		StoreSize:     50,
+		Duration:      30,
+		Renditions:    "480p,audio",
+		RetentionDays: 30,
+		Prices:        []util.ProductPrice{{Price: money.Money{Minor: 500, Currency: "USD"}}},
+	}
+	if err = expectRequest("POST", "/api/products", rootToken, archive, http.StatusCreated); err != nil {
+		return err
+	}
+
+	_, id, err := newBusiness(rootToken, business)
+	if err != nil {
+		return err
+	}
+	sub := util.CreateSubscriptionReq{ID: uint32(id), ProductID: 2004, NumberOfAdmins: 1}
+	if err = expectRequest("POST", "/api/subscription/create", rootToken, sub, http.StatusCreated); err != nil {
+		return err
+	}
+	token, _, err := createCustomersOf(business, customer)
+	if err != nil {
+		return err
+	}
+	cust, err := lookupAccount(token, customer)
+	if err != nil {
+		return err
+	}
+
+	/* the files transcoding would have put to the store */
+	media := []struct {
+		id   int
+		name string
+		days int
+	}{{id, "fresh", 29}, {id, "stale", 31}, {int(cust.ID), "custstale", 31}}
+	for _, m := range media {
+		if err = seedMedia(m.id, "retained", m.name+".mp4"); err != nil {
+			return err
+		}
+		_, err = db.Exec(`UPDATE MediaType SET CreatedAt = ? WHERE ID = ? AND FileName = ?`,
+			time.Now().UTC().AddDate(0, 0, -m.days), m.id, m.name+".mp4")
+		if err != nil {
+			return err
+		}
+		dir := filepath.Join(reliveTestCfg.mediaDir, fmt.Sprint(m.id), m.name)
+		if err = os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+		if err = ioutil.WriteFile(filepath.Join(dir, m.name+".m3u8"), []byte("#EXTM3U\n"), 0600); err != nil {
+			return err
+		}
+	}
+
+	/* relive runs with -retentioncheck of a second */
+	var count int
+	for deadline := time.Now().Add(subscriptionWait); time.Now().Before(deadline); time.Sleep(200 * time.Millisecond) {
+		err = db.QueryRow(`SELECT COUNT(*) FROM MediaType WHERE Catalog = 'retained'`).Scan(&count)
+		if err != nil || count == 1 {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return fmt.Errorf("expected the media past 30 days deleted, %d left", count)
+	}
+	for _, m := range media {
+		_, err = os.Stat(filepath.Join(reliveTestCfg.mediaDir, fmt.Sprint(m.id), m.name))
+		if kept := err == nil; kept != (m.name == "fresh") {
+			return fmt.Errorf("expected only the files of fresh kept, %s: %v", m.name, err)
+		}
+	}
+	return nil
+}
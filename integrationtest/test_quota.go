@@ -0,0 +1,132 @@
+package integrationtest
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/msproject/relive/util"
+)
+
+/* relive runs with -quotaunit of a MiB and -freequota of 10 units */
+const quotaUnit = 1 << 20
+
+// tusCreate - start an upload of length bytes for account id
+func tusCreate(token string, id int, fileName string, length int) (*http.Response, error) {
+	meta := []string{}
+	for k, v := range map[string]string{"filename": fileName, "id": strconv.Itoa(id), "catalog": "tests", "title": fileName} {
+		meta = append(meta, k+" "+base64.StdEncoding.EncodeToString([]byte(v)))
+	}
+	return tusRequest("POST", reliveTestCfg.reliveServerURL+"/api/media/uploads", token, nil, map[string]string{
+		"Upload-Length":   strconv.Itoa(length),
+		"Upload-Metadata": strings.Join(meta, ","),
+	})
+}
+
+func storageUsage(token string, id int) (util.StorageUsageResp, error) {
+	var usage util.StorageUsageResp
+	status, err := doRequest("GET", fmt.Sprintf("%s/api/media/usage?id=%d", reliveTestCfg.reliveServerURL, id), token, nil, &usage)
+	if err == nil && status != http.StatusOK {
+		err = fmt.Errorf("expected 200 from /api/media/usage, got %d", status)
+	}
+	return usage, err
+}
+
+// subscribe - add a running subscription of account id to a product
+func subscribe(id, productID int, productType string) error {
+	db, err := sql.Open("mysql", reliveTestCfg.mysqlAccessAddr)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	now := time.Now().UTC()
+	_, err = db.Exec(`INSERT INTO Subscription (ID, ProductID, ProductType, StoreLocation, StartDate, EndDate, NumberOfAdmins)
+	        VALUES (?, ?, ?, ?, ?, ?, ?)`, id, productID, productType, "", now, now.AddDate(0, 0, 30), 1)
+	return err
+}
+
+// testStorageQuota - uploads of business B are checked against its free
+// quota when they are created, warned about past the soft limit and fit
+// once B subscribes to a larger product
+func testStorageQuota() error {
+	token, err := loginAs(tenantAdminB, tenantPWD)
+	if err != nil {
+		return err
+	}
+	adminB, err := lookupAccount(token, tenantAdminB)
+	if err != nil {
+		return err
+	}
+	id := int(adminB.ID)
+
+	usage, err := storageUsage(token, id)
+	if err != nil {
+		return err
+	}
+	if usage.QuotaBytes != 10*quotaUnit || usage.PendingBytes != 0 || usage.State != "ok" || usage.Policy != "block" {
+		return fmt.Errorf("expected an unused free quota of 10 MiB, got %+v", usage)
+	}
+
+	resp, err := tusCreate(token, id, "toolarge.mp4", 20*quotaUnit)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		return fmt.Errorf("expected 413 creating an upload past the quota, got %d", resp.StatusCode)
+	}
+
+	resp, err = tusCreate(token, id, "nearfull.mp4", 9*quotaUnit)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusCreated || resp.Header.Get("X-Storage-Warning") == "" {
+		return fmt.Errorf("expected 201 with a storage warning past the soft limit, got %d", resp.StatusCode)
+	}
+	uploads := []string{resp.Header.Get("Location")}
+
+	if usage, err = storageUsage(token, id); err != nil {
+		return err
+	}
+	if usage.PendingBytes != 9*quotaUnit || usage.State != "soft" {
+		return fmt.Errorf("expected 9 MiB pending past the soft limit, got %+v", usage)
+	}
+	if resp, err = tusCreate(token, id, "overflow.mp4", 2*quotaUnit); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		return fmt.Errorf("expected 413 for an upload not fitting next to the pending one, got %d", resp.StatusCode)
+	}
+
+	/* the silver product stores 200 units */
+	if err = subscribe(id, 1002, "silver"); err != nil {
+		return err
+	}
+	if resp, err = tusCreate(token, id, "overflow.mp4", 2*quotaUnit); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusCreated || resp.Header.Get("X-Storage-Warning") != "" {
+		return fmt.Errorf("expected 201 without warning once subscribed, got %d", resp.StatusCode)
+	}
+	uploads = append(uploads, resp.Header.Get("Location"))
+
+	for _, location := range uploads {
+		if resp, err = tusRequest("DELETE", reliveTestCfg.reliveServerURL+location, token, nil, nil); err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusNoContent {
+			return fmt.Errorf("expected 204 terminating %s, got %d", location, resp.StatusCode)
+		}
+	}
+	if usage, err = storageUsage(token, id); err != nil {
+		return err
+	}
+	if usage.QuotaBytes != 200*quotaUnit || usage.PendingBytes != 0 {
+		return fmt.Errorf("expected nothing pending of 200 MiB, got %+v", usage)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package integrationtest
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/msproject/relive/dbmodel"
+)
+
+// testRouting - query parameter order does not matter, a known path with
+// the wrong method is a 405 with Allow and an unknown path a 404
+func testRouting() error {
+	rootToken, err := loginAs("root", "video@Cloud")
+	if err != nil {
+		return err
+	}
+	adminA, err := lookupAccount(rootToken, tenantAdminA)
+	if err != nil {
+		return err
+	}
+
+	base := reliveTestCfg.reliveServerURL
+	for _, u := range []string{
+		fmt.Sprintf("/api/accounts/search?id=%d&role=%d", adminA.ID, dbmodel.RoleCustomer),
+		fmt.Sprintf("/api/accounts/search?role=%d&id=%d", dbmodel.RoleCustomer, adminA.ID),
+	} {
+		if err = expectStatus(rootToken, u, http.StatusOK); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest("DELETE", base+"/api/accounts/search", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+rootToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != "GET" {
+		return fmt.Errorf("expected 405 with Allow GET, got %d Allow %q", resp.StatusCode, resp.Header.Get("Allow"))
+	}
+
+	return expectStatus(rootToken, "/api/accounts/nosuchendpoint", http.StatusNotFound)
+}
+
+// testPlaintextRedirect - plaintext answers /health itself and sends
+// everything else to the same URL over HTTPS with a 308
+func testPlaintextRedirect() error {
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Get(reliveTestCfg.reliveHTTPURL + "/health")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("expected 204 for plaintext /health, got %d", resp.StatusCode)
+	}
+
+	resp, err = client.Post(reliveTestCfg.reliveHTTPURL+"/api/accounts/login?x=1", "application/json", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	expected := reliveTestCfg.reliveServerURL + "/api/accounts/login?x=1"
+	if resp.StatusCode != http.StatusPermanentRedirect || resp.Header.Get("Location") != expected {
+		return fmt.Errorf("expected 308 to %s, got %d to %q", expected, resp.StatusCode, resp.Header.Get("Location"))
+	}
+	return nil
+}
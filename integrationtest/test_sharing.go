@@ -0,0 +1,312 @@
+package integrationtest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/msproject/relive/dbmodel"
+	"github.com/msproject/relive/util"
+)
+
+// newBusiness - a business of its own, logged in
+func newBusiness(rootToken, business string) (string, int, error) {
+	err := createTestAccount(rootToken, &util.CreateAccountReq{
+		UserName:    business,
+		Email:       business + "@relive.com",
+		FirstName:   "tenant",
+		LastName:    business,
+		CompanyName: business,
+		PWD:         tenantPWD,
+		Role:        dbmodel.RoleAdmin,
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	token, err := loginAs(business, tenantPWD)
+	if err != nil {
+		return "", 0, err
+	}
+	acct, err := lookupAccount(rootToken, business)
+	return token, int(acct.ID), err
+}
+
+// createCustomersOf - log in as business and add customers below it
+func createCustomersOf(business string, customers ...string) (string, int, error) {
+	token, err := loginAs(business, tenantPWD)
+	if err != nil {
+		return "", 0, err
+	}
+	acct, err := lookupAccount(token, business)
+	if err != nil {
+		return "", 0, err
+	}
+
+	for _, userName := range customers {
+		err = createTestAccount(token, &util.CreateAccountReq{
+			UserName:  userName,
+			Email:     userName + "@relive.com",
+			FirstName: "tenant",
+			LastName:  userName,
+			PWD:       tenantPWD,
+			CompanyID: acct.ID,
+			Role:      dbmodel.RoleCustomer,
+		})
+		if err != nil {
+			return "", 0, err
+		}
+	}
+	return token, int(acct.ID), nil
+}
+
+func expectRequest(method, path, token string, body interface{}, expected int) error {
+	status, err := doRequest(method, reliveTestCfg.reliveServerURL+path, token, body, nil)
+	if err != nil {
+		return err
+	}
+	if status != expected {
+		return fmt.Errorf("expected %d for %s %s, got %d", expected, method, path, status)
+	}
+	return nil
+}
+
+// testMediaSharing - a business shares a media with its customers as
+// editor and viewer, and the customer it made owner keeps the media when
+// the business is deleted. Media nobody else owns go, files and all.
+func testMediaSharing() error {
+	const business, editor, viewer = "sharebiz", "sharecust1", "sharecust2"
+
+	rootToken, err := loginAs("root", "video@Cloud")
+	if err != nil {
+		return err
+	}
+	if _, _, err = newBusiness(rootToken, business); err != nil {
+		return err
+	}
+	token, id, err := createCustomersOf(business, editor, viewer)
+	if err != nil {
+		return err
+	}
+	/* the files transcoding would have put to the store */
+	for _, name := range []string{"reel", "outtake"} {
+		if err = seedMedia(id, "reels", name+".mp4"); err != nil {
+			return err
+		}
+		dir := filepath.Join(reliveTestCfg.mediaDir, fmt.Sprint(id), name)
+		if err = os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+		if err = ioutil.WriteFile(filepath.Join(dir, name+".m3u8"), []byte("#EXTM3U\n"), 0600); err != nil {
+			return err
+		}
+	}
+
+	editorAcct, err := lookupAccount(token, editor)
+	if err != nil {
+		return err
+	}
+	viewerAcct, err := lookupAccount(token, viewer)
+	if err != nil {
+		return err
+	}
+	editorToken, err := loginAs(editor, tenantPWD)
+	if err != nil {
+		return err
+	}
+	viewerToken, err := loginAs(viewer, tenantPWD)
+	if err != nil {
+		return err
+	}
+
+	title := "Our reel"
+	update := util.UpdateMediaReq{ID: id, FileName: "reel.mp4", Title: &title}
+	if err = expectRequest("PUT", "/api/media/update", editorToken, update, http.StatusForbidden); err != nil {
+		return err
+	}
+
+	/* another business can neither share the media nor share with these customers */
+	tokenB, err := loginAs(tenantAdminB, tenantPWD)
+	if err != nil {
+		return err
+	}
+	grant := util.MediaGrantReq{ID: id, FileName: "reel.mp4", AccountID: int(editorAcct.ID), Role: dbmodel.GrantEditor}
+	if err = expectRequest("PUT", "/api/media/grants", tokenB, grant, http.StatusForbidden); err != nil {
+		return err
+	}
+	if err = expectRequest("PUT", "/api/media/grants", token, grant, http.StatusOK); err != nil {
+		return err
+	}
+	grant = util.MediaGrantReq{ID: id, FileName: "reel.mp4", AccountID: int(viewerAcct.ID), Role: dbmodel.GrantViewer}
+	if err = expectRequest("PUT", "/api/media/grants", token, grant, http.StatusOK); err != nil {
+		return err
+	}
+
+	var grants []dbmodel.MediaGrantEntry
+	status, err := doRequest("GET", fmt.Sprintf("%s/api/media/grants?id=%d&filename=reel.mp4", reliveTestCfg.reliveServerURL, id),
+		token, nil, &grants)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK || len(grants) != 2 {
+		return fmt.Errorf("expected 2 grants on the reel, got %d %+v", status, grants)
+	}
+
+	shared, err := searchMediaOf(viewerToken, "/api/media/shared", 0)
+	if err != nil {
+		return err
+	}
+	if len(shared) != 1 || shared[0].FileName != "reel.mp4" || shared[0].URL == "" {
+		return fmt.Errorf("expected the reel shared with %s, got %+v", viewer, shared)
+	}
+	if err = expectRequest("PUT", "/api/media/update", editorToken, update, http.StatusOK); err != nil {
+		return err
+	}
+	if err = expectRequest("PUT", "/api/media/update", viewerToken, update, http.StatusForbidden); err != nil {
+		return err
+	}
+	ref := dbmodel.MediaTypeEntry{ID: id, FileName: "reel.mp4"}
+	if err = expectRequest("POST", "/api/media/trash", editorToken, ref, http.StatusForbidden); err != nil {
+		return err
+	}
+
+	/* the viewer gives up its grant */
+	if err = expectRequest("DELETE", "/api/media/grants", viewerToken, grant, http.StatusNoContent); err != nil {
+		return err
+	}
+	if shared, err = searchMediaOf(viewerToken, "/api/media/shared", 0); err != nil {
+		return err
+	}
+	if len(shared) != 0 {
+		return fmt.Errorf("expected nothing shared with %s after revoking, got %+v", viewer, shared)
+	}
+
+	/* the owner takes the media over when the business goes */
+	grant = util.MediaGrantReq{ID: id, FileName: "reel.mp4", AccountID: int(editorAcct.ID), Role: dbmodel.GrantOwner}
+	if err = expectRequest("PUT", "/api/media/grants", token, grant, http.StatusOK); err != nil {
+		return err
+	}
+	if err = expectRequest("DELETE", "/api/accounts/delete", rootToken, util.CreateAccountReq{UserName: business},
+		http.StatusNoContent); err != nil {
+		return err
+	}
+
+	held, err := searchMediaOf(editorToken, "/api/media/search", int(editorAcct.ID))
+	if err != nil {
+		return err
+	}
+	if len(held) != 1 || held[0].FileName != "reel.mp4" || held[0].Title != title {
+		return fmt.Errorf("expected %s to hold the reel, got %+v", editor, held)
+	}
+	if _, err = os.Stat(filepath.Join(reliveTestCfg.mediaDir, fmt.Sprint(id), "reel", "reel.m3u8")); err != nil {
+		return fmt.Errorf("expected the reel taken over left in the store: %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(reliveTestCfg.mediaDir, fmt.Sprint(id), "outtake")); !os.IsNotExist(err) {
+		return fmt.Errorf("expected the outtake deleted with %s from the store, got %v", business, err)
+	}
+	for _, userName := range []string{editor, viewer} {
+		acct, err := lookupAccount(rootToken, userName)
+		if err != nil {
+			return err
+		}
+		if acct.CompanyID != 0 {
+			return fmt.Errorf("expected %s detached from the deleted business, got %d", userName, acct.CompanyID)
+		}
+	}
+	return nil
+}
+
+// testAccountTransfer - a customer moves from business A to business B
+// only once it accepts, and declining leaves it where it is
+func testAccountTransfer() error {
+	const customer = "movecust"
+
+	tokenA, idA, err := createCustomersOf(tenantAdminA, customer)
+	if err != nil {
+		return err
+	}
+	tokenB, err := loginAs(tenantAdminB, tenantPWD)
+	if err != nil {
+		return err
+	}
+	adminB, err := lookupAccount(tokenB, tenantAdminB)
+	if err != nil {
+		return err
+	}
+	cust, err := lookupAccount(tokenA, customer)
+	if err != nil {
+		return err
+	}
+	custToken, err := loginAs(customer, tenantPWD)
+	if err != nil {
+		return err
+	}
+
+	var transfer dbmodel.AccountTransferEntry
+	req := util.AccountTransferReq{CustomerID: int(cust.ID), ToPID: int(adminB.ID)}
+	status, err := doRequest("POST", reliveTestCfg.reliveServerURL+"/api/accounts/transfers", tokenB, req, &transfer)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusCreated || transfer.Status != dbmodel.TransferPending || transfer.FromPID != idA {
+		return fmt.Errorf("expected a pending transfer from %d, got %d %+v", idA, status, transfer)
+	}
+	if err = expectRequest("POST", "/api/accounts/transfers", tokenA, req, http.StatusConflict); err != nil {
+		return err
+	}
+
+	accept := fmt.Sprintf("/api/accounts/transfers/%d/accept", transfer.TransferID)
+	if err = expectRequest("POST", accept, tokenB, nil, http.StatusForbidden); err != nil {
+		return err
+	}
+	/* nothing moved before the customer consents */
+	customerSearch := "/api/accounts/search?user=" + customer
+	if err = expectStatus(tokenB, customerSearch, http.StatusForbidden); err != nil {
+		return err
+	}
+
+	var transfers []dbmodel.AccountTransferEntry
+	status, err = doRequest("GET", reliveTestCfg.reliveServerURL+"/api/accounts/transfers", custToken, nil, &transfers)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK || len(transfers) != 1 || transfers[0].TransferID != transfer.TransferID {
+		return fmt.Errorf("expected the customer to see transfer %d, got %d %+v", transfer.TransferID, status, transfers)
+	}
+
+	if err = expectRequest("POST", accept, custToken, nil, http.StatusNoContent); err != nil {
+		return err
+	}
+	if err = expectStatus(tokenB, customerSearch, http.StatusOK); err != nil {
+		return err
+	}
+	if err = expectStatus(tokenA, customerSearch, http.StatusForbidden); err != nil {
+		return err
+	}
+	/* the sessions carrying the old business are gone */
+	if err = expectRequest("POST", accept, custToken, nil, http.StatusUnauthorized); err != nil {
+		return err
+	}
+	if custToken, err = loginAs(customer, tenantPWD); err != nil {
+		return err
+	}
+	if err = expectRequest("POST", accept, custToken, nil, http.StatusConflict); err != nil {
+		return err
+	}
+
+	/* business A asks to get the customer back, who declines */
+	req.ToPID = idA
+	status, err = doRequest("POST", reliveTestCfg.reliveServerURL+"/api/accounts/transfers", tokenA, req, &transfer)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusCreated {
+		return fmt.Errorf("expected 201 asking the customer back, got %d", status)
+	}
+	decline := fmt.Sprintf("/api/accounts/transfers/%d/decline", transfer.TransferID)
+	if err = expectRequest("POST", decline, custToken, nil, http.StatusNoContent); err != nil {
+		return err
+	}
+	return expectStatus(tokenB, customerSearch, http.StatusOK)
+}
@@ -0,0 +1,209 @@
+package integrationtest
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/msproject/relive/dbmodel"
+	"github.com/msproject/relive/money"
+	"github.com/msproject/relive/util"
+)
+
+/* relive runs with -subscriptioncheck of a second */
+const subscriptionWait = 10 * time.Second
+
+// addPayment - a card on file for account id
+func addPayment(db *sql.DB, id int) error {
+	_, err := db.Exec(`INSERT INTO Payment (ID, CCNumber, BillingAddress, CCExpiry, CVVCode) VALUES (?, ?, ?, ?, ?)`,
+		id, "4111111111111111", "1 Main St", "12/30", 123)
+	return err
+}
+
+// waitSubscription - the subscription once the scheduler moved it to state
+func waitSubscription(token string, code int, state string) (util.SubscrDetails, error) {
+	searchURL := fmt.Sprintf("%s/api/subscription/search?code=%d", reliveTestCfg.reliveServerURL, code)
+	var subs []util.SubscrDetails
+	for deadline := time.Now().Add(subscriptionWait); time.Now().Before(deadline); time.Sleep(200 * time.Millisecond) {
+		subs = nil
+		status, err := doRequest("GET", searchURL, token, nil, &subs)
+		if err != nil {
+			return util.SubscrDetails{}, err
+		}
+		if status == http.StatusOK && len(subs) == 1 && subs[0].State == state {
+			return subs[0], nil
+		}
+	}
+	return util.SubscrDetails{}, fmt.Errorf("expected subscription %d %s, got %+v", code, state, subs)
+}
+
+// testSubscriptionLifecycle - a business subscribes with a card on file,
+// its first subscription starting in the trial of its product. Unpaid it
+// goes past due, expires after its grace period and leaves its business
+// read-only; paid it renews. Canceled it runs to its end. Only root
+// deletes a subscription, which gives no second trial.
+func testSubscriptionLifecycle() error {
+	db, err := sql.Open("mysql", reliveTestCfg.mysqlAccessAddr)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rootToken, err := loginAs("root", "video@Cloud")
+	if err != nil {
+		return err
+	}
+
+	starter := util.CreateProductReq{
+		ProductID:   2002,
+		ProductType: "starter",
+		StoreSize:   50,
+		Duration:    30,
+		TrialDays:   14,
+		Prices:      []util.ProductPrice{{Price: money.Money{Minor: 900, Currency: "USD"}}},
+	}
+	if err = expectRequest("POST", "/api/products", rootToken, starter, http.StatusCreated); err != nil {
+		return err
+	}
+
+	lapseToken, lapseID, err := newBusiness(rootToken, "lapsebiz")
+	if err != nil {
+		return err
+	}
+
+	sub := util.CreateSubscriptionReq{ID: uint32(lapseID), ProductID: 2002, NumberOfAdmins: 1, StartDate: "2020-01-01"}
+	if err = expectRequest("POST", "/api/subscription/create", lapseToken, sub, http.StatusForbidden); err != nil {
+		return err
+	}
+	sub.StartDate, sub.EndDate = "", "2030-01-01 00:00:00"
+	if err = expectRequest("POST", "/api/subscription/create", lapseToken, sub, http.StatusBadRequest); err != nil {
+		return err
+	}
+	sub.EndDate = ""
+	if err = expectRequest("POST", "/api/subscription/create", lapseToken, sub, http.StatusPaymentRequired); err != nil {
+		return err
+	}
+	if err = addPayment(db, lapseID); err != nil {
+		return err
+	}
+
+	var trial util.SubscrDetails
+	status, err := doRequest("POST", reliveTestCfg.reliveServerURL+"/api/subscription/create", lapseToken, sub, &trial)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusCreated || trial.State != dbmodel.SubscriptionTrialing || trial.EndDate.Sub(trial.StartDate) != 14*24*time.Hour {
+		return fmt.Errorf("expected 201 with a 14 day trial, got %d %+v", status, trial)
+	}
+
+	/* the card goes and the trial ends unpaid: past due for the grace period, then expired */
+	if _, err = db.Exec(`DELETE FROM Payment WHERE ID = ?`, lapseID); err != nil {
+		return err
+	}
+	ago := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	if _, err = db.Exec(`UPDATE Subscription SET EndDate = ? WHERE SubscriptionCode = ?`, ago, trial.SubscrCode); err != nil {
+		return err
+	}
+	pastDue, err := waitSubscription(lapseToken, trial.SubscrCode, dbmodel.SubscriptionPastDue)
+	if err != nil {
+		return err
+	}
+	if pastDue.GraceUntil == nil || !pastDue.GraceUntil.After(time.Now()) {
+		return fmt.Errorf("expected a grace period ahead, got %+v", pastDue)
+	}
+	if usage, err := storageUsage(lapseToken, lapseID); err != nil || usage.ReadOnly || usage.QuotaBytes != 50*quotaUnit {
+		return fmt.Errorf("expected the quota of starter through the grace period, got %+v %v", usage, err)
+	}
+
+	if _, err = db.Exec(`UPDATE Subscription SET GraceUntil = ? WHERE SubscriptionCode = ?`, ago, trial.SubscrCode); err != nil {
+		return err
+	}
+	if _, err = waitSubscription(lapseToken, trial.SubscrCode, dbmodel.SubscriptionExpired); err != nil {
+		return err
+	}
+	if usage, err := storageUsage(lapseToken, lapseID); err != nil || !usage.ReadOnly {
+		return fmt.Errorf("expected an expired business read-only, got %+v %v", usage, err)
+	}
+	resp, err := tusCreate(lapseToken, lapseID, "expired.mp4", quotaUnit)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusPaymentRequired {
+		return fmt.Errorf("expected 402 uploading once expired, got %d", resp.StatusCode)
+	}
+
+	/* deleting the expired subscription neither lifts read-only nor gives a second trial */
+	expired := util.CreateSubscriptionReq{SubscriptionCode: uint32(trial.SubscrCode)}
+	if err = expectRequest("DELETE", "/api/subscription/delete", lapseToken, expired, http.StatusForbidden); err != nil {
+		return err
+	}
+	if usage, err := storageUsage(lapseToken, lapseID); err != nil || !usage.ReadOnly {
+		return fmt.Errorf("expected lapsebiz still read-only, got %+v %v", usage, err)
+	}
+	if err = expectRequest("DELETE", "/api/subscription/delete", rootToken, expired, http.StatusNoContent); err != nil {
+		return err
+	}
+	if err = addPayment(db, lapseID); err != nil {
+		return err
+	}
+	var again util.SubscrDetails
+	if _, err = doRequest("POST", reliveTestCfg.reliveServerURL+"/api/subscription/create", lapseToken, sub, &again); err != nil {
+		return err
+	}
+	if again.State != dbmodel.SubscriptionActive {
+		return fmt.Errorf("expected no second trial after the first was deleted, got %+v", again)
+	}
+
+	/* a business paying renews, no second trial */
+	payToken, payID, err := newBusiness(rootToken, "paybiz")
+	if err != nil {
+		return err
+	}
+	if err = addPayment(db, payID); err != nil {
+		return err
+	}
+	sub.ID = uint32(payID)
+	var paid util.SubscrDetails
+	if _, err = doRequest("POST", reliveTestCfg.reliveServerURL+"/api/subscription/create", payToken, sub, &paid); err != nil {
+		return err
+	}
+	if _, err = db.Exec(`UPDATE Subscription SET EndDate = ? WHERE SubscriptionCode = ?`, ago, paid.SubscrCode); err != nil {
+		return err
+	}
+	renewed, err := waitSubscription(payToken, paid.SubscrCode, dbmodel.SubscriptionActive)
+	if err != nil {
+		return err
+	}
+	if !renewed.StartDate.Equal(ago) || renewed.EndDate.Sub(renewed.StartDate) != 30*24*time.Hour {
+		return fmt.Errorf("expected a 30 day period following the trial, got %+v", renewed)
+	}
+
+	var second util.SubscrDetails
+	if _, err = doRequest("POST", reliveTestCfg.reliveServerURL+"/api/subscription/create", payToken, sub, &second); err != nil {
+		return err
+	}
+	if second.State != dbmodel.SubscriptionActive {
+		return fmt.Errorf("expected no second trial, got %+v", second)
+	}
+
+	cancel := util.CreateSubscriptionReq{SubscriptionCode: uint32(paid.SubscrCode)}
+	if err = expectRequest("POST", "/api/subscription/cancel", lapseToken, cancel, http.StatusForbidden); err != nil {
+		return err
+	}
+	if err = expectRequest("POST", "/api/subscription/cancel", payToken, cancel, http.StatusNoContent); err != nil {
+		return err
+	}
+	if err = expectRequest("POST", "/api/subscription/cancel", payToken, cancel, http.StatusConflict); err != nil {
+		return err
+	}
+
+	var subs []util.SubscrDetails
+	if _, err = doRequest("GET", fmt.Sprintf("%s/api/subscription/search?id=%d", reliveTestCfg.reliveServerURL, payID), payToken, nil, &subs); err != nil {
+		return err
+	}
+	if len(subs) != 2 || subs[1].SubscrCode != paid.SubscrCode || subs[1].State != dbmodel.SubscriptionCanceled {
+		return fmt.Errorf("expected both subscriptions of paybiz, the first canceled, got %+v", subs)
+	}
+	return nil
+}
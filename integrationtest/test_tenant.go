@@ -0,0 +1,174 @@
+package integrationtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/msproject/relive/dbmodel"
+	"github.com/msproject/relive/util"
+)
+
+const (
+	tenantAdminA    = "tenantadmina"
+	tenantAdminB    = "tenantadminb"
+	tenantCustomerA = "tenantcusta"
+	tenantPWD       = "tenant001"
+)
+
+// doRequest - issue an API request with a bearer token, decoding a JSON
+// response into out if it is not nil. Returns the HTTP status.
+func doRequest(method, reqURL, token string, body interface{}, out interface{}) (int, error) {
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			return 0, err
+		}
+	}
+
+	req, err := http.NewRequest(method, reqURL, &reqBody)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create http request %s! err: %v", reqURL, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to call %s! err: %v", reqURL, err)
+	}
+	defer resp.Body.Close()
+
+	if out != nil && resp.StatusCode < 300 {
+		if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, fmt.Errorf("failed to decode response of %s! err: %v", reqURL, err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// lookupAccount - search an account by user name with the given session
+func lookupAccount(token, userName string) (util.SearchAccountReq, error) {
+	var acct util.SearchAccountReq
+	searchURL := reliveTestCfg.reliveServerURL + "/api/accounts/search?user=" + url.QueryEscape(userName)
+	status, err := doRequest("GET", searchURL, token, nil, &acct)
+	if err != nil {
+		return acct, err
+	}
+	if status != http.StatusOK || acct.ID == 0 {
+		return acct, fmt.Errorf("failed to look up account %s, status %d", userName, status)
+	}
+	return acct, nil
+}
+
+func createTestAccount(token string, req *util.CreateAccountReq) error {
+	status, err := doRequest("POST", accountCreateURL, token, req, nil)
+	if err != nil {
+		return err
+	}
+	if status >= 300 {
+		return fmt.Errorf("failed to get 2XX response for create account %s: %d", req.UserName, status)
+	}
+	return nil
+}
+
+// expectStatus - GET the path as token and fail unless the status matches
+func expectStatus(token, path string, expected int) error {
+	return expectRequest("GET", path, token, nil, expected)
+}
+
+// testCrossTenantReads - business B must not read anything of business A
+// or A's customers, while A itself can
+func testCrossTenantReads() error {
+	rootToken, err := loginAs("root", "video@Cloud")
+	if err != nil {
+		return err
+	}
+	adminA, err := lookupAccount(rootToken, tenantAdminA)
+	if err != nil {
+		return err
+	}
+	custA, err := lookupAccount(rootToken, tenantCustomerA)
+	if err != nil {
+		return err
+	}
+
+	tokenA, err := loginAs(tenantAdminA, tenantPWD)
+	if err != nil {
+		return err
+	}
+	tokenB, err := loginAs(tenantAdminB, tenantPWD)
+	if err != nil {
+		return err
+	}
+
+	accountSearch := "/api/accounts/search?user=" + tenantCustomerA
+	adminSearch := fmt.Sprintf("/api/accounts/search?id=%d&role=%d", adminA.ID, dbmodel.RoleCustomer)
+	mediaSearch := fmt.Sprintf("/api/media/search?id=%d", custA.ID)
+
+	/* positive control: A reads its own customer */
+	for _, u := range []string{accountSearch, adminSearch, mediaSearch} {
+		if err = expectStatus(tokenA, u, http.StatusOK); err != nil {
+			return err
+		}
+	}
+
+	/* B is denied every one of them */
+	for _, u := range []string{accountSearch, adminSearch, mediaSearch} {
+		if err = expectStatus(tokenB, u, http.StatusForbidden); err != nil {
+			return err
+		}
+	}
+
+	/* and the customer cannot read its business */
+	tokenCust, err := loginAs(tenantCustomerA, tenantPWD)
+	if err != nil {
+		return err
+	}
+	return expectStatus(tokenCust, "/api/accounts/search?user="+tenantAdminA, http.StatusForbidden)
+}
+
+// testUserNameTaken - an account cannot be renamed to a name another
+// account has, by its business or by itself
+func testUserNameTaken() error {
+	rootToken, err := loginAs("root", "video@Cloud")
+	if err != nil {
+		return err
+	}
+	custA, err := lookupAccount(rootToken, tenantCustomerA)
+	if err != nil {
+		return err
+	}
+	tokenA, err := loginAs(tenantAdminA, tenantPWD)
+	if err != nil {
+		return err
+	}
+	tokenCust, err := loginAs(tenantCustomerA, tenantPWD)
+	if err != nil {
+		return err
+	}
+
+	rename := dbmodel.AccountEntry{ID: int(custA.ID), PID: int(custA.CompanyID), UserName: tenantAdminB,
+		FirstName: custA.FirstName, LastName: custA.LastName, EmailID: custA.Email, Role: int(custA.Role)}
+	if err = expectRequest("POST", "/api/accounts/update", tokenA, rename, http.StatusConflict); err != nil {
+		return err
+	}
+	if err = expectRequest("POST", "/api/accounts/my/update", tokenCust, rename, http.StatusConflict); err != nil {
+		return err
+	}
+
+	/* a null body is a bad request, not a crash */
+	for _, path := range []string{"/api/accounts/update", "/api/accounts/my/update"} {
+		if err = expectRequest("POST", path, tokenA, json.RawMessage("null"), http.StatusBadRequest); err != nil {
+			return err
+		}
+	}
+
+	/* keeping its own name is no conflict */
+	rename.UserName = tenantCustomerA
+	return expectRequest("POST", "/api/accounts/my/update", tokenCust, rename, http.StatusNoContent)
+}
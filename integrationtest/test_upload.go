@@ -0,0 +1,172 @@
+package integrationtest
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/msproject/relive/dbmodel"
+)
+
+// tusRequest - a tus request with a bearer token
+func tusRequest(method, reqURL, token string, body []byte, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequest(method, reqURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+func tusPatch(uploadURL, token string, offset int, chunk []byte, checksum string) (*http.Response, error) {
+	headers := map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": strconv.Itoa(offset),
+	}
+	if checksum != "" {
+		headers["Upload-Checksum"] = checksum
+	}
+	return tusRequest("PATCH", uploadURL, token, chunk, headers)
+}
+
+// testResumableUpload - an upload sent in two chunks, with a wrong offset
+// and a corrupted chunk refused in between. The content is no media, so
+// completing it is refused and the upload dropped.
+func testResumableUpload() error {
+	token, err := loginAs(tenantAdminA, tenantPWD)
+	if err != nil {
+		return err
+	}
+	adminA, err := lookupAccount(token, tenantAdminA)
+	if err != nil {
+		return err
+	}
+
+	content := bytes.Repeat([]byte("relive resumable upload "), 1000)
+	meta := []string{}
+	for k, v := range map[string]string{"filename": "resumable.mp4", "id": strconv.Itoa(int(adminA.ID)),
+		"catalog": "tests", "title": "Resumable"} {
+		meta = append(meta, k+" "+base64.StdEncoding.EncodeToString([]byte(v)))
+	}
+
+	base := reliveTestCfg.reliveServerURL
+	resp, err := tusRequest("POST", base+"/api/media/uploads", token, nil, map[string]string{
+		"Upload-Length":   strconv.Itoa(len(content)),
+		"Upload-Metadata": strings.Join(meta, ","),
+	})
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusCreated || resp.Header.Get("Location") == "" {
+		return fmt.Errorf("expected 201 with Location creating the upload, got %d", resp.StatusCode)
+	}
+	uploadURL := base + resp.Header.Get("Location")
+
+	half := len(content) / 2
+	sum := sha1.Sum(content[:half])
+	resp, err = tusPatch(uploadURL, token, 0, content[:half], "sha1 "+base64.StdEncoding.EncodeToString(sum[:]))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Upload-Offset") != strconv.Itoa(half) {
+		return fmt.Errorf("expected 204 at offset %d, got %d at %s", half, resp.StatusCode, resp.Header.Get("Upload-Offset"))
+	}
+
+	if resp, err = tusPatch(uploadURL, token, 0, content[half:], ""); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusConflict {
+		return fmt.Errorf("expected 409 for a stale offset, got %d", resp.StatusCode)
+	}
+	if resp, err = tusPatch(uploadURL, token, half, content[half:], "sha1 "+base64.StdEncoding.EncodeToString(sum[:])); err != nil {
+		return err
+	}
+	if resp.StatusCode != 460 {
+		return fmt.Errorf("expected 460 for a corrupted chunk, got %d", resp.StatusCode)
+	}
+
+	if resp, err = tusRequest("HEAD", uploadURL, token, nil, nil); err != nil {
+		return err
+	}
+	if resp.Header.Get("Upload-Offset") != strconv.Itoa(half) || resp.Header.Get("Upload-Length") != strconv.Itoa(len(content)) {
+		return fmt.Errorf("expected offset %d of %d, got %s of %s", half, len(content),
+			resp.Header.Get("Upload-Offset"), resp.Header.Get("Upload-Length"))
+	}
+
+	/* other tenants must not see the upload */
+	tokenB, err := loginAs(tenantAdminB, tenantPWD)
+	if err != nil {
+		return err
+	}
+	if resp, err = tusRequest("HEAD", uploadURL, tokenB, nil, nil); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		return fmt.Errorf("expected 403 for another tenant, got %d", resp.StatusCode)
+	}
+
+	if resp, err = tusPatch(uploadURL, token, half, content[half:], ""); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		return fmt.Errorf("expected 415 completing a non media upload, got %d", resp.StatusCode)
+	}
+	if resp, err = tusRequest("HEAD", uploadURL, token, nil, nil); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("expected the rejected upload to be gone, got %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// testUploadFileNames - file names that could leave the store, or are
+// taken by a media of the account, are refused before anything is staged
+func testUploadFileNames() error {
+	token, err := loginAs(tenantAdminA, tenantPWD)
+	if err != nil {
+		return err
+	}
+	adminA, err := lookupAccount(token, tenantAdminA)
+	if err != nil {
+		return err
+	}
+	id := int(adminA.ID)
+
+	for _, fileName := range []string{"../escape.mp4", "/etc/passwd", `..\boot.mp4`, ".hidden.mp4", "\uff0e\uff0e\uff0fx.mp4",
+		"evil\u202egpj.mp4"} {
+		resp, err := tusCreate(token, id, fileName, 100)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusBadRequest {
+			return fmt.Errorf("expected 400 for file name %q, got %d", fileName, resp.StatusCode)
+		}
+	}
+
+	if err = seedMedia(id, "tests", "taken.mp4"); err != nil {
+		return err
+	}
+	resp, err := tusCreate(token, id, "taken.mp4", 100)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusConflict {
+		return fmt.Errorf("expected 409 for a file name in use, got %d", resp.StatusCode)
+	}
+	return expectRequest("DELETE", "/api/media/delete", token, dbmodel.MediaTypeEntry{ID: id, FileName: "taken.mp4"},
+		http.StatusNoContent)
+}
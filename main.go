@@ -1,45 +1,129 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"github.com/msproject/relive/api"
 	"github.com/msproject/relive/dbi"
 	"github.com/msproject/relive/dbinit"
 	"github.com/msproject/relive/dbmodel"
+	"github.com/msproject/relive/egress"
 	"github.com/msproject/relive/logger"
+	"github.com/msproject/relive/mediakey"
+	"github.com/msproject/relive/mediastore"
+	"github.com/msproject/relive/notifier"
+	"github.com/msproject/relive/passwd"
+	"github.com/msproject/relive/playback"
+	"github.com/msproject/relive/quota"
+	"github.com/msproject/relive/server"
+	"github.com/msproject/relive/subscription"
+	"github.com/msproject/relive/transcode"
+	"github.com/msproject/relive/upload"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 )
 
 func main() {
-	var metaURL, listen, listenSSL, certFilePath, keyFilePath string
-	var dbTimeout time.Duration
+	var metaURL, listen, listenSSL, certFilePath, keyFilePath, tlsMinVersion, tlsCiphers, pwdHashAlg, pwdRequire, notifierSpec, mediaStoreSpec, renditions string
+	var playbackKeyFile, publicURL, kekFile, uploadDir, quotaPolicy string
+	var hlsEncrypt, mp4Download, autoMigrate bool
+	var dbTimeout, sessionTTL, resetTTL, transcodeBackoff, playbackTTL, uploadExpiry, egressFlush time.Duration
+	var subscriptionGrace, subscriptionCheck, retentionCheck time.Duration
+	var pwdMinLen, transcodeWorkers, transcodeAttempts, freeQuota, quotaSoft, posters, spriteInterval int
+	var quotaUnit int64
 	flag.StringVar(&metaURL, "metaurl", "root:@tcp(127.0.0.1:3306)/relive?parseTime=true&interpolateParams=true", "URL of the metadata service")
+	flag.BoolVar(&autoMigrate, "automigrate", true, "apply pending schema migrations at startup, else refuse to start on a schema not up to date")
 	flag.DurationVar(&dbTimeout, "dbtimeout", 10*time.Second, "timeout for DB queries")
-	flag.StringVar(&listen, "listen", ":9999", "Host and HTTP port to listen on")
+	flag.StringVar(&listen, "listen", ":9999", "Host and HTTP port redirecting to HTTPS, empty to disable plaintext")
 	flag.StringVar(&listenSSL, "listenssl", ":8443", "Host and HTTPS port to listen on")
 	flag.StringVar(&certFilePath, "cert", "./relive_cert.pem", "absolute file path for the SSL certificate file")
 	flag.StringVar(&keyFilePath, "key", "./relive_key.pem", "absolute file path for the SSL key file")
+	flag.StringVar(&tlsMinVersion, "tlsmin", "1.2", "minimum TLS version (1.0, 1.1, 1.2 or 1.3)")
+	flag.StringVar(&tlsCiphers, "tlsciphers", "", "comma separated TLS 1.2 cipher suites, empty for Go's defaults")
+	flag.DurationVar(&sessionTTL, "sessionttl", api.DefaultSessionTTL, "lifetime of a login session token")
+	flag.StringVar(&pwdHashAlg, "pwdhash", passwd.DefaultAlgorithm, "password hash algorithm for new digests (bcrypt, argon2id or scrypt)")
+	flag.IntVar(&pwdMinLen, "pwdminlen", passwd.DefaultPolicy.MinLength, "minimum password length")
+	flag.StringVar(&pwdRequire, "pwdrequire", "lower,digit", "character classes a password must contain (upper, lower, digit, symbol)")
+	flag.DurationVar(&resetTTL, "resetttl", api.DefaultResetTTL, "lifetime of a password reset token")
+	flag.StringVar(&notifierSpec, "notifier", "", "how password reset tokens are delivered: smtp://[user:password@]host:port?from=<address>, or log or file:<path> for tests")
+	flag.StringVar(&mediaStoreSpec, "mediastore", "local:./media", "where media is stored: local:<dir> or s3:<endpoint>/<bucket>[?region=<region>]")
+	flag.StringVar(&renditions, "renditions", transcode.DefaultLadder, "HLS ladder: presets (1080p, 720p, 480p, 360p, audio) or <name>:<height>:<video kbit/s>:<audio kbit/s>")
+	flag.IntVar(&posters, "posters", transcode.DefaultPosters, "poster candidates sampled across a video")
+	flag.IntVar(&spriteInterval, "spriteinterval", transcode.DefaultSpriteInterval, "seconds between the scrubbing thumbnails of a video, 0 for none")
+	flag.BoolVar(&mp4Download, "mp4download", true, "make a progressive MP4 of videos for download, remuxed if the source is H.264/AAC")
+	flag.StringVar(&playbackKeyFile, "playbackkey", "", "file holding the key (32+ bytes) signing playback URLs, empty for a random key per process")
+	flag.DurationVar(&playbackTTL, "playbackttl", playback.DefaultTTL, "lifetime of a signed playback URL")
+	flag.StringVar(&publicURL, "publicurl", "", "scheme://host[:port] prefixed to playback URLs, empty for host relative URLs")
+	flag.BoolVar(&hlsEncrypt, "hlsencrypt", false, "encrypt HLS segments with AES-128, a key per media")
+	flag.StringVar(&kekFile, "mediakek", "", "file holding the 32 byte key that encrypts stored media keys, required with -hlsencrypt")
+	flag.StringVar(&uploadDir, "uploaddir", "./uploads", "local directory staging resumable uploads until they are complete")
+	flag.DurationVar(&uploadExpiry, "uploadexpiry", upload.DefaultExpiry, "an incomplete resumable upload untouched this long is dropped")
+	flag.DurationVar(&egressFlush, "egressflush", egress.DefaultFlushInterval, "how often the bytes playback served are persisted for billing")
+	flag.Int64Var(&quotaUnit, "quotaunit", quota.DefaultUnit, "bytes per unit of a Product's StoreSize")
+	flag.IntVar(&freeQuota, "freequota", 0, "StoreSize units of a business without a running subscription")
+	flag.IntVar(&quotaSoft, "quotasoft", quota.DefaultSoftPercent, "percent of the quota past which uploads are warned about")
+	flag.StringVar(&quotaPolicy, "quotapolicy", string(quota.PolicyBlock), "uploads past the quota: block, warn or overage (accepted and billed)")
+	flag.IntVar(&transcodeWorkers, "transcodeworkers", transcode.DefaultWorkers, "number of uploads transcoded in parallel")
+	flag.IntVar(&transcodeAttempts, "transcodeattempts", transcode.DefaultMaxAttempts, "attempts before a transcode job fails")
+	flag.DurationVar(&subscriptionGrace, "subscriptiongrace", subscription.DefaultGrace, "how long a subscription that could not be renewed runs on before it expires")
+	flag.DurationVar(&subscriptionCheck, "subscriptioncheck", subscription.DefaultInterval, "how often subscriptions are checked for renewal and expiry")
+	flag.DurationVar(&retentionCheck, "retentioncheck", api.DefaultRetentionCheck, "how often media kept past the retention of their subscription are deleted")
+	flag.DurationVar(&transcodeBackoff, "transcodebackoff", transcode.DefaultBackoff, "delay before retrying a failed transcode job, doubled for each retry")
 	flag.Parse()
 
 	logObj, _ := logger.NewLoggerObject(false)
 	/* first DBInit */
 	var dbInitCfg *dbinit.Config
 	var err error
-	if dbInitCfg, err = dbinit.NewDBInitConfig(metaURL, dbmodel.TableCreateSQL, dbmodel.TableDeleteSQL, logObj); err != nil {
+	if dbInitCfg, err = dbinit.NewDBInitConfig(metaURL, dbmodel.DatabaseSQL, dbmodel.Migrations, logObj); err != nil {
 		logObj.PrintError("DB Init failed, exiting. Error: %v", err)
 		os.Exit(-1)
 	}
 
-	if err = dbInitCfg.Configure(); err != nil {
+	/* relive [flags] migrate up|down [n]|status */
+	if flag.NArg() > 0 {
+		if err = runCommand(dbInitCfg, flag.Args()); err != nil {
+			logObj.PrintError("%v", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if autoMigrate {
+		_, err = dbInitCfg.Configure()
+	} else {
+		err = checkSchema(dbInitCfg)
+	}
+	if err != nil {
 		logObj.PrintError("DB Init failed, exiting. Error: %v", err)
 		os.Exit(-1)
 	}
 	/* end of DBInit */
 
-	sqlDbi, err := dbi.InitializeDBI(metaURL, dbTimeout, logObj)
+	pwdHasher, err := passwd.NewHasher(pwdHashAlg)
+	if err != nil {
+		logObj.PrintError("Invalid password hash algorithm, exiting. Error: %v", err)
+		os.Exit(1)
+	}
+
+	pwdPolicy, err := passwd.ParsePolicy(pwdMinLen, pwdRequire, pwdHasher)
+	if err != nil {
+		logObj.PrintError("Invalid password policy, exiting. Error: %v", err)
+		os.Exit(1)
+	}
+
+	notify, err := notifier.NewNotifier(notifierSpec, logObj)
+	if err != nil {
+		logObj.PrintError("Invalid notifier, exiting. Error: %v", err)
+		os.Exit(1)
+	}
+
+	sqlDbi, err := dbi.InitializeDBI(metaURL, dbTimeout, pwdHasher, logObj)
 
 	if err != nil {
 		logObj.PrintError("Could not initialize the SQL Dbi %s error %s", metaURL, err.Error())
@@ -59,10 +143,24 @@ func main() {
 		os.Exit(1)
 	}
 
+	mediaStore, err := mediastore.NewMediaStore(mediaStoreSpec)
+	if err != nil {
+		logObj.PrintError("Invalid media store, exiting. Error: %v", err)
+		os.Exit(1)
+	}
+
 	accountAPI := api.AccountsAPI{
-		AccountDBI:      sqlDbi.AccountDBI,
-		SubscriptionDBI: sqlDbi.SubscriptionDBI,
-		LogObj:          logObj,
+		AccountDBI:       sqlDbi.AccountDBI,
+		SubscriptionDBI:  sqlDbi.SubscriptionDBI,
+		SessionDBI:       sqlDbi.SessionDBI,
+		PasswordResetDBI: sqlDbi.PasswordResetDBI,
+		TransferDBI:      sqlDbi.AccountTransferDBI,
+		Notifier:         notify,
+		PWDPolicy:        pwdPolicy,
+		SessionTTL:       sessionTTL,
+		ResetTTL:         resetTTL,
+		Store:            mediaStore,
+		LogObj:           logObj,
 	}
 
 	productAPI := api.ProductsAPI{
@@ -74,6 +172,7 @@ func main() {
 		SubscriptionDBI:        sqlDbi.SubscriptionDBI,
 		SubscriptionAccountDBI: sqlDbi.SubscriptionAccountDBI,
 		ProductDBI:             sqlDbi.ProductDBI,
+		PaymentDBI:             sqlDbi.PaymentDBI,
 		LogObj:                 logObj,
 	}
 	paymentAPI := api.PaymentAPI{
@@ -81,55 +180,175 @@ func main() {
 		PaymentHistoryDBI: sqlDbi.PaymentHistoryDBI,
 		LogObj:            logObj,
 	}
-	mediaAPI := api.MediaAPI{
-		MediaDBI:   sqlDbi.MediaTypeDBI,
-		AccountDBI: sqlDbi.AccountDBI,
-		LogObj:     logObj,
+
+	ladder, err := transcode.ParseLadder(renditions)
+	if err != nil {
+		logObj.PrintError("Invalid renditions, exiting. Error: %v", err)
+		os.Exit(1)
+	}
+
+	/* jobs queued before a restart are picked up again */
+	transcoder := transcode.FFmpeg{Ladder: ladder, Posters: posters, SpriteInterval: spriteInterval, MP4: mp4Download}
+	transcodeQueue := transcode.NewQueue(sqlDbi.TranscodeJobDBI, sqlDbi.MediaTypeDBI, transcoder, mediaStore,
+		transcodeWorkers, transcodeAttempts, transcodeBackoff, logObj)
+
+	var keyWrapper *mediakey.Wrapper
+	if hlsEncrypt {
+		kek, err := mediakey.LoadKEK(kekFile)
+		if err == nil {
+			keyWrapper, err = mediakey.NewWrapper(kek)
+		}
+		if err != nil {
+			logObj.PrintError("Invalid media key encryption key, exiting. Error: %v", err)
+			os.Exit(1)
+		}
+		transcodeQueue.EnableEncryption(sqlDbi.MediaKeyDBI, keyWrapper, strings.TrimSuffix(publicURL, "/")+"/api/media/key")
 	}
 
-	router := api.Router{
-		Account:      accountAPI,
-		Subscription: subscriptionAPI,
-		Payment:      paymentAPI,
-		Media:        mediaAPI,
-		Product:      productAPI,
-		AccountDBI:   sqlDbi.AccountDBI,
-		LogObj:       logObj,
+	if err = transcodeQueue.Start(); err != nil {
+		logObj.PrintError("Could not start transcoding, exiting. Error: %v", err)
+		os.Exit(1)
 	}
 
-	routerSSL := api.RouterSSL{
-		Account:      accountAPI,
-		Subscription: subscriptionAPI,
-		Payment:      paymentAPI,
-		Media:        mediaAPI,
-		Product:      productAPI,
-		LogObj:       logObj,
+	uploads, err := upload.NewManager(sqlDbi.UploadDBI, uploadDir, uploadExpiry, logObj)
+	if err != nil {
+		logObj.PrintError("Could not stage uploads, exiting. Error: %v", err)
+		os.Exit(1)
 	}
+	uploads.Start()
 
-	logObj.PrintInfo("Listening on (HTTP) : %s\n", listen)
-	logObj.PrintInfo("Listening on (HTTPS): %s\n", listenSSL)
+	policy, err := quota.ParsePolicy(quotaPolicy)
+	if err != nil {
+		logObj.PrintError("Invalid storage quota policy, exiting. Error: %v", err)
+		os.Exit(1)
+	}
+	quotas := quota.NewQuotas(sqlDbi.AccountDBI, sqlDbi.StorageDBI, quotaUnit, freeQuota, quotaSoft, policy, logObj)
+
+	egressMeter := egress.NewMeter(sqlDbi.EgressDBI, sqlDbi.MediaTypeDBI, egressFlush, logObj)
+	egressMeter.Start()
+
+	/* renewals and expiries missed while down are caught up at once */
+	subscriptions := subscription.NewScheduler(sqlDbi.SubscriptionDBI, subscription.CardOnFile(sqlDbi.PaymentDBI),
+		subscriptionGrace, subscriptionCheck, logObj)
+	subscriptions.Start()
+
+	playbackKey, err := playback.LoadKey(playbackKeyFile)
+	if err != nil {
+		logObj.PrintError("Invalid playback key, exiting. Error: %v", err)
+		os.Exit(1)
+	}
+	playbackSigner, err := playback.NewSigner(playbackKey, playbackTTL)
+	if err != nil {
+		logObj.PrintError("Invalid playback key, exiting. Error: %v", err)
+		os.Exit(1)
+	}
+
+	mediaAPI := api.MediaAPI{
+		MediaDBI:        sqlDbi.MediaTypeDBI,
+		AccountDBI:      sqlDbi.AccountDBI,
+		CatalogDBI:      sqlDbi.CatalogDBI,
+		SubscriptionDBI: sqlDbi.SubscriptionDBI,
+		Store:           mediaStore,
+		Jobs:            transcodeQueue,
+		KeyDBI:          sqlDbi.MediaKeyDBI,
+		GrantDBI:        sqlDbi.MediaGrantDBI,
+		EgressDBI:       sqlDbi.EgressDBI,
+		Keys:            keyWrapper,
+		Uploads:         uploads,
+		Quotas:          quotas,
+		Egress:          egressMeter,
+		Signer:          playbackSigner,
+		PublicURL:       strings.TrimSuffix(publicURL, "/"),
+		LogObj:          logObj,
+	}
+
+	catalogAPI := api.CatalogAPI{
+		CatalogDBI: sqlDbi.CatalogDBI,
+		Media:      mediaAPI,
+		LogObj:     logObj,
+	}
+
+	router := api.NewRouter(accountAPI, subscriptionAPI, paymentAPI, mediaAPI, productAPI, catalogAPI,
+		sqlDbi.SessionDBI, logObj)
+
+	/* purge expired sessions periodically, GetSession ignores them anyway */
+	go func() {
+		for range time.Tick(sessionTTL) {
+			if n, err := sqlDbi.SessionDBI.DeleteExpiredSessions(); err != nil {
+				logObj.PrintError("Failed to purge expired sessions: %v", err)
+			} else if n > 0 {
+				logObj.PrintInfo("Purged %d expired sessions", n)
+			}
+		}
+	}()
+
+	/* delete media past the retention of their subscription */
+	go func() {
+		for range time.Tick(retentionCheck) {
+			if n, err := mediaAPI.DeleteExpiredMedia(context.Background()); err != nil {
+				logObj.PrintError("Failed to delete media past their retention: %v", err)
+			} else if n > 0 {
+				logObj.PrintInfo("Deleted %d media past their retention", n)
+			}
+		}
+	}()
 
-	/* HTTP Server MUX */
-	httpMux := http.NewServeMux()
-	httpMux.Handle("/api/", router)
-	httpMux.HandleFunc("/version", VersionHandler)
-	httpMux.HandleFunc("/health", HealthHandler)
-	//  Start HTTP
+	/* the certificate is re-read on SIGHUP, a failed reload keeps the old one */
+	certs, err := server.NewCertReloader(certFilePath, keyFilePath)
+	if err != nil {
+		logObj.PrintError("Invalid TLS certificate, exiting. Error: %v", err)
+		os.Exit(1)
+	}
+	tlsConfig, err := server.NewTLSConfig(tlsMinVersion, tlsCiphers, certs)
+	if err != nil {
+		logObj.PrintError("Invalid TLS configuration, exiting. Error: %v", err)
+		os.Exit(1)
+	}
 	go func() {
-		err := http.ListenAndServe(listen, httpMux)
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		for range hup {
+			if err := certs.Reload(); err != nil {
+				logObj.PrintError("Certificate reload failed: %v", err)
+				continue
+			}
+			logObj.PrintInfo("Reloaded certificate %s", certFilePath)
+		}
+	}()
+
+	/* plaintext only redirects, every API is served over HTTPS */
+	if listen != "" {
+		redirect, err := server.RedirectHandler(listenSSL, HealthHandler)
 		if err != nil {
-			logObj.PrintError("error Listening : %v", err)
+			logObj.PrintError("Invalid HTTPS listen address %s, exiting. Error: %v", listenSSL, err)
 			os.Exit(1)
 		}
-	}()
+
+		logObj.PrintInfo("Listening on (HTTP) : %s, redirecting to HTTPS\n", listen)
+		go func() {
+			err := http.ListenAndServe(listen, redirect)
+			if err != nil {
+				logObj.PrintError("error Listening : %v", err)
+				os.Exit(1)
+			}
+		}()
+	}
 
 	/* HTTPS Server MUX */
 	httpsMux := http.NewServeMux()
-	httpsMux.Handle("/api/", routerSSL)
+	httpsMux.Handle("/api/", router)
 	httpsMux.HandleFunc("/version", VersionHandler)
 	httpsMux.HandleFunc("/health", HealthHandler)
-	//  Start HTTPS
-	err = http.ListenAndServeTLS(listenSSL, certFilePath, keyFilePath, httpsMux)
+
+	httpsServer := &http.Server{
+		Addr:      listenSSL,
+		Handler:   httpsMux,
+		TLSConfig: tlsConfig,
+	}
+
+	logObj.PrintInfo("Listening on (HTTPS): %s\n", listenSSL)
+	//  Start HTTPS, the certificate comes from tlsConfig
+	err = httpsServer.ListenAndServeTLS("", "")
 	if err != nil {
 		logObj.PrintError("error Listening : %v", err)
 		os.Exit(1)
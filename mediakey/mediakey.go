@@ -0,0 +1,84 @@
+package mediakey
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+)
+
+// KeySize - AES-128, as HLS requires
+const KeySize = 16
+
+// Wrapper - encrypts content keys for storage with AES-256-GCM under a
+// key encryption key. The account and asset are bound in as additional
+// data, so a stored key can't be moved to another asset.
+type Wrapper struct {
+	aead cipher.AEAD
+}
+
+// NewWrapper - wrapper around a 32 byte key encryption key
+func NewWrapper(kek []byte) (*Wrapper, error) {
+	if len(kek) != 32 {
+		return nil, fmt.Errorf("key encryption key must be 32 bytes, got %d", len(kek))
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Wrapper{aead: aead}, nil
+}
+
+// LoadKEK - read the key encryption key from a file
+func LoadKEK(path string) ([]byte, error) {
+	if path == "" {
+		return nil, fmt.Errorf("HLS encryption needs a key encryption key file")
+	}
+	kek, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read key encryption key: %v", err)
+	}
+	return kek, nil
+}
+
+// NewKey - a random content key
+func NewKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Wrap - nonce and ciphertext of the content key of the asset of account id
+func (w *Wrapper) Wrap(id int, asset string, key []byte) ([]byte, error) {
+	nonce := make([]byte, w.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return w.aead.Seal(nonce, nonce, key, additionalData(id, asset)), nil
+}
+
+// Unwrap - the content key, failing if wrapped was altered or belongs to
+// another asset
+func (w *Wrapper) Unwrap(id int, asset string, wrapped []byte) ([]byte, error) {
+	n := w.aead.NonceSize()
+	if len(wrapped) < n {
+		return nil, fmt.Errorf("wrapped key too short")
+	}
+	key, err := w.aead.Open(nil, wrapped[:n], wrapped[n:], additionalData(id, asset))
+	if err != nil {
+		return nil, fmt.Errorf("could not unwrap key of %d/%s: %v", id, asset, err)
+	}
+	return key, nil
+}
+
+func additionalData(id int, asset string) []byte {
+	return []byte(strconv.Itoa(id) + "\x00" + asset)
+}
@@ -0,0 +1,37 @@
+package mediakey
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWrapUnwrap(t *testing.T) {
+	w, err := NewWrapper(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := NewKey()
+
+	wrapped, err := w.Wrap(3, "wedding", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(wrapped, key) {
+		t.Error("wrapped key contains the key")
+	}
+
+	got, err := w.Unwrap(3, "wedding", wrapped)
+	if err != nil || !bytes.Equal(got, key) {
+		t.Errorf("Unwrap = %x, %v", got, err)
+	}
+	if _, err = w.Unwrap(4, "wedding", wrapped); err == nil {
+		t.Error("Unwrap accepted the key of another account")
+	}
+	if _, err = w.Unwrap(3, "party", wrapped); err == nil {
+		t.Error("Unwrap accepted the key of another asset")
+	}
+
+	if _, err = NewWrapper([]byte("short")); err == nil {
+		t.Error("NewWrapper accepted a short key")
+	}
+}
@@ -0,0 +1,181 @@
+package mediastore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrNotExist - no object is stored under the key
+var ErrNotExist = errors.New("media object does not exist")
+
+// ObjectInfo - metadata of a stored object
+type ObjectInfo struct {
+	Key         string
+	Size        int64
+	ModTime     time.Time
+	ContentType string
+}
+
+// MediaStore - where uploads and transcoded media live. Keys are slash
+// separated relative paths, <account id>/<media name>/<file>.
+type MediaStore interface {
+	// Put - store size bytes of r under key, replacing any object there
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+
+	// Get - read the whole object
+	Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error)
+
+	// GetRange - read length bytes of the object starting at offset
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+
+	// Stat - metadata of the object
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+
+	// Delete - remove the object, ErrNotExist if there is none
+	Delete(ctx context.Context, key string) error
+
+	// List - objects whose key starts with prefix, sorted by key
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+}
+
+// NewMediaStore - create a store from its spec, "local:<dir>" for a
+// directory or "s3:<endpoint>/<bucket>[?region=<region>]" for an S3
+// compatible service, with credentials from AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY
+func NewMediaStore(spec string) (MediaStore, error) {
+	switch {
+	case strings.HasPrefix(spec, "local:"):
+		return NewLocalStore(strings.TrimPrefix(spec, "local:"))
+	case strings.HasPrefix(spec, "s3:"):
+		cfg, err := ParseS3Spec(strings.TrimPrefix(spec, "s3:"))
+		if err != nil {
+			return nil, err
+		}
+		return NewS3Store(cfg)
+	}
+	return nil, fmt.Errorf("unknown media store %q", spec)
+}
+
+// Key - the key of file of the media name of account id
+func Key(id int, name, file string) string {
+	return fmt.Sprintf("%d/%s/%s", id, name, file)
+}
+
+// CheckKey - keys must be relative, clean and free of "..", so a key
+// taken from a URL can't leave the store
+func CheckKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return fmt.Errorf("invalid media key %q", key)
+	}
+	for _, seg := range strings.Split(key, "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return fmt.Errorf("invalid media key %q", key)
+		}
+	}
+	return nil
+}
+
+// ContentType - content type of a media file by its extension
+func ContentType(name string) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".m3u8":
+		return "application/vnd.apple.mpegurl"
+	case ".ts":
+		return "video/mp2t"
+	case ".vtt":
+		return "text/vtt"
+	}
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// DeletePrefix - delete every object whose key starts with prefix
+func DeletePrefix(ctx context.Context, store MediaStore, prefix string) error {
+	objects, err := store.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		if err = store.Delete(ctx, obj.Key); err != nil && err != ErrNotExist {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadSeeker - io.ReadSeeker over an object, fetching from the current
+// offset on the first Read after a Seek. Lets http.ServeContent answer
+// range requests without reading the whole object.
+type ReadSeeker struct {
+	ctx    context.Context
+	store  MediaStore
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+// NewReadSeeker - ReadSeeker over the object at key of the given size
+func NewReadSeeker(ctx context.Context, store MediaStore, key string, size int64) *ReadSeeker {
+	return &ReadSeeker{ctx: ctx, store: store, key: key, size: size}
+}
+
+// Read - read from the current offset
+func (rs *ReadSeeker) Read(p []byte) (int, error) {
+	if rs.offset >= rs.size {
+		return 0, io.EOF
+	}
+	if rs.body == nil {
+		body, err := rs.store.GetRange(rs.ctx, rs.key, rs.offset, rs.size-rs.offset)
+		if err != nil {
+			return 0, err
+		}
+		rs.body = body
+	}
+
+	n, err := rs.body.Read(p)
+	rs.offset += int64(n)
+	return n, err
+}
+
+// Seek - move the offset, the next Read fetches from there
+func (rs *ReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = rs.offset + offset
+	case io.SeekEnd:
+		abs = rs.size + offset
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if abs < 0 {
+		return 0, fmt.Errorf("negative position %d", abs)
+	}
+
+	if abs != rs.offset {
+		rs.Close()
+		rs.offset = abs
+	}
+	return abs, nil
+}
+
+// Close - release the open range read, if any
+func (rs *ReadSeeker) Close() error {
+	if rs.body == nil {
+		return nil
+	}
+	err := rs.body.Close()
+	rs.body = nil
+	return err
+}
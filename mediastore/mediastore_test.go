@@ -0,0 +1,218 @@
+package mediastore
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+/* fakeS3 - MinIO style stand-in keeping one bucket in memory, returning
+ * at most two keys per listing page to exercise continuation */
+type fakeS3 struct {
+	bucket  string
+	mu      sync.Mutex
+	objects map[string][]byte
+	types   map[string]string
+}
+
+func newFakeS3(bucket string) *fakeS3 {
+	return &fakeS3{bucket: bucket, objects: map[string][]byte{}, types: map[string]string{}}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=testkey/") ||
+		r.Header.Get("X-Amz-Date") == "" {
+		http.Error(w, "AccessDenied", http.StatusForbidden)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, "/"+f.bucket+"/") {
+		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/"+f.bucket+"/")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if key == "" && r.Method == "GET" {
+		f.list(w, r)
+		return
+	}
+
+	data, ok := f.objects[key]
+	switch r.Method {
+	case "PUT":
+		body, _ := ioutil.ReadAll(r.Body)
+		f.objects[key] = body
+		f.types[key] = r.Header.Get("Content-Type")
+		return
+	case "DELETE":
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !ok {
+		http.Error(w, "NoSuchKey", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", f.types[key])
+	w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+	if rng := r.Header.Get("Range"); rng != "" {
+		var start, end int
+		fmt.Sscanf(rng, "bytes=%d-%d", &start, &end)
+		if end >= len(data) {
+			end = len(data) - 1
+		}
+		data = data[start : end+1]
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		w.WriteHeader(http.StatusPartialContent)
+	} else {
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+	}
+	if r.Method == "GET" {
+		w.Write(data)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	after := r.URL.Query().Get("continuation-token")
+
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) && key > after {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var result listBucketResult
+	if len(keys) > 2 {
+		keys = keys[:2]
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[1]
+	}
+	for _, key := range keys {
+		result.Contents = append(result.Contents, struct {
+			Key          string
+			Size         int64
+			LastModified time.Time
+		}{key, int64(len(f.objects[key])), time.Now().UTC()})
+	}
+	xml.NewEncoder(w).Encode(result)
+}
+
+func testStore(t *testing.T, store MediaStore) {
+	ctx := context.Background()
+	content := []byte("0123456789abcdef")
+
+	for _, key := range []string{"1/movie/movie.m3u8", "1/movie/movie0.ts", "1/movie/movie1.ts", "1/other/other.jpg", "2/movie/movie.m3u8"} {
+		if err := store.Put(ctx, key, bytes.NewReader(content), int64(len(content)), ContentType(key)); err != nil {
+			t.Fatalf("Put %s: %v", key, err)
+		}
+	}
+
+	rc, info, err := store.Get(ctx, "1/movie/movie.m3u8")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	data, _ := ioutil.ReadAll(rc)
+	rc.Close()
+	if !bytes.Equal(data, content) || info.Size != int64(len(content)) || info.ContentType != "application/vnd.apple.mpegurl" {
+		t.Errorf("Get returned %q %+v", data, info)
+	}
+
+	rc, err = store.GetRange(ctx, "1/movie/movie0.ts", 4, 6)
+	if err != nil {
+		t.Fatalf("GetRange: %v", err)
+	}
+	data, _ = ioutil.ReadAll(rc)
+	rc.Close()
+	if string(data) != "456789" {
+		t.Errorf("GetRange returned %q", data)
+	}
+
+	if _, err = store.Stat(ctx, "1/movie/missing.ts"); err != ErrNotExist {
+		t.Errorf("Stat of a missing key returned %v", err)
+	}
+	if _, err = store.Stat(ctx, "1/../2/movie/movie.m3u8"); err == nil || err == ErrNotExist {
+		t.Errorf("Stat accepted a key with ..")
+	}
+
+	objects, err := store.List(ctx, "1/movie/")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	var keys []string
+	for _, obj := range objects {
+		keys = append(keys, obj.Key)
+	}
+	if strings.Join(keys, ",") != "1/movie/movie.m3u8,1/movie/movie0.ts,1/movie/movie1.ts" {
+		t.Errorf("List returned %v", keys)
+	}
+
+	if err = DeletePrefix(ctx, store, "1/movie/"); err != nil {
+		t.Fatalf("DeletePrefix: %v", err)
+	}
+	if objects, _ = store.List(ctx, "1/"); len(objects) != 1 || objects[0].Key != "1/other/other.jpg" {
+		t.Errorf("List after DeletePrefix returned %+v", objects)
+	}
+	if err = store.Delete(ctx, "1/movie/movie.m3u8"); err != ErrNotExist {
+		t.Errorf("Delete of a deleted key returned %v", err)
+	}
+
+	rs := NewReadSeeker(ctx, store, "2/movie/movie.m3u8", int64(len(content)))
+	defer rs.Close()
+	rs.Seek(10, 0)
+	buf := make([]byte, 3)
+	if n, _ := rs.Read(buf); string(buf[:n]) != "abc" {
+		t.Errorf("ReadSeeker read %q after Seek", buf[:n])
+	}
+}
+
+func TestLocalStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mediastore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewLocalStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, store)
+}
+
+func TestS3Store(t *testing.T) {
+	srv := httptest.NewServer(newFakeS3("relive"))
+	defer srv.Close()
+
+	os.Setenv("AWS_ACCESS_KEY_ID", "testkey")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "testsecret")
+	store, err := NewMediaStore("s3:" + srv.URL + "/relive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, store)
+}
+
+func TestSigningKey(t *testing.T) {
+	/* example from the AWS Signature Version 4 documentation */
+	key := signingKey("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "20120215", "us-east-1", "iam")
+	if got := hex.EncodeToString(key); got != "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d" {
+		t.Errorf("signing key %s", got)
+	}
+}
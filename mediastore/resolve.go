@@ -0,0 +1,97 @@
+package mediastore
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrInvalidPath - a path component that could leave its place in the
+// store or be mistaken for another
+var ErrInvalidPath = errors.New("invalid media path")
+
+/* bytes of a file system name */
+const maxNameLen = 255
+
+/* runes taken for separators or dots by people, or by software that
+ * normalizes names: fullwidth and look-alike slashes, backslashes and dots */
+var confusables = map[rune]bool{
+	'\\': true, '/': true,
+	'\u2044': true, '\u2215': true, '\u29f8': true, '\uff0f': true, // fraction, division, big and fullwidth slash
+	'\u2216': true, '\u29f9': true, '\ufe68': true, '\uff3c': true, // set minus, big, small and fullwidth backslash
+	'\u2024': true, '\ufe52': true, '\uff0e': true, '\u3002': true, '\uff61': true, // one dot leader, small, fullwidth and ideographic full stops
+}
+
+/* extensions kept on the stored original */
+var extPattern = regexp.MustCompile(`^\.[A-Za-z0-9]{1,8}$`)
+
+// NewAssetID - random name of an uploaded asset in the store, so no name a
+// client chose ever becomes part of a key
+func NewAssetID() (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id[:]), nil
+}
+
+// CheckName - nil if name is usable as a single path component: valid
+// UTF-8 of printable characters only, no separator or look-alike of one,
+// not starting with a dot and at most 255 bytes. Rejects "..", absolute
+// paths and invisible bidi or zero width characters.
+func CheckName(name string) error {
+	if name == "" || len(name) > maxNameLen || !utf8.ValidString(name) || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("%w: %q", ErrInvalidPath, name)
+	}
+	for _, r := range name {
+		if !unicode.IsPrint(r) || confusables[r] {
+			return fmt.Errorf("%w: %q", ErrInvalidPath, name)
+		}
+	}
+	return nil
+}
+
+// Resolve - the key of file of the asset of account, the one place keys
+// are made from names taken from requests. With a rendition the file is
+// one of that rendition, named <asset>_<rendition>_<file> like the
+// transcoder names segments. Assets stored before asset IDs were generated
+// carry the file name of their upload and are resolved alike.
+func Resolve(account int, asset, rendition, file string) (string, error) {
+	if account <= 0 {
+		return "", fmt.Errorf("%w: account %d", ErrInvalidPath, account)
+	}
+	for _, name := range []string{asset, file} {
+		if err := CheckName(name); err != nil {
+			return "", err
+		}
+	}
+	if rendition != "" {
+		if err := CheckName(rendition); err != nil {
+			return "", err
+		}
+		file = asset + "_" + rendition + "_" + file
+	}
+
+	key := Key(account, asset, file)
+	if err := CheckKey(key); err != nil || len(file) > maxNameLen {
+		return "", fmt.Errorf("%w: %q", ErrInvalidPath, key)
+	}
+	return key, nil
+}
+
+// SourceFile - name the original of an upload named fileName is stored
+// under: "source" with the extension of fileName if it is a plain one,
+// which ffprobe may go by
+func SourceFile(fileName string) string {
+	ext := path.Ext(fileName)
+	if !extPattern.MatchString(ext) {
+		return "source"
+	}
+	return "source" + strings.ToLower(ext)
+}
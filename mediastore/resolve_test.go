@@ -0,0 +1,118 @@
+package mediastore
+
+import (
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+)
+
+func TestResolve(t *testing.T) {
+	asset, err := NewAssetID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(asset) != 32 || strings.Trim(asset, "0123456789abcdef") != "" {
+		t.Fatalf("asset ID %q is not 32 hex digits", asset)
+	}
+
+	key, err := Resolve(7, asset, "", asset+".m3u8")
+	if err != nil || key != "7/"+asset+"/"+asset+".m3u8" {
+		t.Errorf("Resolve of the playlist = %q, %v", key, err)
+	}
+	if key, err = Resolve(7, asset, "720p", "3.ts"); err != nil || key != "7/"+asset+"/"+asset+"_720p_3.ts" {
+		t.Errorf("Resolve of a segment = %q, %v", key, err)
+	}
+	/* assets stored before asset IDs were generated */
+	if key, err = Resolve(7, "Our wedding", "", "Our wedding.m3u8"); err != nil || key != "7/Our wedding/Our wedding.m3u8" {
+		t.Errorf("Resolve of a legacy asset = %q, %v", key, err)
+	}
+
+	for _, c := range []struct {
+		account                int
+		asset, rendition, file string
+	}{
+		{0, asset, "", "a.ts"},
+		{7, "", "", "a.ts"},
+		{7, asset, "", ""},
+		{7, "..", "", "a.ts"},
+		{7, asset, "", ".."},
+		{7, asset, "..", "a.ts"},
+		{7, asset, "", "../../etc/passwd"},
+		{7, asset, "", "/etc/passwd"},
+		{7, asset, "", `..\..\boot.ini`},
+		{7, asset, "", ".hidden"},
+		{7, asset, "", "a\x00.ts"},
+		{7, asset, "", "a\n.ts"},
+		{7, asset, "", "\xff.ts"},
+		{7, asset, "", "\u2025\u2215etc"},
+		{7, asset, "", "\uff0e\uff0e\uff0fetc"},
+		{7, asset, "", "evil\u202egpj.exe"},
+		{7, asset, "", "a\u200b.ts"},
+		{7, asset, "", strings.Repeat("a", 256)},
+		{7, asset, strings.Repeat("r", 200), strings.Repeat("f", 40)},
+	} {
+		if key, err = Resolve(c.account, c.asset, c.rendition, c.file); err == nil {
+			t.Errorf("Resolve(%d, %q, %q, %q) accepted as %q", c.account, c.asset, c.rendition, c.file, key)
+		}
+	}
+}
+
+func TestSourceFile(t *testing.T) {
+	for name, want := range map[string]string{
+		"Holiday.MP4":     "source.mp4",
+		"clip.tar.mkv":    "source.mkv",
+		"noext":           "source",
+		"x.m\u0440\u0434": "source",
+		"a.../../b":       "source",
+	} {
+		if got := SourceFile(name); got != want {
+			t.Errorf("SourceFile(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+// FuzzResolve - whatever a request carries, a resolved key stays below
+// the asset of the account and names one file of it
+func FuzzResolve(f *testing.F) {
+	for _, seed := range [][3]string{
+		{"0123456789abcdef0123456789abcdef", "", "master.m3u8"},
+		{"wedding", "720p", "3.ts"},
+		{"..", "", "x"},
+		{"a", "", "../b"},
+		{"a/b", "", "c"},
+		{"a", "b/../c", "d"},
+		{"\uff0e\uff0e", "", "\uff0f"},
+		{"a", "", "b\u202e"},
+		{"a", "", "\xc0\xaf"},
+	} {
+		f.Add(7, seed[0], seed[1], seed[2])
+	}
+
+	f.Fuzz(func(t *testing.T, account int, asset, rendition, file string) {
+		key, err := Resolve(account, asset, rendition, file)
+		if err != nil {
+			return
+		}
+		if CheckKey(key) != nil {
+			t.Fatalf("Resolve(%d, %q, %q, %q) = %q, an invalid key", account, asset, rendition, file, key)
+		}
+		parts := strings.Split(key, "/")
+		if len(parts) != 3 || parts[1] != asset {
+			t.Fatalf("Resolve(%d, %q, %q, %q) = %q, not a file of the asset", account, asset, rendition, file, key)
+		}
+		if !strings.HasSuffix(parts[2], file) || (rendition != "" && !strings.HasPrefix(parts[2], asset+"_"+rendition+"_")) {
+			t.Fatalf("Resolve(%d, %q, %q, %q) = %q, not the file asked for", account, asset, rendition, file, key)
+		}
+		for _, part := range parts[1:] {
+			if strings.HasPrefix(part, ".") || len(part) > maxNameLen || !utf8.ValidString(part) {
+				t.Fatalf("Resolve(%d, %q, %q, %q) = %q, unsafe component %q", account, asset, rendition, file, key, part)
+			}
+			for _, r := range part {
+				if !unicode.IsPrint(r) || confusables[r] {
+					t.Fatalf("Resolve(%d, %q, %q, %q) = %q, rune %U in %q", account, asset, rendition, file, key, r, part)
+				}
+			}
+		}
+	})
+}
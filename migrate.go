@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/msproject/relive/dbinit"
+)
+
+// runCommand - run the command given after the flags instead of serving
+func runCommand(dbInitCfg *dbinit.Config, args []string) error {
+	const usage = "usage: relive [flags] migrate up|down [n]|status"
+
+	if args[0] != "migrate" || len(args) < 2 {
+		return fmt.Errorf(usage)
+	}
+
+	switch {
+	case args[1] == "up" && len(args) == 2:
+		applied, err := dbInitCfg.Configure()
+		for _, st := range applied {
+			fmt.Printf("applied %d %s\n", st.Version, st.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+		return err
+
+	case args[1] == "down" && len(args) <= 3:
+		n := 1
+		if len(args) == 3 {
+			var err error
+			if n, err = strconv.Atoi(args[2]); err != nil || n < 1 {
+				return fmt.Errorf("%s: n must be a positive number", usage)
+			}
+		}
+		reverted, err := dbInitCfg.Down(n)
+		for _, st := range reverted {
+			fmt.Printf("reverted %d %s\n", st.Version, st.Name)
+		}
+		return err
+
+	case args[1] == "status" && len(args) == 2:
+		status, err := dbInitCfg.Status()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tSTATE\tAPPLIED AT")
+		for _, st := range status {
+			appliedAt := ""
+			if !st.AppliedAt.IsZero() {
+				appliedAt = st.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", st.Version, st.Name, st.State, appliedAt)
+		}
+		return w.Flush()
+	}
+	return fmt.Errorf(usage)
+}
+
+// checkSchema - refuse a schema the migrations of this build have not all
+// been applied to, when they are not applied at startup
+func checkSchema(dbInitCfg *dbinit.Config) error {
+	status, err := dbInitCfg.Status()
+	if err != nil {
+		return err
+	}
+	for _, st := range status {
+		if st.State != dbinit.StateApplied {
+			return fmt.Errorf("migration %d %s is %s, run relive migrate status", st.Version, st.Name, st.State)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+// Package money - exact amounts of money in the minor unit (the cent of
+// the dollar, the yen itself) of an ISO 4217 currency. Amounts are never
+// floats: they are parsed from and formatted to decimal strings digit by
+// digit, and taxes are rounded to whole minor units half away from zero.
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrCurrency - a currency not known to be ISO 4217
+	ErrCurrency = errors.New("unknown currency")
+	// ErrAmount - an amount not exact in the minor unit or out of range
+	ErrAmount = errors.New("invalid amount")
+)
+
+// MaxMinor - largest amount in minor units, small enough that applying any
+// tax rate cannot overflow
+const MaxMinor = 100000000000000
+
+// MaxTaxRate - tax rates are in basis points, 100% at most
+const MaxTaxRate = 10000
+
+/* exponents - digits of the minor unit of the currencies accepted */
+var exponents = map[string]int{
+	"AUD": 2, "BRL": 2, "CAD": 2, "CHF": 2, "CNY": 2, "DKK": 2, "EUR": 2,
+	"GBP": 2, "HKD": 2, "INR": 2, "MXN": 2, "NOK": 2, "NZD": 2, "PLN": 2,
+	"SEK": 2, "SGD": 2, "USD": 2, "ZAR": 2,
+	"JPY": 0, "KRW": 0, "CLP": 0, "ISK": 0,
+	"BHD": 3, "JOD": 3, "KWD": 3, "OMR": 3, "TND": 3,
+}
+
+// Money - an amount of Minor units of Currency
+type Money struct {
+	Minor    int64
+	Currency string
+}
+
+// Exponent - digits of the minor unit of currency, 2 for the cents of USD
+func Exponent(currency string) (int, error) {
+	exp, ok := exponents[currency]
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrCurrency, currency)
+	}
+	return exp, nil
+}
+
+// New - minor units of currency
+func New(minor int64, currency string) (Money, error) {
+	if _, err := Exponent(currency); err != nil {
+		return Money{}, err
+	}
+	if minor > MaxMinor || minor < -MaxMinor {
+		return Money{}, fmt.Errorf("%w: %d out of range", ErrAmount, minor)
+	}
+	return Money{Minor: minor, Currency: currency}, nil
+}
+
+// Parse - amount of currency written in decimal, "12.30" or "12.3" for
+// 1230 cents. More decimals than the currency has are refused, not rounded.
+func Parse(amount, currency string) (Money, error) {
+	exp, err := Exponent(currency)
+	if err != nil {
+		return Money{}, err
+	}
+
+	digits := strings.TrimPrefix(amount, "-")
+	whole, frac, hasPoint := strings.Cut(digits, ".")
+	if whole == "" || (hasPoint && frac == "") || len(frac) > exp || !isDigits(whole) || !isDigits(frac) {
+		return Money{}, fmt.Errorf("%w: %q in %s", ErrAmount, amount, currency)
+	}
+
+	minor, err := strconv.ParseInt(whole+frac+strings.Repeat("0", exp-len(frac)), 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("%w: %q in %s", ErrAmount, amount, currency)
+	}
+	if digits != amount {
+		minor = -minor
+	}
+	return New(minor, currency)
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Decimal - the amount in decimal with all digits of the minor unit, "12.30"
+func (m Money) Decimal() string {
+	exp := exponents[m.Currency]
+	minor := m.Minor
+	sign := ""
+	if minor < 0 {
+		sign, minor = "-", -minor
+	}
+	s := strconv.FormatInt(minor, 10)
+	if exp == 0 {
+		return sign + s
+	}
+	if len(s) <= exp {
+		s = strings.Repeat("0", exp-len(s)+1) + s
+	}
+	return sign + s[:len(s)-exp] + "." + s[len(s)-exp:]
+}
+
+// String - "12.30 USD"
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+// Add - sum of m and o, which must share the currency
+func (m Money) Add(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, fmt.Errorf("adding %s to %s", o.Currency, m.Currency)
+	}
+	return New(m.Minor+o.Minor, m.Currency)
+}
+
+/* moneyJSON - the minor units, the decimal amount and the currency. The
+ * amount is given for people, Minor is what is read if both come in. */
+type moneyJSON struct {
+	Minor    *int64 `json:"Minor,omitempty"`
+	Amount   string `json:"Amount,omitempty"`
+	Currency string `json:"Currency"`
+}
+
+// MarshalJSON - {"Minor": 1230, "Amount": "12.30", "Currency": "USD"}
+func (m Money) MarshalJSON() ([]byte, error) {
+	minor := m.Minor
+	return json.Marshal(moneyJSON{Minor: &minor, Amount: m.Decimal(), Currency: m.Currency})
+}
+
+// UnmarshalJSON - from Minor or, without it, Amount of Currency
+func (m *Money) UnmarshalJSON(b []byte) error {
+	var j moneyJSON
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+
+	var err error
+	var parsed Money
+	switch {
+	case j.Minor != nil:
+		parsed, err = New(*j.Minor, j.Currency)
+		if err == nil && j.Amount != "" && j.Amount != parsed.Decimal() {
+			err = fmt.Errorf("%w: Amount %q is not Minor %d", ErrAmount, j.Amount, *j.Minor)
+		}
+	case j.Amount != "":
+		parsed, err = Parse(j.Amount, j.Currency)
+	default:
+		err = fmt.Errorf("%w: neither Minor nor Amount given", ErrAmount)
+	}
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Breakdown - a price split into the amount before tax and the tax on it
+type Breakdown struct {
+	Net   Money
+	Tax   Money
+	Gross Money
+}
+
+// Tax - split price taxed at rate basis points (2000 for 20%). An
+// inclusive price is the gross the tax is taken out of, an exclusive one
+// the net it is added to.
+func Tax(price Money, inclusive bool, rate int) (Breakdown, error) {
+	if rate < 0 || rate > MaxTaxRate {
+		return Breakdown{}, fmt.Errorf("tax rate %d basis points out of range", rate)
+	}
+	if _, err := New(price.Minor, price.Currency); err != nil {
+		return Breakdown{}, err
+	}
+
+	b := Breakdown{Net: price, Tax: Money{Currency: price.Currency}, Gross: price}
+	if inclusive {
+		b.Net.Minor = divRound(price.Minor*MaxTaxRate, int64(MaxTaxRate+rate))
+		b.Tax.Minor = price.Minor - b.Net.Minor
+	} else {
+		b.Tax.Minor = divRound(price.Minor*int64(rate), MaxTaxRate)
+		b.Gross.Minor = price.Minor + b.Tax.Minor
+	}
+	return b, nil
+}
+
+/* divRound - a/b rounded half away from zero, b positive */
+func divRound(a, b int64) int64 {
+	if a < 0 {
+		return -divRound(-a, b)
+	}
+	return (a + b/2) / b
+}
@@ -0,0 +1,107 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, c := range []struct {
+		amount, currency string
+		minor            int64
+		decimal          string
+	}{
+		{"12.30", "USD", 1230, "12.30"},
+		{"12.3", "USD", 1230, "12.30"},
+		{"12", "USD", 1200, "12.00"},
+		{"0.05", "EUR", 5, "0.05"},
+		{"-4.5", "GBP", -450, "-4.50"},
+		{"1500", "JPY", 1500, "1500"},
+		{"1.005", "KWD", 1005, "1.005"},
+	} {
+		m, err := Parse(c.amount, c.currency)
+		if err != nil || m.Minor != c.minor || m.Currency != c.currency {
+			t.Errorf("Parse(%q, %s) = %v %v, want %d", c.amount, c.currency, m, err, c.minor)
+			continue
+		}
+		if m.Decimal() != c.decimal {
+			t.Errorf("Decimal of %d %s = %q, want %q", m.Minor, m.Currency, m.Decimal(), c.decimal)
+		}
+	}
+
+	for _, c := range [][2]string{
+		{"12.345", "USD"}, {"1.5", "JPY"}, {"12.", "USD"}, {".5", "USD"}, {"", "USD"},
+		{"-", "USD"}, {"1e3", "USD"}, {"+1", "USD"}, {"1,5", "EUR"}, {"99999999999999999999", "USD"},
+		{"1000000000000.01", "USD"},
+	} {
+		if m, err := Parse(c[0], c[1]); !errors.Is(err, ErrAmount) {
+			t.Errorf("Parse(%q, %s) = %v %v, want ErrAmount", c[0], c[1], m, err)
+		}
+	}
+	if _, err := Parse("1", "usd"); !errors.Is(err, ErrCurrency) {
+		t.Errorf("expected lower case currency refused, got %v", err)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	b, err := json.Marshal(Money{Minor: 1230, Currency: "USD"})
+	if err != nil || string(b) != `{"Minor":1230,"Amount":"12.30","Currency":"USD"}` {
+		t.Errorf("marshaled %s %v", b, err)
+	}
+
+	for _, in := range []string{
+		`{"Minor":1230,"Currency":"USD"}`,
+		`{"Amount":"12.3","Currency":"USD"}`,
+		`{"Minor":1230,"Amount":"12.30","Currency":"USD"}`,
+	} {
+		var m Money
+		if err = json.Unmarshal([]byte(in), &m); err != nil || m != (Money{Minor: 1230, Currency: "USD"}) {
+			t.Errorf("unmarshaled %s to %v %v", in, m, err)
+		}
+	}
+
+	for _, in := range []string{
+		`{"Minor":1230,"Amount":"12.31","Currency":"USD"}`,
+		`{"Amount":12.3,"Currency":"USD"}`,
+		`{"Currency":"USD"}`,
+		`{"Minor":1230,"Currency":"XXX"}`,
+	} {
+		var m Money
+		if err = json.Unmarshal([]byte(in), &m); err == nil {
+			t.Errorf("unmarshaled %s to %v, want an error", in, m)
+		}
+	}
+}
+
+func TestTax(t *testing.T) {
+	for _, c := range []struct {
+		price           int64
+		inclusive       bool
+		rate            int
+		net, tax, gross int64
+	}{
+		{1000, false, 2000, 1000, 200, 1200},
+		{1200, true, 2000, 1000, 200, 1200},
+		{999, false, 1900, 999, 190, 1189},  // 189.81 rounds up
+		{1999, true, 1900, 1680, 319, 1999}, // 1679.83 rounds up
+		{5, false, 1000, 5, 1, 6},           // half a cent rounds away from zero
+		{-1000, false, 2000, -1000, -200, -1200},
+		{1000, true, 0, 1000, 0, 1000},
+	} {
+		b, err := Tax(Money{Minor: c.price, Currency: "EUR"}, c.inclusive, c.rate)
+		if err != nil || b.Net.Minor != c.net || b.Tax.Minor != c.tax || b.Gross.Minor != c.gross {
+			t.Errorf("Tax(%d, %v, %d) = %+v %v, want %d + %d = %d", c.price, c.inclusive, c.rate, b, err, c.net, c.tax, c.gross)
+		}
+		if b.Net.Currency != "EUR" || b.Tax.Currency != "EUR" || b.Gross.Currency != "EUR" {
+			t.Errorf("breakdown changed currency: %+v", b)
+		}
+	}
+
+	if _, err := Tax(Money{Minor: 1000, Currency: "EUR"}, false, MaxTaxRate+1); err == nil {
+		t.Errorf("expected a rate past 100%% refused")
+	}
+	if _, err := Tax(Money{Minor: MaxMinor, Currency: "EUR"}, true, MaxTaxRate); err != nil {
+		t.Errorf("largest amount at the highest rate: %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/msproject/relive/dbmodel"
+	"github.com/msproject/relive/logger"
+)
+
+// Notifier - delivers account notifications to the account owner
+type Notifier interface {
+	// PasswordReset - send a password reset token to the account's email
+	PasswordReset(account *dbmodel.AccountEntry, token string, expiresAt time.Time) error
+}
+
+// Message - one notification, as written by FileNotifier
+type Message struct {
+	Type      string    `json:"Type"`
+	UserName  string    `json:"UserName"`
+	EmailID   string    `json:"EmailID"`
+	Token     string    `json:"Token"`
+	ExpiresAt time.Time `json:"ExpiresAt"`
+}
+
+// NewNotifier - create a notifier from its spec:
+// "smtp://[user:password@]host:port?from=<address>" to email notifications,
+// or for tests and development "log" to print them with the logger and
+// "file:<path>" to append them as JSON lines to path. The stand-ins print
+// reset tokens in plain text.
+func NewNotifier(spec string, logObj *logger.Logger) (Notifier, error) {
+	switch {
+	case spec == "":
+		return nil, fmt.Errorf("no notifier configured, reset tokens could not be delivered")
+	case strings.HasPrefix(spec, "smtp://"):
+		return newSMTPNotifier(spec)
+	case spec == "log":
+		logObj.PrintInfo("Notifications are logged, not sent: use -notifier smtp://... in production")
+		return &LogNotifier{logObj: logObj}, nil
+	case strings.HasPrefix(spec, "file:"):
+		path := strings.TrimPrefix(spec, "file:")
+		if path == "" {
+			return nil, fmt.Errorf("file notifier needs a path")
+		}
+		return &FileNotifier{path: path}, nil
+	}
+	return nil, fmt.Errorf("unknown notifier %q", spec)
+}
+
+// SMTPNotifier - emails notifications through an SMTP relay, with STARTTLS
+// when the relay offers it
+type SMTPNotifier struct {
+	addr string
+	from string
+	auth smtp.Auth
+	send func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+func newSMTPNotifier(spec string) (*SMTPNotifier, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid smtp notifier: %v", err)
+	}
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil || host == "" || port == "" {
+		return nil, fmt.Errorf("smtp notifier needs host:port, got %q", u.Host)
+	}
+	from, err := mail.ParseAddress(u.Query().Get("from"))
+	if err != nil {
+		return nil, fmt.Errorf("smtp notifier needs a from address: %v", err)
+	}
+
+	n := &SMTPNotifier{addr: u.Host, from: from.Address, send: smtp.SendMail}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		n.auth = smtp.PlainAuth("", u.User.Username(), password, host)
+	}
+	return n, nil
+}
+
+// PasswordReset - email the reset token to the account
+func (n *SMTPNotifier) PasswordReset(account *dbmodel.AccountEntry, token string, expiresAt time.Time) error {
+	to, err := mail.ParseAddress(account.EmailID)
+	if err != nil {
+		return fmt.Errorf("account %s has no valid email: %v", account.UserName, err)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\nSubject: Password reset\r\nDate: %s\r\n", n.from, to.Address,
+		time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "A password reset was requested for %s.\r\n\r\nReset token: %s\r\nValid until: %s\r\n\r\n",
+		account.UserName, token, expiresAt.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&msg, "If you did not ask for it, ignore this message.\r\n")
+
+	if err = n.send(n.addr, n.auth, n.from, []string{to.Address}, msg.Bytes()); err != nil {
+		return fmt.Errorf("could not email %s: %v", to.Address, err)
+	}
+	return nil
+}
+
+// LogNotifier - stand-in that prints notifications instead of sending them
+type LogNotifier struct {
+	logObj *logger.Logger
+}
+
+// PasswordReset - log the reset token
+func (n *LogNotifier) PasswordReset(account *dbmodel.AccountEntry, token string, expiresAt time.Time) error {
+	n.logObj.PrintInfo("password reset for %s <%s>: token %s valid until %s",
+		account.UserName, account.EmailID, token, expiresAt.Format(time.RFC3339))
+	return nil
+}
+
+// FileNotifier - stand-in that appends notifications to a file, so tests
+// can read back what would have been sent
+type FileNotifier struct {
+	path string
+	mu   sync.Mutex
+}
+
+// PasswordReset - append the reset token as a JSON line
+func (n *FileNotifier) PasswordReset(account *dbmodel.AccountEntry, token string, expiresAt time.Time) error {
+	return n.write(Message{
+		Type:      "password_reset",
+		UserName:  account.UserName,
+		EmailID:   account.EmailID,
+		Token:     token,
+		ExpiresAt: expiresAt,
+	})
+}
+
+func (n *FileNotifier) write(msg Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	f, err := os.OpenFile(n.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("could not open notification file %s: %v", n.path, err)
+	}
+	defer f.Close()
+
+	return json.NewEncoder(f).Encode(msg)
+}
@@ -0,0 +1,112 @@
+package passwd
+
+import (
+	"crypto/subtle"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2idParams - cost parameters for argon2id
+type Argon2idParams struct {
+	Memory  uint32 // KiB
+	Time    uint32
+	Threads uint8
+	SaltLen int
+	KeyLen  uint32
+}
+
+//DefaultArgon2idParams - 64MiB, 3 passes, 2 lanes
+var DefaultArgon2idParams = Argon2idParams{
+	Memory:  64 * 1024,
+	Time:    3,
+	Threads: 2,
+	SaltLen: 16,
+	KeyLen:  32,
+}
+
+/* bounds of the parameters a stored digest may ask for, past which it is
+ * taken for malformed rather than computed */
+const (
+	maxArgon2Memory = 1 << 20 // KiB, 1GiB
+	maxArgon2Time   = 64
+	minKeyLen       = 16
+)
+
+// Argon2idHasher - argon2id with fixed parameters
+type Argon2idHasher struct {
+	params Argon2idParams
+}
+
+// NewArgon2idHasher - create an argon2id hasher for the given parameters
+func NewArgon2idHasher(params Argon2idParams) *Argon2idHasher {
+	return &Argon2idHasher{params: params}
+}
+
+// Algorithm - argon2id
+func (h *Argon2idHasher) Algorithm() string {
+	return ARGON2ID
+}
+
+// Hash - $argon2id$v=19,m=<mem>,t=<time>,p=<threads>$<salt>$<hash>
+func (h *Argon2idHasher) Hash(PWD string) (string, error) {
+	salt, err := randomSalt(h.params.SaltLen)
+	if err != nil {
+		return "", err
+	}
+	p := h.params
+	key := argon2.IDKey([]byte(PWD), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+	return fmt.Sprintf("$%s$v=%d,m=%d,t=%d,p=%d$%s$%s", ARGON2ID, argon2.Version,
+		p.Memory, p.Time, p.Threads, encodeB64(salt), encodeB64(key)), nil
+}
+
+func (h *Argon2idHasher) decode(digest string) (p Argon2idParams, salt, key []byte, err error) {
+	params, saltStr, keyStr, err := splitDigest(digest, ARGON2ID)
+	if err != nil {
+		return p, nil, nil, err
+	}
+	var version int
+	_, err = fmt.Sscanf(params, "v=%d,m=%d,t=%d,p=%d", &version, &p.Memory, &p.Time, &p.Threads)
+	if err != nil {
+		return p, nil, nil, fmt.Errorf("malformed argon2id params: %v", err)
+	}
+	if version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+	if p.Threads < 1 || p.Time < 1 || p.Time > maxArgon2Time || p.Memory < 8*uint32(p.Threads) || p.Memory > maxArgon2Memory {
+		return p, nil, nil, fmt.Errorf("invalid argon2id cost m=%d,t=%d,p=%d", p.Memory, p.Time, p.Threads)
+	}
+	if salt, err = decodeB64(saltStr); err != nil {
+		return p, nil, nil, fmt.Errorf("malformed argon2id salt: %v", err)
+	}
+	if key, err = decodeB64(keyStr); err != nil {
+		return p, nil, nil, fmt.Errorf("malformed argon2id hash: %v", err)
+	}
+	if len(key) < minKeyLen {
+		return p, nil, nil, fmt.Errorf("argon2id hash of %d bytes is too short", len(key))
+	}
+	p.SaltLen = len(salt)
+	p.KeyLen = uint32(len(key))
+	return p, salt, key, nil
+}
+
+// Verify - recompute the key with the digest's parameters and compare
+func (h *Argon2idHasher) Verify(PWD, digest string) (bool, error) {
+	p, salt, key, err := h.decode(digest)
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(PWD), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+// NeedsRehash - true if digest is not argon2id or has different parameters
+func (h *Argon2idHasher) NeedsRehash(digest string) bool {
+	p, _, _, err := h.decode(digest)
+	return err != nil || p != h.params
+}
+
+// MaxBytes - no limit, the password is hashed whole
+func (h *Argon2idHasher) MaxBytes() int {
+	return 0
+}
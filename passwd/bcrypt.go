@@ -0,0 +1,71 @@
+package passwd
+
+import (
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+//DefaultBcryptCost - work factor used for new bcrypt digests
+const DefaultBcryptCost = 12
+
+/* bcryptMaxBytes - bcrypt refuses longer passwords */
+const bcryptMaxBytes = 72
+
+// BcryptHasher - bcrypt with a fixed cost
+type BcryptHasher struct {
+	cost int
+}
+
+// NewBcryptHasher - create a bcrypt hasher for the given cost
+func NewBcryptHasher(cost int) *BcryptHasher {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		cost = DefaultBcryptCost
+	}
+	return &BcryptHasher{cost: cost}
+}
+
+func isBcrypt(digest string) bool {
+	return strings.HasPrefix(digest, "$2a$") || strings.HasPrefix(digest, "$2b$") ||
+		strings.HasPrefix(digest, "$2y$")
+}
+
+// Algorithm - bcrypt
+func (h *BcryptHasher) Algorithm() string {
+	return BCRYPT
+}
+
+// Hash - bcrypt digest in its native $2a$<cost>$ encoding
+func (h *BcryptHasher) Hash(PWD string) (string, error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(PWD), h.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Verify - compare password and bcrypt digest
+func (h *BcryptHasher) Verify(PWD, digest string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(digest), []byte(PWD))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// NeedsRehash - true if digest is not bcrypt or has a different cost
+func (h *BcryptHasher) NeedsRehash(digest string) bool {
+	if !isBcrypt(digest) {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(digest))
+	return err != nil || cost != h.cost
+}
+
+// MaxBytes - 72, bcrypt refuses longer passwords
+func (h *BcryptHasher) MaxBytes() int {
+	return bcryptMaxBytes
+}
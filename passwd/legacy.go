@@ -0,0 +1,25 @@
+package passwd
+
+import (
+	"crypto/md5"
+	"crypto/subtle"
+	"fmt"
+	"io"
+)
+
+// VerifyLegacyMD5 - check a password against the salted MD5 digests
+// written before the Hasher interface existed. These rows store a hex
+// digest in PasswdDigest and the salt in the Salt column; they are only
+// ever verified, never produced, and get rehashed on the next login.
+func VerifyLegacyMD5(PWD, salt, digest string) bool {
+	h := md5.New()
+	io.WriteString(h, PWD)
+	pwmd5 := fmt.Sprintf("%x", h.Sum(nil))
+
+	// salt + MD5 splicing
+	io.WriteString(h, salt)
+	io.WriteString(h, pwmd5)
+	inDigest := fmt.Sprintf("%x", h.Sum(nil))
+
+	return subtle.ConstantTimeCompare([]byte(inDigest), []byte(digest)) == 1
+}
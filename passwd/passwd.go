@@ -0,0 +1,113 @@
+package passwd
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+const (
+	//BCRYPT - algorithm name for bcrypt digests
+	BCRYPT = "bcrypt"
+	//ARGON2ID - algorithm name for argon2id digests
+	ARGON2ID = "argon2id"
+	//SCRYPT - algorithm name for scrypt digests
+	SCRYPT = "scrypt"
+	//MD5 - algorithm name for the legacy salted MD5 digests
+	MD5 = "md5"
+
+	//DefaultAlgorithm - algorithm used for new digests unless configured otherwise
+	DefaultAlgorithm = BCRYPT
+)
+
+// Hasher - hashes and verifies passwords for one algorithm.
+// Digests are self describing strings of the form $<algorithm>$<params>$<salt>$<hash>
+// (bcrypt keeps its native $2a$<cost>$... encoding), so a digest always
+// records the algorithm and cost that produced it.
+type Hasher interface {
+	// Algorithm - name of the algorithm this hasher produces
+	Algorithm() string
+
+	// Hash - generate an encoded digest for the password
+	Hash(PWD string) (string, error)
+
+	// Verify - check the password against an encoded digest of this algorithm
+	Verify(PWD, digest string) (bool, error)
+
+	// NeedsRehash - true if the digest was not produced with this
+	// hasher's algorithm and cost
+	NeedsRehash(digest string) bool
+
+	// MaxBytes - longest password hashed in full, 0 if there is no limit
+	MaxBytes() int
+}
+
+var hashers = map[string]Hasher{
+	BCRYPT:   NewBcryptHasher(DefaultBcryptCost),
+	ARGON2ID: NewArgon2idHasher(DefaultArgon2idParams),
+	SCRYPT:   NewScryptHasher(DefaultScryptParams),
+}
+
+// NewHasher - get a hasher with default cost for the given algorithm name
+func NewHasher(algorithm string) (Hasher, error) {
+	h, ok := hashers[strings.ToLower(algorithm)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported password hash algorithm %q", algorithm)
+	}
+	return h, nil
+}
+
+// Identify - return the algorithm name recorded in an encoded digest.
+// Digests not in the encoded format are the legacy MD5 hex digests.
+func Identify(digest string) string {
+	if isBcrypt(digest) {
+		return BCRYPT
+	}
+	if !strings.HasPrefix(digest, "$") {
+		return MD5
+	}
+	parts := strings.SplitN(digest[1:], "$", 2)
+	return parts[0]
+}
+
+// Verify - check the password against an encoded digest of any supported
+// algorithm. Legacy MD5 digests cannot be verified here since their salt
+// is stored separately; use VerifyLegacyMD5 for those.
+func Verify(PWD, digest string) (bool, error) {
+	alg := Identify(digest)
+	if alg == MD5 {
+		return false, fmt.Errorf("legacy digest requires a salt")
+	}
+	h, ok := hashers[alg]
+	if !ok {
+		return false, fmt.Errorf("unsupported password hash algorithm %q", alg)
+	}
+	return h.Verify(PWD, digest)
+}
+
+// randomSalt - n bytes from the system CSPRNG
+func randomSalt(n int) ([]byte, error) {
+	salt := make([]byte, n)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("could not generate salt: %v", err)
+	}
+	return salt, nil
+}
+
+func encodeB64(b []byte) string {
+	return base64.RawStdEncoding.EncodeToString(b)
+}
+
+func decodeB64(s string) ([]byte, error) {
+	return base64.RawStdEncoding.DecodeString(s)
+}
+
+// splitDigest - split $alg$params$salt$hash into its 4 fields
+func splitDigest(digest, algorithm string) (params, salt, hash string, err error) {
+	parts := strings.Split(digest, "$")
+	if len(parts) != 5 || parts[0] != "" || parts[1] != algorithm {
+		return "", "", "", fmt.Errorf("malformed %s digest", algorithm)
+	}
+	return parts[2], parts[3], parts[4], nil
+}
@@ -0,0 +1,143 @@
+package passwd
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+/* cheap parameters, the defaults take too long for a test */
+var (
+	testArgon2id = Argon2idParams{Memory: 64, Time: 1, Threads: 1, SaltLen: 16, KeyLen: 32}
+	testScrypt   = ScryptParams{LogN: 4, R: 8, P: 1, SaltLen: 16, KeyLen: 32}
+)
+
+func testHashers() []Hasher {
+	return []Hasher{
+		NewBcryptHasher(bcrypt.MinCost),
+		NewArgon2idHasher(testArgon2id),
+		NewScryptHasher(testScrypt),
+	}
+}
+
+func TestHashVerify(t *testing.T) {
+	for _, h := range testHashers() {
+		digest, err := h.Hash("secret123")
+		if err != nil {
+			t.Fatalf("%s: %v", h.Algorithm(), err)
+		}
+		if alg := Identify(digest); alg != h.Algorithm() {
+			t.Errorf("%s digest identified as %s", h.Algorithm(), alg)
+		}
+		for _, c := range []struct {
+			pwd string
+			ok  bool
+		}{
+			{"secret123", true},
+			{"secret124", false},
+			{"", false},
+		} {
+			if ok, err := h.Verify(c.pwd, digest); ok != c.ok || err != nil {
+				t.Errorf("%s Verify(%q) = %v %v, want %v", h.Algorithm(), c.pwd, ok, err, c.ok)
+			}
+		}
+		if again, _ := h.Hash("secret123"); again == digest {
+			t.Errorf("%s hashed twice alike, the salt is not random", h.Algorithm())
+		}
+		if h.NeedsRehash(digest) {
+			t.Errorf("%s digest needs a rehash with the parameters it was made with", h.Algorithm())
+		}
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	bcryptDigest, _ := NewBcryptHasher(bcrypt.MinCost).Hash("secret123")
+	argonDigest, _ := NewArgon2idHasher(testArgon2id).Hash("secret123")
+	stronger := testArgon2id
+	stronger.Time++
+
+	for _, c := range []struct {
+		h      Hasher
+		digest string
+		want   bool
+	}{
+		{NewBcryptHasher(bcrypt.MinCost + 1), bcryptDigest, true},
+		{NewBcryptHasher(bcrypt.MinCost), bcryptDigest, false},
+		{NewArgon2idHasher(stronger), argonDigest, true},
+		{NewArgon2idHasher(testArgon2id), bcryptDigest, true},
+		{NewScryptHasher(testScrypt), argonDigest, true},
+		{NewBcryptHasher(bcrypt.MinCost), "0a00f1ca40000aaef59c8cf3d8a14db6", true},
+	} {
+		if got := c.h.NeedsRehash(c.digest); got != c.want {
+			t.Errorf("%s NeedsRehash(%.20s...) = %v, want %v", c.h.Algorithm(), c.digest, got, c.want)
+		}
+	}
+}
+
+func TestVerifyLegacyMD5(t *testing.T) {
+	const digest = "0a00f1ca40000aaef59c8cf3d8a14db6" // md5(PWD + salt + hex md5(PWD))
+	if !VerifyLegacyMD5("secret123", "abcd", digest) {
+		t.Errorf("expected the legacy digest to verify")
+	}
+	if VerifyLegacyMD5("secret123", "abce", digest) || VerifyLegacyMD5("secret124", "abcd", digest) {
+		t.Errorf("expected a wrong salt or password refused")
+	}
+	if Identify(digest) != MD5 {
+		t.Errorf("expected a hex digest identified as md5")
+	}
+	if _, err := Verify("secret123", digest); err == nil {
+		t.Errorf("expected Verify to refuse a legacy digest without its salt")
+	}
+}
+
+func TestMalformedDigests(t *testing.T) {
+	key := strings.Repeat("A", 43) // 32 bytes
+	salt := strings.Repeat("A", 22)
+	for _, digest := range []string{
+		"",
+		"$argon2id$",
+		"$argon2id$v=19,m=64,t=1,p=1$" + salt,
+		"$argon2id$v=18,m=64,t=1,p=1$" + salt + "$" + key,
+		"$argon2id$v=19,m=64,t=1,p=0$" + salt + "$" + key,
+		"$argon2id$v=19,m=64,t=0,p=1$" + salt + "$" + key,
+		"$argon2id$v=19,m=4,t=1,p=1$" + salt + "$" + key,
+		"$argon2id$v=19,m=4294967295,t=1,p=1$" + salt + "$" + key,
+		"$argon2id$v=19,m=64,t=1,p=1$" + salt + "$",
+		"$argon2id$v=19,m=64,t=1,p=1$" + salt + "$!!!",
+		"$scrypt$ln=31,r=8,p=1$" + salt + "$" + key,
+		"$scrypt$ln=4,r=0,p=1$" + salt + "$" + key,
+		"$scrypt$ln=20,r=32,p=1$" + salt + "$" + key,
+		"$scrypt$ln=4,r=8,p=1$" + salt + "$",
+		"$scrypt$ln=x,r=8,p=1$" + salt + "$" + key,
+		"$2a$04$short",
+		"$sha1$x$y$z",
+	} {
+		if ok, err := Verify("secret123", digest); ok || err == nil {
+			t.Errorf("Verify(%q) = %v %v, want an error", digest, ok, err)
+		}
+	}
+}
+
+func TestPolicyMaxBytes(t *testing.T) {
+	bcryptPolicy, err := ParsePolicy(8, "lower,digit", NewBcryptHasher(bcrypt.MinCost))
+	if err != nil || bcryptPolicy.MaxBytes != 72 {
+		t.Fatalf("expected bcrypt to cap passwords at 72 bytes, got %+v %v", bcryptPolicy, err)
+	}
+	argonPolicy, _ := ParsePolicy(8, "lower,digit", NewArgon2idHasher(testArgon2id))
+	if argonPolicy.MaxBytes != DefaultPolicy.MaxBytes {
+		t.Fatalf("expected the default cap for argon2id, got %+v", argonPolicy)
+	}
+
+	/* 38 characters, 75 bytes */
+	long := strings.Repeat("é", 37) + "1"
+	if err = bcryptPolicy.Validate(long); err == nil {
+		t.Errorf("expected %d bytes refused under bcrypt", len(long))
+	}
+	if err = argonPolicy.Validate(long); err != nil {
+		t.Errorf("expected %d bytes accepted under argon2id: %v", len(long), err)
+	}
+	if err = bcryptPolicy.Validate(strings.Repeat("a", 71) + "1"); err != nil {
+		t.Errorf("expected 72 bytes accepted under bcrypt: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package passwd
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Policy - rules a new password must satisfy. MinLength counts
+// characters, MaxBytes the bytes of the password hashers see
+type Policy struct {
+	MinLength     int
+	MaxBytes      int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+}
+
+//DefaultPolicy - at least 8 characters with a letter and a digit
+var DefaultPolicy = Policy{
+	MinLength:    8,
+	MaxBytes:     128,
+	RequireLower: true,
+	RequireDigit: true,
+}
+
+// ParsePolicy - build a policy from a minimum length and a comma separated
+// list of required character classes (upper, lower, digit, symbol). No
+// password may be longer than hasher hashes in full.
+func ParsePolicy(minLength int, require string, hasher Hasher) (Policy, error) {
+	policy := Policy{MinLength: minLength, MaxBytes: DefaultPolicy.MaxBytes}
+	if max := hasher.MaxBytes(); max > 0 && max < policy.MaxBytes {
+		policy.MaxBytes = max
+	}
+	for _, class := range strings.Split(require, ",") {
+		switch strings.TrimSpace(strings.ToLower(class)) {
+		case "":
+		case "upper":
+			policy.RequireUpper = true
+		case "lower":
+			policy.RequireLower = true
+		case "digit":
+			policy.RequireDigit = true
+		case "symbol":
+			policy.RequireSymbol = true
+		default:
+			return policy, fmt.Errorf("unknown password character class %q", class)
+		}
+	}
+	return policy, nil
+}
+
+// Validate - nil if PWD satisfies the policy, else an error naming the
+// first rule it breaks
+func (p Policy) Validate(PWD string) error {
+	n := utf8.RuneCountInString(PWD)
+	if n < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters", p.MinLength)
+	}
+	if p.MaxBytes > 0 && len(PWD) > p.MaxBytes {
+		return fmt.Errorf("password must be at most %d bytes", p.MaxBytes)
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, c := range PWD {
+		switch {
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsDigit(c):
+			digit = true
+		case unicode.IsPunct(c) || unicode.IsSymbol(c):
+			symbol = true
+		}
+	}
+
+	if p.RequireUpper && !upper {
+		return fmt.Errorf("password must contain an upper case letter")
+	}
+	if p.RequireLower && !lower {
+		return fmt.Errorf("password must contain a lower case letter")
+	}
+	if p.RequireDigit && !digit {
+		return fmt.Errorf("password must contain a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		return fmt.Errorf("password must contain a symbol")
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package passwd
+
+import (
+	"crypto/subtle"
+	"fmt"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// ScryptParams - cost parameters for scrypt
+type ScryptParams struct {
+	LogN    uint8 // N = 1 << LogN
+	R       int
+	P       int
+	SaltLen int
+	KeyLen  int
+}
+
+//DefaultScryptParams - N=32768, r=8, p=1
+var DefaultScryptParams = ScryptParams{
+	LogN:    15,
+	R:       8,
+	P:       1,
+	SaltLen: 16,
+	KeyLen:  32,
+}
+
+/* maxScryptMemory - most memory a stored digest may ask for, 1GiB */
+const maxScryptMemory = 1 << 30
+
+// ScryptHasher - scrypt with fixed parameters
+type ScryptHasher struct {
+	params ScryptParams
+}
+
+// NewScryptHasher - create a scrypt hasher for the given parameters
+func NewScryptHasher(params ScryptParams) *ScryptHasher {
+	return &ScryptHasher{params: params}
+}
+
+// Algorithm - scrypt
+func (h *ScryptHasher) Algorithm() string {
+	return SCRYPT
+}
+
+// Hash - $scrypt$ln=<logN>,r=<r>,p=<p>$<salt>$<hash>
+func (h *ScryptHasher) Hash(PWD string) (string, error) {
+	salt, err := randomSalt(h.params.SaltLen)
+	if err != nil {
+		return "", err
+	}
+	p := h.params
+	key, err := scrypt.Key([]byte(PWD), salt, 1<<p.LogN, p.R, p.P, p.KeyLen)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("$%s$ln=%d,r=%d,p=%d$%s$%s", SCRYPT, p.LogN, p.R, p.P,
+		encodeB64(salt), encodeB64(key)), nil
+}
+
+func (h *ScryptHasher) decode(digest string) (p ScryptParams, salt, key []byte, err error) {
+	params, saltStr, keyStr, err := splitDigest(digest, SCRYPT)
+	if err != nil {
+		return p, nil, nil, err
+	}
+	_, err = fmt.Sscanf(params, "ln=%d,r=%d,p=%d", &p.LogN, &p.R, &p.P)
+	if err != nil {
+		return p, nil, nil, fmt.Errorf("malformed scrypt params: %v", err)
+	}
+	/* scrypt takes 128 * r * N bytes of memory */
+	if p.LogN < 1 || p.LogN > 30 || p.R < 1 || p.R > 32 || p.P < 1 || p.P > 16 || 128*p.R<<p.LogN > maxScryptMemory {
+		return p, nil, nil, fmt.Errorf("invalid scrypt cost ln=%d,r=%d,p=%d", p.LogN, p.R, p.P)
+	}
+	if salt, err = decodeB64(saltStr); err != nil {
+		return p, nil, nil, fmt.Errorf("malformed scrypt salt: %v", err)
+	}
+	if key, err = decodeB64(keyStr); err != nil {
+		return p, nil, nil, fmt.Errorf("malformed scrypt hash: %v", err)
+	}
+	if len(key) < minKeyLen {
+		return p, nil, nil, fmt.Errorf("scrypt hash of %d bytes is too short", len(key))
+	}
+	p.SaltLen = len(salt)
+	p.KeyLen = len(key)
+	return p, salt, key, nil
+}
+
+// Verify - recompute the key with the digest's parameters and compare
+func (h *ScryptHasher) Verify(PWD, digest string) (bool, error) {
+	p, salt, key, err := h.decode(digest)
+	if err != nil {
+		return false, err
+	}
+	other, err := scrypt.Key([]byte(PWD), salt, 1<<p.LogN, p.R, p.P, p.KeyLen)
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+// NeedsRehash - true if digest is not scrypt or has different parameters
+func (h *ScryptHasher) NeedsRehash(digest string) bool {
+	p, _, _, err := h.decode(digest)
+	return err != nil || p != h.params
+}
+
+// MaxBytes - no limit, the password is hashed whole
+func (h *ScryptHasher) MaxBytes() int {
+	return 0
+}
@@ -0,0 +1,106 @@
+package playback
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//DefaultTTL - how long a playback URL stays valid
+const DefaultTTL = 4 * time.Hour
+
+/* keys shorter than the HMAC output would weaken it */
+const minKeyLen = 32
+
+var (
+	//ErrBadSignature - the signature does not match account, asset and expiry
+	ErrBadSignature = errors.New("invalid playback signature")
+	//ErrExpired - the URL was signed validly but is past its expiry
+	ErrExpired = errors.New("playback URL expired")
+)
+
+// Signer - signs and verifies playback URLs. A signature covers an
+// account, one of its media assets and the expiry, so it is valid for
+// every file of the asset: the master playlist, the variant playlists and
+// segments it references and the poster.
+type Signer struct {
+	key []byte
+	ttl time.Duration
+	now func() time.Time
+}
+
+// NewSigner - signer with an HMAC key of at least 32 bytes
+func NewSigner(key []byte, ttl time.Duration) (*Signer, error) {
+	if len(key) < minKeyLen {
+		return nil, fmt.Errorf("playback key must be at least %d bytes", minKeyLen)
+	}
+	if ttl <= 0 {
+		return nil, fmt.Errorf("playback URL lifetime must be positive")
+	}
+	return &Signer{key: key, ttl: ttl, now: time.Now}, nil
+}
+
+// LoadKey - the key from a file, or a random one if path is empty; URLs
+// signed with a random key die with the process
+func LoadKey(path string) ([]byte, error) {
+	if path == "" {
+		key := make([]byte, minKeyLen)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+
+	key, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read playback key: %v", err)
+	}
+	return key, nil
+}
+
+// Sign - expiry (unix seconds) and signature for the asset of account id
+func (s *Signer) Sign(id int, asset string) (expires int64, sig string) {
+	expires = s.now().Add(s.ttl).Unix()
+	return expires, s.mac(id, asset, expires)
+}
+
+// Verify - nil if sig is the signature of the asset of account id and
+// expires has not passed
+func (s *Signer) Verify(id int, asset string, expires int64, sig string) error {
+	if !hmac.Equal([]byte(sig), []byte(s.mac(id, asset, expires))) {
+		return ErrBadSignature
+	}
+	if s.now().Unix() >= expires {
+		return ErrExpired
+	}
+	return nil
+}
+
+func (s *Signer) mac(id int, asset string, expires int64) string {
+	m := hmac.New(sha256.New, s.key)
+	/* NUL separated, asset names can't contain one */
+	m.Write([]byte(strconv.Itoa(id) + "\x00" + asset + "\x00" + strconv.FormatInt(expires, 10)))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+// CacheControl - caching of a file served under a URL still valid for
+// remaining. The pipeline writes segments, posters, sprites and downloads
+// once, so caches may keep them as long as the URL lives; playlists are
+// revalidated against their ETag.
+func CacheControl(file string, remaining time.Duration) string {
+	if strings.ToLower(path.Ext(file)) == ".m3u8" {
+		return "no-cache"
+	}
+	if remaining <= 0 {
+		return "no-store"
+	}
+	return fmt.Sprintf("public, max-age=%d, immutable", int64(remaining/time.Second))
+}
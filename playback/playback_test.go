@@ -0,0 +1,65 @@
+package playback
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestSignVerify(t *testing.T) {
+	s, err := NewSigner(bytes.Repeat([]byte("k"), 32), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1500000000, 0)
+	s.now = func() time.Time { return now }
+
+	expires, sig := s.Sign(7, "wedding")
+	if expires != now.Add(time.Hour).Unix() {
+		t.Errorf("expires %d", expires)
+	}
+	if err = s.Verify(7, "wedding", expires, sig); err != nil {
+		t.Errorf("Verify of a fresh signature: %v", err)
+	}
+
+	for _, c := range []struct {
+		id      int
+		asset   string
+		expires int64
+	}{
+		{8, "wedding", expires},
+		{7, "party", expires},
+		{7, "wedding", expires + 3600},
+	} {
+		if err = s.Verify(c.id, c.asset, c.expires, sig); err != ErrBadSignature {
+			t.Errorf("Verify(%d, %s, %d) = %v", c.id, c.asset, c.expires, err)
+		}
+	}
+
+	now = now.Add(2 * time.Hour)
+	if err = s.Verify(7, "wedding", expires, sig); err != ErrExpired {
+		t.Errorf("Verify after expiry = %v", err)
+	}
+
+	if _, err = NewSigner([]byte("short"), time.Hour); err == nil {
+		t.Error("NewSigner accepted a short key")
+	}
+}
+
+func TestCacheControl(t *testing.T) {
+	for _, c := range []struct {
+		file      string
+		remaining time.Duration
+		want      string
+	}{
+		{"clip.m3u8", time.Hour, "no-cache"},
+		{"clip_720p.M3U8", time.Hour, "no-cache"},
+		{"clip_720p_3.ts", time.Hour, "public, max-age=3600, immutable"},
+		{"clip_download.mp4", 90 * time.Second, "public, max-age=90, immutable"},
+		{"clip_poster_1.jpg", 0, "no-store"},
+	} {
+		if got := CacheControl(c.file, c.remaining); got != c.want {
+			t.Errorf("CacheControl(%s, %v) = %q, want %q", c.file, c.remaining, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// Package quota - storage quotas of businesses. A business may store what
+// the Product StoreSize of its subscription allows, counted in units of a
+// configurable number of bytes; its customers' media count against it.
+// Past a soft limit uploads are warned about, past the quota the policy
+// decides whether they are blocked, only warned about or billed as overage.
+// A business whose subscriptions all expired is read-only.
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/msproject/relive/dbi"
+	"github.com/msproject/relive/dbmodel"
+	"github.com/msproject/relive/logger"
+)
+
+// Policy - what happens to uploads past the quota
+type Policy string
+
+// Policies
+const (
+	//PolicyBlock - refuse uploads past the quota
+	PolicyBlock Policy = "block"
+	//PolicyWarn - accept them with a warning
+	PolicyWarn Policy = "warn"
+	//PolicyOverage - accept them and record the overage for billing
+	PolicyOverage Policy = "overage"
+)
+
+// Usage states
+const (
+	//StateOK - below the soft limit
+	StateOK = "ok"
+	//StateSoft - past the soft limit, within the quota
+	StateSoft = "soft"
+	//StateOver - past the quota
+	StateOver = "over"
+)
+
+const (
+	//DefaultUnit - bytes per unit of Product StoreSize
+	DefaultUnit = int64(1) << 30
+	//DefaultSoftPercent - share of the quota past which uploads are warned about
+	DefaultSoftPercent = 80
+)
+
+var (
+	// ErrExceeded - the upload does not fit the quota and the policy blocks it
+	ErrExceeded = errors.New("storage quota exceeded")
+	// ErrReadOnly - the subscriptions of the business expired
+	ErrReadOnly = errors.New("subscription expired, storage is read-only")
+)
+
+// ParsePolicy - the policy named s
+func ParsePolicy(s string) (Policy, error) {
+	switch p := Policy(s); p {
+	case PolicyBlock, PolicyWarn, PolicyOverage:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown quota policy %q, expected block, warn or overage", s)
+}
+
+// Usage - storage of a business, in bytes. Pending counts uploads at their
+// full length and originals waiting to be transcoded.
+type Usage struct {
+	Business  int
+	Unlimited bool
+	Quota     int64
+	SoftLimit int64
+	Media     int64
+	Pending   int64
+	Overage   int64 // peak past the quota in the current billing period
+	ReadOnly  bool  // no subscription runs and one expired
+}
+
+// Used - bytes stored or about to be
+func (u *Usage) Used() int64 {
+	return u.Media + u.Pending
+}
+
+// State - the state usage is in once add more bytes are stored
+func (u *Usage) State(add int64) string {
+	used := u.Used() + add
+	switch {
+	case u.Unlimited:
+		return StateOK
+	case used > u.Quota:
+		return StateOver
+	case used > u.SoftLimit:
+		return StateSoft
+	}
+	return StateOK
+}
+
+// Quotas - checks uploads against the quotas of businesses
+type Quotas struct {
+	accountDBI  dbi.AccountTblDBI
+	storageDBI  dbi.StorageTblDBI
+	unit        int64
+	free        int
+	softPercent int
+	policy      Policy
+	logObj      *logger.Logger
+}
+
+// NewQuotas - quotas of unit bytes per unit of StoreSize. Businesses
+// without a running subscription get free units.
+func NewQuotas(accountDBI dbi.AccountTblDBI, storageDBI dbi.StorageTblDBI, unit int64, free, softPercent int,
+	policy Policy, logObj *logger.Logger) *Quotas {
+	if unit <= 0 {
+		unit = DefaultUnit
+	}
+	if softPercent <= 0 || softPercent > 100 {
+		softPercent = DefaultSoftPercent
+	}
+	return &Quotas{
+		accountDBI:  accountDBI,
+		storageDBI:  storageDBI,
+		unit:        unit,
+		free:        free,
+		softPercent: softPercent,
+		policy:      policy,
+		logObj:      logObj,
+	}
+}
+
+// Policy - what happens to uploads past the quota
+func (q *Quotas) Policy() Policy {
+	return q.policy
+}
+
+// period - the billing period overage is recorded for
+func period(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+// Usage - storage of the business account id belongs to: a business
+// itself, the business of a customer, a customer that left its business on
+// its own. Root is unlimited.
+func (q *Quotas) Usage(id int) (*Usage, error) {
+	account, err := q.accountDBI.GetAccountByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if account == nil {
+		return nil, fmt.Errorf("account %d does not exist", id)
+	}
+	if account.Role == dbmodel.RoleRoot {
+		return &Usage{Business: id, Unlimited: true}, nil
+	}
+
+	business := account.ID
+	if account.Role == dbmodel.RoleCustomer && account.PID != 0 {
+		business = account.PID
+	}
+
+	subscribed, err := q.storageDBI.GetSubscribedStorage(business)
+	if err != nil {
+		return nil, err
+	}
+	storeSize := subscribed.StoreSize
+	if subscribed.Running == 0 {
+		storeSize = q.free
+	}
+
+	stored, err := q.storageDBI.GetStorageUsage(business)
+	if err != nil {
+		return nil, err
+	}
+	overage, err := q.storageDBI.GetStorageOverage(business, period(time.Now()))
+	if err != nil {
+		return nil, err
+	}
+
+	quota := int64(storeSize) * q.unit
+	return &Usage{
+		Business:  business,
+		Quota:     quota,
+		SoftLimit: quota / 100 * int64(q.softPercent),
+		Media:     stored.MediaBytes,
+		Pending:   stored.PendingBytes,
+		Overage:   overage,
+		ReadOnly:  subscribed.Running == 0 && subscribed.Expired > 0,
+	}, nil
+}
+
+// Check - whether add more bytes may be stored for account id, add being 0
+// for uploads already counted as pending. Returns ErrReadOnly if the
+// business is read-only, ErrExceeded if the policy blocks them, the usage
+// they lead to otherwise. Under PolicyOverage the bytes past the quota are
+// recorded for billing.
+func (q *Quotas) Check(id int, add int64) (*Usage, error) {
+	usage, err := q.Usage(id)
+	if err != nil {
+		return nil, err
+	}
+	if usage.ReadOnly {
+		return usage, ErrReadOnly
+	}
+	if usage.State(add) != StateOver {
+		return usage, nil
+	}
+
+	over := usage.Used() + add - usage.Quota
+	switch q.policy {
+	case PolicyWarn:
+		q.logObj.PrintInfo("business %d is %d bytes past its storage quota", usage.Business, over)
+	case PolicyOverage:
+		if over > usage.Overage {
+			if err = q.storageDBI.RecordStorageOverage(usage.Business, period(time.Now()), over); err != nil {
+				return nil, err
+			}
+			usage.Overage = over
+		}
+	default:
+		return usage, ErrExceeded
+	}
+	return usage, nil
+}
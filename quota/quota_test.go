@@ -0,0 +1,128 @@
+package quota
+
+import (
+	"testing"
+
+	"github.com/msproject/relive/dbi"
+	"github.com/msproject/relive/dbmodel"
+	"github.com/msproject/relive/logger"
+)
+
+/* memAccounts - in memory accounts, only GetAccountByID is used */
+type memAccounts struct {
+	dbi.AccountTblDBI
+	accounts map[int]dbmodel.AccountEntry
+}
+
+func (m memAccounts) GetAccountByID(id int) (*dbmodel.AccountEntry, error) {
+	account, ok := m.accounts[id]
+	if !ok {
+		return nil, nil
+	}
+	return &account, nil
+}
+
+/* memStorage - in memory StorageTblDBI */
+type memStorage struct {
+	storeSize map[int]int
+	expired   map[int]bool
+	usage     map[int]dbmodel.StorageUsageEntry
+	overage   map[int]int64
+}
+
+func (m *memStorage) GetStorageUsage(id int) (dbmodel.StorageUsageEntry, error) {
+	return m.usage[id], nil
+}
+
+func (m *memStorage) GetSubscribedStorage(id int) (dbmodel.SubscribedStorageEntry, error) {
+	var storage dbmodel.SubscribedStorageEntry
+	if storeSize, ok := m.storeSize[id]; ok {
+		storage.StoreSize, storage.Running = storeSize, 1
+	}
+	if m.expired[id] {
+		storage.Expired = 1
+	}
+	return storage, nil
+}
+
+func (m *memStorage) RecordStorageOverage(id int, period string, bytes int64) error {
+	if bytes > m.overage[id] {
+		m.overage[id] = bytes
+	}
+	return nil
+}
+
+func (m *memStorage) GetStorageOverage(id int, period string) (int64, error) {
+	return m.overage[id], nil
+}
+
+func TestCheck(t *testing.T) {
+	logObj, err := logger.NewLoggerObject(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accounts := memAccounts{accounts: map[int]dbmodel.AccountEntry{
+		1: {ID: 1, Role: dbmodel.RoleRoot},
+		2: {ID: 2, PID: 1, Role: dbmodel.RoleAdmin},
+		3: {ID: 3, PID: 2, Role: dbmodel.RoleCustomer},
+		4: {ID: 4, PID: 1, Role: dbmodel.RoleAdmin},
+		5: {ID: 5, PID: 1, Role: dbmodel.RoleAdmin},
+		6: {ID: 6, PID: 5, Role: dbmodel.RoleCustomer},
+	}}
+	storage := &memStorage{
+		storeSize: map[int]int{2: 10},
+		expired:   map[int]bool{2: true, 5: true},
+		usage: map[int]dbmodel.StorageUsageEntry{
+			2: {MediaBytes: 700, PendingBytes: 100},
+			4: {MediaBytes: 50},
+		},
+		overage: map[int]int64{},
+	}
+
+	q := NewQuotas(accounts, storage, 100, 1, 75, PolicyBlock, logObj)
+
+	/* a customer is checked against its business: 800 of 1000 used */
+	usage, err := q.Check(3, 150)
+	if err != nil || usage.Business != 2 || usage.Quota != 1000 || usage.State(150) != StateSoft {
+		t.Fatalf("expected 950 of 1000 to be past the soft limit, got %+v %v", usage, err)
+	}
+	if _, err = q.Check(2, 201); err != ErrExceeded {
+		t.Fatalf("expected 1001 of 1000 blocked, got %v", err)
+	}
+	if usage, err = q.Check(2, 200); err != nil || usage.State(200) != StateSoft {
+		t.Fatalf("expected the quota itself to fit, got %+v %v", usage, err)
+	}
+
+	/* no subscription: the free units */
+	if usage, err = q.Check(4, 50); err != nil || usage.Quota != 100 || usage.State(50) != StateSoft {
+		t.Fatalf("expected 100 of a free 100, got %+v %v", usage, err)
+	}
+	if usage, err = q.Check(1, 1<<40); err != nil || !usage.Unlimited {
+		t.Fatalf("expected root unlimited, got %+v %v", usage, err)
+	}
+
+	/* expired subscriptions only: read-only, the business and its customers */
+	if usage, err = q.Check(6, 1); err != ErrReadOnly || !usage.ReadOnly || usage.Quota != 100 {
+		t.Fatalf("expected an expired business read-only, got %+v %v", usage, err)
+	}
+
+	q.policy = PolicyOverage
+	if usage, err = q.Check(2, 500); err != nil || usage.Overage != 300 || storage.overage[2] != 300 {
+		t.Fatalf("expected 300 bytes overage recorded, got %+v %v", usage, err)
+	}
+	if usage, err = q.Check(2, 250); err != nil || usage.Overage != 300 {
+		t.Fatalf("expected the peak overage kept, got %+v %v", usage, err)
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	for _, s := range []string{"block", "warn", "overage"} {
+		if p, err := ParsePolicy(s); err != nil || string(p) != s {
+			t.Errorf("ParsePolicy(%q) = %q, %v", s, p, err)
+		}
+	}
+	if _, err := ParsePolicy("bill"); err == nil {
+		t.Errorf("expected an unknown policy rejected")
+	}
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// RedirectHandler - plaintext handler sending every request to the same
+// URL on the HTTPS listener with a 308, so the method and body are kept.
+// /health is still answered in plaintext for load balancer probes.
+func RedirectHandler(listenSSL string, health http.HandlerFunc) (http.Handler, error) {
+	_, port, err := net.SplitHostPort(listenSSL)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", health)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		host := strings.Trim(r.Host, "[]")
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+	return mux, nil
+}
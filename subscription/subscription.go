@@ -0,0 +1,208 @@
+// Package subscription - the lifecycle of subscriptions. A subscription
+// starts in the trial of its product or in a period of the product's
+// Duration, which it keeps like the other features of the product. When a
+// period ends the scheduler renews it for another period
+// if charging the business for it succeeds, CardOnFile taking a payment
+// method on file for paid; otherwise the subscription is past due, charged
+// again on every check and runs on through a grace period before it
+// expires. Canceled subscriptions run
+// to the end of their period and expire. A business whose subscriptions
+// all expired is read-only for uploads.
+package subscription
+
+import (
+	"time"
+
+	"github.com/msproject/relive/dbi"
+	"github.com/msproject/relive/dbmodel"
+	"github.com/msproject/relive/logger"
+)
+
+const (
+	//DefaultGrace - how long a past due subscription runs on
+	DefaultGrace = 7 * 24 * time.Hour
+	//DefaultInterval - how often subscriptions are checked for renewal
+	DefaultInterval = time.Hour
+)
+
+/* day - unit of Product TrialDays and Duration */
+const day = 24 * time.Hour
+
+// Running - whether sub entitles its business to its product
+func Running(sub dbmodel.SubscriptionEntry) bool {
+	return sub.State != dbmodel.SubscriptionExpired
+}
+
+// Start - a subscription of business id to pr from start, in the trial of
+// pr if trial is set and pr has one. It has the features pr has now.
+func Start(id int, pr dbmodel.ProductEntry, start time.Time, trial bool) dbmodel.SubscriptionEntry {
+	sub := dbmodel.SubscriptionEntry{
+		ID:            id,
+		ProductID:     pr.ProductID,
+		ProductType:   pr.ProductType,
+		StartDate:     start,
+		State:         dbmodel.SubscriptionActive,
+		StoreSize:     pr.StoreSize,
+		MaxAdmins:     pr.MaxAdmins,
+		MaxCustomers:  pr.MaxCustomers,
+		Renditions:    pr.Renditions,
+		RetentionDays: pr.RetentionDays,
+		Duration:      pr.Duration,
+	}
+	sub.EndDate = start.Add(period(sub))
+	if trial && pr.TrialDays > 0 {
+		sub.State = dbmodel.SubscriptionTrialing
+		sub.EndDate = start.Add(time.Duration(pr.TrialDays) * day)
+	}
+	return sub
+}
+
+/* period - length of a period of sub, a day at least */
+func period(sub dbmodel.SubscriptionEntry) time.Duration {
+	if sub.Duration < 1 {
+		return day
+	}
+	return time.Duration(sub.Duration) * day
+}
+
+// Next - the state sub is in at now, and whether it changed. paid tells
+// whether a renewal can be paid for. A renewed period follows the one that
+// ended, unless that would leave it over already.
+func Next(sub dbmodel.SubscriptionEntry, paid bool, now time.Time, grace time.Duration) (dbmodel.SubscriptionEntry, bool) {
+	next := sub
+
+	switch sub.State {
+	case dbmodel.SubscriptionExpired:
+		return sub, false
+
+	case dbmodel.SubscriptionCanceled:
+		if now.Before(sub.EndDate) {
+			return sub, false
+		}
+		next.State = dbmodel.SubscriptionExpired
+
+	case dbmodel.SubscriptionPastDue:
+		if paid {
+			return renew(sub, now), true
+		}
+		if sub.GraceUntil != nil && now.Before(*sub.GraceUntil) {
+			return sub, false
+		}
+		next.State = dbmodel.SubscriptionExpired
+
+	default:
+		if now.Before(sub.EndDate) {
+			return sub, false
+		}
+		if paid {
+			return renew(sub, now), true
+		}
+		graceUntil := sub.EndDate.Add(grace)
+		next.State, next.GraceUntil = dbmodel.SubscriptionPastDue, &graceUntil
+	}
+	return next, true
+}
+
+func renew(sub dbmodel.SubscriptionEntry, now time.Time) dbmodel.SubscriptionEntry {
+	start := sub.EndDate
+	if !start.Add(period(sub)).After(now) {
+		start = now
+	}
+	sub.State, sub.GraceUntil = dbmodel.SubscriptionActive, nil
+	sub.StartDate, sub.EndDate = start, start.Add(period(sub))
+	return sub
+}
+
+// Charge - charge business sub.ID for the period following the one of sub,
+// false if it was declined. Instances running at once may charge the same
+// renewal, a Charge is to pay each SubscriptionCode and EndDate once.
+type Charge func(sub dbmodel.SubscriptionEntry) (bool, error)
+
+// CardOnFile - a Charge taking a renewal for paid if the business has a
+// payment method on file, charging it being up to the payment processor
+func CardOnFile(paymentDBI dbi.PaymentTblDBI) Charge {
+	return func(sub dbmodel.SubscriptionEntry) (bool, error) {
+		payments, err := paymentDBI.SearchPayment(dbi.RootScope(), sub.ID)
+		if err != nil {
+			return false, err
+		}
+		return len(payments) > 0, nil
+	}
+}
+
+// Scheduler - moves subscriptions on as their periods and grace periods end
+type Scheduler struct {
+	subscriptionDBI dbi.SubscriptionTblDBI
+	charge          Charge
+	grace           time.Duration
+	interval        time.Duration
+	logObj          *logger.Logger
+	now             func() time.Time
+}
+
+// NewScheduler - scheduler checking subscriptions every interval once
+// started, renewals paid with charge and past due ones running on for grace
+func NewScheduler(subscriptionDBI dbi.SubscriptionTblDBI, charge Charge,
+	grace, interval time.Duration, logObj *logger.Logger) *Scheduler {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return &Scheduler{
+		subscriptionDBI: subscriptionDBI,
+		charge:          charge,
+		grace:           grace,
+		interval:        interval,
+		logObj:          logObj,
+		now:             time.Now,
+	}
+}
+
+// Start - check subscriptions now and every interval
+func (s *Scheduler) Start() {
+	go func() {
+		for {
+			if err := s.Run(); err != nil {
+				s.logObj.PrintError("Failed to move subscriptions on, retrying: %v", err)
+			}
+			time.Sleep(s.interval)
+		}
+	}()
+}
+
+// Run - move on every subscription whose period or grace period ended.
+// Instances running at once move each subscription once, a subscription
+// another instance moved first is left alone.
+func (s *Scheduler) Run() error {
+	now := s.now().UTC().Truncate(time.Second)
+	due, err := s.subscriptionDBI.ListDueSubscriptions(now)
+	if err != nil {
+		return err
+	}
+
+	var failed error
+	for _, sub := range due {
+		paid := false
+		if sub.State != dbmodel.SubscriptionCanceled {
+			/* a charge that did not go through is tried again next time */
+			if paid, err = s.charge(sub); err != nil {
+				s.logObj.PrintError("Failed to charge subscription %d of account %d: %v", sub.SubscriptionCode, sub.ID, err)
+				failed = err
+				continue
+			}
+		}
+
+		next, changed := Next(sub, paid, now, s.grace)
+		if !changed {
+			continue
+		}
+		moved, err := s.subscriptionDBI.AdvanceSubscription(&sub, &next)
+		if err != nil {
+			failed = err
+			continue
+		}
+		if moved {
+			s.logObj.PrintInfo("Subscription %d of account %d is %s until %v", sub.SubscriptionCode, sub.ID, next.State, next.EndDate)
+		}
+	}
+	return failed
+}
@@ -0,0 +1,199 @@
+package subscription
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/msproject/relive/dbi"
+	"github.com/msproject/relive/dbmodel"
+	"github.com/msproject/relive/logger"
+	"github.com/msproject/relive/util"
+)
+
+var (
+	t0    = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	gold  = dbmodel.ProductEntry{ProductID: 1003, ProductType: "gold", StoreSize: 300, Duration: 30, MaxCustomers: 5}
+	trial = dbmodel.ProductEntry{ProductID: 1004, ProductType: "trial", Duration: 30, TrialDays: 14}
+)
+
+func TestStart(t *testing.T) {
+	sub := Start(7, gold, t0, true)
+	if sub.ID != 7 || sub.State != dbmodel.SubscriptionActive || !sub.EndDate.Equal(t0.AddDate(0, 0, 30)) || sub.ProductType != "gold" {
+		t.Errorf("expected 30 active days of gold, got %+v", sub)
+	}
+	if sub.StoreSize != 300 || sub.MaxCustomers != 5 || sub.MaxAdmins != 0 || sub.Duration != 30 {
+		t.Errorf("expected the features of gold, got %+v", sub)
+	}
+	if sub = Start(7, trial, t0, true); sub.State != dbmodel.SubscriptionTrialing || !sub.EndDate.Equal(t0.AddDate(0, 0, 14)) {
+		t.Errorf("expected a 14 day trial, got %+v", sub)
+	}
+	if sub = Start(7, trial, t0, false); sub.State != dbmodel.SubscriptionActive || !sub.EndDate.Equal(t0.AddDate(0, 0, 30)) {
+		t.Errorf("expected no second trial, got %+v", sub)
+	}
+}
+
+func TestNext(t *testing.T) {
+	grace := 7 * day
+	end := t0.AddDate(0, 0, 30)
+	active := Start(7, gold, t0, false)
+
+	if _, changed := Next(active, false, end.Add(-time.Second), grace); changed {
+		t.Errorf("expected a running period left alone")
+	}
+
+	renewed, changed := Next(active, true, end.Add(time.Hour), grace)
+	if !changed || renewed.State != dbmodel.SubscriptionActive || !renewed.StartDate.Equal(end) || !renewed.EndDate.Equal(end.AddDate(0, 0, 30)) {
+		t.Errorf("expected the next period to follow the last, got %+v", renewed)
+	}
+
+	/* the period subscribed with, whatever the product has now */
+	weekly := active
+	weekly.Duration = 7
+	if renewed, _ = Next(weekly, true, end.Add(time.Hour), grace); !renewed.EndDate.Equal(end.AddDate(0, 0, 7)) {
+		t.Errorf("expected a period of the subscribed 7 days, got %+v", renewed)
+	}
+
+	/* down for longer than a period: it starts over from now */
+	late := end.AddDate(0, 0, 45)
+	if renewed, _ = Next(active, true, late, grace); !renewed.StartDate.Equal(late) {
+		t.Errorf("expected a period that would be over already to start now, got %+v", renewed)
+	}
+
+	pastDue, changed := Next(active, false, end, grace)
+	if !changed || pastDue.State != dbmodel.SubscriptionPastDue || pastDue.GraceUntil == nil || !pastDue.GraceUntil.Equal(end.Add(grace)) {
+		t.Fatalf("expected past due for 7 days, got %+v", pastDue)
+	}
+	if !Running(pastDue) {
+		t.Errorf("expected a past due subscription running")
+	}
+	if _, changed = Next(pastDue, false, end.Add(grace-time.Second), grace); changed {
+		t.Errorf("expected past due to run through its grace period")
+	}
+	if renewed, _ = Next(pastDue, true, end.Add(time.Hour), grace); renewed.State != dbmodel.SubscriptionActive || renewed.GraceUntil != nil {
+		t.Errorf("expected a paid past due subscription renewed, got %+v", renewed)
+	}
+
+	expired, changed := Next(pastDue, false, end.Add(grace), grace)
+	if !changed || expired.State != dbmodel.SubscriptionExpired || Running(expired) {
+		t.Errorf("expected expired once the grace period is over, got %+v", expired)
+	}
+	if _, changed = Next(expired, true, end.AddDate(1, 0, 0), grace); changed {
+		t.Errorf("expected an expired subscription to stay expired")
+	}
+
+	canceled := active
+	canceled.State = dbmodel.SubscriptionCanceled
+	if _, changed = Next(canceled, true, end.Add(-time.Second), grace); changed {
+		t.Errorf("expected a canceled subscription to run to its EndDate")
+	}
+	if expired, _ = Next(canceled, true, end, grace); expired.State != dbmodel.SubscriptionExpired {
+		t.Errorf("expected a canceled subscription to expire, not renew, got %+v", expired)
+	}
+}
+
+/* memSubscriptions - in memory subscriptions, only the lifecycle functions are used */
+type memSubscriptions struct {
+	dbi.SubscriptionTblDBI
+	subs map[int]dbmodel.SubscriptionEntry
+}
+
+func (m *memSubscriptions) ListDueSubscriptions(now time.Time) ([]dbmodel.SubscriptionEntry, error) {
+	var due []dbmodel.SubscriptionEntry
+	for _, sub := range m.subs {
+		if sub.State == dbmodel.SubscriptionPastDue || (sub.State != dbmodel.SubscriptionExpired && !sub.EndDate.After(now)) {
+			due = append(due, sub)
+		}
+	}
+	return due, nil
+}
+
+func (m *memSubscriptions) AdvanceSubscription(prev, next *dbmodel.SubscriptionEntry) (bool, error) {
+	cur := m.subs[prev.SubscriptionCode]
+	if cur.State != prev.State || !cur.EndDate.Equal(prev.EndDate) {
+		return false, nil
+	}
+	m.subs[prev.SubscriptionCode] = *next
+	return true, nil
+}
+
+/* memPayments - accounts with a payment method on file */
+type memPayments struct {
+	dbi.PaymentTblDBI
+	paying map[int]bool
+}
+
+func (m memPayments) SearchPayment(scope dbi.TenantScope, id int) ([]util.PaymentDetails, error) {
+	if !m.paying[id] {
+		return nil, nil
+	}
+	return []util.PaymentDetails{{ID: id}}, nil
+}
+
+func TestSchedulerRun(t *testing.T) {
+	logObj, err := logger.NewLoggerObject(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subs := &memSubscriptions{subs: map[int]dbmodel.SubscriptionEntry{}}
+	for code, id := range map[int]int{1: 10, 2: 20, 3: 30, 4: 40} {
+		sub := Start(id, gold, t0, false)
+		sub.SubscriptionCode = code
+		subs.subs[code] = sub
+	}
+	running := subs.subs[3]
+	running.EndDate = t0.AddDate(1, 0, 0)
+	subs.subs[3] = running
+
+	/* 10 pays, 20 is declined and charging 40 fails */
+	unreachable := fmt.Errorf("processor unreachable")
+	var charged []int
+	charge := func(sub dbmodel.SubscriptionEntry) (bool, error) {
+		charged = append(charged, sub.ID)
+		if sub.ID == 40 {
+			return false, unreachable
+		}
+		return sub.ID == 10, nil
+	}
+	s := NewScheduler(subs, charge, DefaultGrace, time.Minute, logObj)
+	now := t0.AddDate(0, 0, 31)
+	s.now = func() time.Time { return now }
+
+	if err = s.Run(); err != unreachable {
+		t.Fatalf("expected the failed charge reported, got %v", err)
+	}
+	if len(charged) != 3 {
+		t.Errorf("expected the 3 ended periods charged, got %v", charged)
+	}
+	if sub := subs.subs[1]; sub.State != dbmodel.SubscriptionActive || !sub.EndDate.Equal(t0.AddDate(0, 0, 60)) {
+		t.Errorf("expected the paying business renewed, got %+v", sub)
+	}
+	if sub := subs.subs[2]; sub.State != dbmodel.SubscriptionPastDue {
+		t.Errorf("expected the business declined past due, got %+v", sub)
+	}
+	if sub := subs.subs[4]; sub.State != dbmodel.SubscriptionActive {
+		t.Errorf("expected a charge that failed tried again rather than past due, got %+v", sub)
+	}
+	if subs.subs[3] != running {
+		t.Errorf("expected a running period left alone, got %+v", subs.subs[3])
+	}
+
+	now = now.Add(DefaultGrace)
+	if err = s.Run(); err != unreachable {
+		t.Fatalf("expected the failed charge reported, got %v", err)
+	}
+	if sub := subs.subs[2]; sub.State != dbmodel.SubscriptionExpired {
+		t.Errorf("expected expired past the grace period, got %+v", sub)
+	}
+}
+
+func TestCardOnFile(t *testing.T) {
+	charge := CardOnFile(memPayments{paying: map[int]bool{10: true}})
+	if paid, err := charge(dbmodel.SubscriptionEntry{ID: 10}); err != nil || !paid {
+		t.Errorf("expected a business with a card paid, got %v %v", paid, err)
+	}
+	if paid, err := charge(dbmodel.SubscriptionEntry{ID: 20}); err != nil || paid {
+		t.Errorf("expected a business without a card unpaid, got %v %v", paid, err)
+	}
+}
@@ -54,7 +54,7 @@ Outer:
 	}
 
 	// And run the sql to remove and create DB (so as to verify the container is UP)
-	for _, stmt := range append(createSQL, dbmodel.TableCreateSQL[:2]...) {
+	for _, stmt := range append(createSQL, dbmodel.DatabaseSQL...) {
 		// Sometimes mysql is a bit slower up, retry 5 times with a 10second sleep.
 		for i := 0; i < 5; i++ {
 			fmt.Println("Issue stmt:", stmt)
@@ -164,9 +164,21 @@ func CleanUpTables(db *sql.DB) error {
 		`Delete From MediaType`,
 		`Delete From Payment`,
 		`Delete From PaymentHistory`,
+		`Delete From ProductPrice`,
 		`Delete From Product`,
 		`Delete From Subscription`,
 		`Delete From SubscriptionAccount`,
+		`Delete From Session`,
+		`Delete From PasswordReset`,
+		`Delete From TranscodeJob`,
+		`Delete From MediaKey`,
+		`Delete From Upload`,
+		`Delete From MediaInfo`,
+		`Delete From Catalog`,
+		`Delete From MediaGrant`,
+		`Delete From AccountTransfer`,
+		`Delete From StorageOverage`,
+		`Delete From Egress`,
 	}
 
 	for _, sqlStr := range sqlStrs {
@@ -0,0 +1,62 @@
+package transcode
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/msproject/relive/dbmodel"
+)
+
+// DownloadFile - file name of the progressive MP4 of the media name, apart
+// from the original, which may share its name
+func DownloadFile(name string) string {
+	return name + "_download.mp4"
+}
+
+// Download - the MP4 handed out for download next to the HLS ladder, with
+// the index up front so it plays while it is fetched. Sources in H.264 and
+// AAC are only remuxed, others are encoded like the highest video
+// rendition of the ladder. Audio gets none.
+func (f FFmpeg) Download(ctx context.Context, src, outDir, name string, info dbmodel.MediaInfoEntry) (string, error) {
+	if !f.MP4 || info.VideoCodec == "" {
+		return "", nil
+	}
+
+	args, err := downloadArgs(src, filepath.Join(outDir, DownloadFile(name)), f.Ladder, info)
+	if err != nil {
+		return "", err
+	}
+	if err = runFFmpeg(ctx, args...); err != nil {
+		return "", err
+	}
+	return DownloadFile(name), nil
+}
+
+// downloadArgs - ffmpeg arguments writing the download MP4 of src to dst
+func downloadArgs(src, dst string, ladder []Rendition, info dbmodel.MediaInfoEntry) ([]string, error) {
+	args := []string{"-y", "-i", src, "-map", "0:v:0", "-map", "0:a:0?"}
+
+	if info.VideoCodec == "h264" && (info.AudioCodec == "" || info.AudioCodec == "aac") {
+		args = append(args, "-c", "copy")
+	} else {
+		source := sourceInfo{Width: info.Width, Height: info.Height, HasVideo: true, HasAudio: info.AudioCodec != ""}
+		var top *Rendition
+		for _, r := range selectRenditions(ladder, source) {
+			if !r.IsAudio() && (top == nil || r.Height > top.Height) {
+				r := r
+				top = &r
+			}
+		}
+		if top == nil {
+			return nil, fmt.Errorf("no video rendition of the ladder fits %s", src)
+		}
+		args = append(args,
+			"-c:v", "libx264", "-profile:v", "high", "-level:v", "4.1", "-pix_fmt", "yuv420p",
+			"-vf", fmt.Sprintf("scale=-2:%d", top.Height),
+			"-b:v", fmt.Sprintf("%dk", top.VideoBitrate),
+			"-c:a", "aac", "-b:a", fmt.Sprintf("%dk", top.AudioBitrate), "-ac", "2")
+	}
+
+	return append(args, "-movflags", "+faststart", "-f", "mp4", dst), nil
+}
@@ -0,0 +1,243 @@
+package transcode
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/msproject/relive/dbmodel"
+)
+
+// Transcoder - the media processing steps of a job
+type Transcoder interface {
+	// Transcode - encode src into an HLS ladder in outDir, with the
+	// master playlist <name>.m3u8; segments are encrypted if key is set
+	Transcode(ctx context.Context, src, outDir, name string, key *ContentKey) error
+	// Thumbnail - grab poster candidates and thumbnail sprite sheets of
+	// src, probed as info, into outDir
+	Thumbnail(ctx context.Context, src, outDir, name string, info dbmodel.MediaInfoEntry) (Thumbnails, error)
+	// Download - write a progressive MP4 of src into outDir, returns its
+	// file name, "" if none was made
+	Download(ctx context.Context, src, outDir, name string, info dbmodel.MediaInfoEntry) (string, error)
+	// WithLadder - the transcoder encoding ladder instead of its own
+	WithLadder(ladder []Rendition) Transcoder
+}
+
+/* H.264 High@4.1 and AAC-LC, as announced in the master playlist */
+const (
+	videoCodec = "avc1.640029"
+	audioCodec = "mp4a.40.2"
+
+	segmentSeconds = 6
+)
+
+// ContentKey - AES-128 key encrypting the segments of an asset, and the
+// URI players fetch it from
+type ContentKey struct {
+	Key []byte
+	URI string
+}
+
+// FFmpeg - Transcoder running the ffmpeg binary found in PATH. Posters is
+// the number of poster candidates, SpriteInterval the seconds between the
+// thumbnails of the track, 0 for no track. MP4 makes a download MP4 of
+// videos.
+type FFmpeg struct {
+	Ladder         []Rendition
+	Posters        int
+	SpriteInterval int
+	MP4            bool
+}
+
+// WithLadder - f encoding ladder
+func (f FFmpeg) WithLadder(ladder []Rendition) Transcoder {
+	f.Ladder = ladder
+	return f
+}
+
+// Transcode - one variant playlist <name>_<rendition>.m3u8 per rendition
+// of the ladder the source is large enough for, then the master playlist
+func (f FFmpeg) Transcode(ctx context.Context, src, outDir, name string, key *ContentKey) error {
+	info, err := probeSource(ctx, src)
+	if err != nil {
+		return err
+	}
+
+	keyInfo := ""
+	if key != nil {
+		/* next to src, outside outDir, so the key is never stored */
+		if keyInfo, err = writeKeyInfo(filepath.Dir(src), key); err != nil {
+			return err
+		}
+	}
+
+	renditions := selectRenditions(f.Ladder, info)
+	if len(renditions) == 0 {
+		return fmt.Errorf("no rendition of the ladder fits %s", src)
+	}
+
+	var variants []variant
+	for _, r := range renditions {
+		v := variant{Rendition: r, Playlist: fmt.Sprintf("%s_%s.m3u8", name, r.Name)}
+		if !r.IsAudio() {
+			v.Width, v.Height = info.scaledWidth(r.Height), r.Height
+		}
+
+		err = runFFmpeg(ctx, encodeArgs(src, outDir, name, v, keyInfo)...)
+		if err != nil {
+			return fmt.Errorf("rendition %s: %v", r.Name, err)
+		}
+		variants = append(variants, v)
+	}
+
+	master, err := os.Create(fmt.Sprintf("%s/%s.m3u8", outDir, name))
+	if err != nil {
+		return err
+	}
+	err = writeMasterPlaylist(master, variants, info.HasAudio)
+	if cerr := master.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// writeKeyInfo - the hls_key_info_file telling ffmpeg the key URI and the
+// file holding the key; without an IV line segments use their sequence
+// number as IV
+func writeKeyInfo(dir string, key *ContentKey) (string, error) {
+	keyFile := filepath.Join(dir, "content.key")
+	if err := ioutil.WriteFile(keyFile, key.Key, 0600); err != nil {
+		return "", err
+	}
+
+	keyInfo := filepath.Join(dir, "content.keyinfo")
+	if err := ioutil.WriteFile(keyInfo, []byte(key.URI+"\n"+keyFile+"\n"), 0600); err != nil {
+		return "", err
+	}
+	return keyInfo, nil
+}
+
+// encodeArgs - ffmpeg arguments encoding one variant as VOD HLS, with
+// key frames on segment boundaries so players can switch between variants
+func encodeArgs(src, outDir, name string, v variant, keyInfo string) []string {
+	args := []string{"-y", "-i", src}
+
+	if v.IsAudio() {
+		args = append(args, "-map", "0:a:0", "-vn")
+	} else {
+		args = append(args,
+			"-map", "0:v:0", "-map", "0:a:0?",
+			"-c:v", "libx264", "-profile:v", "high", "-level:v", "4.1", "-pix_fmt", "yuv420p",
+			"-vf", fmt.Sprintf("scale=-2:%d", v.Height),
+			"-b:v", fmt.Sprintf("%dk", v.VideoBitrate),
+			"-maxrate", fmt.Sprintf("%dk", int(float64(v.VideoBitrate)*maxrateFactor)),
+			"-bufsize", fmt.Sprintf("%dk", v.VideoBitrate*3/2),
+			"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", segmentSeconds))
+	}
+
+	if keyInfo != "" {
+		args = append(args, "-hls_key_info_file", keyInfo)
+	}
+
+	return append(args,
+		"-c:a", "aac", "-b:a", fmt.Sprintf("%dk", v.AudioBitrate), "-ac", "2",
+		"-f", "hls", "-hls_time", fmt.Sprint(segmentSeconds), "-hls_playlist_type", "vod",
+		"-hls_segment_filename", fmt.Sprintf("%s/%s_%s_%%d.ts", outDir, name, v.Name),
+		fmt.Sprintf("%s/%s", outDir, v.Playlist))
+}
+
+// Thumbnail - poster candidates spread across the video, then sprite
+// sheets of a thumbnail every SpriteInterval seconds and the WebVTT track
+// pointing into them. Audio has neither.
+func (f FFmpeg) Thumbnail(ctx context.Context, src, outDir, name string, info dbmodel.MediaInfoEntry) (Thumbnails, error) {
+	var thumbs Thumbnails
+	if info.Width == 0 || info.Height == 0 {
+		return thumbs, nil
+	}
+
+	for i, t := range posterTimes(info.Duration, f.Posters) {
+		poster := PosterFile(name, i+1)
+		err := runFFmpeg(ctx,
+			"-y", "-ss", fmt.Sprintf("%.3f", t), "-i", src,
+			"-vframes", "1", "-q:v", "5", filepath.Join(outDir, poster))
+		if err != nil {
+			return Thumbnails{}, fmt.Errorf("poster %d: %v", i+1, err)
+		}
+		thumbs.Posters = append(thumbs.Posters, poster)
+	}
+
+	if f.SpriteInterval <= 0 || info.Duration <= 0 {
+		return thumbs, nil
+	}
+
+	/* the image2 muxer numbers the sheets, a % in name must not count */
+	height := thumbHeight(info.Width, info.Height)
+	err := runFFmpeg(ctx,
+		"-y", "-i", src, "-map", "0:v:0",
+		"-vf", fmt.Sprintf("fps=1/%d,scale=%d:%d,tile=%dx%d", f.SpriteInterval, thumbWidth, height, spriteColumns, spriteRows),
+		"-q:v", "5", filepath.Join(outDir, strings.Replace(name, "%", "%%", -1)+"_sprite_%d.jpg"))
+	if err != nil {
+		return Thumbnails{}, fmt.Errorf("sprites: %v", err)
+	}
+
+	/* cues only for tiles of sheets ffmpeg actually wrote */
+	sheets := 0
+	for {
+		if _, err = os.Stat(filepath.Join(outDir, SpriteFile(name, sheets+1))); err != nil {
+			break
+		}
+		sheets++
+	}
+	count := thumbCount(info.Duration, f.SpriteInterval)
+	if tiles := sheets * spriteColumns * spriteRows; count > tiles {
+		count = tiles
+	}
+	if count == 0 {
+		return thumbs, nil
+	}
+
+	track, err := os.Create(filepath.Join(outDir, TrackFile(name)))
+	if err != nil {
+		return Thumbnails{}, err
+	}
+	err = writeThumbnailTrack(track, name, info.Duration, f.SpriteInterval, count, height)
+	if cerr := track.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return Thumbnails{}, err
+	}
+	thumbs.Track = TrackFile(name)
+	return thumbs, nil
+}
+
+func runFFmpeg(ctx context.Context, args ...string) error {
+	ffmpegPath, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return fmt.Errorf("error looking up path for ffmpeg :%s", err.Error())
+	}
+
+	cmd := exec.CommandContext(ctx, ffmpegPath, args...)
+	err = cmd.Start()
+	if err != nil {
+		return fmt.Errorf("Cannot start command, err: %v", err)
+	}
+
+	if err = cmd.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if exiterr, ok := err.(*exec.ExitError); ok {
+			if status, ok := exiterr.Sys().(syscall.WaitStatus); ok && status.ExitStatus() != 0 {
+				return fmt.Errorf("Exit Status: %d", status.ExitStatus())
+			}
+		}
+		return fmt.Errorf("error in  cmd.Wait: %v", err)
+	}
+	return nil
+}
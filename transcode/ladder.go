@@ -0,0 +1,164 @@
+package transcode
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Rendition - one variant of the HLS ladder. Height 0 makes it audio only.
+type Rendition struct {
+	Name         string
+	Height       int
+	VideoBitrate int // kbit/s
+	AudioBitrate int // kbit/s
+}
+
+// renditionPresets - renditions selectable by name
+var renditionPresets = map[string]Rendition{
+	"1080p": {Name: "1080p", Height: 1080, VideoBitrate: 5000, AudioBitrate: 192},
+	"720p":  {Name: "720p", Height: 720, VideoBitrate: 2800, AudioBitrate: 128},
+	"480p":  {Name: "480p", Height: 480, VideoBitrate: 1400, AudioBitrate: 128},
+	"360p":  {Name: "360p", Height: 360, VideoBitrate: 800, AudioBitrate: 96},
+	"audio": {Name: "audio", AudioBitrate: 128},
+}
+
+//DefaultLadder - renditions transcoded unless configured otherwise
+const DefaultLadder = "1080p,720p,480p,audio"
+
+/* peak bitrate allowed to the encoder, and announced as BANDWIDTH */
+const maxrateFactor = 1.07
+
+// IsAudio - true for an audio only rendition
+func (r Rendition) IsAudio() bool {
+	return r.Height == 0
+}
+
+// ParseLadder - renditions of a comma separated list, each a preset name
+// (1080p, 720p, 480p, 360p, audio) or <name>:<height>:<video kbit/s>:<audio kbit/s>
+// with height 0 for audio only
+func ParseLadder(spec string) ([]Rendition, error) {
+	var ladder []Rendition
+	seen := map[string]bool{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		r, ok := renditionPresets[entry]
+		if !ok {
+			var err error
+			if r, err = parseRendition(entry); err != nil {
+				return nil, err
+			}
+		}
+		if seen[r.Name] {
+			return nil, fmt.Errorf("rendition %q listed twice", r.Name)
+		}
+		seen[r.Name] = true
+		ladder = append(ladder, r)
+	}
+
+	if len(ladder) == 0 {
+		return nil, fmt.Errorf("no renditions in %q", spec)
+	}
+	return ladder, nil
+}
+
+func parseRendition(entry string) (Rendition, error) {
+	parts := strings.Split(entry, ":")
+	if len(parts) != 4 || parts[0] == "" || strings.ContainsAny(parts[0], "/. ") {
+		return Rendition{}, fmt.Errorf("invalid rendition %q, expected a preset or <name>:<height>:<video kbit/s>:<audio kbit/s>", entry)
+	}
+
+	var nums [3]int
+	for i, p := range parts[1:] {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return Rendition{}, fmt.Errorf("invalid number %q in rendition %q", p, entry)
+		}
+		nums[i] = n
+	}
+
+	r := Rendition{Name: parts[0], Height: nums[0], VideoBitrate: nums[1], AudioBitrate: nums[2]}
+	if r.Height%2 != 0 {
+		return Rendition{}, fmt.Errorf("height of rendition %q must be even", entry)
+	}
+	if r.IsAudio() && r.AudioBitrate == 0 || !r.IsAudio() && r.VideoBitrate == 0 {
+		return Rendition{}, fmt.Errorf("rendition %q needs a bitrate", entry)
+	}
+	return r, nil
+}
+
+// selectRenditions - the renditions worth encoding for a source: no
+// upscaling beyond the source height, but at least the smallest video
+// rendition, and no audio rendition for a silent source
+func selectRenditions(ladder []Rendition, src sourceInfo) []Rendition {
+	var selected []Rendition
+	var smallest *Rendition
+	for i, r := range ladder {
+		switch {
+		case r.IsAudio():
+			if src.HasAudio {
+				selected = append(selected, r)
+			}
+		case !src.HasVideo:
+		case r.Height <= src.Height:
+			selected = append(selected, r)
+		case smallest == nil || r.Height < smallest.Height:
+			smallest = &ladder[i]
+		}
+	}
+
+	hasVideo := false
+	for _, r := range selected {
+		hasVideo = hasVideo || !r.IsAudio()
+	}
+	if src.HasVideo && !hasVideo && smallest != nil {
+		selected = append([]Rendition{*smallest}, selected...)
+	}
+	return selected
+}
+
+// variant - a rendition as encoded, with the width scaling gave it
+type variant struct {
+	Rendition
+	Width    int
+	Height   int
+	Playlist string
+}
+
+// bandwidth - peak and average bit/s of the variant
+func (v variant) bandwidth() (peak, average int) {
+	video := float64(v.VideoBitrate)
+	if v.IsAudio() {
+		video = 0
+	}
+	peak = int((video*maxrateFactor + float64(v.AudioBitrate)) * 1000)
+	average = (int(video) + v.AudioBitrate) * 1000
+	return peak, average
+}
+
+// writeMasterPlaylist - HLS master playlist listing the variants
+func writeMasterPlaylist(w io.Writer, variants []variant, hasAudio bool) error {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	for _, v := range variants {
+		peak, average := v.bandwidth()
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,AVERAGE-BANDWIDTH=%d", peak, average)
+		switch {
+		case v.IsAudio():
+			b.WriteString(`,CODECS="` + audioCodec + `"`)
+		case hasAudio:
+			fmt.Fprintf(&b, `,RESOLUTION=%dx%d,CODECS="%s,%s"`, v.Width, v.Height, videoCodec, audioCodec)
+		default:
+			fmt.Fprintf(&b, `,RESOLUTION=%dx%d,CODECS="%s"`, v.Width, v.Height, videoCodec)
+		}
+		b.WriteString("\n" + v.Playlist + "\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
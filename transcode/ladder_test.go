@@ -0,0 +1,84 @@
+package transcode
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/msproject/relive/dbmodel"
+)
+
+func TestParseLadder(t *testing.T) {
+	ladder, err := ParseLadder("720p, hd:1440:8000:192 ,audio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ladder) != 3 || ladder[0].Height != 720 || ladder[1] != (Rendition{"hd", 1440, 8000, 192}) || !ladder[2].IsAudio() {
+		t.Errorf("parsed %+v", ladder)
+	}
+
+	for _, bad := range []string{"", "4k", "x:1080:0:128", "x:1081:100:128", "a/b:720:100:128", "720p,720p", "x:0:0:0"} {
+		if _, err = ParseLadder(bad); err == nil {
+			t.Errorf("ParseLadder accepted %q", bad)
+		}
+	}
+}
+
+func TestSelectRenditions(t *testing.T) {
+	ladder, _ := ParseLadder(DefaultLadder)
+	names := func(rs []Rendition) (s string) {
+		for _, r := range rs {
+			s += r.Name + " "
+		}
+		return s
+	}
+
+	cases := []struct {
+		src  sourceInfo
+		want string
+	}{
+		{sourceInfo{1920, 1080, true, true}, "1080p 720p 480p audio "},
+		{sourceInfo{1280, 720, true, false}, "720p 480p "},
+		{sourceInfo{320, 240, true, true}, "480p audio "},
+		{sourceInfo{0, 0, false, true}, "audio "},
+	}
+	for _, c := range cases {
+		if got := names(selectRenditions(ladder, c.src)); got != c.want {
+			t.Errorf("%+v selected %q, want %q", c.src, got, c.want)
+		}
+	}
+}
+
+func TestWithLadder(t *testing.T) {
+	server, _ := ParseLadder(DefaultLadder)
+	subscribed, _ := ParseLadder("480p,audio")
+	f := FFmpeg{Ladder: server, MP4: true}
+
+	g, ok := f.WithLadder(subscribed).(FFmpeg)
+	if !ok || len(g.Ladder) != 2 || !g.MP4 || len(f.Ladder) != 4 {
+		t.Fatalf("expected a copy encoding the subscribed ladder, got %+v from %+v", g, f)
+	}
+	args, err := downloadArgs("in.mkv", "out.mp4", g.Ladder, dbmodel.MediaInfoEntry{VideoCodec: "hevc", Width: 1920, Height: 1080})
+	if err != nil || !strings.Contains(strings.Join(args, " "), "scale=-2:480") {
+		t.Errorf("expected the download encoded like 480p, got %v %v", args, err)
+	}
+}
+
+func TestMasterPlaylist(t *testing.T) {
+	src := sourceInfo{Width: 1440, Height: 1080, HasVideo: true, HasAudio: true}
+	variants := []variant{
+		{Rendition: renditionPresets["720p"], Width: src.scaledWidth(720), Height: 720, Playlist: "m_720p.m3u8"},
+		{Rendition: renditionPresets["audio"], Playlist: "m_audio.m3u8"},
+	}
+
+	var b bytes.Buffer
+	if err := writeMasterPlaylist(&b, variants, true); err != nil {
+		t.Fatal(err)
+	}
+	want := "#EXTM3U\n#EXT-X-VERSION:3\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=3124000,AVERAGE-BANDWIDTH=2928000,RESOLUTION=960x720,CODECS=\"avc1.640029,mp4a.40.2\"\nm_720p.m3u8\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=128000,AVERAGE-BANDWIDTH=128000,CODECS=\"mp4a.40.2\"\nm_audio.m3u8\n"
+	if b.String() != want {
+		t.Errorf("master playlist\n%s\nwant\n%s", b.String(), want)
+	}
+}
@@ -0,0 +1,168 @@
+package transcode
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/msproject/relive/dbmodel"
+)
+
+// ErrUnsupported - the upload is no media, or its container or codecs are
+// not accepted
+var ErrUnsupported = errors.New("unsupported media")
+
+/* what uploads may contain, as named by ffprobe */
+var (
+	supportedContainers = map[string]bool{
+		"mov": true, "mp4": true, "matroska": true, "webm": true, "mpegts": true, "avi": true,
+		"flv": true, "mpeg": true, "mp3": true, "wav": true, "ogg": true, "flac": true,
+	}
+	supportedVideoCodecs = map[string]bool{
+		"h264": true, "hevc": true, "vp8": true, "vp9": true, "av1": true, "mpeg4": true, "mpeg2video": true,
+	}
+	supportedAudioCodecs = map[string]bool{
+		"aac": true, "mp3": true, "opus": true, "vorbis": true, "ac3": true, "eac3": true, "flac": true, "alac": true,
+	}
+)
+
+// sourceInfo - what the ladder needs to know about an upload
+type sourceInfo struct {
+	Width    int
+	Height   int
+	HasVideo bool
+	HasAudio bool
+}
+
+// Probe - container, codecs, resolution, duration and size of the local
+// file src as reported by ffprobe. A file ffprobe cannot read is
+// ErrUnsupported.
+func Probe(ctx context.Context, src string) (dbmodel.MediaInfoEntry, error) {
+	ffprobePath, err := exec.LookPath("ffprobe")
+	if err != nil {
+		return dbmodel.MediaInfoEntry{}, fmt.Errorf("error looking up path for ffprobe :%s", err.Error())
+	}
+
+	out, err := exec.CommandContext(ctx, ffprobePath, "-v", "error",
+		"-show_entries", "format=format_name,duration,bit_rate,size:stream=codec_type,codec_name,width,height,avg_frame_rate:stream_disposition=attached_pic",
+		"-of", "json", src).Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return dbmodel.MediaInfoEntry{}, ctx.Err()
+		}
+		if _, ok := err.(*exec.ExitError); ok {
+			return dbmodel.MediaInfoEntry{}, fmt.Errorf("%w: ffprobe of %s failed: %v", ErrUnsupported, src, err)
+		}
+		return dbmodel.MediaInfoEntry{}, fmt.Errorf("ffprobe of %s failed: %v", src, err)
+	}
+	return parseProbe(out)
+}
+
+// parseProbe - media info of ffprobe's JSON output. The first video stream
+// that is not cover art and the first audio stream count.
+func parseProbe(out []byte) (dbmodel.MediaInfoEntry, error) {
+	var info dbmodel.MediaInfoEntry
+
+	var probe struct {
+		Format struct {
+			FormatName string `json:"format_name"`
+			Duration   string `json:"duration"`
+			BitRate    string `json:"bit_rate"`
+			Size       string `json:"size"`
+		} `json:"format"`
+		Streams []struct {
+			CodecType    string `json:"codec_type"`
+			CodecName    string `json:"codec_name"`
+			Width        int    `json:"width"`
+			Height       int    `json:"height"`
+			AvgFrameRate string `json:"avg_frame_rate"`
+			Disposition  struct {
+				AttachedPic int `json:"attached_pic"`
+			} `json:"disposition"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return info, fmt.Errorf("could not parse ffprobe output: %v", err)
+	}
+
+	info.Container = probe.Format.FormatName
+	info.Duration, _ = strconv.ParseFloat(probe.Format.Duration, 64)
+	info.Bitrate, _ = strconv.ParseInt(probe.Format.BitRate, 10, 64)
+	info.FileSize, _ = strconv.ParseInt(probe.Format.Size, 10, 64)
+
+	for _, s := range probe.Streams {
+		switch s.CodecType {
+		case "video":
+			if info.VideoCodec == "" && s.Disposition.AttachedPic == 0 && s.Width > 0 && s.Height > 0 {
+				info.VideoCodec, info.Width, info.Height = s.CodecName, s.Width, s.Height
+				info.FrameRate = parseRate(s.AvgFrameRate)
+			}
+		case "audio":
+			if info.AudioCodec == "" {
+				info.AudioCodec = s.CodecName
+			}
+		}
+	}
+	if info.VideoCodec == "" && info.AudioCodec == "" {
+		return info, fmt.Errorf("%w: neither video nor audio found", ErrUnsupported)
+	}
+	return info, nil
+}
+
+// parseRate - frames per second of an ffprobe rate such as 30000/1001,
+// 0 if unknown
+func parseRate(rate string) float64 {
+	parts := strings.SplitN(rate, "/", 2)
+	num, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || len(parts) == 1 {
+		return num
+	}
+	den, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil || den == 0 {
+		return 0
+	}
+	return num / den
+}
+
+// CheckSupported - ErrUnsupported unless container and codecs of the
+// probed upload are accepted
+func CheckSupported(info dbmodel.MediaInfoEntry) error {
+	container := false
+	for _, name := range strings.Split(info.Container, ",") {
+		container = container || supportedContainers[name]
+	}
+	if !container {
+		return fmt.Errorf("%w: container %s", ErrUnsupported, info.Container)
+	}
+	if info.VideoCodec != "" && !supportedVideoCodecs[info.VideoCodec] {
+		return fmt.Errorf("%w: video codec %s", ErrUnsupported, info.VideoCodec)
+	}
+	if info.AudioCodec != "" && !supportedAudioCodecs[info.AudioCodec] && !strings.HasPrefix(info.AudioCodec, "pcm_") {
+		return fmt.Errorf("%w: audio codec %s", ErrUnsupported, info.AudioCodec)
+	}
+	return nil
+}
+
+// probeSource - stream layout of src as reported by ffprobe
+func probeSource(ctx context.Context, src string) (sourceInfo, error) {
+	info, err := Probe(ctx, src)
+	if err != nil {
+		return sourceInfo{}, err
+	}
+	return sourceInfo{
+		Width:    info.Width,
+		Height:   info.Height,
+		HasVideo: info.VideoCodec != "",
+		HasAudio: info.AudioCodec != "",
+	}, nil
+}
+
+// scaledWidth - the width ffmpeg's scale=-2:<height> gives the source
+func (s sourceInfo) scaledWidth(height int) int {
+	w := (s.Width*height + s.Height/2) / s.Height
+	return w / 2 * 2
+}
@@ -0,0 +1,69 @@
+package transcode
+
+import (
+	"errors"
+	"testing"
+)
+
+const ffprobeMP4 = `{
+	"streams": [
+		{"codec_name": "h264", "codec_type": "video", "width": 1920, "height": 1080,
+		 "avg_frame_rate": "30000/1001", "disposition": {"attached_pic": 0}},
+		{"codec_name": "aac", "codec_type": "audio", "avg_frame_rate": "0/0", "disposition": {"attached_pic": 0}}
+	],
+	"format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2", "duration": "62.562000", "size": "15712345", "bit_rate": "2009154"}
+}`
+
+const ffprobeMP3 = `{
+	"streams": [
+		{"codec_name": "mp3", "codec_type": "audio", "avg_frame_rate": "0/0", "disposition": {"attached_pic": 0}},
+		{"codec_name": "png", "codec_type": "video", "width": 500, "height": 500,
+		 "avg_frame_rate": "0/0", "disposition": {"attached_pic": 1}}
+	],
+	"format": {"format_name": "mp3", "duration": "180.0", "size": "2880000", "bit_rate": "128000"}
+}`
+
+func TestParseProbe(t *testing.T) {
+	info, err := parseProbe([]byte(ffprobeMP4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.VideoCodec != "h264" || info.AudioCodec != "aac" || info.Width != 1920 || info.Height != 1080 ||
+		info.Duration != 62.562 || info.FileSize != 15712345 || info.Bitrate != 2009154 ||
+		info.FrameRate < 29.97 || info.FrameRate > 29.98 {
+		t.Fatalf("unexpected media info %+v", info)
+	}
+	if err = CheckSupported(info); err != nil {
+		t.Fatal(err)
+	}
+
+	/* cover art is no video */
+	info, err = parseProbe([]byte(ffprobeMP3))
+	if err != nil || info.VideoCodec != "" || info.AudioCodec != "mp3" {
+		t.Fatalf("unexpected media info %+v, %v", info, err)
+	}
+
+	if _, err = parseProbe([]byte(`{"streams": [], "format": {"format_name": "tty"}}`)); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("expected ErrUnsupported without streams, got %v", err)
+	}
+}
+
+func TestCheckSupported(t *testing.T) {
+	info, _ := parseProbe([]byte(ffprobeMP4))
+
+	bad := info
+	bad.Container = "gif"
+	if err := CheckSupported(bad); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("expected gif to be refused, got %v", err)
+	}
+	bad = info
+	bad.VideoCodec = "theora"
+	if err := CheckSupported(bad); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("expected theora to be refused, got %v", err)
+	}
+	bad = info
+	bad.AudioCodec = "pcm_s16le"
+	if err := CheckSupported(bad); err != nil {
+		t.Fatalf("expected PCM audio to be accepted, got %v", err)
+	}
+}
@@ -0,0 +1,434 @@
+package transcode
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/msproject/relive/dbi"
+	"github.com/msproject/relive/dbmodel"
+	"github.com/msproject/relive/logger"
+	"github.com/msproject/relive/mediakey"
+	"github.com/msproject/relive/mediastore"
+)
+
+// Job steps, as reported in TranscodeJob.Step
+const (
+	StepFetch     = "fetch"
+	StepTranscode = "transcode"
+	StepThumbnail = "thumbnail"
+	StepDownload  = "download"
+	StepStore     = "store"
+	StepPublish   = "publish"
+)
+
+const (
+	//DefaultWorkers - jobs transcoded in parallel
+	DefaultWorkers = 2
+	//DefaultMaxAttempts - attempts before a job fails for good
+	DefaultMaxAttempts = 3
+	//DefaultBackoff - delay before the second attempt, doubled for each further one
+	DefaultBackoff = 30 * time.Second
+
+	pollInterval = 5 * time.Second
+	maxBackoff   = time.Hour
+)
+
+// Queue - bounded pool of workers executing the jobs persisted in the
+// TranscodeJob table. Jobs survive restarts: the table is the queue, and
+// jobs a previous process left running are queued again on Start.
+type Queue struct {
+	jobDBI      dbi.TranscodeJobTblDBI
+	mediaDBI    dbi.MediaTypeTblDBI
+	transcoder  Transcoder
+	store       mediastore.MediaStore
+	workers     int
+	maxAttempts int
+	backoff     time.Duration
+	logObj      *logger.Logger
+
+	keyDBI  dbi.MediaKeyTblDBI
+	wrapper *mediakey.Wrapper
+	keyURL  string
+
+	wake    chan struct{}
+	mu      sync.Mutex
+	running map[int]context.CancelFunc
+}
+
+// NewQueue - create a queue, workers start with Start
+func NewQueue(jobDBI dbi.TranscodeJobTblDBI, mediaDBI dbi.MediaTypeTblDBI, transcoder Transcoder, store mediastore.MediaStore,
+	workers, maxAttempts int, backoff time.Duration, logObj *logger.Logger) *Queue {
+	if workers < 1 {
+		workers = 1
+	}
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	return &Queue{
+		jobDBI:      jobDBI,
+		mediaDBI:    mediaDBI,
+		transcoder:  transcoder,
+		store:       store,
+		workers:     workers,
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		logObj:      logObj,
+		wake:        make(chan struct{}, workers),
+		running:     map[int]context.CancelFunc{},
+	}
+}
+
+// EnableEncryption - encrypt the segments of every asset with a key of its
+// own, stored wrapped in MediaKey. Players fetch it from
+// <keyURL>/<account id>/<media name>. Call before Start.
+func (q *Queue) EnableEncryption(keyDBI dbi.MediaKeyTblDBI, wrapper *mediakey.Wrapper, keyURL string) {
+	q.keyDBI = keyDBI
+	q.wrapper = wrapper
+	q.keyURL = keyURL
+}
+
+// Start - requeue interrupted jobs and start the workers
+func (q *Queue) Start() error {
+	n, err := q.jobDBI.RequeueRunningTranscodeJobs()
+	if err != nil {
+		return fmt.Errorf("could not requeue interrupted transcode jobs: %v", err)
+	}
+	if n > 0 {
+		q.logObj.PrintInfo("Resuming %d interrupted transcode jobs", n)
+	}
+
+	for i := 0; i < q.workers; i++ {
+		go q.work()
+	}
+	return nil
+}
+
+// Enqueue - persist a new job and wake a worker
+func (q *Queue) Enqueue(job *dbmodel.TranscodeJobEntry) error {
+	if err := q.jobDBI.CreateTranscodeJob(job); err != nil {
+		return err
+	}
+	q.notify()
+	return nil
+}
+
+// Job - get a job by JobID, nil if none exists
+func (q *Queue) Job(jobID int) (*dbmodel.TranscodeJobEntry, error) {
+	return q.jobDBI.GetTranscodeJob(jobID)
+}
+
+// Cancel - cancel a queued job, or stop ffmpeg of a running one. false if
+// the job had already ended.
+func (q *Queue) Cancel(jobID int) (bool, error) {
+	ok, err := q.jobDBI.CancelTranscodeJob(jobID)
+	if err != nil || !ok {
+		return ok, err
+	}
+
+	q.mu.Lock()
+	cancel, found := q.running[jobID]
+	if found {
+		cancel()
+	}
+	q.mu.Unlock()
+
+	/* a running job is discarded by its worker once ffmpeg stopped */
+	if !found {
+		job, err := q.jobDBI.GetTranscodeJob(jobID)
+		if err != nil {
+			return true, err
+		}
+		if job != nil {
+			q.discard(job)
+		}
+	}
+	return true, nil
+}
+
+// discard - delete what a job that will not publish left behind: its
+// upload, whatever it stored of the output and its content key
+func (q *Queue) discard(job *dbmodel.TranscodeJobEntry) {
+	ctx := context.Background()
+	if err := q.store.Delete(ctx, job.SrcKey); err != nil && err != mediastore.ErrNotExist {
+		q.logObj.PrintError("transcode job %d: failed to delete %s: %v", job.JobID, job.SrcKey, err)
+	}
+	if err := mediastore.DeletePrefix(ctx, q.store, job.OutPrefix+"/"); err != nil {
+		q.logObj.PrintError("transcode job %d: failed to delete %s: %v", job.JobID, job.OutPrefix, err)
+	}
+	if q.keyDBI == nil {
+		return
+	}
+	if err := q.keyDBI.DeleteMediaKey(job.ID, job.BaseName); err != nil {
+		q.logObj.PrintError("transcode job %d: failed to delete its key: %v", job.JobID, err)
+	}
+}
+
+func (q *Queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+/* work - claim due jobs until none is left, then wait for Enqueue or the
+ * next poll, which picks up jobs whose retry backoff has passed */
+func (q *Queue) work() {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		job, err := q.jobDBI.ClaimTranscodeJob()
+		if err != nil {
+			q.logObj.PrintError("Failed to claim transcode job: %v", err)
+		}
+		if job != nil {
+			q.run(job)
+			continue
+		}
+
+		select {
+		case <-q.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (q *Queue) run(job *dbmodel.TranscodeJobEntry) {
+	ctx, cancel := context.WithCancel(context.Background())
+	q.mu.Lock()
+	q.running[job.JobID] = cancel
+	q.mu.Unlock()
+
+	defer func() {
+		q.mu.Lock()
+		delete(q.running, job.JobID)
+		q.mu.Unlock()
+		cancel()
+	}()
+
+	q.logObj.PrintInfo("transcode job %d: attempt %d of %s", job.JobID, job.Attempts, job.SrcKey)
+	err := q.process(ctx, job)
+
+	status, lastError, nextRunAt := dbmodel.JobDone, "", time.Now()
+	switch {
+	case err == nil:
+	case ctx.Err() != nil:
+		/* canceled, the row already says so */
+		q.logObj.PrintInfo("transcode job %d: canceled", job.JobID)
+		q.discard(job)
+		return
+	case job.Attempts >= q.maxAttempts:
+		status, lastError = dbmodel.JobFailed, err.Error()
+	default:
+		status, lastError = dbmodel.JobQueued, err.Error()
+		nextRunAt = nextRunAt.Add(q.retryDelay(job.Attempts))
+	}
+
+	if err != nil {
+		q.logObj.PrintError("transcode job %d: attempt %d failed: %v", job.JobID, job.Attempts, err)
+	}
+	if _, err = q.jobDBI.FinishTranscodeJob(job.JobID, status, lastError, nextRunAt); err != nil {
+		q.logObj.PrintError("transcode job %d: %v", job.JobID, err)
+	}
+	if status == dbmodel.JobFailed {
+		q.discard(job)
+	}
+}
+
+// retryDelay - backoff after the given number of failed attempts
+func (q *Queue) retryDelay(attempts int) time.Duration {
+	delay := q.backoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay
+}
+
+/* process - ffmpeg needs local files: the upload is fetched into a
+ * scratch directory, transcoded there and the output put to the store */
+func (q *Queue) process(ctx context.Context, job *dbmodel.TranscodeJobEntry) error {
+	workDir, err := ioutil.TempDir("", fmt.Sprintf("relive-job-%d-", job.JobID))
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	src := filepath.Join(workDir, "src"+filepath.Ext(job.FileName))
+	outDir := filepath.Join(workDir, "out")
+	if err = os.Mkdir(outDir, 0700); err != nil {
+		return err
+	}
+
+	/* the ladder of the subscription, checked when the product was saved */
+	transcoder := q.transcoder
+	if job.Renditions != "" {
+		ladder, err := ParseLadder(job.Renditions)
+		if err != nil {
+			return err
+		}
+		transcoder = transcoder.WithLadder(ladder)
+	}
+
+	var key *ContentKey
+	var info dbmodel.MediaInfoEntry
+	var thumbs Thumbnails
+	var download string
+	var outBytes int64
+
+	steps := []struct {
+		name string
+		run  func() error
+	}{
+		{StepFetch, func() error { return q.fetch(ctx, job.SrcKey, src) }},
+		{StepTranscode, func() (err error) {
+			if info, err = Probe(ctx, src); err != nil {
+				return err
+			}
+			if key, err = q.contentKey(job); err != nil {
+				return err
+			}
+			return transcoder.Transcode(ctx, src, outDir, job.BaseName, key)
+		}},
+		{StepThumbnail, func() (err error) {
+			thumbs, err = transcoder.Thumbnail(ctx, src, outDir, job.BaseName, info)
+			return err
+		}},
+		{StepDownload, func() (err error) {
+			download, err = transcoder.Download(ctx, src, outDir, job.BaseName, info)
+			return err
+		}},
+		{StepStore, func() (err error) {
+			outBytes, err = q.storeOutput(ctx, outDir, job.OutPrefix)
+			return err
+		}},
+		{StepPublish, func() error { return q.publish(job, info, thumbs, download, job.SrcSize+outBytes) }},
+	}
+
+	for _, step := range steps {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := q.jobDBI.SetTranscodeJobStep(job.JobID, step.name); err != nil {
+			return err
+		}
+		if err := step.run(); err != nil {
+			return fmt.Errorf("%s: %v", step.name, err)
+		}
+	}
+	return nil
+}
+
+// contentKey - a new key for the asset of job, stored before any segment
+// is encrypted with it; nil if encryption is off
+func (q *Queue) contentKey(job *dbmodel.TranscodeJobEntry) (*ContentKey, error) {
+	if q.wrapper == nil {
+		return nil, nil
+	}
+
+	key, err := mediakey.NewKey()
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := q.wrapper.Wrap(job.ID, job.BaseName, key)
+	if err != nil {
+		return nil, err
+	}
+
+	err = q.keyDBI.PutMediaKey(&dbmodel.MediaKeyEntry{
+		ID:         job.ID,
+		Name:       job.BaseName,
+		WrappedKey: wrapped,
+		CreatedAt:  time.Now().UTC(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	uri := fmt.Sprintf("%s/%d/%s", q.keyURL, job.ID, url.PathEscape(job.BaseName))
+	return &ContentKey{Key: key, URI: uri}, nil
+}
+
+// fetch - copy the object at key to the local file dst
+func (q *Queue) fetch(ctx context.Context, key, dst string) error {
+	rc, _, err := q.store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, rc)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// storeOutput - put every file of dir to the store below prefix, returns
+// the bytes stored
+func (q *Queue) storeOutput(ctx context.Context, dir, prefix string) (int64, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var stored int64
+	for _, fi := range files {
+		if fi.IsDir() {
+			continue
+		}
+		f, err := os.Open(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return 0, err
+		}
+		err = q.store.Put(ctx, prefix+"/"+fi.Name(), f, fi.Size(), mediastore.ContentType(fi.Name()))
+		f.Close()
+		if err != nil {
+			return 0, err
+		}
+		stored += fi.Size()
+	}
+	return stored, nil
+}
+
+/* publish - add the MediaType row with the probed info, the thumbnails,
+ * the download and the bytes the original and its output take in the
+ * store, only once all of the above succeeded. The first poster candidate
+ * is the poster. */
+func (q *Queue) publish(job *dbmodel.TranscodeJobEntry, info dbmodel.MediaInfoEntry, thumbs Thumbnails, download string,
+	storedBytes int64) error {
+	mt := dbmodel.MediaTypeEntry{
+		ID:          job.ID,
+		Catalog:     job.Catalog,
+		FileName:    job.FileName,
+		Title:       job.Title,
+		Description: job.Description,
+		URL:         job.URL,
+		Posters:     len(thumbs.Posters),
+		Info:        &info,
+		StoredBytes: storedBytes,
+	}
+	if len(thumbs.Posters) > 0 {
+		mt.Poster = job.OutPrefix + "/" + thumbs.Posters[0]
+	}
+	if thumbs.Track != "" {
+		mt.Thumbnails = job.OutPrefix + "/" + thumbs.Track
+	}
+	if download != "" {
+		mt.Download = job.OutPrefix + "/" + download
+	}
+	return q.mediaDBI.AddMediaType(&mt)
+}
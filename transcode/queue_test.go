@@ -0,0 +1,90 @@
+package transcode
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/msproject/relive/dbi"
+	"github.com/msproject/relive/dbmodel"
+	"github.com/msproject/relive/logger"
+	"github.com/msproject/relive/mediastore"
+)
+
+/* memJobs - in memory jobs, only the ones Cancel uses */
+type memJobs struct {
+	dbi.TranscodeJobTblDBI
+	jobs map[int]dbmodel.TranscodeJobEntry
+}
+
+func (m memJobs) CancelTranscodeJob(jobID int) (bool, error) {
+	_, ok := m.jobs[jobID]
+	return ok, nil
+}
+
+func (m memJobs) GetTranscodeJob(jobID int) (*dbmodel.TranscodeJobEntry, error) {
+	job, ok := m.jobs[jobID]
+	if !ok {
+		return nil, nil
+	}
+	return &job, nil
+}
+
+/* memKeys - records the keys deleted */
+type memKeys struct {
+	dbi.MediaKeyTblDBI
+	deleted []string
+}
+
+func (m *memKeys) DeleteMediaKey(id int, name string) error {
+	m.deleted = append(m.deleted, name)
+	return nil
+}
+
+func TestCancelDiscards(t *testing.T) {
+	logObj, err := logger.NewLoggerObject(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "relive-queue-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := mediastore.NewLocalStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for _, key := range []string{"2/src/clip.mp4", "2/asset/asset.m3u8", "2/asset/asset_0.ts", "2/other/other.m3u8"} {
+		if err = store.Put(ctx, key, strings.NewReader("x"), 1, "application/octet-stream"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	jobs := memJobs{jobs: map[int]dbmodel.TranscodeJobEntry{
+		7: {JobID: 7, ID: 2, SrcKey: "2/src/clip.mp4", OutPrefix: "2/asset", BaseName: "asset"},
+	}}
+	keys := &memKeys{}
+	q := NewQueue(jobs, nil, nil, store, 1, 1, 0, logObj)
+	q.keyDBI = keys
+
+	canceled, err := q.Cancel(7)
+	if err != nil || !canceled {
+		t.Fatalf("Cancel = %v, %v, want true", canceled, err)
+	}
+
+	left, err := store.List(ctx, "2/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 1 || left[0].Key != "2/other/other.m3u8" {
+		t.Errorf("store keeps %+v, want only the other asset", left)
+	}
+	if len(keys.deleted) != 1 || keys.deleted[0] != "asset" {
+		t.Errorf("deleted keys %v, want the key of asset", keys.deleted)
+	}
+}
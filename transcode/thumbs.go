@@ -0,0 +1,105 @@
+package transcode
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+const (
+	//DefaultPosters - poster candidates sampled across a video
+	DefaultPosters = 5
+	//DefaultSpriteInterval - seconds between the frames of the thumbnail track
+	DefaultSpriteInterval = 10
+
+	/* sprite sheets of 5x5 thumbnails 160 pixels wide */
+	spriteColumns = 5
+	spriteRows    = 5
+	thumbWidth    = 160
+)
+
+// Thumbnails - what the thumbnail step wrote to the output directory,
+// file names relative to it
+type Thumbnails struct {
+	Posters []string // poster candidates, the first being the default poster
+	Track   string   // WebVTT thumbnail track, "" if none was generated
+}
+
+// PosterFile - file name of poster candidate i, counting from 1, of the
+// media name
+func PosterFile(name string, i int) string {
+	return fmt.Sprintf("%s_poster_%d.jpg", name, i)
+}
+
+// SpriteFile - file name of sprite sheet i, counting from 1
+func SpriteFile(name string, i int) string {
+	return fmt.Sprintf("%s_sprite_%d.jpg", name, i)
+}
+
+// TrackFile - file name of the WebVTT thumbnail track
+func TrackFile(name string) string {
+	return name + "_thumbs.vtt"
+}
+
+// posterTimes - n timestamps spread evenly across duration seconds, away
+// from fade-ins and end credits. The frame at 3s if the duration is
+// unknown.
+func posterTimes(duration float64, n int) []float64 {
+	if duration <= 0 {
+		return []float64{3}
+	}
+	if n < 1 {
+		n = 1
+	}
+	times := make([]float64, n)
+	for i := range times {
+		times[i] = duration * float64(i+1) / float64(n+1)
+	}
+	return times
+}
+
+// thumbHeight - height of a thumbnail of a width x height video, even as
+// the encoder wants it
+func thumbHeight(width, height int) int {
+	h := (thumbWidth*height/width + 1) &^ 1
+	if h < 2 {
+		h = 2
+	}
+	return h
+}
+
+// thumbCount - thumbnails of the track, one per interval seconds
+func thumbCount(duration float64, interval int) int {
+	n := int(duration) / interval
+	if float64(n*interval) < duration {
+		n++
+	}
+	return n
+}
+
+// vttTime - hh:mm:ss.mmm
+func vttTime(seconds float64) string {
+	ms := int64(seconds*1000 + 0.5)
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// writeThumbnailTrack - WebVTT cues of count thumbnails, one per interval
+// seconds of duration, each pointing at its tile of the sprite sheets of
+// name. Sheets are referenced relative to the track, which is served from
+// the same signed directory.
+func writeThumbnailTrack(w io.Writer, name string, duration float64, interval, count, height int) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprint(b, "WEBVTT\n")
+
+	perSheet := spriteColumns * spriteRows
+	for i := 0; i < count; i++ {
+		start, end := float64(i*interval), float64((i+1)*interval)
+		if end > duration {
+			end = duration
+		}
+		tile := i % perSheet
+		fmt.Fprintf(b, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n", vttTime(start), vttTime(end),
+			SpriteFile(name, i/perSheet+1), tile%spriteColumns*thumbWidth, tile/spriteColumns*height, thumbWidth, height)
+	}
+	return b.Flush()
+}
@@ -0,0 +1,51 @@
+package transcode
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPosterTimes(t *testing.T) {
+	times := posterTimes(60, 5)
+	want := []float64{10, 20, 30, 40, 50}
+	if len(times) != len(want) {
+		t.Fatalf("got %v, want %v", times, want)
+	}
+	for i := range want {
+		if times[i] != want[i] {
+			t.Errorf("got %v, want %v", times, want)
+		}
+	}
+
+	if times = posterTimes(0, 5); len(times) != 1 || times[0] != 3 {
+		t.Errorf("unknown duration sampled %v, want the frame at 3s", times)
+	}
+}
+
+func TestThumbnailTrack(t *testing.T) {
+	if h := thumbHeight(1920, 1080); h != 90 {
+		t.Errorf("thumbnail of 1920x1080 %d high, want 90", h)
+	}
+	if h := thumbHeight(720, 576); h != 128 {
+		t.Errorf("thumbnail of 720x576 %d high, want 128", h)
+	}
+	if n := thumbCount(255.5, 10); n != 26 {
+		t.Errorf("counted %d thumbnails for 255.5s, want 26", n)
+	}
+
+	var b bytes.Buffer
+	if err := writeThumbnailTrack(&b, "clip", 255.5, 10, 26, 90); err != nil {
+		t.Fatal(err)
+	}
+	track := b.String()
+	for _, cue := range []string{
+		"WEBVTT\n\n00:00:00.000 --> 00:00:10.000\nclip_sprite_1.jpg#xywh=0,0,160,90\n",
+		"\n00:01:10.000 --> 00:01:20.000\nclip_sprite_1.jpg#xywh=320,90,160,90\n",
+		"\n00:04:00.000 --> 00:04:10.000\nclip_sprite_1.jpg#xywh=640,360,160,90\n",
+		"\n00:04:10.000 --> 00:04:15.500\nclip_sprite_2.jpg#xywh=0,0,160,90\n",
+	} {
+		if !bytes.Contains(b.Bytes(), []byte(cue)) {
+			t.Errorf("track misses cue %q:\n%s", cue, track)
+		}
+	}
+}
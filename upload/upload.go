@@ -0,0 +1,339 @@
+// Package upload - resumable uploads following the tus 1.0 protocol. Chunks
+// are appended to a file staged on local disk, the offset reached is kept in
+// the Upload table so an upload survives restarts and can be resumed from
+// any request.
+package upload
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/msproject/relive/dbi"
+	"github.com/msproject/relive/dbmodel"
+	"github.com/msproject/relive/logger"
+)
+
+const (
+	//DefaultExpiry - an upload untouched this long is dropped
+	DefaultExpiry = 24 * time.Hour
+
+	sweepInterval = 10 * time.Minute
+)
+
+// ChecksumAlgorithms - algorithms accepted in Upload-Checksum
+const ChecksumAlgorithms = "sha1,sha256,md5"
+
+var (
+	// ErrOffsetMismatch - the chunk does not start at the upload's offset
+	ErrOffsetMismatch = errors.New("upload offset does not match")
+	// ErrChecksumMismatch - the chunk does not match its checksum
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	// ErrBadChecksum - Upload-Checksum is malformed or uses an unsupported algorithm
+	ErrBadChecksum = errors.New("unsupported checksum")
+	// ErrTooLarge - the chunk goes past the upload's length
+	ErrTooLarge = errors.New("chunk exceeds the upload length")
+	// ErrBusy - another request is writing to the upload
+	ErrBusy = errors.New("upload is locked by another request")
+)
+
+// Manager - stages resumable uploads below a local directory. Writes to
+// an upload are serialized within the process; the conditional offset
+// update keeps the table consistent between processes.
+type Manager struct {
+	uploadDBI dbi.UploadTblDBI
+	dir       string
+	expiry    time.Duration
+	logObj    *logger.Logger
+
+	mu     sync.Mutex
+	locked map[string]bool
+}
+
+// NewManager - manager staging uploads in dir, created if missing
+func NewManager(uploadDBI dbi.UploadTblDBI, dir string, expiry time.Duration, logObj *logger.Logger) (*Manager, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("could not create upload directory %s: %v", dir, err)
+	}
+	if expiry <= 0 {
+		expiry = DefaultExpiry
+	}
+	return &Manager{
+		uploadDBI: uploadDBI,
+		dir:       dir,
+		expiry:    expiry,
+		logObj:    logObj,
+		locked:    map[string]bool{},
+	}, nil
+}
+
+// Start - periodically drop expired uploads and their staged files
+func (m *Manager) Start() {
+	go func() {
+		for {
+			m.sweep()
+			time.Sleep(sweepInterval)
+		}
+	}()
+}
+
+// Create - stage a new empty upload, setting its UploadID and expiry
+func (m *Manager) Create(up *dbmodel.UploadEntry) error {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return err
+	}
+	up.UploadID = hex.EncodeToString(id[:])
+	up.Offset, up.JobID = 0, 0
+	up.ExpiresAt = time.Now().Add(m.expiry)
+
+	f, err := os.OpenFile(m.path(up.UploadID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	f.Close()
+
+	if err = m.uploadDBI.CreateUpload(up); err != nil {
+		os.Remove(m.path(up.UploadID))
+		return err
+	}
+	return nil
+}
+
+// Get - get an upload, nil if none exists or it expired
+func (m *Manager) Get(uploadID string) (*dbmodel.UploadEntry, error) {
+	up, err := m.uploadDBI.GetUpload(uploadID)
+	if err != nil || up == nil {
+		return nil, err
+	}
+	if time.Now().After(up.ExpiresAt) {
+		return nil, nil
+	}
+	return up, nil
+}
+
+// Append - write the chunk read from body at offset, which has to be the
+// upload's current offset. checksum is the Upload-Checksum header, empty if
+// the client sent none. Without a checksum the bytes received before an
+// interrupted body are kept, so the client resumes after them; with one
+// the whole chunk is discarded unless it matches. The upload is returned
+// with the offset reached, also along with an error.
+func (m *Manager) Append(uploadID string, offset int64, body io.Reader, checksum string) (*dbmodel.UploadEntry, error) {
+	var h hash.Hash
+	var sum []byte
+	if checksum != "" {
+		var err error
+		if h, sum, err = ParseChecksum(checksum); err != nil {
+			return nil, err
+		}
+	}
+
+	if !m.lock(uploadID) {
+		return nil, ErrBusy
+	}
+	defer m.unlock(uploadID)
+
+	up, err := m.Get(uploadID)
+	if err != nil || up == nil {
+		return up, err
+	}
+	if offset != up.Offset {
+		return up, ErrOffsetMismatch
+	}
+
+	f, err := os.OpenFile(m.path(uploadID), os.O_WRONLY, 0600)
+	if err != nil {
+		return up, err
+	}
+	defer f.Close()
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		return up, err
+	}
+
+	var w io.Writer = f
+	if h != nil {
+		w = io.MultiWriter(f, h)
+	}
+	n, copyErr := io.Copy(w, io.LimitReader(body, up.Length-offset))
+	if copyErr == nil {
+		/* anything left in the body goes past the length */
+		var extra [1]byte
+		if k, _ := body.Read(extra[:]); k > 0 {
+			copyErr = ErrTooLarge
+		}
+	}
+
+	if h != nil && copyErr == nil && !bytes.Equal(h.Sum(nil), sum) {
+		copyErr = ErrChecksumMismatch
+	}
+	/* a chunk with a checksum is kept whole or not at all */
+	if copyErr == ErrTooLarge || (h != nil && copyErr != nil) {
+		n = 0
+	}
+	if err = f.Truncate(offset + n); err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		return up, err
+	}
+
+	if n > 0 {
+		expiresAt := time.Now().Add(m.expiry)
+		ok, err := m.uploadDBI.SetUploadOffset(uploadID, offset, offset+n, expiresAt)
+		if err != nil {
+			return up, err
+		}
+		if !ok {
+			return up, ErrOffsetMismatch
+		}
+		up.Offset, up.ExpiresAt = offset+n, expiresAt
+	}
+	return up, copyErr
+}
+
+// Complete - hand a fully received upload over to handoff, once: the job
+// handoff returns is recorded and the staged file dropped. handoff gets the
+// staged file opened for reading. An upload already handed over is
+// returned as is.
+func (m *Manager) Complete(uploadID string, handoff func(up *dbmodel.UploadEntry, src *os.File) (int, error)) (*dbmodel.UploadEntry, error) {
+	if !m.lock(uploadID) {
+		return nil, ErrBusy
+	}
+	defer m.unlock(uploadID)
+
+	up, err := m.Get(uploadID)
+	if err != nil || up == nil || up.JobID != 0 {
+		return up, err
+	}
+	if up.Offset != up.Length {
+		return up, fmt.Errorf("upload %s is incomplete, %d of %d bytes", uploadID, up.Offset, up.Length)
+	}
+
+	f, err := os.Open(m.path(uploadID))
+	if err != nil {
+		return up, err
+	}
+	defer f.Close()
+
+	jobID, err := handoff(up, f)
+	if err != nil {
+		return up, err
+	}
+	if err = m.uploadDBI.SetUploadJob(uploadID, jobID); err != nil {
+		return up, err
+	}
+	up.JobID = jobID
+	os.Remove(m.path(uploadID))
+	return up, nil
+}
+
+// Remove - drop an upload and its staged file
+func (m *Manager) Remove(uploadID string) error {
+	if !m.lock(uploadID) {
+		return ErrBusy
+	}
+	defer m.unlock(uploadID)
+
+	if err := os.Remove(m.path(uploadID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return m.uploadDBI.DeleteUpload(uploadID)
+}
+
+func (m *Manager) sweep() {
+	ids, err := m.uploadDBI.GetExpiredUploads(time.Now())
+	if err != nil {
+		m.logObj.PrintError("Failed to list expired uploads: %v", err)
+		return
+	}
+	for _, id := range ids {
+		if err = m.Remove(id); err != nil && err != ErrBusy {
+			m.logObj.PrintError("Failed to remove expired upload %s: %v", id, err)
+		}
+	}
+}
+
+func (m *Manager) path(uploadID string) string {
+	return filepath.Join(m.dir, filepath.Base(uploadID))
+}
+
+func (m *Manager) lock(uploadID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.locked[uploadID] {
+		return false
+	}
+	m.locked[uploadID] = true
+	return true
+}
+
+func (m *Manager) unlock(uploadID string) {
+	m.mu.Lock()
+	delete(m.locked, uploadID)
+	m.mu.Unlock()
+}
+
+// ParseChecksum - hash and expected sum of an Upload-Checksum header,
+// "<algorithm> <base64 sum>"
+func ParseChecksum(header string) (hash.Hash, []byte, error) {
+	parts := strings.Fields(header)
+	if len(parts) != 2 {
+		return nil, nil, ErrBadChecksum
+	}
+
+	var h hash.Hash
+	switch strings.ToLower(parts[0]) {
+	case "sha1":
+		h = sha1.New()
+	case "sha256":
+		h = sha256.New()
+	case "md5":
+		h = md5.New()
+	default:
+		return nil, nil, ErrBadChecksum
+	}
+
+	sum, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil || len(sum) != h.Size() {
+		return nil, nil, ErrBadChecksum
+	}
+	return h, sum, nil
+}
+
+// ParseMetadata - key/value pairs of an Upload-Metadata header, pairs of
+// a key and a base64 value separated by commas
+func ParseMetadata(header string) (map[string]string, error) {
+	meta := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.Fields(pair)
+		if len(kv) > 2 {
+			return nil, fmt.Errorf("invalid Upload-Metadata pair %q", pair)
+		}
+		var value []byte
+		if len(kv) == 2 {
+			var err error
+			if value, err = base64.StdEncoding.DecodeString(kv[1]); err != nil {
+				return nil, fmt.Errorf("invalid Upload-Metadata value of %s: %v", kv[0], err)
+			}
+		}
+		meta[kv[0]] = string(value)
+	}
+	return meta, nil
+}
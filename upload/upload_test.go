@@ -0,0 +1,156 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/msproject/relive/dbmodel"
+	"github.com/msproject/relive/logger"
+)
+
+/* memDBI - in memory UploadTblDBI */
+type memDBI map[string]dbmodel.UploadEntry
+
+func (m memDBI) CreateUpload(up *dbmodel.UploadEntry) error {
+	m[up.UploadID] = *up
+	return nil
+}
+
+func (m memDBI) GetUpload(uploadID string) (*dbmodel.UploadEntry, error) {
+	up, ok := m[uploadID]
+	if !ok {
+		return nil, nil
+	}
+	return &up, nil
+}
+
+func (m memDBI) SetUploadOffset(uploadID string, from, to int64, expiresAt time.Time) (bool, error) {
+	up, ok := m[uploadID]
+	if !ok || up.Offset != from {
+		return false, nil
+	}
+	up.Offset, up.ExpiresAt = to, expiresAt
+	m[uploadID] = up
+	return true, nil
+}
+
+func (m memDBI) SetUploadJob(uploadID string, jobID int) error {
+	up := m[uploadID]
+	up.JobID = jobID
+	m[uploadID] = up
+	return nil
+}
+
+func (m memDBI) DeleteUpload(uploadID string) error {
+	delete(m, uploadID)
+	return nil
+}
+
+func (m memDBI) GetExpiredUploads(now time.Time) ([]string, error) {
+	var ids []string
+	for id, up := range m {
+		if up.ExpiresAt.Before(now) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func checksum(b []byte) string {
+	sum := sha1.Sum(b)
+	return "sha1 " + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+/* failingReader - yields data, then fails like a dropped connection */
+type failingReader struct{ data io.Reader }
+
+func (f failingReader) Read(p []byte) (int, error) {
+	n, err := f.data.Read(p)
+	if err == io.EOF {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func TestResumeUpload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logObj, err := logger.NewLoggerObject(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(memDBI{}, dir, time.Hour, logObj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("0123456789abcdefghij")
+	up := &dbmodel.UploadEntry{ID: 1, FileName: "a.mp4", Length: int64(len(content))}
+	if err = m.Create(up); err != nil {
+		t.Fatal(err)
+	}
+
+	/* a dropped connection without checksum keeps what arrived */
+	got, err := m.Append(up.UploadID, 0, failingReader{bytes.NewReader(content[:6])}, "")
+	if err != io.ErrUnexpectedEOF || got.Offset != 6 {
+		t.Fatalf("expected offset 6 after a dropped chunk, got %v, %v", got, err)
+	}
+
+	if _, err = m.Append(up.UploadID, 0, bytes.NewReader(content), ""); err != ErrOffsetMismatch {
+		t.Fatalf("expected ErrOffsetMismatch, got %v", err)
+	}
+	if got, err = m.Append(up.UploadID, 6, bytes.NewReader(content[6:]), checksum(content)); err != ErrChecksumMismatch || got.Offset != 6 {
+		t.Fatalf("expected ErrChecksumMismatch at offset 6, got %v, %v", got, err)
+	}
+	if _, err = m.Append(up.UploadID, 6, bytes.NewReader(append(content[6:], 'x')), ""); err != ErrTooLarge {
+		t.Fatalf("expected ErrTooLarge, got %v", err)
+	}
+	if got, err = m.Append(up.UploadID, 6, bytes.NewReader(content[6:]), checksum(content[6:])); err != nil || got.Offset != got.Length {
+		t.Fatalf("expected a complete upload, got %v, %v", got, err)
+	}
+
+	var received []byte
+	got, err = m.Complete(up.UploadID, func(up *dbmodel.UploadEntry, src *os.File) (int, error) {
+		received, err = ioutil.ReadAll(src)
+		return 42, err
+	})
+	if err != nil || got.JobID != 42 || !bytes.Equal(received, content) {
+		t.Fatalf("expected hand-off of %q to job 42, got %q to %v, %v", content, received, got, err)
+	}
+	if _, err = os.Stat(m.path(up.UploadID)); !os.IsNotExist(err) {
+		t.Fatalf("expected the staged file to be gone, got %v", err)
+	}
+
+	/* a second Complete does not hand off again */
+	if _, err = m.Complete(up.UploadID, func(*dbmodel.UploadEntry, *os.File) (int, error) {
+		return 0, errors.New("handed off twice")
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseMetadata(t *testing.T) {
+	meta, err := ParseMetadata("filename " + base64.StdEncoding.EncodeToString([]byte("a b.mp4")) + ",flag")
+	if err != nil || meta["filename"] != "a b.mp4" || len(meta) != 2 {
+		t.Fatalf("unexpected metadata %v, %v", meta, err)
+	}
+	if _, err = ParseMetadata("filename %%%"); err == nil {
+		t.Fatal("expected an error for a malformed value")
+	}
+	for _, bad := range []string{"sha1", "crc32 AAAA", "sha1 " + strings.Repeat("A", 8)} {
+		if _, _, err = ParseChecksum(bad); err != ErrBadChecksum {
+			t.Fatalf("expected ErrBadChecksum for %q, got %v", bad, err)
+		}
+	}
+}
@@ -1,5 +1,11 @@
 package util
 
+import (
+	"time"
+
+	"github.com/msproject/relive/money"
+)
+
 // CreateAccountReq - used to create account
 type CreateAccountReq struct {
 	UserName    string `json:"UserName"`
@@ -12,13 +18,52 @@ type CreateAccountReq struct {
 	Role        uint32 `json:"Role"`
 }
 
-// CreateProductReq - used to create product
+// CreateProductReq - used to create or update a product. A zero
+// MaxAdmins, MaxCustomers or RetentionDays is no limit, a zero TrialDays no
+// trial, empty Renditions the server's ladder
 type CreateProductReq struct {
-	ProductID   uint32 `json:"ProductID"`
-	ProductType string `json:"ProductType"`
-	StoreSize   uint32 `json:"StoreSize,omitempty"`
-	Duration    uint32 `json:"Duration,omitempty"`
-	Amount      uint32 `json:"Amount,omitempty"`
+	ProductID     uint32         `json:"ProductID"`
+	ProductType   string         `json:"ProductType"`
+	StoreSize     uint32         `json:"StoreSize,omitempty"`
+	Duration      uint32         `json:"Duration,omitempty"`
+	MaxAdmins     uint32         `json:"MaxAdmins,omitempty"`
+	MaxCustomers  uint32         `json:"MaxCustomers,omitempty"`
+	Renditions    string         `json:"Renditions,omitempty"`
+	RetentionDays uint32         `json:"RetentionDays,omitempty"`
+	TrialDays     uint32         `json:"TrialDays,omitempty"`
+	Prices        []ProductPrice `json:"Prices"`
+}
+
+// ProductPrice - price of a product in a currency, one per currency.
+// TaxRate is in basis points, 2000 for 20%, taken out of TaxInclusive
+// prices and added to others
+type ProductPrice struct {
+	Price        money.Money `json:"Price"`
+	TaxInclusive bool        `json:"TaxInclusive,omitempty"`
+	TaxRate      int         `json:"TaxRate,omitempty"`
+}
+
+// ProductResp - a product with its prices split into net, tax and gross
+type ProductResp struct {
+	ProductID     int                `json:"ProductID"`
+	ProductType   string             `json:"ProductType"`
+	StoreSize     int                `json:"StoreSize"`
+	Duration      int                `json:"Duration"`
+	MaxAdmins     int                `json:"MaxAdmins"`
+	MaxCustomers  int                `json:"MaxCustomers"`
+	Renditions    string             `json:"Renditions"`
+	RetentionDays int                `json:"RetentionDays"`
+	TrialDays     int                `json:"TrialDays"`
+	Prices        []ProductPriceResp `json:"Prices"`
+	ArchivedAt    *time.Time         `json:"ArchivedAt,omitempty"`
+}
+
+// ProductPriceResp - a price and what it comes to
+type ProductPriceResp struct {
+	ProductPrice
+	Net   money.Money `json:"Net"`
+	Tax   money.Money `json:"Tax"`
+	Gross money.Money `json:"Gross"`
 }
 
 // LoginReq - Login Account
@@ -27,6 +72,37 @@ type LoginReq struct {
 	PWD      string
 }
 
+// ChangePasswordReq - change the caller's password
+type ChangePasswordReq struct {
+	OldPWD string `json:"OldPWD"`
+	NewPWD string `json:"NewPWD"`
+}
+
+// ForgotPasswordReq - request a password reset token
+type ForgotPasswordReq struct {
+	UserName string `json:"UserName"`
+}
+
+// ResetPasswordReq - set a new password with a reset token
+type ResetPasswordReq struct {
+	Token  string `json:"Token"`
+	NewPWD string `json:"NewPWD"`
+}
+
+// LoginResp - bearer session returned by login and refresh
+type LoginResp struct {
+	Token     string    `json:"Token"`
+	TokenType string    `json:"TokenType"`
+	ExpiresAt time.Time `json:"ExpiresAt"`
+	ID        int       `json:"ID"`
+	PID       int       `json:"PID"`
+	UserName  string    `json:"UserName"`
+	EmailID   string    `json:"EmailID,omitempty"`
+	FirstName string    `json:"FirstName,omitempty"`
+	LastName  string    `json:"LastName,omitempty"`
+	Role      int       `json:"Role"`
+}
+
 // CreateSubscriptionReq - used to create subscription
 type CreateSubscriptionReq struct {
 	ID               uint32 // ID is constrained to Account ID
@@ -34,8 +110,8 @@ type CreateSubscriptionReq struct {
 	SubscriptionCode uint32
 	ProductType      string
 	StoreLocation    string
-	StartDate        string
-	EndDate          string
+	StartDate        string // root only, now if left out
+	EndDate          string // computed from the product, refused if given
 	NumberOfAdmins   uint32
 }
 
@@ -59,12 +135,17 @@ type UserDetails struct {
 	CustomerCount int
 }
 
-// SubscrDetails struct
+// SubscrDetails - a subscription in its current period. GraceUntil is set
+// while it is past due
 type SubscrDetails struct {
 	ID          int
 	ProductID   int
 	SubscrCode  int
 	ProductType string
+	State       string
+	StartDate   time.Time
+	EndDate     time.Time
+	GraceUntil  *time.Time `json:",omitempty"`
 }
 
 //PaymentDetails - payment details
@@ -75,3 +156,134 @@ type PaymentDetails struct {
 	CCExpiry       string
 	CVVCode        int
 }
+
+//MediaJobResp - state of an upload's transcode job
+type MediaJobResp struct {
+	JobID     int
+	ID        int
+	FileName  string
+	Title     string
+	Status    string
+	Step      string
+	Attempts  int
+	LastError string    `json:"LastError,omitempty"`
+	NextRunAt time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	URL       string `json:"URL,omitempty"`
+	Poster    string `json:"Poster,omitempty"`
+}
+
+//UpdateMediaReq - changes to a media, fields left nil are kept. Poster is
+//a file name below the media, such as one of its poster candidates
+type UpdateMediaReq struct {
+	ID          int
+	FileName    string
+	Catalog     *string
+	Title       *string
+	Description *string
+	Poster      *string
+}
+
+//MediaPosterResp - poster candidate Index of a media, URL signed
+type MediaPosterResp struct {
+	Index    int
+	URL      string
+	Selected bool
+}
+
+//SelectPosterReq - make poster candidate Index the poster of a media
+type SelectPosterReq struct {
+	ID       int
+	FileName string
+	Index    int
+}
+
+//MoveMediaReq - move media of account ID to the catalog entity CatalogID,
+//or if it is 0 to the free text Catalog
+type MoveMediaReq struct {
+	ID        int
+	FileNames []string
+	Catalog   string
+	CatalogID int
+}
+
+//MoveMediaResp - number of media moved
+type MoveMediaResp struct {
+	Moved int64
+}
+
+//MediaGrantReq - grant account AccountID Role on the media FileName held
+//by account ID. Role is ignored when revoking
+type MediaGrantReq struct {
+	ID        int
+	FileName  string
+	AccountID int
+	Role      string
+}
+
+//AccountTransferReq - move customer CustomerID to the business ToPID
+type AccountTransferReq struct {
+	CustomerID int
+	ToPID      int
+}
+
+//StorageUsageResp - storage of the business account ID belongs to, in
+//bytes. Used counts stored media, trash included, and pending uploads;
+//State is ok, soft past the soft limit or over past the quota. Overage is
+//the peak past the quota in the current billing period. ReadOnly is set
+//once the subscriptions of the business expired.
+type StorageUsageResp struct {
+	ID             int
+	BusinessID     int
+	Unlimited      bool
+	QuotaBytes     int64
+	SoftLimitBytes int64
+	MediaBytes     int64
+	PendingBytes   int64
+	UsedBytes      int64
+	OverageBytes   int64
+	State          string
+	Policy         string
+	ReadOnly       bool
+}
+
+//EgressResp - bytes playback served of the media of account ID and, for
+//a business, its customers from From to To, UTC dates both included
+type EgressResp struct {
+	ID         int
+	From       string
+	To         string
+	TotalBytes int64
+	Days       []EgressDay
+}
+
+//EgressDay - bytes served on Day of the media of account ID
+type EgressDay struct {
+	ID    int
+	Day   string
+	Bytes int64
+}
+
+//CatalogReq - create a catalog of account ID below ParentID, 0 for a top
+//level one. Cover is the FileName of a media whose poster the catalog shows
+type CatalogReq struct {
+	ID        int
+	ParentID  int
+	Name      string
+	Cover     string
+	SortOrder int
+}
+
+//UpdateCatalogReq - changes to a catalog, fields left nil are kept
+type UpdateCatalogReq struct {
+	ParentID  *int
+	Name      *string
+	Cover     *string
+	SortOrder *int
+}
+
+//CatalogOrderReq - media of a catalog in the order they are listed in
+type CatalogOrderReq struct {
+	FileNames []string
+}